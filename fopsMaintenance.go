@@ -1,18 +1,33 @@
 package fopsMaintenance
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"go.uber.org/zap"
@@ -22,19 +37,98 @@ import (
 func init() {
 	caddy.RegisterModule(&MaintenanceHandler{})
 	httpcaddyfile.RegisterHandlerDirective("maintenance", parseCaddyfile)
+	// Answer before authentication and the handlers that respond to requests,
+	// so clients are not prompted for credentials during maintenance
+	httpcaddyfile.RegisterDirectiveOrder("maintenance", httpcaddyfile.Before, "basic_auth")
 }
 
 // MaintenanceHandler handles maintenance mode functionality
 type MaintenanceHandler struct {
+	// Name identifying this instance in admin API responses
+	Name string `json:"name,omitempty"`
+
 	// Custom HTML template for maintenance page
 	HTMLTemplate string `json:"html_template,omitempty"`
 
-	// List of IPs allowed to bypass maintenance mode
+	// URL of the HTML template, fetched at provision time when no template
+	// file is set. The last fetched copy is kept in TemplateCacheFile and
+	// used when a later fetch fails.
+	TemplateURL          string         `json:"template_url,omitempty"`
+	TemplateCacheFile    string         `json:"template_cache_file,omitempty"`
+	TemplateFetchTimeout caddy.Duration `json:"template_fetch_timeout,omitempty"`
+
+	// Content type of the HTML response (default: text/html; charset=utf-8),
+	// e.g. for XHTML or legacy charsets
+	HTMLContentType string `json:"html_content_type,omitempty"`
+
+	// Name of the built-in template used when no custom template is set
+	// (default, minimal, branded, dark)
+	DefaultTemplate string `json:"default_template,omitempty"`
+
+	// Pre-captured HTML snapshot of the site served as is instead of the
+	// maintenance page, with the status of SnapshotStatus (default: 503)
+	SnapshotFile   string `json:"snapshot_file,omitempty"`
+	SnapshotStatus int    `json:"snapshot_status,omitempty"`
+
+	// Texts of the built-in templates, replacing their defaults when set
+	Title      string `json:"title,omitempty"`
+	Heading    string `json:"heading,omitempty"`
+	BodyText   string `json:"body_text,omitempty"`
+	ButtonText string `json:"button_text,omitempty"`
+
+	// How the built-in templates offer a refresh: "script" (default) uses an
+	// inline onclick handler, "link" a plain link and "none" omits it, for
+	// pages served under a strict Content-Security-Policy
+	RefreshButton string `json:"refresh_button,omitempty"`
+
+	// Content-Security-Policy header sent with the maintenance response
+	ContentSecurityPolicy string `json:"content_security_policy,omitempty"`
+
+	// Generate a nonce per maintenance response for the inline style and
+	// script of the built-in templates, replacing {nonce} in the policy
+	CSP bool `json:"csp,omitempty"`
+
+	// Block denied clients with 403 Forbidden instead of the 503 maintenance
+	// page, for security incidents rather than planned maintenance
+	Lockdown bool `json:"lockdown,omitempty"`
+
+	// Custom HTML template for the lockdown page
+	LockdownTemplate string `json:"lockdown_template,omitempty"`
+
+	// Whether an HTML template failing to render at request time is replaced
+	// by the built-in page and logged as a "warn"ing (default) or returned
+	// as an "error"
+	TemplateFailure string `json:"template_failure,omitempty"`
+
+	// Maintenance message of the JSON and text responses, also available to
+	// templates as {{.Message}}
+	Message string `json:"message,omitempty"`
+
+	// JSON template file for the JSON response, rendered with the same
+	// variables as the HTML template
+	JSONTemplate string `json:"json_template,omitempty"`
+
+	// Keys of the built-in JSON response renamed for the consumers' schema,
+	// from the default key to the new one, e.g. {"message": "detail"}
+	JSONKeys map[string]string `json:"json_keys,omitempty"`
+
+	// Localized HTML template files keyed by language tag (e.g. "fr", "en-US")
+	TemplatesByLang map[string]string `json:"templates_by_lang,omitempty"`
+
+	// HTML template files keyed by request protocol (e.g. "HTTP/1.0", "HTTP/2")
+	ProtocolOverrides map[string]string `json:"protocol_overrides,omitempty"`
+
+	// List of IPs allowed to bypass maintenance mode. Entries prefixed with
+	// "!" deny the addresses they cover, the last entry matching the client
+	// IP deciding.
 	AllowedIPs []string `json:"allowed_ips,omitempty"`
 
 	// File path containing allowed IPs with comments
 	AllowedIPsFile string `json:"allowed_ips_file,omitempty"`
 
+	// ASN database resolving AS<number> entries of allowed_ips to networks
+	ASNDatabase string `json:"asn_database,omitempty"`
+
 	// Enable support for forwarded headers (X-Forwarded-For, X-Real-IP)
 	UseForwardedHeaders bool `json:"use_forwarded_headers,omitempty"`
 
@@ -44,29 +138,237 @@ type MaintenanceHandler struct {
 	// Retry-After header value in seconds
 	RetryAfter int `json:"retry_after,omitempty"`
 
+	// Bounds in seconds clamping retry_after values supplied via the admin
+	// API, zero for no bound
+	RetryAfterMin int `json:"retry_after_min,omitempty"`
+	RetryAfterMax int `json:"retry_after_max,omitempty"`
+
+	// Omit the Retry-After header, for CDNs mishandling it on 503 responses
+	DisableRetryAfter bool `json:"disable_retry_after,omitempty"`
+
+	// Statuses of responses and errors of the next handlers replaced with
+	// the maintenance page while maintenance is disabled, e.g. 502
+	UpstreamErrorStatuses []int `json:"upstream_error_statuses,omitempty"`
+
+	// Send a request ID with the maintenance response, as X-Request-ID
+	// header and in the response body, for users to quote to support
+	RequestID bool `json:"request_id,omitempty"`
+
+	// Additional media types answered with the JSON response
+	// (application/json and any */*+json type are always treated as JSON)
+	JSONMediaTypes []string `json:"json_media_types,omitempty"`
+
+	// Paths always answered with the JSON response whatever the Accept
+	// header, e.g. /api/* for browsers navigating to API routes
+	APIPaths []string `json:"api_paths,omitempty"`
+
+	// Representation served when the client expresses no preference
+	// (html, json or text, default html)
+	DefaultRepresentation string `json:"default_representation,omitempty"`
+
+	// Status replacing 503 for clients negotiating a representation (html,
+	// json or text), e.g. {"json": 429} for API consumers
+	RepresentationStatus map[string]int `json:"representation_status,omitempty"`
+
 	// Default state of maintenance mode at startup
 	DefaultEnabled bool `json:"default_enabled,omitempty"`
 
 	// File path to persist maintenance status
 	StatusFile string `json:"status_file,omitempty"`
 
+	// Redundant copies of the status file, e.g. on other mounts. Every copy
+	// is written, and the first one that can be read is restored.
+	StatusFiles []string `json:"status_files,omitempty"`
+
+	// Coalesce status file writes within this window (0 writes immediately)
+	StatusFileDebounce caddy.Duration `json:"status_file_debounce,omitempty"`
+
+	// JSON Lines file to which admin actions are appended, for retention
+	// apart from the application logs
+	AuditFile string `json:"audit_file,omitempty"`
+
+	// IANA time zone in which times are presented and evaluated (default:
+	// UTC), e.g. "Europe/Paris"
+	Timezone string `json:"timezone,omitempty"`
+
+	// Expected end of the maintenance window (RFC3339), reported to clients
+	EstimatedEnd string `json:"estimated_end,omitempty"`
+
+	// Scheduled start of maintenance (RFC3339), maintenance gets enabled then
+	ScheduledStart string `json:"scheduled_start,omitempty"`
+
+	// Lead time before ScheduledStart during which a banner is injected
+	// into HTML responses, with the banner text template
+	PreNotice     caddy.Duration `json:"pre_notice,omitempty"`
+	PreNoticeText string         `json:"pre_notice_text,omitempty"`
+
+	// Weekly ranges during which enabling maintenance through the admin API
+	// is warned about or rejected
+	BusinessHours *BusinessHours `json:"business_hours,omitempty"`
+
+	// Log a warning once maintenance has been enabled for longer than this
+	MaxDurationWarn caddy.Duration `json:"max_duration_warn,omitempty"`
+
+	// Keep forwarding requests for this long once maintenance is enabled,
+	// so that in-flight deploys settle before the maintenance page is served
+	GracePeriod caddy.Duration `json:"grace_period,omitempty"`
+
+	// Start of the maintenance window already warned about, and the
+	// duration watcher lifecycle
+	durationWarnedFor time.Time
+	durationWatcher   *backgroundTicker
+
 	// Maintenance mode state
-	enabled    bool
+	enabled      bool
+	startedAt    time.Time
+	estimatedEnd time.Time
+	expiresAt    time.Time
+	expiryTimer  *time.Timer
+
+	// Start of the grace period, set when maintenance gets switched on
+	// through the admin API or the flag file
+	graceStart time.Time
+
+	// Request retention mode instrumentation
+	retentionMetrics *retentionMetrics
+
+	// Parsed timezone, nil for UTC
+	location *time.Location
+
+	// Parsed scheduled start, its pending timer and the pre-notice banner
+	scheduledStart    time.Time
+	scheduleTimer     *time.Timer
+	preNoticeTemplate *template.Template
+	// Parsed business hours, nil when not configured
+	businessHours *businessHours
+	// forced keeps maintenance enabled, see forceEnv
+	forced     bool
 	enabledMux sync.RWMutex
 
 	// Request retention mode timeout in seconds
 	RequestRetentionModeTimeout int `json:"request_retention_mode_timeout,omitempty"`
 
+	// Forward the retained requests to the next handlers instead of serving
+	// the maintenance page when Caddy shuts down or reloads, e.g. to let a
+	// draining upstream answer them during a deploy
+	ReleaseOnShutdown bool `json:"release_on_shutdown,omitempty"`
+
+	// Forward the request to the next handlers when the maintenance page
+	// cannot be served, e.g. a template failing to render, instead of
+	// returning the error to Caddy
+	FailOpen bool `json:"fail_open,omitempty"`
+
+	// Answer with an empty body: "always", or "auto" for requests without
+	// an Accept header such as health checks and load balancer probes
+	MinimalResponse string `json:"minimal_response,omitempty"`
+
+	// Cookie set when the full HTML maintenance page is served, browsers
+	// sending it back during the same maintenance get an empty body instead,
+	// for AckCookieTTL (default: 1h)
+	AckCookie    string         `json:"ack_cookie,omitempty"`
+	AckCookieTTL caddy.Duration `json:"ack_cookie_ttl,omitempty"`
+
+	// Delay in milliseconds before the maintenance page is written
+	ResponseDelay int `json:"response_delay,omitempty"`
+
 	// HTTP Basic Authentication configuration
 	AuthRealm    string `json:"auth_realm,omitempty"`
 	HtpasswdFile string `json:"htpasswd_file,omitempty"`
 
+	// Alternatives to HtpasswdFile not requiring a mounted file: htpasswd
+	// lines given inline, or the name of an environment variable holding them
+	HtpasswdEntries []string `json:"htpasswd_entries,omitempty"`
+	HtpasswdEnv     string   `json:"htpasswd_env,omitempty"`
+
+	// Minimum bcrypt cost of htpasswd hashes (default bcrypt.DefaultCost) and
+	// whether weaker hashes are an "error" or only logged as a "warn"ing
+	MinBcryptCost  int    `json:"min_bcrypt_cost,omitempty"`
+	WeakBcryptCost string `json:"weak_bcrypt_cost,omitempty"`
+
+	// Announce charset="UTF-8" in the challenge so clients encode non-ASCII
+	// credentials as UTF-8 (RFC 7617)
+	AuthCharsetUTF8 bool `json:"auth_charset_utf8,omitempty"`
+
+	// Sections of the site with their own realm and credentials, which
+	// apply instead of the htpasswd above to the requests under them
+	PathAuth []PathAuth `json:"path_auth,omitempty"`
+
+	// Data plane path answering with the read-only maintenance status
+	StatusPath string `json:"status_path,omitempty"`
+
 	// Paths that should bypass maintenance mode completely
 	BypassPaths []string `json:"bypass_paths,omitempty"`
 
-	// Pre-parsed IP access control for performance
-	allowedIndividualIPs []net.IP
-	allowedNetworks      []*net.IPNet
+	// Paths, IPs and CIDR ranges bypassing maintenance mode only for plain
+	// HTTP requests, or only for requests over TLS
+	BypassInsecureOnly []string `json:"bypass_insecure_only,omitempty"`
+	BypassSecureOnly   []string `json:"bypass_secure_only,omitempty"`
+
+	// Compiled BypassInsecureOnly and BypassSecureOnly
+	insecureBypass schemeBypass
+	secureBypass   schemeBypass
+
+	// Paths answered with the maintenance page whether maintenance is
+	// enabled or not, e.g. retired endpoints, with the status of
+	// AlwaysBlockStatus (default: 410)
+	AlwaysBlockPaths  []string `json:"always_block_paths,omitempty"`
+	AlwaysBlockStatus int      `json:"always_block_status,omitempty"`
+
+	// Apply maintenance only to the hosts missing from KnownHosts, e.g. on a
+	// catch-all site serving parked domains next to real ones. Known hosts
+	// may start with "*." to cover their subdomains.
+	OnlyUnknownHosts bool     `json:"only_unknown_hosts,omitempty"`
+	KnownHosts       []string `json:"known_hosts,omitempty"`
+
+	// Match bypass paths against the full request URI (path and raw query)
+	BypassMatchFullURI bool `json:"bypass_match_full_uri,omitempty"`
+
+	// Response header set on requests let through during maintenance, with
+	// the reason as value (ip, grant, cert, auth, user or path), e.g.
+	// X-Maintenance-Bypass
+	BypassHeader string `json:"bypass_header,omitempty"`
+
+	// Response header and cookie set to "active" on requests let through
+	// during maintenance, telling the operator's tooling that the public is
+	// currently blocked. The cookie is expired once maintenance is disabled.
+	MaintenanceNoticeHeader string `json:"maintenance_notice_header,omitempty"`
+	MaintenanceNoticeCookie string `json:"maintenance_notice_cookie,omitempty"`
+
+	// Users authenticated by an earlier Caddy authentication handler
+	// ({http.auth.user.id}) allowed to bypass maintenance mode
+	BypassUsers []string `json:"bypass_users,omitempty"`
+
+	// Names allowed to bypass maintenance mode when found in the subject
+	// common name or the DNS/email SANs of a verified TLS client certificate
+	BypassClientCertCN []string `json:"bypass_client_cert_cn,omitempty"`
+
+	// Whether a hostname in allowed_ips that fails to resolve is an "error"
+	// (default) or only logged as a "warn"ing
+	HostnameLookupFailure string `json:"hostname_lookup_failure,omitempty"`
+
+	// Pre-parsed IP access control for performance, in allowed_ips order
+	allowRules []allowRule
+
+	// Re-resolve hostnames of the allow-list at this interval (0 disables)
+	HostnameRefreshInterval caddy.Duration `json:"hostname_refresh_interval,omitempty"`
+
+	// JSON feature-flag file driving the maintenance state, with the key of
+	// its boolean flag (dot-separated for nested objects, e.g. "shop.maintenance")
+	FlagFile string `json:"flag_file,omitempty"`
+	FlagKey  string `json:"flag_key,omitempty"`
+
+	// How often the flag file is read (default: 5s)
+	FlagPollInterval caddy.Duration `json:"flag_poll_interval,omitempty"`
+
+	// Last flag value applied and the flag watcher lifecycle
+	flagValue   *bool
+	flagWatcher *backgroundTicker
+
+	// Hostnames of the allow-list and the addresses they resolved to
+	allowedHostnames []string
+	resolvedHostIPs  map[string][]net.IP
+	ipMux            sync.RWMutex
+	hostnameRefresh  *backgroundTicker
 
 	// Pre-parsed trusted proxy IPs and networks for forwarded headers
 	trustedProxyIPs      []net.IP
@@ -74,8 +376,60 @@ type MaintenanceHandler struct {
 
 	// Pre-parsed htpasswd entries for performance
 	htpasswdEntries map[string][]byte
-	logger          *zap.Logger
-	ctx             caddy.Context
+	// Access expiry of htpasswd users, for users with an expires= field
+	htpasswdExpiry map[string]time.Time
+
+	// Paths of the template files, whose contents replace HTMLTemplate,
+	// JSONTemplate and LockdownTemplate at provision time
+	htmlTemplateFile     string
+	jsonTemplateFile     string
+	lockdownTemplateFile string
+	// allowed_ips entries of the configuration, without those of the
+	// allowed_ips_file
+	configuredAllowedIPs []string
+	// Entries last read from or written to the allowed_ips_file, so that a
+	// reload keeps an allow-list pushed through the admin API unless the
+	// file changed
+	allowedIPsFileEntries []string
+	// bypass_paths, api_paths and always_block_paths decoded like
+	// r.URL.Path, see decodePaths
+	bypassPathsDecoded      []string
+	apiPathsDecoded         []string
+	alwaysBlockPathsDecoded []string
+	// Guards the templates and htpasswd entries replaced by the reload
+	// endpoint
+	filesMux sync.RWMutex
+	// Template fetched from template_url, kept apart from HTMLTemplate so
+	// that the configuration is left as written, and the lifecycle of the
+	// background fetch
+	fetchedTemplate     string
+	templateFetchCancel context.CancelFunc
+	templateFetchDone   chan struct{}
+	// Parsed HTML templates keyed by their content, see parseHTMLTemplate
+	templateCache sync.Map
+	// Pre-parsed credentials of the path_auth sections
+	pathCredentials []*authCredentials
+
+	// Pre-loaded localized templates keyed by lowercased language tag,
+	// replaced as a whole on reload under filesMux
+	langTemplates map[string]string
+
+	// Protocol template contents keyed by normalized protocol, replaced as
+	// a whole on reload under filesMux
+	protocolTemplates map[string]string
+
+	// Content of the snapshot file
+	snapshot []byte
+
+	// Audit file shared with the instances configuring the same audit_file
+	auditFile *auditFile
+
+	// Debounced status persistence
+	persistMux    sync.Mutex
+	persistTimer  *time.Timer
+	pendingStatus []byte
+	logger        *zap.Logger
+	ctx           caddy.Context
 }
 
 // CaddyModule returns the Caddy module information.
@@ -88,8 +442,13 @@ func (*MaintenanceHandler) CaddyModule() caddy.ModuleInfo {
 
 // Provision implements caddy.Provisioner.
 func (h *MaintenanceHandler) Provision(ctx caddy.Context) error {
-	h.logger = ctx.Logger()
+	h.logger = instanceLogger(contextLoggerFunc(ctx), h.Name)
 	h.ctx = ctx
+	h.retentionMetrics = newRetentionMetrics(ctx.GetMetricsRegistry())
+	h.forced = maintenanceForced()
+	if h.forced && h.logger != nil {
+		h.logger.Warn("Maintenance mode forced by environment", zap.String("env", forceEnv))
+	}
 
 	// Register the maintenance handler for admin API operations.
 	registerMaintenanceHandler(h)
@@ -98,6 +457,31 @@ func (h *MaintenanceHandler) Provision(ctx caddy.Context) error {
 	if err := h.parseAllowedIPs(); err != nil {
 		return fmt.Errorf("failed to parse allowed IPs: %v", err)
 	}
+	h.startHostnameRefresh()
+
+	h.location = nil
+	if h.Timezone != "" {
+		location, err := time.LoadLocation(h.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone '%s': %v", h.Timezone, err)
+		}
+		h.location = location
+	}
+
+	// An invalid estimated_end is reported by Validate
+	if estimatedEnd, err := time.Parse(time.RFC3339, h.EstimatedEnd); err == nil {
+		h.enabledMux.Lock()
+		h.estimatedEnd = estimatedEnd
+		h.enabledMux.Unlock()
+	}
+
+	if err := h.provisionSchedule(); err != nil {
+		return err
+	}
+
+	if err := h.provisionBusinessHours(); err != nil {
+		return err
+	}
 
 	// Pre-parse trusted proxies for forwarded headers support
 	if err := h.parseTrustedProxies(); err != nil {
@@ -108,761 +492,2960 @@ func (h *MaintenanceHandler) Provision(ctx caddy.Context) error {
 	if err := h.parseHtpasswdFile(); err != nil {
 		return fmt.Errorf("failed to parse htpasswd file: %v", err)
 	}
+	if err := h.decodePaths(); err != nil {
+		return err
+	}
+	if err := h.provisionSchemeBypass(); err != nil {
+		return err
+	}
+	// Once the allow-list and the per-scheme entries are both compiled
+	pruneBypassStats()
+
+	for i, knownHost := range h.KnownHosts {
+		h.KnownHosts[i] = normalizeHost(knownHost)
+	}
+
+	// Browsers handle an empty realm poorly, announce a sensible default
+	if h.htpasswdConfigured() && strings.TrimSpace(h.AuthRealm) == "" {
+		h.AuthRealm = defaultAuthRealm
+	}
+	if err := h.provisionPathAuth(); err != nil {
+		return err
+	}
 	// Load template file if path is provided
 	if h.HTMLTemplate != "" {
 		content, err := os.ReadFile(h.HTMLTemplate)
 		if err != nil {
 			return fmt.Errorf("failed to read template file: %v", err)
 		}
+		h.htmlTemplateFile = h.HTMLTemplate
 		h.HTMLTemplate = string(content)
+	} else if h.TemplateURL != "" {
+		h.startTemplateFetch()
+	} else if h.DefaultTemplate != "" {
+		content, err := builtinTemplate(h.DefaultTemplate)
+		if err != nil {
+			return err
+		}
+		h.HTMLTemplate = content
 	}
 
-	// Try to load persisted status if StatusFile is configured
-	if h.StatusFile != "" {
-		if data, err := os.ReadFile(h.StatusFile); err == nil {
-			var status struct {
-				Enabled bool `json:"enabled"`
-			}
-			if err := json.Unmarshal(data, &status); err == nil {
-				h.enabledMux.Lock()
-				h.enabled = status.Enabled
-				h.enabledMux.Unlock()
-				return nil
-			}
+	h.releaseAuditFile()
+	if h.AuditFile != "" {
+		file, err := acquireAuditFile(h.AuditFile)
+		if err != nil {
+			return fmt.Errorf("failed to open audit file: %v", err)
 		}
+		h.auditFile = file
 	}
 
-	// If no persisted status, use DefaultEnabled
-	h.enabledMux.Lock()
-	h.enabled = h.DefaultEnabled
-	h.enabledMux.Unlock()
-
-	return nil
-}
-
-// parseAllowedIPs pre-parses individual IPs and CIDR networks for performance
-func (h *MaintenanceHandler) parseAllowedIPs() error {
-	// Reset slices to prevent duplication on multiple calls
-	h.allowedIndividualIPs = nil
-	h.allowedNetworks = nil
-
-	// Load IPs from file if specified
-	if h.AllowedIPsFile != "" {
-		fileIPs, err := h.loadIPsFromFile(h.AllowedIPsFile)
+	if h.SnapshotFile != "" {
+		content, err := os.ReadFile(h.SnapshotFile)
 		if err != nil {
-			return fmt.Errorf("failed to load IPs from file '%s': %v", h.AllowedIPsFile, err)
+			return fmt.Errorf("failed to read snapshot file: %v", err)
 		}
-		h.AllowedIPs = append(h.AllowedIPs, fileIPs...)
+		h.snapshot = content
 	}
 
-	for _, allowedIP := range h.AllowedIPs {
-		// Trim spaces to tolerate stray spaces in Caddyfiles
-		allowedIP = strings.TrimSpace(allowedIP)
+	if h.JSONTemplate != "" {
+		content, err := os.ReadFile(h.JSONTemplate)
+		if err != nil {
+			return fmt.Errorf("failed to read JSON template file: %v", err)
+		}
+		h.jsonTemplateFile = h.JSONTemplate
+		h.JSONTemplate = string(content)
+	}
 
-		// Check if it's a CIDR notation
-		if strings.Contains(allowedIP, "/") {
-			// Parse CIDR network
-			_, ipNet, err := net.ParseCIDR(allowedIP)
-			if err != nil {
-				return fmt.Errorf("invalid CIDR notation '%s': %v", allowedIP, err)
-			}
-			h.allowedNetworks = append(h.allowedNetworks, ipNet)
-		} else {
-			// Parse individual IP
-			ip := net.ParseIP(allowedIP)
-			if ip == nil {
-				return fmt.Errorf("invalid IP address '%s'", allowedIP)
-			}
-			h.allowedIndividualIPs = append(h.allowedIndividualIPs, ip)
+	if h.LockdownTemplate != "" {
+		content, err := os.ReadFile(h.LockdownTemplate)
+		if err != nil {
+			return fmt.Errorf("failed to read lockdown template file: %v", err)
 		}
+		h.lockdownTemplateFile = h.LockdownTemplate
+		h.LockdownTemplate = string(content)
 	}
-	return nil
-}
 
-// parseTrustedProxies pre-parses trusted proxies into IPs and networks
-func (h *MaintenanceHandler) parseTrustedProxies() error {
-	// Reset slices to prevent duplication on multiple calls
-	h.trustedProxyIPs = nil
-	h.trustedProxyNetworks = nil
+	// Load localized templates
+	if err := h.loadLangTemplates(); err != nil {
+		return err
+	}
 
-	if h.UseForwardedHeaders && len(h.TrustedProxies) == 0 {
-		return fmt.Errorf("use_forwarded_headers requires at least one trusted proxy")
+	// Load protocol specific templates
+	if err := h.loadProtocolTemplates(); err != nil {
+		return err
 	}
 
-	for _, proxy := range h.TrustedProxies {
-		proxy = strings.TrimSpace(proxy)
-		if proxy == "" {
-			continue
-		}
+	// Validate templates syntax once instead of failing on each request
+	if err := h.validateTemplates(); err != nil {
+		return err
+	}
 
-		if strings.Contains(proxy, "/") {
-			_, ipNet, err := net.ParseCIDR(proxy)
-			if err != nil {
-				return fmt.Errorf("invalid trusted proxy CIDR '%s': %v", proxy, err)
-			}
-			h.trustedProxyNetworks = append(h.trustedProxyNetworks, ipNet)
-			continue
-		}
+	h.loadEnabledState()
+	h.startDurationWatcher()
 
-		ip := net.ParseIP(proxy)
-		if ip == nil {
-			return fmt.Errorf("invalid trusted proxy IP '%s'", proxy)
-		}
-		h.trustedProxyIPs = append(h.trustedProxyIPs, ip)
+	// The feature-flag file has the last word on the initial state
+	return h.startFlagWatcher()
+}
+
+// contextLoggerFunc returns the logger Caddy gives the module, replaceable in tests
+var contextLoggerFunc = func(ctx caddy.Context) *zap.Logger {
+	return ctx.Logger()
+}
+
+// instanceLogger tags the entries of a named instance with its name, so that
+// the logs of several sites can be told apart
+func instanceLogger(logger *zap.Logger, name string) *zap.Logger {
+	if name == "" {
+		return logger
 	}
 
-	return nil
+	return logger.With(zap.String("instance", name))
 }
 
-// loadIPsFromFile reads IPs from a file with comment support
-func (h *MaintenanceHandler) loadIPsFromFile(filePath string) ([]string, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
+// loadEnabledState restores the persisted status, falling back to DefaultEnabled
+func (h *MaintenanceHandler) loadEnabledState() {
+	status, _, invalid := h.readPersistedStatus()
+	for _, failure := range invalid {
+		if h.logger != nil {
+			h.logger.Warn("Ignoring status file with unexpected content",
+				zap.String("status_file", failure.path),
+				zap.Error(failure.err),
+			)
+		}
+	}
+	if status != nil {
+		h.applyPersistedStatus(*status)
+		return
 	}
 
-	var ips []string
-	lines := strings.Split(string(content), "\n")
+	// If no persisted status, use DefaultEnabled
+	h.enabledMux.Lock()
+	h.setEnabledLocked(h.DefaultEnabled, time.Now())
+	h.enabledMux.Unlock()
+}
 
-	for lineNum, line := range lines {
-		line = strings.TrimSpace(line)
+// statusFileError is a status file whose content could not be parsed
+type statusFileError struct {
+	path string
+	err  error
+}
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+// readPersistedStatus reads the status from the first readable status file
+// with a valid content and its path, returning the files skipped for their
+// content. The status is nil when no file holds one.
+func (h *MaintenanceHandler) readPersistedStatus() (*persistedStatus, string, []statusFileError) {
+	var invalid []statusFileError
+	for _, statusFile := range h.statusFilePaths() {
+		data, err := os.ReadFile(statusFile)
+		if err != nil {
 			continue
 		}
-
-		// Extract IP from line (remove inline comments)
-		if commentIndex := strings.Index(line, "#"); commentIndex != -1 {
-			line = strings.TrimSpace(line[:commentIndex])
-		}
-
-		// Skip empty lines after comment removal
-		if line == "" {
+		var status persistedStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			invalid = append(invalid, statusFileError{path: statusFile, err: err})
 			continue
 		}
+		return &status, statusFile, invalid
+	}
 
-		// Validate IP format
-		if strings.Contains(line, "/") {
-			// CIDR notation
-			_, _, err := net.ParseCIDR(line)
-			if err != nil {
-				return nil, fmt.Errorf("invalid CIDR notation '%s' at line %d: %v", line, lineNum+1, err)
-			}
-		} else {
-			// Individual IP
-			ip := net.ParseIP(line)
-			if ip == nil {
-				return nil, fmt.Errorf("invalid IP address '%s' at line %d", line, lineNum+1)
-			}
-		}
+	return nil, "", invalid
+}
 
-		ips = append(ips, line)
+// applyPersistedStatus applies a status read from a status file
+func (h *MaintenanceHandler) applyPersistedStatus(status persistedStatus) {
+	startedAt := time.Now()
+	if status.StartedAt != nil {
+		startedAt = *status.StartedAt
+	}
+	// A maintenance window that expired while stopped is over
+	enabled := status.Enabled
+	if enabled && status.ExpiresAt != nil && !status.ExpiresAt.After(time.Now()) {
+		enabled = false
 	}
+	h.enabledMux.Lock()
+	defer h.enabledMux.Unlock()
+	h.setEnabledLocked(enabled, startedAt)
+	if enabled && status.ExpiresAt != nil {
+		h.setExpiryLocked(*status.ExpiresAt)
+	}
+}
 
-	return ips, nil
+// statusFilePaths returns the status file followed by its redundant copies
+func (h *MaintenanceHandler) statusFilePaths() []string {
+	var paths []string
+	if h.StatusFile != "" {
+		paths = append(paths, h.StatusFile)
+	}
+
+	return append(paths, h.StatusFiles...)
 }
 
-// parseHtpasswdFile parses the htpasswd file and stores credentials in memory
-func (h *MaintenanceHandler) parseHtpasswdFile() error {
-	// Reset map to prevent duplication on multiple calls
-	h.htpasswdEntries = make(map[string][]byte)
+// persistedStatus is the content of the status file
+type persistedStatus struct {
+	Enabled bool `json:"enabled"`
+	// StartedAt is when maintenance mode was enabled
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// ExpiresAt is when maintenance mode gets disabled automatically
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
 
-	if h.HtpasswdFile == "" {
-		if h.logger != nil {
-			h.logger.Debug("No htpasswd file configured")
-		}
-		return nil // No htpasswd file configured
+// UnmarshalJSON accepts "true" and "false" strings for enabled, as written
+// by hand or by tools quoting every value
+func (s *persistedStatus) UnmarshalJSON(data []byte) error {
+	type plainStatus persistedStatus
+	var raw struct {
+		plainStatus
+		Enabled json.RawMessage `json:"enabled"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
 	}
 
-	if h.logger != nil {
-		h.logger.Debug("Loading htpasswd file", zap.String("file", h.HtpasswdFile))
+	*s = persistedStatus(raw.plainStatus)
+	if len(raw.Enabled) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Enabled, &s.Enabled); err == nil {
+		return nil
 	}
 
-	content, err := os.ReadFile(h.HtpasswdFile)
-	if err != nil {
-		if h.logger != nil {
-			h.logger.Error("Failed to read htpasswd file", zap.String("file", h.HtpasswdFile), zap.Error(err))
+	var enabled string
+	if err := json.Unmarshal(raw.Enabled, &enabled); err == nil {
+		switch strings.ToLower(enabled) {
+		case "true":
+			s.Enabled = true
+			return nil
+		case "false":
+			s.Enabled = false
+			return nil
 		}
-		return fmt.Errorf("failed to read htpasswd file '%s': %v", h.HtpasswdFile, err)
 	}
 
-	lines := strings.Split(string(content), "\n")
-	loadedUsers := 0
+	return fmt.Errorf("invalid enabled value %s, expected true or false", raw.Enabled)
+}
 
-	for lineNum, line := range lines {
-		line = strings.TrimSpace(line)
+// forceEnv forces maintenance mode on for every instance when set to true at
+// startup. Only a restart without the variable clears it.
+const forceEnv = "FOPS_MAINTENANCE_FORCE"
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+// maintenanceForced reports whether forceEnv is set
+func maintenanceForced() bool {
+	forced, err := strconv.ParseBool(os.Getenv(forceEnv))
+	return err == nil && forced
+}
 
-		// Extract line content (remove inline comments)
-		if commentIndex := strings.Index(line, "#"); commentIndex != -1 {
-			line = strings.TrimSpace(line[:commentIndex])
-		}
+// setEnabledLocked updates the maintenance state, recording startedAt when
+// maintenance gets enabled. A forced handler stays enabled.
+// The caller must hold enabledMux.
+func (h *MaintenanceHandler) setEnabledLocked(enabled bool, startedAt time.Time) {
+	if h.forced {
+		enabled = true
+	}
+	switch {
+	case !enabled:
+		h.startedAt = time.Time{}
+		h.graceStart = time.Time{}
+		h.durationWarnedFor = time.Time{}
+		h.setExpiryLocked(time.Time{})
+	case !h.enabled || h.startedAt.IsZero():
+		h.startedAt = startedAt
+	}
+	h.enabled = enabled
+}
 
-		// Skip empty lines after comment removal
-		if line == "" {
-			continue
-		}
+// toggleEnabledLocked is setEnabledLocked for a change requested while
+// running: going from disabled to enabled starts the grace period.
+// The caller must hold enabledMux.
+func (h *MaintenanceHandler) toggleEnabledLocked(enabled bool, startedAt time.Time) {
+	wasEnabled := h.enabled
+	h.setEnabledLocked(enabled, startedAt)
+	if !wasEnabled && h.enabled {
+		h.graceStart = time.Now()
+	}
+}
 
-		// Parse htpasswd line (username:password_hash)
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
-			if h.logger != nil {
-				h.logger.Error("Invalid htpasswd format", zap.Int("line", lineNum+1), zap.String("line", line))
-			}
-			return fmt.Errorf("invalid htpasswd format at line %d: expected 'username:password_hash'", lineNum+1)
+// validateTemplates parses the configured HTML templates
+func (h *MaintenanceHandler) validateTemplates() error {
+	if err := h.checkHTMLTemplate(h.HTMLTemplate, "template"); err != nil {
+		return err
+	}
+
+	// Render the JSON template with sample values to catch invalid JSON early
+	if h.JSONTemplate != "" {
+		sample := templateData{StartedAt: time.Now(), EstimatedEnd: time.Now(), RetryAfter: defaultRetryAfter, Message: h.message()}
+		if _, err := renderJSONTemplate(h.JSONTemplate, sample); err != nil {
+			return err
 		}
+	}
 
-		username := strings.TrimSpace(parts[0])
-		passwordHash := strings.TrimSpace(parts[1])
+	if err := h.checkHTMLTemplate(h.LockdownTemplate, "lockdown template"); err != nil {
+		return err
+	}
 
-		if username == "" {
-			if h.logger != nil {
-				h.logger.Error("Empty username in htpasswd", zap.Int("line", lineNum+1))
-			}
-			return fmt.Errorf("empty username at line %d", lineNum+1)
+	for lang, content := range h.langTemplates {
+		if err := h.checkHTMLTemplate(content, fmt.Sprintf("template for language '%s'", lang)); err != nil {
+			return err
 		}
+	}
 
-		if passwordHash == "" {
-			if h.logger != nil {
-				h.logger.Error("Empty password hash in htpasswd", zap.Int("line", lineNum+1), zap.String("username", username))
-			}
-			return fmt.Errorf("empty password hash at line %d", lineNum+1)
+	for protocol, content := range h.protocolTemplates {
+		if err := h.checkHTMLTemplate(content, fmt.Sprintf("template for protocol '%s'", protocol)); err != nil {
+			return err
 		}
+	}
 
-		// Store the password hash
-		h.htpasswdEntries[username] = []byte(passwordHash)
-		loadedUsers++
+	return nil
+}
 
-		if h.logger != nil {
-			h.logger.Debug("Loaded user from htpasswd", zap.String("username", username))
-		}
+// checkHTMLTemplate reports an HTML template that is not a valid Go template.
+// Such a page, e.g. one holding Vue or Handlebars markup, is served as is
+// unless template_failure is "error", which rejects it.
+func (h *MaintenanceHandler) checkHTMLTemplate(content, name string) error {
+	_, err := h.parseHTMLTemplate(content)
+	if err == nil {
+		return nil
+	}
+	if h.TemplateFailure == failureModeError {
+		return fmt.Errorf("failed to parse %s: %v", name, err)
 	}
 
 	if h.logger != nil {
-		h.logger.Info("Htpasswd file loaded successfully",
-			zap.String("file", h.HtpasswdFile),
-			zap.Int("users_loaded", loadedUsers),
+		h.logger.Warn("Maintenance template is not a valid Go template, serving it as is",
+			zap.String("template", name),
+			zap.Error(err),
 		)
 	}
-
 	return nil
 }
 
-// isAuthenticated checks if the request has valid HTTP Basic Authentication
-func (h *MaintenanceHandler) isAuthenticated(r *http.Request) bool {
-	if h.HtpasswdFile == "" || len(h.htpasswdEntries) == 0 {
-		if h.logger != nil {
-			h.logger.Debug("No authentication configured")
-		}
-		return false // No authentication configured
+// loadLangTemplates reads every localized template configured in TemplatesByLang
+func (h *MaintenanceHandler) loadLangTemplates() error {
+	templates, err := h.readLangTemplates()
+	if err != nil {
+		return err
 	}
+	h.langTemplates = templates
 
-	// Get Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		if h.logger != nil {
-			h.logger.Debug("No Authorization header present")
-		}
-		return false
+	return nil
+}
+
+// readLangTemplates returns the localized templates keyed by lowercased
+// language tag, nil when none is configured
+func (h *MaintenanceHandler) readLangTemplates() (map[string]string, error) {
+	if len(h.TemplatesByLang) == 0 {
+		return nil, nil
 	}
 
-	// Check if it's Basic authentication
-	if !strings.HasPrefix(authHeader, "Basic ") {
-		if h.logger != nil {
-			h.logger.Debug("Authorization header is not Basic", zap.String("auth_header", authHeader))
+	templates := make(map[string]string, len(h.TemplatesByLang))
+	for lang, templatePath := range h.TemplatesByLang {
+		content, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file for language '%s': %v", lang, err)
 		}
-		return false
+		templates[strings.ToLower(strings.TrimSpace(lang))] = string(content)
 	}
 
-	// Extract and decode credentials
-	encodedCredentials := strings.TrimPrefix(authHeader, "Basic ")
-	decodedCredentials, err := base64.StdEncoding.DecodeString(encodedCredentials)
+	return templates, nil
+}
+
+// loadProtocolTemplates reads every template configured in ProtocolOverrides
+func (h *MaintenanceHandler) loadProtocolTemplates() error {
+	templates, err := h.readProtocolTemplates()
 	if err != nil {
-		if h.logger != nil {
-			h.logger.Debug("Failed to decode base64 credentials", zap.Error(err))
-		}
-		return false
+		return err
 	}
+	h.protocolTemplates = templates
 
-	// Split username and password
-	credentials := strings.SplitN(string(decodedCredentials), ":", 2)
-	if len(credentials) != 2 {
-		if h.logger != nil {
-			h.logger.Debug("Invalid credentials format", zap.String("decoded", string(decodedCredentials)))
-		}
-		return false
-	}
-
-	username := credentials[0]
-	password := credentials[1]
+	return nil
+}
 
-	if h.logger != nil {
-		h.logger.Debug("Checking authentication",
-			zap.String("username", username),
-			zap.Bool("password_provided", password != ""),
-		)
+// readProtocolTemplates returns the protocol templates keyed by normalized
+// protocol, nil when none is configured
+func (h *MaintenanceHandler) readProtocolTemplates() (map[string]string, error) {
+	if len(h.ProtocolOverrides) == 0 {
+		return nil, nil
 	}
 
-	// Get stored password hash
-	storedHash, exists := h.htpasswdEntries[username]
-	if !exists {
-		if h.logger != nil {
-			h.logger.Debug("User not found in htpasswd", zap.String("username", username))
+	templates := make(map[string]string, len(h.ProtocolOverrides))
+	for protocol, templatePath := range h.ProtocolOverrides {
+		key := strings.ToUpper(strings.TrimSpace(protocol))
+		if _, _, ok := http.ParseHTTPVersion(key); !ok {
+			if _, _, ok := http.ParseHTTPVersion(key + ".0"); !ok {
+				return nil, fmt.Errorf("invalid protocol '%s' in protocol_overrides, expected e.g. 'HTTP/1.0' or 'HTTP/2'", protocol)
+			}
 		}
-		return false
-	}
 
-	// Verify password
-	result := h.verifyPassword(password, storedHash)
-	if h.logger != nil {
-		h.logger.Debug("Password verification result",
-			zap.String("username", username),
-			zap.Bool("valid", result),
-		)
+		content, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file for protocol '%s': %v", protocol, err)
+		}
+		templates[key] = string(content)
 	}
-	return result
+
+	return templates, nil
 }
 
-// verifyPassword verifies a password against a stored hash
-func (h *MaintenanceHandler) verifyPassword(password string, storedHash []byte) bool {
-	// Check if it's a bcrypt hash (starts with $2a$, $2b$, or $2y$)
-	if len(storedHash) >= 4 && (storedHash[0] == '$' && storedHash[1] == '2') {
-		// bcrypt hash
-		err := bcrypt.CompareHashAndPassword(storedHash, []byte(password))
-		return err == nil
-	}
+// templateOverrides returns the localized and protocol templates in use
+func (h *MaintenanceHandler) templateOverrides() (map[string]string, map[string]string) {
+	h.filesMux.RLock()
+	defer h.filesMux.RUnlock()
 
-	// For other hash types (MD5, SHA1, etc.), we would need additional libraries
-	// For now, we only support bcrypt which is the most secure option
-	return false
+	return h.langTemplates, h.protocolTemplates
 }
 
-// isIPAllowed checks if an IP address is allowed using pre-parsed IPs and networks
-func (h *MaintenanceHandler) isIPAllowed(clientIP string) bool {
-	// Parse client IP
-	ip := net.ParseIP(clientIP)
-	if ip == nil {
-		return false
-	}
-
-	// Check individual IPs first (faster for exact matches)
-	for _, allowedIP := range h.allowedIndividualIPs {
-		if ip.Equal(allowedIP) {
-			return true
-		}
+// setExpiryLocked schedules maintenance to be disabled at expiresAt, replacing
+// any pending schedule. A zero time cancels it, and a forced handler never
+// expires. The caller must hold enabledMux.
+func (h *MaintenanceHandler) setExpiryLocked(expiresAt time.Time) {
+	if h.expiryTimer != nil {
+		h.expiryTimer.Stop()
+		h.expiryTimer = nil
 	}
-
-	// Check CIDR networks
-	for _, network := range h.allowedNetworks {
-		if network.Contains(ip) {
-			return true
-		}
+	h.expiresAt = expiresAt
+	if expiresAt.IsZero() || h.forced {
+		return
 	}
 
-	return false
+	h.expiryTimer = time.AfterFunc(time.Until(expiresAt), func() {
+		h.expire(expiresAt)
+	})
 }
 
-// isTrustedProxy checks whether an IP belongs to the trusted proxy list
-func (h *MaintenanceHandler) isTrustedProxy(ip net.IP) bool {
-	if ip == nil {
-		return false
+// expire disables maintenance once its duration elapsed, unless the schedule
+// was replaced in the meantime. Forced maintenance is left untouched, so the
+// status file is not told it ended.
+func (h *MaintenanceHandler) expire(expiresAt time.Time) {
+	h.enabledMux.Lock()
+	if !h.enabled || h.forced || !h.expiresAt.Equal(expiresAt) {
+		h.enabledMux.Unlock()
+		return
 	}
+	h.expiryTimer = nil
+	h.setEnabledLocked(false, time.Time{})
+	h.enabledMux.Unlock()
 
-	for _, proxyIP := range h.trustedProxyIPs {
-		if ip.Equal(proxyIP) {
-			return true
-		}
+	if h.logger != nil {
+		h.logger.Info("Maintenance duration elapsed, maintenance mode disabled")
 	}
-
-	for _, network := range h.trustedProxyNetworks {
-		if network.Contains(ip) {
-			return true
-		}
+	if err := persistEnabledStatus([]*MaintenanceHandler{h}, false, time.Time{}, time.Time{}); err != nil && h.logger != nil {
+		h.logger.Error("Failed to persist maintenance status after expiry", zap.Error(err))
 	}
-
-	return false
 }
 
-// isPathBypassed checks if a request path should bypass maintenance mode completely
-func (h *MaintenanceHandler) isPathBypassed(path string) bool {
-	if len(h.BypassPaths) == 0 {
-		return false
+// Validate implements caddy.Validator. It holds the checks that only look at
+// the configuration, so that they run under `caddy validate` without
+// depending on anything Provision loads.
+func (h *MaintenanceHandler) Validate() error {
+	if h.HTMLContentType != "" {
+		if _, _, err := mime.ParseMediaType(h.HTMLContentType); err != nil {
+			return fmt.Errorf("invalid html_content_type '%s': %v", h.HTMLContentType, err)
+		}
 	}
 
-	// Normalize path for comparison
-	path = strings.TrimSuffix(path, "/")
-	if path == "" {
-		path = "/"
+	switch h.DefaultRepresentation {
+	case "", representationHTML, representationJSON, representationText:
+	default:
+		return fmt.Errorf("invalid default_representation '%s', expected '%s', '%s' or '%s'", h.DefaultRepresentation, representationHTML, representationJSON, representationText)
 	}
 
-	for _, bypassPath := range h.BypassPaths {
-		bypassPath = strings.TrimSuffix(bypassPath, "/")
-		if bypassPath == "" {
-			bypassPath = "/"
-		}
-
-		// Exact match
-		if path == bypassPath {
-			return true
+	for representation, status := range h.RepresentationStatus {
+		switch representation {
+		case representationHTML, representationJSON, representationText:
+		default:
+			return fmt.Errorf("invalid representation_status representation '%s', expected '%s', '%s' or '%s'", representation, representationHTML, representationJSON, representationText)
 		}
-
-		// Prefix match (for directories)
-		if strings.HasSuffix(bypassPath, "/*") {
-			prefix := strings.TrimSuffix(bypassPath, "/*")
-			if prefix == "" {
-				prefix = "/"
-			}
-			if strings.HasPrefix(path, prefix) {
-				return true
-			}
+		if status < 400 || status > 599 {
+			return fmt.Errorf("invalid representation_status %d for %s, expected a status between 400 and 599", status, representation)
 		}
 	}
+	if _, ok := h.RepresentationStatus[representationHTML]; ok && h.SnapshotStatus != 0 {
+		return fmt.Errorf("representation_status html cannot be combined with snapshot_status")
+	}
 
-	return false
-}
+	if err := validateJSONKeys(h.JSONKeys); err != nil {
+		return err
+	}
 
-// Interface guards
-var (
-	_ caddy.Provisioner           = (*MaintenanceHandler)(nil)
-	_ caddyhttp.MiddlewareHandler = (*MaintenanceHandler)(nil)
-)
+	switch h.TemplateFailure {
+	case "", failureModeError, failureModeWarn:
+	default:
+		return fmt.Errorf("invalid template_failure '%s', expected '%s' or '%s'", h.TemplateFailure, failureModeError, failureModeWarn)
+	}
 
-// getClientIP returns the effective client IP, optionally using forwarded headers
-func (h *MaintenanceHandler) getClientIP(r *http.Request) string {
-	clientIP := r.RemoteAddr
-	if host, _, err := net.SplitHostPort(clientIP); err == nil {
-		clientIP = host
+	if h.TemplateURL != "" {
+		if _, err := parseTemplateURL(h.TemplateURL); err != nil {
+			return err
+		}
 	}
 
-	if !h.UseForwardedHeaders {
-		return clientIP
+	if h.EstimatedEnd != "" {
+		if _, err := time.Parse(time.RFC3339, h.EstimatedEnd); err != nil {
+			return fmt.Errorf("invalid estimated_end '%s': %v", h.EstimatedEnd, err)
+		}
 	}
 
-	remoteIP := net.ParseIP(clientIP)
-	if remoteIP == nil || !h.isTrustedProxy(remoteIP) {
-		return clientIP
+	if h.FlagFile != "" && h.FlagKey == "" {
+		return fmt.Errorf("flag_file requires a flag_key")
 	}
 
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		for i := len(parts) - 1; i >= 0; i-- {
-			candidate := strings.TrimSpace(parts[i])
-			if candidate == "" {
-				continue
-			}
+	// Files written at runtime are not touched by Provision, check that
+	// their directory is there
+	for _, statusFile := range h.statusFilePaths() {
+		if err := checkParentDirectory(statusFile); err != nil {
+			return fmt.Errorf("invalid status_file '%s': %v", statusFile, err)
+		}
+	}
+	if h.TemplateCacheFile != "" {
+		if err := checkParentDirectory(h.TemplateCacheFile); err != nil {
+			return fmt.Errorf("invalid template_cache_file '%s': %v", h.TemplateCacheFile, err)
+		}
+	}
 
-			ip := net.ParseIP(candidate)
-			if ip == nil {
-				continue
-			}
+	switch h.RefreshButton {
+	case "", refreshButtonScript, refreshButtonLink, refreshButtonNone:
+	default:
+		return fmt.Errorf("invalid refresh_button '%s', expected '%s', '%s' or '%s'", h.RefreshButton, refreshButtonScript, refreshButtonLink, refreshButtonNone)
+	}
 
-			if h.isTrustedProxy(ip) {
-				continue
-			}
+	if h.RetryAfterMin < 0 || h.RetryAfterMax < 0 {
+		return fmt.Errorf("retry_after_min and retry_after_max must not be negative")
+	}
+	if h.RetryAfterMin > 0 && h.RetryAfterMax > 0 && h.RetryAfterMin > h.RetryAfterMax {
+		return fmt.Errorf("retry_after_min %d is greater than retry_after_max %d", h.RetryAfterMin, h.RetryAfterMax)
+	}
 
-			return candidate
+	if h.BusinessHours != nil {
+		switch h.BusinessHours.Policy {
+		case "", businessHoursPolicyWarn, businessHoursPolicyReject:
+		default:
+			return fmt.Errorf("invalid business_hours policy '%s', expected '%s' or '%s'", h.BusinessHours.Policy, businessHoursPolicyWarn, businessHoursPolicyReject)
 		}
 	}
 
-	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
-		if ip := net.ParseIP(xrip); ip != nil && !h.isTrustedProxy(ip) {
-			return xrip
+	if h.MaintenanceNoticeCookie != "" {
+		if err := (&http.Cookie{Name: h.MaintenanceNoticeCookie, Value: maintenanceNoticeValue}).Valid(); err != nil {
+			return fmt.Errorf("invalid maintenance_notice_cookie '%s': %v", h.MaintenanceNoticeCookie, err)
 		}
 	}
 
-	return clientIP
-}
-
-// ServeHTTP implements caddyhttp.MiddlewareHandler.
-func (h *MaintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	h.enabledMux.RLock()
-	enabled := h.enabled
-	requestRetentionTimeout := h.RequestRetentionModeTimeout
-	temporaryModeEnabled := requestRetentionTimeout > 0
-	h.enabledMux.RUnlock()
-
-	if !enabled {
-		return next.ServeHTTP(w, r)
+	switch h.WeakBcryptCost {
+	case "", failureModeError, failureModeWarn:
+	default:
+		return fmt.Errorf("invalid weak_bcrypt_cost '%s', expected '%s' or '%s'", h.WeakBcryptCost, failureModeError, failureModeWarn)
+	}
+	if h.MinBcryptCost != 0 && (h.MinBcryptCost < bcrypt.MinCost || h.MinBcryptCost > bcrypt.MaxCost) {
+		return fmt.Errorf("invalid min_bcrypt_cost %d, expected a cost between %d and %d", h.MinBcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
 	}
 
-	// Check if path should bypass maintenance mode completely
-	if h.isPathBypassed(r.URL.Path) {
-		if h.logger != nil {
-			h.logger.Debug("Path bypassed, forwarding request",
-				zap.String("path", r.URL.Path),
-				zap.Strings("bypass_paths", h.BypassPaths),
-			)
+	htpasswdSources := 0
+	for _, configured := range []bool{h.HtpasswdFile != "", len(h.HtpasswdEntries) > 0, h.HtpasswdEnv != ""} {
+		if configured {
+			htpasswdSources++
 		}
-		return next.ServeHTTP(w, r)
+	}
+	if htpasswdSources > 1 {
+		return fmt.Errorf("htpasswd_file, htpasswd_entries and htpasswd_env cannot be combined")
 	}
 
-	// Check if client IP is in allowed list
-	clientIP := h.getClientIP(r)
-
-	// Debug logging
-	if h.logger != nil {
-		h.logger.Debug("Maintenance mode active",
-			zap.String("client_ip", clientIP),
-			zap.String("user_agent", r.UserAgent()),
-			zap.String("path", r.URL.Path),
-			zap.Bool("htpasswd_configured", h.HtpasswdFile != ""),
-			zap.Int("htpasswd_entries_count", len(h.htpasswdEntries)),
-		)
+	if err := h.validatePathAuth(); err != nil {
+		return err
 	}
 
-	if h.isIPAllowed(clientIP) {
-		if h.logger != nil {
-			h.logger.Debug("IP allowed, bypassing maintenance", zap.String("client_ip", clientIP))
+	if h.AlwaysBlockStatus != 0 {
+		if len(h.AlwaysBlockPaths) == 0 {
+			return fmt.Errorf("always_block_status requires always_block_paths")
+		}
+		if h.AlwaysBlockStatus < 400 || h.AlwaysBlockStatus > 599 {
+			return fmt.Errorf("invalid always_block_status %d, expected a status between 400 and 599", h.AlwaysBlockStatus)
 		}
-		return next.ServeHTTP(w, r)
 	}
 
-	// Check if client is authenticated via HTTP Basic Auth
-	authResult := h.isAuthenticated(r)
-	if h.logger != nil {
-		h.logger.Debug("Authentication check result",
-			zap.Bool("authenticated", authResult),
-			zap.String("auth_header", r.Header.Get("Authorization")),
-		)
+	if h.GracePeriod < 0 {
+		return fmt.Errorf("grace_period must not be negative")
 	}
 
-	if authResult {
-		return next.ServeHTTP(w, r)
+	if h.OnlyUnknownHosts && len(h.KnownHosts) == 0 {
+		return fmt.Errorf("only_unknown_hosts requires known_hosts")
+	}
+	if len(h.KnownHosts) > 0 && !h.OnlyUnknownHosts {
+		return fmt.Errorf("known_hosts requires only_unknown_hosts")
 	}
 
-	// Request retention mode disabled, serve maintenance page now
-	if !temporaryModeEnabled {
-		if h.logger != nil {
-			h.logger.Debug("Serving maintenance page", zap.String("client_ip", clientIP))
+	for _, status := range h.UpstreamErrorStatuses {
+		if status < 500 || status > 599 {
+			return fmt.Errorf("invalid upstream_error_statuses %d, expected a 5xx status", status)
 		}
-		return serveMaintenancePage(r, w, h)
 	}
 
-	// Request retention mode enabled, retain request for the predefined period
-	timer := time.NewTimer(time.Duration(requestRetentionTimeout) * time.Second)
-	for {
-		// Wait for the timer to expire, the context to be cancelled or the maintenance mode to be disabled
-		// Context can be cancelled in several real-world scenarios:
-		// Client connection closed, Caddy config reload, Server graceful shutdown (SIGTERM)....
-		select {
-		// Timeout reached, serve maintenance page
-		case <-timer.C:
-			return serveMaintenancePage(r, w, h)
-		// Context cancelled, serve maintenance page
-		case <-h.ctx.Done():
-			return serveMaintenancePage(r, w, h)
-		// Check every second the "enabled" state
-		case <-time.After(1000 * time.Millisecond):
-			h.enabledMux.RLock()
-			enabled := h.enabled
-			h.enabledMux.RUnlock()
-			if !enabled {
-				// Maintenance mode disabled, forward the request
-				return next.ServeHTTP(w, r)
-			}
+	if h.SnapshotStatus != 0 {
+		if h.SnapshotFile == "" {
+			return fmt.Errorf("snapshot_status requires a snapshot_file")
+		}
+		if h.SnapshotStatus < 200 || h.SnapshotStatus > 599 {
+			return fmt.Errorf("invalid snapshot_status %d, expected a status between 200 and 599", h.SnapshotStatus)
 		}
 	}
-}
+	if h.SnapshotFile != "" && (h.HTMLTemplate != "" || h.TemplateURL != "" || h.DefaultTemplate != "") {
+		return fmt.Errorf("snapshot_file cannot be combined with template, template_url or default_template")
+	}
 
-func serveMaintenancePage(r *http.Request, w http.ResponseWriter, h *MaintenanceHandler) error {
-	// Set Retry-After header with default value if not specified
-	retryAfter := defaultRetryAfter
-	if h.RetryAfter > 0 {
-		retryAfter = h.RetryAfter
+	switch h.MinimalResponse {
+	case "", minimalResponseAlways, minimalResponseAuto:
+	default:
+		return fmt.Errorf("invalid minimal_response '%s', expected '%s' or '%s'", h.MinimalResponse, minimalResponseAlways, minimalResponseAuto)
 	}
-	w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
 
-	// Check if HTTP Basic Auth is configured
-	if h.HtpasswdFile != "" && len(h.htpasswdEntries) > 0 {
-		realm := "Maintenance Mode"
-		if h.AuthRealm != "" {
-			realm = h.AuthRealm
-		}
-		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, realm))
-		// Return 401 to prompt for authentication
-		w.WriteHeader(http.StatusUnauthorized)
-		if h.logger != nil {
-			h.logger.Debug("Returning 401 Unauthorized to prompt for authentication",
-				zap.String("realm", realm),
-				zap.String("htpasswd_file", h.HtpasswdFile),
-				zap.Int("users_configured", len(h.htpasswdEntries)),
-			)
-		}
-	} else {
-		// No authentication configured, return 503 for maintenance
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if h.logger != nil {
-			h.logger.Debug("Returning 503 Service Unavailable (no authentication configured)")
+	if h.AckCookie != "" {
+		if err := (&http.Cookie{Name: h.AckCookie, Value: "0"}).Valid(); err != nil {
+			return fmt.Errorf("invalid ack_cookie '%s': %v", h.AckCookie, err)
 		}
 	}
-
-	// Check if client accepts JSON
-	if isJSONRequest(r) {
-		return serveJSON(w)
+	if h.AckCookieTTL < 0 {
+		return fmt.Errorf("ack_cookie_ttl must not be negative")
+	}
+	if h.AckCookieTTL != 0 && h.AckCookie == "" {
+		return fmt.Errorf("ack_cookie_ttl requires ack_cookie")
 	}
 
-	// Serve HTML maintenance page
-	return serveHTML(w, h.HTMLTemplate)
+	return nil
 }
 
-func isJSONRequest(r *http.Request) bool {
-	accept := r.Header.Get("Accept")
-	return accept == "application/json" || r.Header.Get("Content-Type") == "application/json"
-}
+// checkParentDirectory reports an error when the directory a file is written
+// to does not exist or cannot be accessed
+func checkParentDirectory(path string) error {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", dir)
+	}
 
-func serveJSON(w http.ResponseWriter) error {
-	w.Header().Set("Content-Type", "application/json")
+	return nil
+}
 
-	response := map[string]string{
-		"status":  "error",
-		"message": "Service temporarily unavailable for maintenance",
+// Cleanup implements caddy.CleanerUpper.
+func (h *MaintenanceHandler) Cleanup() error {
+	h.enabledMux.Lock()
+	if h.expiryTimer != nil {
+		h.expiryTimer.Stop()
+		h.expiryTimer = nil
 	}
-	return json.NewEncoder(w).Encode(response)
-}
+	h.enabledMux.Unlock()
 
-func serveHTML(w http.ResponseWriter, template string) error {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Make sure a debounced status write is not lost
+	h.flushPendingStatus()
 
-	if template == "" {
-		template = defaultHTMLTemplate
-	}
-	_, err := w.Write([]byte(template))
-	return err
+	h.stopHostnameRefresh()
+	h.stopFlagWatcher()
+	h.stopDurationWatcher()
+	h.stopScheduleTimer()
+	h.stopTemplateFetch()
+	h.templateCache.Clear()
+	h.releaseAuditFile()
+
+	return nil
 }
 
-const defaultHTMLTemplate = `<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <title>Maintenance in Progress</title>
-    <meta name="robots" content="noindex">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <style>
-        :root {
-            --primary-color: #2563eb;
-            --secondary-color: #4b5563;
-            --text-color: #1f2937;
-            --bg-color: #f3f4f6;
-            --container-bg-color: #ffffff;
-        }
-        
-        * {
-            margin: 0;
-            padding: 0;
-            box-sizing: border-box;
-        }
-        
-        body {
-            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
-            line-height: 1.6;
-            color: var(--text-color);
-            background: var(--bg-color);
-            min-height: 100vh;
-            display: flex;
-            align-items: center;
-            justify-content: center;
-            padding: 1rem;
-        }
-        
-        .maintenance-container {
-            max-width: 500px;
-            text-align: center;
-            background: var(--container-bg-color);
-            padding: 2rem;
-            border-radius: 1rem;
-            box-shadow: 0 4px 8px rgba(0, 0, 0, 0.1);
-            transition: box-shadow 0.3s ease-in-out;
-        }
-
-        .maintenance-container:hover {
-            box-shadow: 0 8px 16px rgba(0, 0, 0, 0.2);
-        }
-        
-        .icon {
-            font-size: 4rem;
-            margin-bottom: 1rem;
-            color: var(--primary-color);
-        }
-        
-        h1 {
-            font-size: 2rem;
-            font-weight: 700;
-            margin-bottom: 1rem;
-            color: var(--text-color);
-        }
-        
-        p {
-            font-size: 1.125rem;
-            color: var(--secondary-color);
-            margin-bottom: 1.5rem;
-        }
-
-        .refresh-button {
-            background-color: var(--primary-color);
-            color: white;
-            border: none;
-            padding: 0.75rem 1.5rem;
-            border-radius: 0.5rem;
-            font-size: 1rem;
-            cursor: pointer;
-            transition: background-color 0.3s;
-        }
-
-        .refresh-button:hover {
-            background-color: #1d4ed8;
-        }
-        
-        @media (max-width: 640px) {
-            .maintenance-container {
-                padding: 1.5rem;
-                margin: 1rem;
-            }
-            
-            h1 {
-                font-size: 1.5rem;
-            }
-            
-            p {
-                font-size: 1rem;
-            }
-            
-            .icon {
-                font-size: 3rem;
-            }
-
-            .refresh-button {
-                padding: 0.5rem 1rem;
-                font-size: 0.875rem;
-            }
-        }
-    </style>
-</head>
-<body>
-    <div class="maintenance-container">
-        <div class="icon">🔧</div>
-        <h1>We'll Be Back Soon!</h1>
-        <p>We're currently upgrading our system to serve you better. <br>We appreciate your patience during this brief maintenance.</p>
-        <p>Feel free to refresh the page in a few minutes.</p>
-        <button class="refresh-button" onclick="location.reload()">Refresh Page</button>
-    </div>
-</body>
-</html>`
+// releaseAuditFile drops the reference of the instance to its audit file
+func (h *MaintenanceHandler) releaseAuditFile() {
+	if h.auditFile != nil {
+		h.auditFile.release()
+		h.auditFile = nil
+	}
+}
 
-const defaultRetryAfter = 300
+// lookupIPFunc resolves hostnames of the allow-list, replaceable in tests
+var lookupIPFunc = net.LookupIP
 
-// parseCaddyfile parses the maintenance directive in the Caddyfile
-func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
-	var m MaintenanceHandler
+// parseAllowedIPs pre-parses individual IPs and CIDR networks for performance
+// and resolves hostname entries
+func (h *MaintenanceHandler) parseAllowedIPs() error {
+	// Reset slices to prevent duplication on multiple calls
+	h.setAllowList(&allowList{})
 
-	for h.Next() {
-		// Parse any arguments on the same line as the directive
-		if h.NextArg() {
-			m.HTMLTemplate = h.Val() // This will now be treated as a file path
+	// Load IPs from file if specified
+	h.configuredAllowedIPs = slices.Clone(h.AllowedIPs)
+	if h.AllowedIPsFile != "" {
+		fileIPs, err := h.loadIPsFromFile(h.AllowedIPsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load IPs from file '%s': %v", h.AllowedIPsFile, err)
+		}
+		h.AllowedIPs = append(h.AllowedIPs, fileIPs...)
+		h.allowedIPsFileEntries = fileIPs
+	}
+
+	switch h.HostnameLookupFailure {
+	case "", failureModeError, failureModeWarn:
+	default:
+		return fmt.Errorf("invalid hostname_lookup_failure '%s', expected '%s' or '%s'", h.HostnameLookupFailure, failureModeError, failureModeWarn)
+	}
+
+	list, err := h.compileAllowList(h.AllowedIPs)
+	if err != nil {
+		return err
+	}
+	h.setAllowList(list)
+
+	return nil
+}
+
+// allowList is a parsed allow-list, replaced at once under ipMux
+type allowList struct {
+	rules     []allowRule
+	hostnames []string
+	resolved  map[string][]net.IP
+}
+
+// compileAllowList parses allowed_ips entries and resolves their hostnames,
+// without touching the allow-list in use
+func (h *MaintenanceHandler) compileAllowList(entries []string) (*allowList, error) {
+	list := &allowList{}
+	allowedASNs := make(map[uint32]bool)
+	for _, allowedIP := range entries {
+		// Trim spaces to tolerate stray spaces in Caddyfiles
+		allowedIP = strings.TrimSpace(allowedIP)
+
+		var rule allowRule
+		if negated, ok := strings.CutPrefix(allowedIP, "!"); ok {
+			rule.negated = true
+			allowedIP = negated
+		}
+		rule.entry = allowedIP
+
+		if asn, ok := parseASN(allowedIP); ok {
+			rule.asn = asn
+			allowedASNs[asn] = true
+		} else if allowedIP == "*" {
+			// Any address, e.g. to allow everyone but a few denied entries
+			rule.networks = []*net.IPNet{
+				{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+				{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+			}
+		} else if strings.Contains(allowedIP, "/") {
+			// Parse CIDR network
+			_, ipNet, err := net.ParseCIDR(allowedIP)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR notation '%s': %v", allowedIP, err)
+			}
+			rule.networks = []*net.IPNet{ipNet}
+		} else if ip := net.ParseIP(allowedIP); ip != nil {
+			rule.networks = []*net.IPNet{singleIPNetwork(ip)}
+		} else if isHostname(allowedIP) {
+			rule.hostname = allowedIP
+			list.hostnames = append(list.hostnames, allowedIP)
+		} else {
+			return nil, fmt.Errorf("invalid IP address '%s'", allowedIP)
+		}
+		list.rules = append(list.rules, rule)
+	}
+
+	if len(allowedASNs) > 0 {
+		if h.ASNDatabase == "" {
+			return nil, fmt.Errorf("AS entries in allowed_ips require an asn_database")
+		}
+		networks, err := h.loadASNNetworks(allowedASNs)
+		if err != nil {
+			return nil, err
+		}
+		for i, rule := range list.rules {
+			if rule.asn != 0 {
+				list.rules[i].networks = networks[rule.asn]
+			}
+		}
+	}
+
+	resolved, err := h.resolveHostnames(list.hostnames, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	list.resolved = resolved
+
+	return list, nil
+}
+
+// setAllowList replaces the allow-list in use
+func (h *MaintenanceHandler) setAllowList(list *allowList) {
+	h.ipMux.Lock()
+	defer h.ipMux.Unlock()
+	h.allowRules = list.rules
+	h.allowedHostnames = list.hostnames
+	h.resolvedHostIPs = list.resolved
+}
+
+// allowRule is a parsed allowed_ips entry: networks for IP, CIDR, "*" and AS
+// entries, or a hostname whose addresses are in resolvedHostIPs
+type allowRule struct {
+	// entry is the allowed_ips entry without its "!" prefix
+	entry string
+	// Entries prefixed with "!" deny the addresses they cover
+	negated  bool
+	networks []*net.IPNet
+	hostname string
+	asn      uint32
+}
+
+// singleIPNetwork returns the /32 or /128 network holding only ip
+func singleIPNetwork(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// resolveAllowedHostnames resolves hostname entries of the allow-list. During
+// a refresh, failures never abort and a hostname that fails to resolve keeps
+// its previous addresses.
+func (h *MaintenanceHandler) resolveAllowedHostnames(refresh bool) error {
+	h.ipMux.RLock()
+	hostnames := h.allowedHostnames
+	previous := h.resolvedHostIPs
+	h.ipMux.RUnlock()
+
+	resolved, err := h.resolveHostnames(hostnames, previous, refresh)
+	if err != nil {
+		return err
+	}
+
+	h.ipMux.Lock()
+	defer h.ipMux.Unlock()
+	// The allow-list may have been replaced while resolving
+	if slices.Equal(h.allowedHostnames, hostnames) {
+		h.resolvedHostIPs = resolved
+	}
+
+	return nil
+}
+
+// resolveHostnames looks up hostnames, falling back to their previous
+// addresses when a lookup fails and failures are tolerated
+func (h *MaintenanceHandler) resolveHostnames(hostnames []string, previous map[string][]net.IP, refresh bool) (map[string][]net.IP, error) {
+	resolved := make(map[string][]net.IP, len(hostnames))
+	for _, hostname := range hostnames {
+		ips, err := lookupIPFunc(hostname)
+		if err != nil {
+			if !refresh && h.HostnameLookupFailure != failureModeWarn {
+				return nil, fmt.Errorf("failed to resolve allowed hostname '%s': %v", hostname, err)
+			}
+			if h.logger != nil {
+				h.logger.Warn("Failed to resolve allowed hostname", zap.String("hostname", hostname), zap.Bool("refresh", refresh), zap.Error(err))
+			}
+			if previousIPs, ok := previous[hostname]; ok {
+				resolved[hostname] = previousIPs
+			}
+			continue
+		}
+		resolved[hostname] = ips
+	}
+
+	return resolved, nil
+}
+
+// startHostnameRefresh periodically re-resolves allowed hostnames
+func (h *MaintenanceHandler) startHostnameRefresh() {
+	h.stopHostnameRefresh()
+	if h.HostnameRefreshInterval <= 0 || len(h.allowedHostnames) == 0 {
+		return
+	}
+
+	h.hostnameRefresh = runTicker(time.Duration(h.HostnameRefreshInterval), func() {
+		_ = h.resolveAllowedHostnames(true)
+	})
+}
+
+// stopHostnameRefresh stops the re-resolution ticker and waits for it to exit
+func (h *MaintenanceHandler) stopHostnameRefresh() {
+	h.hostnameRefresh.stop()
+	h.hostnameRefresh = nil
+}
+
+// backgroundTicker is a function called periodically in the background
+type backgroundTicker struct {
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// runTicker calls fn every interval in the background until stopped
+func runTicker(interval time.Duration, fn func()) *backgroundTicker {
+	t := &backgroundTicker{
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(t.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-t.stopCh:
+				return
+			}
+		}
+	}()
+
+	return t
+}
+
+// stop stops the ticker and waits for a running call to return. Stopping a
+// nil ticker does nothing.
+func (t *backgroundTicker) stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	<-t.done
+}
+
+// maxDurationCheckInterval caps how often the maintenance duration is checked
+const maxDurationCheckInterval = time.Minute
+
+// startDurationWatcher periodically checks how long maintenance has been enabled
+func (h *MaintenanceHandler) startDurationWatcher() {
+	h.stopDurationWatcher()
+	if h.MaxDurationWarn <= 0 {
+		return
+	}
+
+	interval := min(time.Duration(h.MaxDurationWarn)/4, maxDurationCheckInterval)
+	h.durationWatcher = runTicker(max(interval, time.Millisecond), func() {
+		h.checkMaxDuration(time.Now())
+	})
+}
+
+// checkMaxDuration logs a warning, once per maintenance window, when
+// maintenance has been enabled for longer than MaxDurationWarn
+func (h *MaintenanceHandler) checkMaxDuration(now time.Time) {
+	h.enabledMux.Lock()
+	startedAt := h.startedAt
+	warned := h.durationWarnedFor.Equal(startedAt)
+	if !h.enabled || startedAt.IsZero() || warned || now.Sub(startedAt) < time.Duration(h.MaxDurationWarn) {
+		h.enabledMux.Unlock()
+		return
+	}
+	h.durationWarnedFor = startedAt
+	h.enabledMux.Unlock()
+
+	if h.logger != nil {
+		h.logger.Warn("Maintenance mode enabled for longer than max_duration_warn",
+			zap.Time("started_at", startedAt),
+			zap.Duration("enabled_for", now.Sub(startedAt).Round(time.Second)),
+			zap.Duration("max_duration_warn", time.Duration(h.MaxDurationWarn)),
+		)
+	}
+}
+
+// stopDurationWatcher stops the duration watcher and waits for it to exit
+func (h *MaintenanceHandler) stopDurationWatcher() {
+	h.durationWatcher.stop()
+	h.durationWatcher = nil
+}
+
+// isHostname reports whether value looks like a DNS name rather than a
+// mistyped IP address: at least two labels and a non-numeric last label
+func isHostname(value string) bool {
+	value = strings.TrimSuffix(value, ".")
+	labels := strings.Split(value, ".")
+	if len(labels) < 2 || len(value) > 253 {
+		return false
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	_, err := strconv.Atoi(labels[len(labels)-1])
+	return err != nil
+}
+
+// parseTrustedProxies pre-parses trusted proxies into IPs and networks
+func (h *MaintenanceHandler) parseTrustedProxies() error {
+	// Reset slices to prevent duplication on multiple calls
+	h.trustedProxyIPs = nil
+	h.trustedProxyNetworks = nil
+
+	if h.UseForwardedHeaders && len(h.TrustedProxies) == 0 {
+		return fmt.Errorf("use_forwarded_headers requires at least one trusted proxy")
+	}
+
+	for _, proxy := range h.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if strings.Contains(proxy, "/") {
+			_, ipNet, err := net.ParseCIDR(proxy)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy CIDR '%s': %v", proxy, err)
+			}
+			h.trustedProxyNetworks = append(h.trustedProxyNetworks, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return fmt.Errorf("invalid trusted proxy IP '%s'", proxy)
+		}
+		h.trustedProxyIPs = append(h.trustedProxyIPs, ip)
+	}
+
+	return nil
+}
+
+// utf8BOM is the byte order mark some Windows editors prepend to UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// splitLines splits file content into lines, dropping a leading UTF-8 BOM
+// and the carriage return of CRLF line endings
+func splitLines(content []byte) []string {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// fileLine is a significant line of a configuration file
+type fileLine struct {
+	number int // one-based line number
+	text   string
+}
+
+// scanLines returns the non-empty lines of a configuration file with
+// surrounding whitespace trimmed and '#' comments removed, so every file
+// parser treats comments and blank lines the same way
+func scanLines(content []byte) []fileLine {
+	var out []fileLine
+	for i, line := range splitLines(content) {
+		// Remove full-line and inline comments
+		if commentIndex := strings.Index(line, "#"); commentIndex != -1 {
+			line = line[:commentIndex]
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		out = append(out, fileLine{number: i + 1, text: line})
+	}
+	return out
+}
+
+// loadIPsFromFile reads IPs from a file with comment support
+func (h *MaintenanceHandler) loadIPsFromFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	defer file.Close()
+
+	return parseIPList(file)
+}
+
+// parseIPList parses a list of IPs and CIDR ranges, one per line with
+// comment support
+func parseIPList(reader io.Reader) ([]string, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+
+	var ips []string
+
+	for _, entry := range scanLines(content) {
+		line, lineNum := entry.text, entry.number
+
+		// Validate IP format
+		if strings.Contains(line, "/") {
+			// CIDR notation
+			_, _, err := net.ParseCIDR(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR notation '%s' at line %d: %v", line, lineNum, err)
+			}
+		} else {
+			// Individual IP
+			ip := net.ParseIP(line)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address '%s' at line %d", line, lineNum)
+			}
+		}
+
+		ips = append(ips, line)
+	}
+
+	return ips, nil
+}
+
+// parseHtpasswdFile parses the htpasswd file and stores credentials in memory
+func (h *MaintenanceHandler) parseHtpasswdFile() error {
+	// Reset maps to prevent duplication on multiple calls
+	h.htpasswdEntries = make(map[string][]byte)
+	h.htpasswdExpiry = make(map[string]time.Time)
+
+	source, open := h.htpasswdSource()
+	if open == nil {
+		if h.logger != nil {
+			h.logger.Debug("No htpasswd file configured")
+		}
+		return nil // No htpasswd file configured
+	}
+
+	if h.logger != nil {
+		h.logger.Debug("Loading htpasswd file", zap.String("file", source))
+	}
+
+	reader, err := open()
+	if err != nil {
+		if h.logger != nil {
+			h.logger.Error("Failed to read htpasswd file", zap.String("file", source), zap.Error(err))
+		}
+		return err
+	}
+
+	loadedUsers, err := h.parseHtpasswd(reader)
+	if err != nil {
+		return err
+	}
+
+	if h.logger != nil {
+		h.logger.Info("Htpasswd file loaded successfully",
+			zap.String("file", source),
+			zap.Int("users_loaded", loadedUsers),
+		)
+	}
+
+	return nil
+}
+
+// htpasswdSource returns the configured htpasswd source, described for logs
+// as the file path, "env:<name>" or "inline", and the function opening it.
+// The function is nil when no source is configured.
+func (h *MaintenanceHandler) htpasswdSource() (string, func() (io.Reader, error)) {
+	switch {
+	case h.HtpasswdFile != "":
+		return h.HtpasswdFile, func() (io.Reader, error) {
+			content, err := os.ReadFile(h.HtpasswdFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read htpasswd file '%s': %v", h.HtpasswdFile, err)
+			}
+			return bytes.NewReader(content), nil
+		}
+	case h.HtpasswdEnv != "":
+		return "env:" + h.HtpasswdEnv, func() (io.Reader, error) {
+			content, ok := os.LookupEnv(h.HtpasswdEnv)
+			if !ok {
+				return nil, fmt.Errorf("htpasswd environment variable '%s' is not set", h.HtpasswdEnv)
+			}
+			return strings.NewReader(content), nil
+		}
+	case len(h.HtpasswdEntries) > 0:
+		return "inline", func() (io.Reader, error) {
+			return strings.NewReader(strings.Join(h.HtpasswdEntries, "\n")), nil
+		}
+	default:
+		return "", nil
+	}
+}
+
+// htpasswdConfigured reports whether HTTP Basic Authentication is configured
+// with at least one user
+func (h *MaintenanceHandler) htpasswdConfigured() bool {
+	return h.htpasswdUserCount() > 0
+}
+
+// htpasswdUserCount returns the number of users of the htpasswd
+func (h *MaintenanceHandler) htpasswdUserCount() int {
+	h.filesMux.RLock()
+	defer h.filesMux.RUnlock()
+	return len(h.htpasswdEntries)
+}
+
+// parseHtpasswd stores the credentials of htpasswd content, returning the
+// number of users loaded
+func (h *MaintenanceHandler) parseHtpasswd(reader io.Reader) (int, error) {
+	h.htpasswdEntries = make(map[string][]byte)
+	h.htpasswdExpiry = make(map[string]time.Time)
+
+	return h.loadHtpasswd(reader, h.htpasswdEntries, h.htpasswdExpiry)
+}
+
+// loadHtpasswd parses htpasswd content into the given password hashes and
+// access expiries by username, returning the number of users loaded
+func (h *MaintenanceHandler) loadHtpasswd(reader io.Reader, entries map[string][]byte, expiry map[string]time.Time) (int, error) {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read htpasswd content: %v", err)
+	}
+
+	loadedUsers := 0
+
+	for _, entry := range scanLines(content) {
+		line, lineNum := entry.text, entry.number
+
+		// Parse htpasswd line (username:password_hash)
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			if h.logger != nil {
+				h.logger.Error("Invalid htpasswd format", zap.Int("line", lineNum), zap.String("line", line))
+			}
+			return 0, fmt.Errorf("invalid htpasswd format at line %d: expected 'username:password_hash'", lineNum)
+		}
+
+		username := strings.TrimSpace(parts[0])
+		passwordHash := strings.TrimSpace(parts[1])
+
+		// Optional access expiry (username:password_hash:expires=<rfc3339>)
+		var expiresAt time.Time
+		if index := strings.LastIndex(passwordHash, ":expires="); index != -1 {
+			expiresAt, err = time.Parse(time.RFC3339, strings.TrimSpace(passwordHash[index+len(":expires="):]))
+			if err != nil {
+				if h.logger != nil {
+					h.logger.Error("Invalid expiry in htpasswd", zap.Int("line", lineNum), zap.String("username", username), zap.Error(err))
+				}
+				return 0, fmt.Errorf("invalid expiry at line %d: %v", lineNum, err)
+			}
+			passwordHash = strings.TrimSpace(passwordHash[:index])
+		}
+
+		if username == "" {
+			if h.logger != nil {
+				h.logger.Error("Empty username in htpasswd", zap.Int("line", lineNum))
+			}
+			return 0, fmt.Errorf("empty username at line %d", lineNum)
+		}
+
+		if passwordHash == "" {
+			if h.logger != nil {
+				h.logger.Error("Empty password hash in htpasswd", zap.Int("line", lineNum), zap.String("username", username))
+			}
+			return 0, fmt.Errorf("empty password hash at line %d", lineNum)
+		}
+
+		if err := h.checkBcryptCost(username, []byte(passwordHash)); err != nil {
+			return 0, fmt.Errorf("%v at line %d", err, lineNum)
+		}
+
+		// Store the password hash
+		entries[username] = []byte(passwordHash)
+		if !expiresAt.IsZero() {
+			expiry[username] = expiresAt
+		}
+		loadedUsers++
+
+		if h.logger != nil {
+			h.logger.Debug("Loaded user from htpasswd", zap.String("username", username))
+		}
+	}
+
+	return loadedUsers, nil
+}
+
+// checkBcryptCost warns about, or rejects, bcrypt hashes with a cost factor
+// below the configured minimum. Other hash types are left to verifyPassword.
+func (h *MaintenanceHandler) checkBcryptCost(username string, passwordHash []byte) error {
+	cost, err := bcrypt.Cost(passwordHash)
+	if err != nil {
+		return nil
+	}
+
+	minCost := h.MinBcryptCost
+	if minCost <= 0 {
+		minCost = bcrypt.DefaultCost
+	}
+	if cost >= minCost {
+		return nil
+	}
+
+	if h.WeakBcryptCost == failureModeError {
+		return fmt.Errorf("bcrypt cost %d of user '%s' is below the minimum of %d", cost, username, minCost)
+	}
+	if h.logger != nil {
+		h.logger.Warn("Weak bcrypt cost in htpasswd",
+			zap.String("username", username),
+			zap.Int("cost", cost),
+			zap.Int("min_cost", minCost),
+		)
+	}
+
+	return nil
+}
+
+// isAuthenticated checks if the request has valid HTTP Basic Authentication
+func (h *MaintenanceHandler) isAuthenticated(r *http.Request) bool {
+	realmCredentials := h.credentialsFor(r)
+	if len(realmCredentials.entries) == 0 {
+		if h.logger != nil {
+			h.logger.Debug("No authentication configured")
+		}
+		return false // No authentication configured
+	}
+
+	// Get Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		if h.logger != nil {
+			h.logger.Debug("No Authorization header present")
+		}
+		return false
+	}
+
+	// Check if it's Basic authentication
+	if !strings.HasPrefix(authHeader, "Basic ") {
+		if h.logger != nil {
+			h.logger.Debug("Authorization header is not Basic", zap.String("auth_header", authHeader))
+		}
+		return false
+	}
+
+	// Extract and decode credentials
+	encodedCredentials := strings.TrimPrefix(authHeader, "Basic ")
+	decodedCredentials, err := base64.StdEncoding.DecodeString(encodedCredentials)
+	if err != nil {
+		if h.logger != nil {
+			h.logger.Debug("Failed to decode base64 credentials", zap.Error(err))
+		}
+		return false
+	}
+
+	// Split username and password
+	credentials := strings.SplitN(string(decodedCredentials), ":", 2)
+	if len(credentials) != 2 {
+		if h.logger != nil {
+			h.logger.Debug("Invalid credentials format", zap.String("decoded", string(decodedCredentials)))
+		}
+		return false
+	}
+
+	username := credentials[0]
+	password := credentials[1]
+
+	if h.logger != nil {
+		h.logger.Debug("Checking authentication",
+			zap.String("realm", realmCredentials.realm),
+			zap.String("username", username),
+			zap.Bool("password_provided", password != ""),
+		)
+	}
+
+	// Get stored password hash
+	storedHash, exists := realmCredentials.entries[username]
+	if !exists {
+		if h.logger != nil {
+			h.logger.Debug("User not found in htpasswd", zap.String("username", username))
+		}
+		return false
+	}
+
+	// Temporary accounts are rejected once expired, whatever the password
+	if expiresAt, ok := realmCredentials.expiry[username]; ok && !time.Now().Before(expiresAt) {
+		if h.logger != nil {
+			h.logger.Debug("User access expired", zap.String("username", username), zap.Time("expires_at", expiresAt))
+		}
+		return false
+	}
+
+	// Verify password
+	result := h.verifyPassword(password, storedHash)
+	if h.logger != nil {
+		h.logger.Debug("Password verification result",
+			zap.String("username", username),
+			zap.Bool("valid", result),
+		)
+	}
+	return result
+}
+
+// verifyPassword verifies a password against a stored hash
+func (h *MaintenanceHandler) verifyPassword(password string, storedHash []byte) bool {
+	// Check if it's a bcrypt hash (starts with $2a$, $2b$, or $2y$)
+	if len(storedHash) >= 4 && (storedHash[0] == '$' && storedHash[1] == '2') {
+		// bcrypt hash
+		err := bcrypt.CompareHashAndPassword(storedHash, []byte(password))
+		return err == nil
+	}
+
+	// For other hash types (MD5, SHA1, etc.), we would need additional libraries
+	// For now, we only support bcrypt which is the most secure option
+	return false
+}
+
+// isIPAllowed checks if an IP address is allowed using pre-parsed networks
+func (h *MaintenanceHandler) isIPAllowed(clientIP string) bool {
+	_, allowed := h.allowedEntry(clientIP)
+	return allowed
+}
+
+// allowedEntry returns the allowed_ips entry letting clientIP through
+func (h *MaintenanceHandler) allowedEntry(clientIP string) (string, bool) {
+	// Parse client IP
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return "", false
+	}
+
+	// Entries are evaluated in order, the last one matching deciding
+	h.ipMux.RLock()
+	defer h.ipMux.RUnlock()
+	for _, rule := range slices.Backward(h.allowRules) {
+		if h.allowRuleMatchesLocked(rule, ip) {
+			if rule.negated {
+				return "", false
+			}
+			return rule.entry, true
+		}
+	}
+
+	return "", false
+}
+
+// allowRuleMatchesLocked reports whether ip is covered by an allowed_ips
+// entry. The caller must hold ipMux.
+func (h *MaintenanceHandler) allowRuleMatchesLocked(rule allowRule, ip net.IP) bool {
+	for _, network := range rule.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	// Check addresses resolved from allowed hostnames
+	for _, resolvedIP := range h.resolvedHostIPs[rule.hostname] {
+		if ip.Equal(resolvedIP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTrustedProxy checks whether an IP belongs to the trusted proxy list
+func (h *MaintenanceHandler) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, proxyIP := range h.trustedProxyIPs {
+		if ip.Equal(proxyIP) {
+			return true
+		}
+	}
+
+	for _, network := range h.trustedProxyNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodePaths decodes the configured paths the same way as r.URL.Path, which
+// is already decoded. The configuration is left as written, so provisioning
+// it again does not decode it twice.
+func (h *MaintenanceHandler) decodePaths() error {
+	var err error
+	if h.bypassPathsDecoded, err = decodePathList(h.BypassPaths, "bypass path"); err != nil {
+		return err
+	}
+	if h.apiPathsDecoded, err = decodePathList(h.APIPaths, "api_paths path"); err != nil {
+		return err
+	}
+	if h.alwaysBlockPathsDecoded, err = decodePathList(h.AlwaysBlockPaths, "always_block_paths path"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// decodePathList returns the unescaped copy of configured paths
+func decodePathList(paths []string, option string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	decoded := make([]string, len(paths))
+	for i, configured := range paths {
+		path, err := url.PathUnescape(configured)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", option, configured, err)
+		}
+		decoded[i] = path
+	}
+
+	return decoded, nil
+}
+
+// isPathBypassed checks if a request path should bypass maintenance mode completely
+func (h *MaintenanceHandler) isPathBypassed(path string) bool {
+	return matchPaths(path, h.bypassPathsDecoded)
+}
+
+// isKnownHost reports whether only_unknown_hosts is enabled and the request
+// host is one of the known hosts
+func (h *MaintenanceHandler) isKnownHost(r *http.Request) bool {
+	if !h.OnlyUnknownHosts {
+		return false
+	}
+
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = normalizeHost(host)
+
+	for _, knownHost := range h.KnownHosts {
+		if host == knownHost {
+			return true
+		}
+		if parent, ok := strings.CutPrefix(knownHost, "*."); ok && strings.HasSuffix(host, "."+parent) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeHost lowercases a host name and strips its trailing dot
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// isPathBlocked checks if the request path is retired by always_block_paths
+func (h *MaintenanceHandler) isPathBlocked(r *http.Request) bool {
+	return matchPaths(cleanRequestPath(r.URL.Path), h.alwaysBlockPathsDecoded)
+}
+
+// matchPaths reports whether path matches one of the patterns, exactly or
+// below a pattern ending with "/*". Trailing slashes are ignored, "/health"
+// and "/health/" match each other.
+func matchPaths(path string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+
+	// Normalize path for comparison
+	path = trimTrailingSlash(path)
+
+	for _, pattern := range patterns {
+		pattern = trimTrailingSlash(pattern)
+
+		// Exact match
+		if path == pattern {
+			return true
+		}
+
+		// Prefix match (for directories)
+		if strings.HasSuffix(pattern, "/*") {
+			prefix := strings.TrimSuffix(pattern, "/*")
+			if prefix == "" {
+				prefix = "/"
+			}
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// trimTrailingSlash strips the trailing slash of the path of a match target,
+// before its query string with bypass_match_full_uri
+func trimTrailingSlash(target string) string {
+	path, query, hasQuery := strings.Cut(target, "?")
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		path = "/"
+	}
+	if hasQuery {
+		return path + "?" + query
+	}
+
+	return path
+}
+
+// Values of the bypass_header response header
+const (
+	bypassReasonIP   = "ip"
+	bypassReasonCert = "cert"
+	bypassReasonAuth = "auth"
+	bypassReasonUser = "user"
+	bypassReasonPath = "path"
+)
+
+// bypassClientCert returns the name of the verified client certificate
+// matching BypassClientCertCN. Unverified certificates are ignored, since
+// anyone can present a certificate with any name.
+func (h *MaintenanceHandler) bypassClientCert(r *http.Request) (string, bool) {
+	if len(h.BypassClientCertCN) == 0 || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, name := range names {
+		if name != "" && slices.Contains(h.BypassClientCertCN, name) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// authUserIDPlaceholder is set by Caddy's authentication handler
+const authUserIDPlaceholder = "http.auth.user.id"
+
+// bypassUser returns the user authenticated by Caddy's authentication
+// handler when it is listed in BypassUsers
+func (h *MaintenanceHandler) bypassUser(r *http.Request) (string, bool) {
+	if len(h.BypassUsers) == 0 {
+		return "", false
+	}
+
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return "", false
+	}
+	userID, _ := repl.GetString(authUserIDPlaceholder)
+	if userID == "" {
+		return "", false
+	}
+
+	return userID, slices.Contains(h.BypassUsers, userID)
+}
+
+// requestIDHeader carries the request ID of maintenance responses
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of request IDs taken from clients
+const maxRequestIDLength = 128
+
+// requestUUIDPlaceholder is Caddy's request ID, also found in its access logs
+const requestUUIDPlaceholder = "http.request.uuid"
+
+// requestID returns the ID of the request: the X-Request-ID header when it
+// holds a valid ID, else Caddy's request ID, else a generated one
+func requestID(r *http.Request) string {
+	if id := strings.TrimSpace(r.Header.Get(requestIDHeader)); validRequestID(id) {
+		return id
+	}
+
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		if id, _ := repl.GetString(requestUUIDPlaceholder); id != "" {
+			return id
+		}
+	}
+
+	return rand.Text()
+}
+
+// validRequestID reports whether a client supplied request ID can be
+// echoed back, i.e. it is not too long and only has visible ASCII characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// maintenanceNoticeValue is the value of the maintenance notice header and
+// cookie
+const maintenanceNoticeValue = "active"
+
+// setBypassHeaders tells the client it sees the live site despite maintenance
+func (h *MaintenanceHandler) setBypassHeaders(w http.ResponseWriter, r *http.Request, reason string) {
+	if h.BypassHeader != "" {
+		w.Header().Set(h.BypassHeader, reason)
+	}
+	if h.MaintenanceNoticeHeader != "" {
+		w.Header().Set(h.MaintenanceNoticeHeader, maintenanceNoticeValue)
+	}
+	if h.MaintenanceNoticeCookie != "" {
+		http.SetCookie(w, h.maintenanceNoticeCookie(r, maintenanceNoticeValue, 0))
+	}
+}
+
+// clearMaintenanceNotice expires the maintenance notice cookie of a client
+// still holding it once maintenance is disabled
+func (h *MaintenanceHandler) clearMaintenanceNotice(w http.ResponseWriter, r *http.Request) {
+	if h.MaintenanceNoticeCookie == "" {
+		return
+	}
+	if _, err := r.Cookie(h.MaintenanceNoticeCookie); err != nil {
+		return
+	}
+
+	http.SetCookie(w, h.maintenanceNoticeCookie(r, "", -1))
+}
+
+// maintenanceNoticeCookie returns the maintenance notice cookie, a session
+// cookie readable by scripts of the operator's tooling
+func (h *MaintenanceHandler) maintenanceNoticeCookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     h.MaintenanceNoticeCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// defaultAckCookieTTL is how long the ack cookie suppresses the full page
+// when ack_cookie_ttl is not set
+const defaultAckCookieTTL = time.Hour
+
+// ackCookieValue identifies the maintenance acknowledged by the ack cookie,
+// so that a cookie left from an earlier maintenance does not hide the page
+// of the next one
+func ackCookieValue(data templateData) string {
+	if data.StartedAt.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(data.StartedAt.Unix(), 10)
+}
+
+// hasAcknowledged reports whether the client already got the full
+// maintenance page of the ongoing maintenance
+func (h *MaintenanceHandler) hasAcknowledged(r *http.Request, data templateData) bool {
+	cookie, err := r.Cookie(h.AckCookie)
+	return err == nil && cookie.Value == ackCookieValue(data)
+}
+
+// ackCookie returns the cookie set along with the full maintenance page
+func (h *MaintenanceHandler) ackCookie(r *http.Request, data templateData) *http.Cookie {
+	ttl := time.Duration(h.AckCookieTTL)
+	if ttl == 0 {
+		ttl = defaultAckCookieTTL
+	}
+
+	return &http.Cookie{
+		Name:     h.AckCookie,
+		Value:    ackCookieValue(data),
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// bypassMatchTarget returns the request target used for bypass path matching.
+// The decoded path is cleaned first so that dot segments and duplicate
+// slashes (e.g. /health/../admin) cannot smuggle a request through a bypass
+func (h *MaintenanceHandler) bypassMatchTarget(r *http.Request) string {
+	target := cleanRequestPath(r.URL.Path)
+	if h.BypassMatchFullURI && r.URL.RawQuery != "" {
+		return target + "?" + r.URL.RawQuery
+	}
+
+	return target
+}
+
+// cleanRequestPath returns the shortest rooted equivalent of a decoded path
+func cleanRequestPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*MaintenanceHandler)(nil)
+	_ caddy.Validator             = (*MaintenanceHandler)(nil)
+	_ caddy.CleanerUpper          = (*MaintenanceHandler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*MaintenanceHandler)(nil)
+)
+
+// remoteHost strips the port from a remote address. Some transports (unix
+// sockets, tests) set a bare IP without port, possibly in IPv6 brackets, in
+// which case the whole address is the host.
+func remoteHost(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+
+	remoteAddr = strings.TrimSpace(remoteAddr)
+	if strings.HasPrefix(remoteAddr, "[") && strings.HasSuffix(remoteAddr, "]") {
+		return remoteAddr[1 : len(remoteAddr)-1]
+	}
+
+	return remoteAddr
+}
+
+// getClientIP returns the effective client IP, optionally using forwarded headers
+func (h *MaintenanceHandler) getClientIP(r *http.Request) string {
+	clientIP := remoteHost(r.RemoteAddr)
+
+	if !h.UseForwardedHeaders {
+		return clientIP
+	}
+
+	remoteIP := net.ParseIP(clientIP)
+	if remoteIP == nil || !h.isTrustedProxy(remoteIP) {
+		return clientIP
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		parts := strings.Split(xff, ",")
+		for i := len(parts) - 1; i >= 0; i-- {
+			candidate := strings.TrimSpace(parts[i])
+			if candidate == "" {
+				continue
+			}
+
+			ip := net.ParseIP(candidate)
+			if ip == nil {
+				continue
+			}
+
+			if h.isTrustedProxy(ip) {
+				continue
+			}
+
+			return candidate
+		}
+	}
+
+	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
+		if ip := net.ParseIP(xrip); ip != nil && !h.isTrustedProxy(ip) {
+			return xrip
+		}
+	}
+
+	return clientIP
+}
+
+// ServeHTTP implements caddyhttp.MiddlewareHandler.
+func (h *MaintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	h.enabledMux.RLock()
+	enabled := h.enabled
+	inGracePeriod := h.inGracePeriodLocked(time.Now())
+	requestRetentionTimeout := h.RequestRetentionModeTimeout
+	// A lockdown is not temporary, there is no point in retaining requests
+	temporaryModeEnabled := requestRetentionTimeout > 0 && !h.Lockdown
+	h.enabledMux.RUnlock()
+
+	setMaintenancePlaceholder(r, enabled)
+
+	// Retired paths are blocked in both modes and for every client
+	if h.isPathBlocked(r) {
+		status := h.AlwaysBlockStatus
+		if status == 0 {
+			status = http.StatusGone
+		}
+		if h.logger != nil {
+			h.logger.Debug("Path blocked", zap.String("path", r.URL.Path), zap.Int("status", status))
+		}
+		return writeMaintenancePage(r, w, h, status)
+	}
+
+	// The status path is answered in both modes and never blocked by maintenance
+	if h.StatusPath != "" && r.URL.Path == h.StatusPath && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		return h.serveStatus(w)
+	}
+
+	if !enabled || inGracePeriod {
+		if len(h.UpstreamErrorStatuses) > 0 {
+			return h.serveWithUpstreamErrorPage(w, r, next)
+		}
+		return h.serveDisabled(w, r, next)
+	}
+
+	// Known hosts are not concerned by maintenance of the unknown ones
+	if h.isKnownHost(r) {
+		if h.logger != nil {
+			h.logger.Debug("Known host, forwarding request", zap.String("host", r.Host))
+		}
+		return next.ServeHTTP(w, r)
+	}
+
+	// Check if path should bypass maintenance mode completely
+	if bypassTarget := h.bypassMatchTarget(r); h.isPathBypassed(bypassTarget) || h.isSchemePathBypassed(r, bypassTarget) {
+		if h.logger != nil {
+			h.logger.Debug("Path bypassed, forwarding request",
+				zap.String("path", bypassTarget),
+				zap.Strings("bypass_paths", h.BypassPaths),
+			)
+		}
+		h.setBypassHeaders(w, r, bypassReasonPath)
+		return next.ServeHTTP(w, r)
+	}
+
+	// Check if client IP is in allowed list
+	clientIP := h.getClientIP(r)
+
+	// Debug logging
+	if h.logger != nil {
+		h.logger.Debug("Maintenance mode active",
+			zap.String("client_ip", clientIP),
+			zap.String("user_agent", r.UserAgent()),
+			zap.String("path", r.URL.Path),
+			zap.Bool("htpasswd_configured", h.htpasswdConfigured()),
+			zap.Int("htpasswd_entries_count", h.htpasswdUserCount()),
+		)
+	}
+
+	if entry, allowed := h.allowedEntry(clientIP); allowed {
+		recordBypass(entry, time.Now())
+		if h.logger != nil {
+			h.logger.Debug("IP allowed, bypassing maintenance", zap.String("client_ip", clientIP), zap.String("allowed_entry", entry))
+		}
+		h.setBypassHeaders(w, r, bypassReasonIP)
+		return next.ServeHTTP(w, r)
+	}
+
+	// Entries scoped to the request scheme come in addition to allowed_ips
+	if entry, allowed := h.schemeAllowedEntry(r, clientIP); allowed {
+		recordBypass(entry, time.Now())
+		if h.logger != nil {
+			h.logger.Debug("IP allowed for the request scheme, bypassing maintenance", zap.String("client_ip", clientIP), zap.Bool("tls", r.TLS != nil), zap.String("allowed_entry", entry))
+		}
+		h.setBypassHeaders(w, r, bypassReasonIP)
+		return next.ServeHTTP(w, r)
+	}
+
+	// Check if the client presented a grant issued through the admin API
+	if bypassGrant(r) {
+		if h.logger != nil {
+			h.logger.Debug("Grant presented, bypassing maintenance", zap.String("client_ip", clientIP))
+		}
+		h.setBypassHeaders(w, r, bypassReasonGrant)
+		return next.ServeHTTP(w, r)
+	}
+
+	// Check if the client presented an allowed TLS client certificate
+	if name, ok := h.bypassClientCert(r); ok {
+		if h.logger != nil {
+			h.logger.Debug("Client certificate allowed, bypassing maintenance", zap.String("name", name))
+		}
+		h.setBypassHeaders(w, r, bypassReasonCert)
+		return next.ServeHTTP(w, r)
+	}
+
+	// Check if an earlier authentication handler identified a bypass user
+	if userID, ok := h.bypassUser(r); ok {
+		if h.logger != nil {
+			h.logger.Debug("Authenticated user allowed, bypassing maintenance", zap.String("user_id", userID))
+		}
+		h.setBypassHeaders(w, r, bypassReasonUser)
+		return next.ServeHTTP(w, r)
+	}
+
+	// Check if client is authenticated via HTTP Basic Auth
+	authResult := h.isAuthenticated(r)
+	if h.logger != nil {
+		h.logger.Debug("Authentication check result",
+			zap.Bool("authenticated", authResult),
+			zap.String("auth_header", r.Header.Get("Authorization")),
+		)
+	}
+
+	if authResult {
+		h.setBypassHeaders(w, r, bypassReasonAuth)
+		return next.ServeHTTP(w, r)
+	}
+
+	// Request retention mode disabled, serve maintenance page now
+	if !temporaryModeEnabled {
+		if h.logger != nil {
+			h.logger.Debug("Serving maintenance page", zap.String("client_ip", clientIP))
+		}
+		return h.serveMaintenancePageOrFailOpen(w, r, next)
+	}
+
+	// Request retention mode enabled, retain request for the predefined period
+	timer := time.NewTimer(time.Duration(requestRetentionTimeout) * time.Second)
+	defer timer.Stop()
+	resolve := h.retentionMetrics.hold()
+	heldSince := time.Now()
+	for {
+		// Wait for the timer to expire, a context to be cancelled or the maintenance mode to be disabled
+		// The request context is cancelled when the client connection is closed, the handler
+		// context on Caddy config reload or server graceful shutdown (SIGTERM)....
+		select {
+		// Timeout reached, serve maintenance page
+		case <-timer.C:
+			resolve(retentionOutcomeTimedOut)
+			setHeldHeader(w, heldSince)
+			return h.serveMaintenancePageOrFailOpen(w, r, next)
+		// Client went away, nobody is left to read a maintenance page
+		case <-r.Context().Done():
+			resolve(retentionOutcomeCancelled)
+			if h.logger != nil {
+				h.logger.Debug("Client disconnected during request retention",
+					zap.String("client_ip", clientIP),
+					zap.Error(r.Context().Err()),
+				)
+			}
+			return nil
+		// Handler context cancelled, serve maintenance page
+		case <-h.ctx.Done():
+			// The shutdown may already have closed the client connection,
+			// only requests with a client left are released
+			if h.ReleaseOnShutdown && r.Context().Err() == nil {
+				resolve(retentionOutcomeShutdownReleased)
+				setHeldHeader(w, heldSince)
+				if h.logger != nil {
+					h.logger.Debug("Server shutting down during request retention, forwarding request",
+						zap.String("client_ip", clientIP),
+					)
+				}
+				return next.ServeHTTP(w, r)
+			}
+			resolve(retentionOutcomeShutdown)
+			setHeldHeader(w, heldSince)
+			if h.logger != nil {
+				h.logger.Debug("Server shutting down during request retention, serving maintenance page",
+					zap.String("client_ip", clientIP),
+				)
+			}
+			return h.serveMaintenancePageOrFailOpen(w, r, next)
+		// Check every second the "enabled" state
+		case <-time.After(1000 * time.Millisecond):
+			h.enabledMux.RLock()
+			enabled := h.enabled
+			h.enabledMux.RUnlock()
+			if !enabled {
+				// Maintenance mode disabled, forward the request
+				resolve(retentionOutcomeReleased)
+				setHeldHeader(w, heldSince)
+				return next.ServeHTTP(w, r)
+			}
+		}
+	}
+}
+
+// heldHeader tells the client how long its request was held in retention
+// mode before being answered, in seconds
+const heldHeader = "X-Maintenance-Held-Seconds"
+
+// setHeldHeader sets the held header of a request retained since heldSince
+func setHeldHeader(w http.ResponseWriter, heldSince time.Time) {
+	w.Header().Set(heldHeader, strconv.FormatFloat(time.Since(heldSince).Seconds(), 'f', 3, 64))
+}
+
+// inGracePeriodLocked reports whether maintenance was switched on less than
+// GracePeriod before now. The caller must hold enabledMux.
+func (h *MaintenanceHandler) inGracePeriodLocked(now time.Time) bool {
+	return h.enabled && h.GracePeriod > 0 && !h.graceStart.IsZero() && now.Sub(h.graceStart) < time.Duration(h.GracePeriod)
+}
+
+// serveDisabled forwards a request while maintenance is disabled
+func (h *MaintenanceHandler) serveDisabled(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	h.clearMaintenanceNotice(w, r)
+
+	if h.inPreNotice(time.Now()) {
+		return h.serveWithPreNotice(w, r, next)
+	}
+
+	return next.ServeHTTP(w, r)
+}
+
+// publicStatus is the payload served on the data plane status path
+type publicStatus struct {
+	Enabled      bool       `json:"enabled"`
+	RetryAfter   int        `json:"retry_after,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	EstimatedEnd *time.Time `json:"estimated_end,omitempty"`
+}
+
+// serveStatus writes the current maintenance status as JSON
+func (h *MaintenanceHandler) serveStatus(w http.ResponseWriter) error {
+	h.enabledMux.RLock()
+	status := publicStatus{Enabled: h.enabled}
+	if h.enabled {
+		status.RetryAfter = h.effectiveRetryAfterLocked()
+		startedAt := h.localTime(h.startedAt)
+		status.StartedAt = &startedAt
+	}
+	if !h.estimatedEnd.IsZero() {
+		estimatedEnd := h.localTime(h.estimatedEnd)
+		status.EstimatedEnd = &estimatedEnd
+	}
+	h.enabledMux.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(status)
+}
+
+// defaultAuthRealm is the realm announced when auth_realm is not configured
+const defaultAuthRealm = "Maintenance Mode"
+
+// authRealm returns the configured realm, defaulting when it is blank
+func (h *MaintenanceHandler) authRealm() string {
+	if strings.TrimSpace(h.AuthRealm) == "" {
+		return defaultAuthRealm
+	}
+
+	return h.AuthRealm
+}
+
+// wwwAuthenticate builds the WWW-Authenticate challenge for realm, announcing
+// the UTF-8 charset of RFC 7617 when configured
+func (h *MaintenanceHandler) wwwAuthenticate(realm string) string {
+	challenge := fmt.Sprintf(`Basic realm="%s"`, realm)
+	if h.AuthCharsetUTF8 {
+		challenge += `, charset="UTF-8"`
+	}
+
+	return challenge
+}
+
+// maintenanceVar is the request variable and access log field set when the
+// maintenance page is served
+const maintenanceVar = "maintenance"
+
+// markMaintenanceServed flags the request as answered by maintenance mode,
+// both as a {http.vars.maintenance} variable and in Caddy's access log
+func markMaintenanceServed(r *http.Request) {
+	caddyhttp.SetVar(r.Context(), maintenanceVar, true)
+	if extra, ok := r.Context().Value(caddyhttp.ExtraLogFieldsCtxKey).(*caddyhttp.ExtraLogFields); ok {
+		extra.Set(zap.Bool(maintenanceVar, true))
+	}
+}
+
+// maintenanceEnabledPlaceholder is the placeholder reporting whether
+// maintenance is enabled, to the directives running after the handler
+const maintenanceEnabledPlaceholder = "http.maintenance.enabled"
+
+// setMaintenancePlaceholder sets the {http.maintenance.enabled} placeholder
+// on the request replacer
+func setMaintenancePlaceholder(r *http.Request, enabled bool) {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set(maintenanceEnabledPlaceholder, enabled)
+	}
+}
+
+// effectiveRetryAfterLocked returns the Retry-After value in seconds,
+// falling back to the default. The caller must hold enabledMux.
+func (h *MaintenanceHandler) effectiveRetryAfterLocked() int {
+	if h.RetryAfter > 0 {
+		return h.RetryAfter
+	}
+
+	return defaultRetryAfter
+}
+
+// clampRetryAfter bounds a retry_after value supplied via the admin API by
+// retry_after_min and retry_after_max, logging when it gets clamped
+func (h *MaintenanceHandler) clampRetryAfter(retryAfter int) int {
+	clamped := retryAfter
+	if h.RetryAfterMin > 0 && clamped < h.RetryAfterMin {
+		clamped = h.RetryAfterMin
+	}
+	if h.RetryAfterMax > 0 && clamped > h.RetryAfterMax {
+		clamped = h.RetryAfterMax
+	}
+
+	if clamped != retryAfter && h.logger != nil {
+		h.logger.Warn("Clamped retry_after supplied via admin API",
+			zap.Int("requested", retryAfter),
+			zap.Int("retry_after", clamped),
+			zap.Int("retry_after_min", h.RetryAfterMin),
+			zap.Int("retry_after_max", h.RetryAfterMax),
+		)
+	}
+
+	return clamped
+}
+
+// effectiveRetryAfter returns the Retry-After value in seconds, falling back to the default
+func (h *MaintenanceHandler) effectiveRetryAfter() int {
+	h.enabledMux.RLock()
+	defer h.enabledMux.RUnlock()
+
+	return h.effectiveRetryAfterLocked()
+}
+
+func serveMaintenancePage(r *http.Request, w http.ResponseWriter, h *MaintenanceHandler) error {
+	return writeMaintenancePage(r, w, h, 0)
+}
+
+// serveMaintenancePageOrFailOpen serves the maintenance page, forwarding the
+// request to the next handlers instead when it fails with fail_open. A page
+// already partly written cannot be taken back, its error is returned.
+func (h *MaintenanceHandler) serveMaintenancePageOrFailOpen(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if !h.FailOpen {
+		return serveMaintenancePage(r, w, h)
+	}
+
+	header := w.Header().Clone()
+	recorder := &failOpenResponseWriter{ResponseWriter: w}
+	err := serveMaintenancePage(r, recorder, h)
+	if err == nil || recorder.wroteHeader {
+		return err
+	}
+
+	if h.logger != nil {
+		h.logger.Error("Failed to serve maintenance page, forwarding request", zap.Error(err))
+	}
+	// Drop the headers set for the maintenance page
+	clear(w.Header())
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	return next.ServeHTTP(w, r)
+}
+
+// failOpenResponseWriter records whether the maintenance page started to be
+// written
+type failOpenResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *failOpenResponseWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *failOpenResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+// writeMaintenancePage serves the maintenance page. With a fixed status, e.g.
+// in place of an upstream error, the page is neither a lockdown nor an
+// authentication prompt, which only apply to an enabled maintenance.
+func writeMaintenancePage(r *http.Request, w http.ResponseWriter, h *MaintenanceHandler, fixedStatus int) error {
+	// Slow down clients ignoring Retry-After, but don't hold on to
+	// connections whose client already went away
+	if h.ResponseDelay > 0 {
+		timer := time.NewTimer(time.Duration(h.ResponseDelay) * time.Millisecond)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+			return nil
+		}
+	}
+
+	markMaintenanceServed(r)
+
+	data := h.templateData()
+	data.Protocol = r.Proto
+	if fixedStatus != 0 {
+		data.Lockdown = false
+	}
+	if h.RequestID {
+		data.RequestID = requestID(r)
+		w.Header().Set(requestIDHeader, data.RequestID)
+	}
+
+	// The page is always sent in full, never as a partial response to a
+	// Range request, even if an earlier handler advertised range support
+	w.Header().Del("Accept-Ranges")
+	w.Header().Del("Content-Range")
+
+	// Set Retry-After header with default value if not specified, a
+	// lockdown has no expected end and other fixed statuses are not retried
+	retryable := fixedStatus == 0 || fixedStatus == http.StatusServiceUnavailable
+	if !data.Lockdown && !h.DisableRetryAfter && retryable {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", data.RetryAfter))
+	}
+
+	// The body depends on content negotiation, let caches key on it
+	w.Header().Set("Vary", strings.Join(h.varyHeaders(), ", "))
+
+	h.setContentSecurityPolicy(w, &data)
+
+	// Check if HTTP Basic Auth is configured
+	status := http.StatusServiceUnavailable
+	if data.Lockdown {
+		status = http.StatusForbidden
+	}
+	if fixedStatus != 0 {
+		status = fixedStatus
+	} else if credentials := h.credentialsFor(r); len(credentials.entries) > 0 {
+		w.Header().Set("WWW-Authenticate", h.wwwAuthenticate(credentials.realm))
+		// Return 401 to prompt for authentication
+		status = http.StatusUnauthorized
+		if h.logger != nil {
+			h.logger.Debug("Returning 401 Unauthorized to prompt for authentication",
+				zap.String("realm", credentials.realm),
+				zap.String("htpasswd_file", credentials.source),
+				zap.Int("users_configured", len(credentials.entries)),
+			)
+		}
+	} else if h.logger != nil {
+		// No authentication configured, return 503 for maintenance
+		h.logger.Debug("Returning maintenance status (no authentication configured)", zap.Int("status", status))
+	}
+
+	// API consumers may handle a 429 better than a 503, the status of the
+	// maintenance can be set per representation
+	representation := h.negotiateRepresentation(r)
+	if override := h.RepresentationStatus[representation]; override != 0 && status == http.StatusServiceUnavailable && fixedStatus == 0 {
+		status = override
+		if status == http.StatusTooManyRequests && !h.DisableRetryAfter {
+			w.Header().Set("RateLimit-Remaining", "0")
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(data.RetryAfter))
+		}
+	}
+
+	// Browsers that already got the full page during this maintenance get
+	// the status only
+	acknowledgeable := h.AckCookie != "" && representation == representationHTML && !data.Lockdown && h.snapshot == nil
+	if h.isMinimalResponse(r) || (acknowledgeable && h.hasAcknowledged(r, data)) {
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(status)
+		return nil
+	}
+	if acknowledgeable {
+		http.SetCookie(w, h.ackCookie(r, data))
+	}
+
+	var err error
+	switch {
+	case representation == representationJSON:
+		h.filesMux.RLock()
+		jsonTemplate := h.JSONTemplate
+		h.filesMux.RUnlock()
+		err = serveJSON(w, status, data, jsonTemplate, h.JSONKeys)
+	case representation == representationText:
+		err = serveText(w, status, data)
+	default:
+		err = h.writeHTMLMaintenancePage(w, r, status, data)
+	}
+
+	// A client that went away is not an error worth reporting to Caddy
+	if err != nil && isClientGoneError(err) {
+		if h.logger != nil {
+			h.logger.Debug("Client went away while writing maintenance page", zap.Error(err))
+		}
+		return nil
+	}
+
+	return err
+}
+
+// setContentSecurityPolicy sets the Content-Security-Policy header, with a
+// fresh nonce in data when csp is enabled
+func (h *MaintenanceHandler) setContentSecurityPolicy(w http.ResponseWriter, data *templateData) {
+	policy := h.ContentSecurityPolicy
+	if h.CSP {
+		data.Nonce = rand.Text()
+		if policy == "" {
+			policy = defaultNonceCSP
+		}
+		policy = strings.ReplaceAll(policy, "{nonce}", data.Nonce)
+	}
+	if policy != "" {
+		w.Header().Set("Content-Security-Policy", policy)
+	}
+}
+
+// writeHTMLMaintenancePage writes the HTML representation of the maintenance
+// page: the lockdown page, the snapshot or the template selected for the
+// request
+func (h *MaintenanceHandler) writeHTMLMaintenancePage(w http.ResponseWriter, r *http.Request, status int, data templateData) error {
+	switch {
+	case data.Lockdown:
+		h.filesMux.RLock()
+		lockdownTemplate := h.LockdownTemplate
+		h.filesMux.RUnlock()
+		if lockdownTemplate == "" {
+			lockdownTemplate = defaultLockdownTemplate
+		}
+		return h.serveHTMLPage(w, status, lockdownTemplate, defaultLockdownTemplate, data)
+	case h.snapshot != nil:
+		if status == http.StatusServiceUnavailable && h.SnapshotStatus != 0 {
+			status = h.SnapshotStatus
+		}
+		return serveSnapshot(w, status, h.snapshot, h.HTMLContentType)
+	default:
+		// Serve HTML maintenance page
+		return h.serveHTMLPage(w, status, h.selectHTMLTemplate(r), defaultHTMLTemplate, data)
+	}
+}
+
+// isClientGoneError reports whether a write failed because the client
+// closed the connection
+func isClientGoneError(err error) bool {
+	return errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, http.ErrHandlerTimeout) ||
+		errors.Is(err, context.Canceled)
+}
+
+// templateData holds the variables available to maintenance templates
+type templateData struct {
+	// StartedAt is when maintenance mode was enabled, zero when disabled
+	StartedAt time.Time
+	// EstimatedEnd is when maintenance is expected to end, zero when unknown
+	EstimatedEnd time.Time
+	// ScheduledStart is the configured scheduled start, zero when not set
+	ScheduledStart time.Time
+	// RetryAfter is the Retry-After value in seconds
+	RetryAfter int
+	// Lockdown is set when access is blocked by lockdown mode
+	Lockdown bool
+	// Message is the configured maintenance message
+	Message string
+	// Protocol is the request protocol, e.g. "HTTP/1.1"
+	Protocol string
+	// Title, Heading, BodyText and ButtonText override the texts of the
+	// built-in templates, empty to keep their defaults
+	Title      string
+	Heading    string
+	BodyText   string
+	ButtonText string
+	// RefreshButton is the configured refresh_button, empty for "script"
+	RefreshButton string
+	// Nonce is the per-response CSP nonce, empty unless csp is enabled
+	Nonce string
+	// RequestID identifies the response, empty unless request_id is enabled
+	RequestID string
+}
+
+// templateData returns the current template variables
+func (h *MaintenanceHandler) templateData() templateData {
+	h.enabledMux.RLock()
+	defer h.enabledMux.RUnlock()
+
+	return templateData{
+		StartedAt:      h.localTime(h.startedAt),
+		EstimatedEnd:   h.localTime(h.estimatedEnd),
+		ScheduledStart: h.localTime(h.scheduledStart),
+		RetryAfter:     h.effectiveRetryAfterLocked(),
+		Lockdown:       h.Lockdown,
+		Message:        h.message(),
+		Title:          h.Title,
+		Heading:        h.Heading,
+		BodyText:       h.BodyText,
+		ButtonText:     h.ButtonText,
+		RefreshButton:  h.RefreshButton,
+	}
+}
+
+// timeLocation returns the configured timezone, UTC by default
+func (h *MaintenanceHandler) timeLocation() *time.Location {
+	if h.location == nil {
+		return time.UTC
+	}
+
+	return h.location
+}
+
+// localTime returns t in the configured timezone, keeping zero times zero
+func (h *MaintenanceHandler) localTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+
+	return t.In(h.timeLocation())
+}
+
+// message returns the configured maintenance message or the default one
+func (h *MaintenanceHandler) message() string {
+	if h.Message == "" {
+		return defaultMessage
+	}
+
+	return h.Message
+}
+
+// varyHeaders lists the request headers the maintenance response depends on
+func (h *MaintenanceHandler) varyHeaders() []string {
+	// HTML vs JSON is negotiated from Accept and the request Content-Type
+	headers := []string{"Accept", "Content-Type"}
+	if langTemplates, _ := h.templateOverrides(); len(langTemplates) > 0 {
+		headers = append(headers, "Accept-Language")
+	}
+	if h.AckCookie != "" {
+		headers = append(headers, "Cookie")
+	}
+
+	return headers
+}
+
+// selectHTMLTemplate picks the template configured for the request protocol,
+// then the localized template matching the request's Accept-Language header,
+// falling back to the default template
+func (h *MaintenanceHandler) selectHTMLTemplate(r *http.Request) string {
+	langTemplates, protocolTemplates := h.templateOverrides()
+	if content, ok := selectProtocolTemplate(protocolTemplates, r); ok {
+		return content
+	}
+
+	if len(langTemplates) == 0 {
+		return h.htmlTemplate()
+	}
+
+	for _, tag := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if content, ok := langTemplates[tag]; ok {
+			return content
+		}
+
+		// Fall back to the primary subtag (e.g. "fr-CA" -> "fr")
+		if i := strings.Index(tag, "-"); i > 0 {
+			if content, ok := langTemplates[tag[:i]]; ok {
+				return content
+			}
+		}
+	}
+
+	return h.htmlTemplate()
+}
+
+// htmlTemplate returns the content of the default HTML template
+func (h *MaintenanceHandler) htmlTemplate() string {
+	h.filesMux.RLock()
+	defer h.filesMux.RUnlock()
+	if h.HTMLTemplate == "" {
+		return h.fetchedTemplate
+	}
+	return h.HTMLTemplate
+}
+
+// selectProtocolTemplate returns the template configured for the exact request
+// protocol (e.g. "HTTP/1.0"), then for its major version (e.g. "HTTP/1")
+func selectProtocolTemplate(protocolTemplates map[string]string, r *http.Request) (string, bool) {
+	if len(protocolTemplates) == 0 {
+		return "", false
+	}
+
+	if content, ok := protocolTemplates[strings.ToUpper(r.Proto)]; ok {
+		return content, true
+	}
+	content, ok := protocolTemplates["HTTP/"+strconv.Itoa(r.ProtoMajor)]
+
+	return content, ok
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language header
+// ordered by descending quality value. Tags with q=0 and the "*" wildcard are omitted.
+func parseAcceptLanguage(header string) []string {
+	var tags []string
+	for _, tag := range parseQualityList(header) {
+		if tag != "*" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// parseQualityList returns the lowercased values of a comma-separated header
+// with optional quality values (e.g. Accept, Accept-Language), without
+// parameters, ordered by descending quality. Values with q=0 are omitted.
+func parseQualityList(header string) []string {
+	type qualityValue struct {
+		value   string
+		quality float64
+	}
+
+	var values []qualityValue
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(fields[0]))
+		if value == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			quality = q
+		}
+
+		if quality == 0 {
+			continue
+		}
+		values = append(values, qualityValue{value: value, quality: quality})
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].quality > values[j].quality
+	})
+
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		result = append(result, v.value)
+	}
+
+	return result
+}
+
+// isJSONRequest reports whether the client prefers a JSON response, either
+// through its most preferred Accept media type or its request Content-Type
+func (h *MaintenanceHandler) isJSONRequest(r *http.Request) bool {
+	if ranges := parseAccept(r.Header.Get("Accept")); len(ranges) > 0 && h.isJSONMediaType(ranges[0]) {
+		return true
+	}
+
+	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	return h.isJSONMediaType(strings.ToLower(strings.TrimSpace(contentType)))
+}
+
+// isMinimalResponse reports whether the request gets an empty body
+func (h *MaintenanceHandler) isMinimalResponse(r *http.Request) bool {
+	switch h.MinimalResponse {
+	case minimalResponseAlways:
+		return true
+	case minimalResponseAuto:
+		return strings.TrimSpace(r.Header.Get("Accept")) == ""
+	}
+
+	return false
+}
+
+// negotiateRepresentation picks the response representation, JSON for
+// api_paths. A missing Accept header or a leading */* range counts as no
+// preference and gets the configured default representation.
+func (h *MaintenanceHandler) negotiateRepresentation(r *http.Request) string {
+	if h.isJSONRequest(r) || matchPaths(cleanRequestPath(r.URL.Path), h.apiPathsDecoded) {
+		return representationJSON
+	}
+
+	ranges := parseAccept(r.Header.Get("Accept"))
+	switch {
+	case len(ranges) == 0 || ranges[0] == "*/*":
+		if h.DefaultRepresentation != "" {
+			return h.DefaultRepresentation
+		}
+		return representationHTML
+	case ranges[0] == "text/plain":
+		return representationText
+	}
+
+	return representationHTML
+}
+
+// isJSONMediaType reports whether a lowercased media type denotes JSON
+func (h *MaintenanceHandler) isJSONMediaType(mediaType string) bool {
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		return true
+	}
+
+	for _, jsonMediaType := range h.JSONMediaTypes {
+		if strings.EqualFold(mediaType, strings.TrimSpace(jsonMediaType)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseAccept returns the lowercased media ranges of an Accept header, without
+// parameters, ordered by descending quality value. Ranges with q=0 are omitted.
+func parseAccept(header string) []string {
+	return parseQualityList(header)
+}
+
+// jsonResponseKeys are the keys of the built-in JSON response
+var jsonResponseKeys = []string{"status", "message", "retry_after", "started_at", "estimated_end", "request_id"}
+
+// validateJSONKeys checks that json_keys renames keys of the built-in JSON
+// response to distinct keys
+func validateJSONKeys(keys map[string]string) error {
+	for key, renamed := range keys {
+		if !slices.Contains(jsonResponseKeys, key) {
+			return fmt.Errorf("invalid json_keys key '%s', expected one of %s", key, strings.Join(jsonResponseKeys, ", "))
+		}
+		if strings.TrimSpace(renamed) == "" {
+			return fmt.Errorf("json_keys key '%s' cannot be renamed to an empty key", key)
+		}
+	}
+
+	used := make(map[string]string, len(jsonResponseKeys))
+	for _, key := range jsonResponseKeys {
+		renamed := jsonKey(keys, key)
+		if previous, ok := used[renamed]; ok {
+			return fmt.Errorf("json_keys renames '%s' and '%s' to the same key '%s'", previous, key, renamed)
+		}
+		used[renamed] = key
+	}
+
+	return nil
+}
+
+// jsonKey returns the key of the built-in JSON response renamed by json_keys
+func jsonKey(keys map[string]string, key string) string {
+	if renamed, ok := keys[key]; ok {
+		return renamed
+	}
+
+	return key
+}
+
+// renameJSONKeys applies json_keys to a built-in JSON response
+func renameJSONKeys(response map[string]any, keys map[string]string) map[string]any {
+	if len(keys) == 0 {
+		return response
+	}
+
+	renamed := make(map[string]any, len(response))
+	for key, value := range response {
+		renamed[jsonKey(keys, key)] = value
+	}
+
+	return renamed
+}
+
+// serveJSON writes the JSON maintenance response, rendering jsonTemplate
+// when one is configured. keys renames the keys of the built-in response.
+func serveJSON(w http.ResponseWriter, status int, data templateData, jsonTemplate string, keys map[string]string) error {
+	if jsonTemplate != "" && !data.Lockdown {
+		body, err := renderJSONTemplate(jsonTemplate, data)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, err = w.Write(body)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data.Lockdown {
+		response := map[string]any{
+			"status":  "error",
+			"message": "Access restricted",
+		}
+		if data.RequestID != "" {
+			response["request_id"] = data.RequestID
+		}
+		return json.NewEncoder(w).Encode(renameJSONKeys(response, keys))
+	}
+
+	response := map[string]any{
+		"status":      "error",
+		"message":     data.Message,
+		"retry_after": data.RetryAfter,
+	}
+	if !data.StartedAt.IsZero() {
+		response["started_at"] = data.StartedAt.Format(time.RFC3339)
+	}
+	if !data.EstimatedEnd.IsZero() {
+		response["estimated_end"] = data.EstimatedEnd.Format(time.RFC3339)
+	}
+	if data.RequestID != "" {
+		response["request_id"] = data.RequestID
+	}
+	return json.NewEncoder(w).Encode(renameJSONKeys(response, keys))
+}
+
+func serveText(w http.ResponseWriter, status int, data templateData) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+
+	if data.Lockdown {
+		body := "Access restricted\n"
+		if data.RequestID != "" {
+			body += fmt.Sprintf("Reference: %s\n", data.RequestID)
+		}
+		_, err := w.Write([]byte(body))
+		return err
+	}
+
+	body := data.Message + "\n"
+	if !data.StartedAt.IsZero() {
+		body += fmt.Sprintf("In maintenance since %s\n", data.StartedAt.Format(time.RFC3339))
+	}
+	body += fmt.Sprintf("Retry after %d seconds\n", data.RetryAfter)
+	if data.RequestID != "" {
+		body += fmt.Sprintf("Reference: %s\n", data.RequestID)
+	}
+
+	_, err := w.Write([]byte(body))
+	return err
+}
+
+// errTemplateRender is returned when executing an HTML template fails,
+// before anything was written
+var errTemplateRender = errors.New("failed to render template")
+
+// serveHTML renders the HTML template before writing anything, so a
+// rendering error never results in a partial page. Content that is not a
+// valid Go template is served as is.
+func (h *MaintenanceHandler) serveHTML(w http.ResponseWriter, status int, templateContent string, data templateData) error {
+	contentType := h.HTMLContentType
+	if contentType == "" {
+		contentType = defaultHTMLContentType
+	}
+	if templateContent == "" {
+		templateContent = defaultHTMLTemplate
+	}
+
+	var body bytes.Buffer
+	if tmpl, err := h.parseHTMLTemplate(templateContent); err != nil {
+		body.WriteString(templateContent)
+	} else if err := tmpl.Execute(&body, data); err != nil {
+		return fmt.Errorf("%w: %v", errTemplateRender, err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// serveHTMLPage serves an HTML template, replacing it with the built-in
+// fallback template when it fails to render unless template_failure is "error"
+func (h *MaintenanceHandler) serveHTMLPage(w http.ResponseWriter, status int, templateContent, fallback string, data templateData) error {
+	err := h.serveHTML(w, status, templateContent, data)
+	if err == nil || !errors.Is(err, errTemplateRender) || h.TemplateFailure == failureModeError {
+		return err
+	}
+
+	if h.logger != nil {
+		h.logger.Warn("Maintenance template failed to render, serving the built-in page", zap.Error(err))
+	}
+	return h.serveHTML(w, status, fallback, data)
+}
+
+// serveSnapshot writes the snapshot as is, it is not a template
+func serveSnapshot(w http.ResponseWriter, status int, snapshot []byte, contentType string) error {
+	if contentType == "" {
+		contentType = defaultHTMLContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(snapshot)))
+	w.WriteHeader(status)
+	_, err := w.Write(snapshot)
+	return err
+}
+
+// defaultHTMLContentType is the content type of HTML maintenance pages
+const defaultHTMLContentType = "text/html; charset=utf-8"
+
+// parsedHTMLTemplate is the outcome of parsing an HTML template
+type parsedHTMLTemplate struct {
+	tmpl *template.Template
+	err  error
+}
+
+// parseHTMLTemplate parses an HTML template, reusing the outcome of previous
+// calls. The cache belongs to the handler and is dropped along with it, or
+// when the reload endpoint replaces the templates.
+func (h *MaintenanceHandler) parseHTMLTemplate(content string) (*template.Template, error) {
+	if cached, ok := h.templateCache.Load(content); ok {
+		parsed := cached.(parsedHTMLTemplate)
+		return parsed.tmpl, parsed.err
+	}
+
+	tmpl, err := template.New("maintenance").Parse(content)
+	h.templateCache.Store(content, parsedHTMLTemplate{tmpl: tmpl, err: err})
+
+	return tmpl, err
+}
+
+// jsonTemplateFuncs are the functions available to JSON templates
+var jsonTemplateFuncs = texttemplate.FuncMap{
+	// json encodes a value, e.g. {{json .Message}} for a quoted string
+	"json": func(v any) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// parseJSONTemplate parses a JSON template
+func parseJSONTemplate(content string) (*texttemplate.Template, error) {
+	return texttemplate.New("maintenance").Funcs(jsonTemplateFuncs).Parse(content)
+}
+
+// renderJSONTemplate renders a JSON template, failing when the output is not valid JSON
+func renderJSONTemplate(content string, data templateData) ([]byte, error) {
+	tmpl, err := parseJSONTemplate(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON template: %v", err)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("failed to render JSON template: %v", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("JSON template does not render valid JSON")
+	}
+
+	return body.Bytes(), nil
+}
+
+const defaultRetryAfter = 300
+
+// defaultMessage is the maintenance message used when message is not configured
+const defaultMessage = "Service temporarily unavailable for maintenance"
+
+// Representations of the maintenance response
+const (
+	representationHTML = "html"
+	representationJSON = "json"
+	representationText = "text"
+)
+
+// Accepted values for minimal_response
+const (
+	minimalResponseAlways = "always"
+	minimalResponseAuto   = "auto"
+)
+
+// defaultNonceCSP is the policy sent when csp is enabled without a
+// content_security_policy, {nonce} being replaced by the response nonce
+const defaultNonceCSP = "default-src 'none'; style-src 'nonce-{nonce}'; script-src 'nonce-{nonce}'"
+
+// Accepted values for refresh_button
+const (
+	refreshButtonScript = "script"
+	refreshButtonLink   = "link"
+	refreshButtonNone   = "none"
+)
+
+// Accepted values for hostname_lookup_failure, weak_bcrypt_cost and
+// template_failure
+const (
+	failureModeError = "error"
+	failureModeWarn  = "warn"
+)
+
+// parseCaddyfile parses the maintenance directive in the Caddyfile
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var m MaintenanceHandler
+
+	// Merge included fragments ahead of the inline subdirectives
+	tokens, err := expandIncludes(h.Dispenser, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	h.Dispenser = caddyfile.NewDispenser(tokens)
+
+	for h.Next() {
+		// Parse any arguments on the same line as the directive
+		if h.NextArg() {
+			m.HTMLTemplate = h.Val() // This will now be treated as a file path
 		}
 
 		// Parse any block
@@ -872,12 +3455,338 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 				if !h.NextArg() {
 					return nil, h.ArgErr()
 				}
-				m.HTMLTemplate = h.Val() // This will now be treated as a file path
+				m.HTMLTemplate = h.Val() // This will now be treated as a file path
+			case "name":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Name = h.Val()
+			case "status_path":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				if !strings.HasPrefix(h.Val(), "/") {
+					return nil, h.Errf("status_path must start with '/'")
+				}
+				m.StatusPath = h.Val()
+			case "template_url":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.TemplateURL = h.Val()
+			case "template_cache_file":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.TemplateCacheFile = h.Val()
+			case "template_fetch_timeout":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid template_fetch_timeout value: %v", err)
+				}
+				if val <= 0 {
+					return nil, h.Errf("template_fetch_timeout value must be positive")
+				}
+				m.TemplateFetchTimeout = caddy.Duration(val)
+			case "html_content_type":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.HTMLContentType = h.Val()
+			case "default_template":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.DefaultTemplate = h.Val()
+			case "message":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Message = h.Val()
+			case "json_template":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.JSONTemplate = h.Val()
+			case "lockdown":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid lockdown value: %v", err)
+				}
+				m.Lockdown = val
+			case "title":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Title = h.Val()
+			case "heading":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Heading = h.Val()
+			case "body_text":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.BodyText = h.Val()
+			case "button_text":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.ButtonText = h.Val()
+			case "refresh_button":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				switch h.Val() {
+				case refreshButtonScript, refreshButtonLink, refreshButtonNone:
+				default:
+					return nil, h.Errf("invalid refresh_button value '%s', expected '%s', '%s' or '%s'", h.Val(), refreshButtonScript, refreshButtonLink, refreshButtonNone)
+				}
+				m.RefreshButton = h.Val()
+			case "csp":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid csp value: %v", err)
+				}
+				m.CSP = val
+			case "content_security_policy":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.ContentSecurityPolicy = h.Val()
+			case "snapshot_file":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.SnapshotFile = h.Val()
+			case "snapshot_status":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.Atoi(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid snapshot_status value: %v", err)
+				}
+				m.SnapshotStatus = val
+			case "lockdown_template":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.LockdownTemplate = h.Val()
+			case "template_failure":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				value := h.Val()
+				if value != failureModeError && value != failureModeWarn {
+					return nil, h.Errf("invalid template_failure value '%s', expected '%s' or '%s'", value, failureModeError, failureModeWarn)
+				}
+				m.TemplateFailure = value
+			case "representation_status":
+				if m.RepresentationStatus == nil {
+					m.RepresentationStatus = make(map[string]int)
+				}
+				// Single mapping on the same line: representation_status <representation> <status>
+				if h.NextArg() {
+					representation := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					val, err := strconv.Atoi(h.Val())
+					if err != nil {
+						return nil, h.Errf("invalid representation_status value: %v", err)
+					}
+					m.RepresentationStatus[representation] = val
+				}
+				// Block of mappings, one "<representation> <status>" pair per line
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					representation := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					val, err := strconv.Atoi(h.Val())
+					if err != nil {
+						return nil, h.Errf("invalid representation_status value: %v", err)
+					}
+					m.RepresentationStatus[representation] = val
+				}
+			case "json_keys":
+				if m.JSONKeys == nil {
+					m.JSONKeys = make(map[string]string)
+				}
+				// Single renamed key on the same line: json_keys <key> <renamed>
+				if h.NextArg() {
+					key := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					m.JSONKeys[key] = h.Val()
+					if h.NextArg() {
+						return nil, h.ArgErr()
+					}
+				}
+				// Block of renamed keys, one "<key> <renamed>" pair per line
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					key := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					m.JSONKeys[key] = h.Val()
+					if h.NextArg() {
+						return nil, h.ArgErr()
+					}
+				}
+			case "templates_by_lang":
+				if m.TemplatesByLang == nil {
+					m.TemplatesByLang = make(map[string]string)
+				}
+				// Single mapping on the same line: templates_by_lang <lang> <file>
+				if h.NextArg() {
+					lang := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					m.TemplatesByLang[lang] = h.Val()
+				}
+				// Block of mappings, one "<lang> <file>" pair per line
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					lang := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					m.TemplatesByLang[lang] = h.Val()
+				}
+			case "protocol_overrides":
+				if m.ProtocolOverrides == nil {
+					m.ProtocolOverrides = make(map[string]string)
+				}
+				// Single mapping on the same line: protocol_overrides <protocol> <file>
+				if h.NextArg() {
+					protocol := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					m.ProtocolOverrides[protocol] = h.Val()
+				}
+				// Block of mappings, one "<protocol> <file>" pair per line
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					protocol := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					m.ProtocolOverrides[protocol] = h.Val()
+				}
 			case "allowed_ips":
 				// Parse multiple IPs until the end of the line
 				for h.NextArg() {
 					m.AllowedIPs = append(m.AllowedIPs, h.Val())
 				}
+			case "hostname_lookup_failure":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				value := h.Val()
+				if value != failureModeError && value != failureModeWarn {
+					return nil, h.Errf("invalid hostname_lookup_failure value '%s', expected '%s' or '%s'", value, failureModeError, failureModeWarn)
+				}
+				m.HostnameLookupFailure = value
+			case "timezone":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.Timezone = h.Val()
+			case "estimated_end":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				if _, err := time.Parse(time.RFC3339, h.Val()); err != nil {
+					return nil, h.Errf("invalid estimated_end value: %v", err)
+				}
+				m.EstimatedEnd = h.Val()
+			case "scheduled_start":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				if _, err := time.Parse(time.RFC3339, h.Val()); err != nil {
+					return nil, h.Errf("invalid scheduled_start value: %v", err)
+				}
+				m.ScheduledStart = h.Val()
+			case "pre_notice":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid pre_notice value: %v", err)
+				}
+				if val <= 0 {
+					return nil, h.Errf("pre_notice value must be positive")
+				}
+				m.PreNotice = caddy.Duration(val)
+			case "pre_notice_text":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.PreNoticeText = h.Val()
+			case "business_hours":
+				if h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.BusinessHours = &BusinessHours{}
+				// One setting or "<days> <HH:MM-HH:MM>" range per line
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					key := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					switch key {
+					case "timezone":
+						m.BusinessHours.Timezone = h.Val()
+					case "policy":
+						m.BusinessHours.Policy = h.Val()
+					default:
+						weekly := key + " " + h.Val()
+						if _, err := parseWeeklyRange(weekly); err != nil {
+							return nil, h.Errf("invalid business_hours range '%s': %v", weekly, err)
+						}
+						m.BusinessHours.Ranges = append(m.BusinessHours.Ranges, weekly)
+					}
+					if h.NextArg() {
+						return nil, h.ArgErr()
+					}
+				}
+			case "default_representation":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				switch h.Val() {
+				case representationHTML, representationJSON, representationText:
+					m.DefaultRepresentation = h.Val()
+				default:
+					return nil, h.Errf("invalid default_representation value '%s', expected '%s', '%s' or '%s'", h.Val(), representationHTML, representationJSON, representationText)
+				}
+			case "json_media_types":
+				for h.NextArg() {
+					m.JSONMediaTypes = append(m.JSONMediaTypes, h.Val())
+				}
+			case "api_paths":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.APIPaths = append(m.APIPaths, h.Val())
+				for h.NextArg() {
+					m.APIPaths = append(m.APIPaths, h.Val())
+				}
 			case "retry_after":
 				if !h.NextArg() {
 					return nil, h.ArgErr()
@@ -890,6 +3799,62 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 					return nil, h.Errf("retry_after value must be positive")
 				}
 				m.RetryAfter = val
+			case "retry_after_min":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.Atoi(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid retry_after_min value: %v", err)
+				}
+				if val <= 0 {
+					return nil, h.Errf("retry_after_min value must be positive")
+				}
+				m.RetryAfterMin = val
+			case "retry_after_max":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.Atoi(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid retry_after_max value: %v", err)
+				}
+				if val <= 0 {
+					return nil, h.Errf("retry_after_max value must be positive")
+				}
+				m.RetryAfterMax = val
+			case "disable_retry_after":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid disable_retry_after value: %v", err)
+				}
+				m.DisableRetryAfter = val
+			case "upstream_error_statuses":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				for {
+					val, err := strconv.Atoi(h.Val())
+					if err != nil {
+						return nil, h.Errf("invalid upstream_error_statuses value: %v", err)
+					}
+					m.UpstreamErrorStatuses = append(m.UpstreamErrorStatuses, val)
+					if !h.NextArg() {
+						break
+					}
+				}
+			case "request_id":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid request_id value: %v", err)
+				}
+				m.RequestID = val
 			case "default_enabled":
 				if !h.NextArg() {
 					return nil, h.ArgErr()
@@ -904,6 +3869,133 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 					return nil, h.ArgErr()
 				}
 				m.StatusFile = h.Val()
+				// Redundant copies on the same line: status_file <file> [<file>...]
+				for h.NextArg() {
+					m.StatusFiles = append(m.StatusFiles, h.Val())
+				}
+			case "audit_file":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.AuditFile = h.Val()
+			case "asn_database":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.ASNDatabase = h.Val()
+			case "hostname_refresh_interval":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid hostname_refresh_interval value: %v", err)
+				}
+				if val < 0 {
+					return nil, h.Errf("hostname_refresh_interval value must not be negative")
+				}
+				m.HostnameRefreshInterval = caddy.Duration(val)
+			case "max_duration_warn":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid max_duration_warn value: %v", err)
+				}
+				if val <= 0 {
+					return nil, h.Errf("max_duration_warn value must be positive")
+				}
+				m.MaxDurationWarn = caddy.Duration(val)
+			case "flag_file":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.FlagFile = h.Val()
+				// Optional key on the same line: flag_file <file> <key>
+				if h.NextArg() {
+					m.FlagKey = h.Val()
+				}
+			case "flag_key":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.FlagKey = h.Val()
+			case "grace_period":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid grace_period value: %v", err)
+				}
+				if val < 0 {
+					return nil, h.Errf("grace_period value must not be negative")
+				}
+				m.GracePeriod = caddy.Duration(val)
+			case "flag_poll_interval":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid flag_poll_interval value: %v", err)
+				}
+				if val <= 0 {
+					return nil, h.Errf("flag_poll_interval value must be positive")
+				}
+				m.FlagPollInterval = caddy.Duration(val)
+			case "status_file_debounce":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid status_file_debounce value: %v", err)
+				}
+				if val < 0 {
+					return nil, h.Errf("status_file_debounce value must not be negative")
+				}
+				m.StatusFileDebounce = caddy.Duration(val)
+			case "minimal_response":
+				m.MinimalResponse = minimalResponseAlways
+				if h.NextArg() {
+					switch h.Val() {
+					case minimalResponseAlways, minimalResponseAuto:
+						m.MinimalResponse = h.Val()
+					default:
+						return nil, h.Errf("invalid minimal_response value '%s', expected '%s' or '%s'", h.Val(), minimalResponseAlways, minimalResponseAuto)
+					}
+				}
+			case "ack_cookie":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.AckCookie = h.Val()
+			case "ack_cookie_ttl":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := caddy.ParseDuration(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid ack_cookie_ttl value: %v", err)
+				}
+				if val <= 0 {
+					return nil, h.Errf("ack_cookie_ttl value must be positive")
+				}
+				m.AckCookieTTL = caddy.Duration(val)
+			case "response_delay":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.Atoi(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid response_delay value: %v", err)
+				}
+				if val < 0 {
+					return nil, h.Errf("response_delay value must not be negative")
+				}
+				m.ResponseDelay = val
 			case "request_retention_mode_timeout":
 				if !h.NextArg() {
 					return nil, h.ArgErr()
@@ -916,6 +4008,24 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 					return nil, h.Errf("request_retention_mode_timeout value must be positive")
 				}
 				m.RequestRetentionModeTimeout = val
+			case "release_on_shutdown":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid release_on_shutdown value: %v", err)
+				}
+				m.ReleaseOnShutdown = val
+			case "fail_open":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid fail_open value: %v", err)
+				}
+				m.FailOpen = val
 			case "use_forwarded_headers":
 				if !h.NextArg() {
 					return nil, h.ArgErr()
@@ -950,6 +4060,161 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 				for h.NextArg() {
 					m.BypassPaths = append(m.BypassPaths, h.Val())
 				}
+			case "bypass_insecure_only":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.BypassInsecureOnly = append(m.BypassInsecureOnly, h.Val())
+				for h.NextArg() {
+					m.BypassInsecureOnly = append(m.BypassInsecureOnly, h.Val())
+				}
+			case "bypass_secure_only":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.BypassSecureOnly = append(m.BypassSecureOnly, h.Val())
+				for h.NextArg() {
+					m.BypassSecureOnly = append(m.BypassSecureOnly, h.Val())
+				}
+			case "only_unknown_hosts":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid only_unknown_hosts value: %v", err)
+				}
+				m.OnlyUnknownHosts = val
+			case "known_hosts":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.KnownHosts = append(m.KnownHosts, h.Val())
+				for h.NextArg() {
+					m.KnownHosts = append(m.KnownHosts, h.Val())
+				}
+			case "always_block_paths":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.AlwaysBlockPaths = append(m.AlwaysBlockPaths, h.Val())
+				for h.NextArg() {
+					m.AlwaysBlockPaths = append(m.AlwaysBlockPaths, h.Val())
+				}
+			case "always_block_status":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.Atoi(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid always_block_status value: %v", err)
+				}
+				m.AlwaysBlockStatus = val
+			case "htpasswd_entries":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.HtpasswdEntries = append(m.HtpasswdEntries, h.Val())
+				for h.NextArg() {
+					m.HtpasswdEntries = append(m.HtpasswdEntries, h.Val())
+				}
+			case "htpasswd_env":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.HtpasswdEnv = h.Val()
+			case "min_bcrypt_cost":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.Atoi(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid min_bcrypt_cost value: %v", err)
+				}
+				if val < bcrypt.MinCost || val > bcrypt.MaxCost {
+					return nil, h.Errf("min_bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+				}
+				m.MinBcryptCost = val
+			case "weak_bcrypt_cost":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				value := h.Val()
+				if value != failureModeError && value != failureModeWarn {
+					return nil, h.Errf("invalid weak_bcrypt_cost value '%s', expected '%s' or '%s'", value, failureModeError, failureModeWarn)
+				}
+				m.WeakBcryptCost = value
+			case "auth_charset_utf8":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid auth_charset_utf8 value: %v", err)
+				}
+				m.AuthCharsetUTF8 = val
+			case "path_auth":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				section := PathAuth{PathPrefix: h.Val()}
+				if h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				for nesting := h.Nesting(); h.NextBlock(nesting); {
+					key := h.Val()
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+					switch key {
+					case "realm":
+						section.Realm = h.Val()
+					case "htpasswd_file":
+						section.HtpasswdFile = h.Val()
+					default:
+						return nil, h.Errf("unknown path_auth setting '%s'", key)
+					}
+					if h.NextArg() {
+						return nil, h.ArgErr()
+					}
+				}
+				m.PathAuth = append(m.PathAuth, section)
+			case "bypass_users":
+				users := h.RemainingArgs()
+				if len(users) == 0 {
+					return nil, h.ArgErr()
+				}
+				m.BypassUsers = append(m.BypassUsers, users...)
+			case "bypass_client_cert_cn":
+				names := h.RemainingArgs()
+				if len(names) == 0 {
+					return nil, h.ArgErr()
+				}
+				m.BypassClientCertCN = append(m.BypassClientCertCN, names...)
+			case "bypass_header":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.BypassHeader = h.Val()
+			case "maintenance_notice_header":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.MaintenanceNoticeHeader = h.Val()
+			case "maintenance_notice_cookie":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				m.MaintenanceNoticeCookie = h.Val()
+			case "bypass_match_full_uri":
+				if !h.NextArg() {
+					return nil, h.ArgErr()
+				}
+				val, err := strconv.ParseBool(h.Val())
+				if err != nil {
+					return nil, h.Errf("invalid bypass_match_full_uri value: %v", err)
+				}
+				m.BypassMatchFullURI = val
 			default:
 				return nil, h.Errf("unknown subdirective '%s'", h.Val())
 			}
@@ -0,0 +1,70 @@
+package fopsMaintenance
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// parseASN parses an autonomous system number written as AS<number>
+func parseASN(value string) (uint32, bool) {
+	if len(value) < 3 || !strings.EqualFold(value[:2], "AS") {
+		return 0, false
+	}
+
+	asn, err := strconv.ParseUint(value[2:], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint32(asn), true
+}
+
+// loadASNNetworks reads the networks announced by the given autonomous
+// systems from an ASN database. The database lists one "<cidr> <asn>" pair
+// per line, the ASN written with or without the AS prefix, with '#' comments.
+// The networks are returned by ASN.
+func (h *MaintenanceHandler) loadASNNetworks(asns map[uint32]bool) (map[uint32][]*net.IPNet, error) {
+	content, err := os.ReadFile(h.ASNDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ASN database: %v", err)
+	}
+
+	networks := make(map[uint32][]*net.IPNet, len(asns))
+	for _, line := range scanLines(content) {
+		fields := strings.Fields(line.text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid ASN database entry at line %d: expected '<cidr> <asn>'", line.number)
+		}
+
+		_, network, err := net.ParseCIDR(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR notation '%s' at line %d: %v", fields[0], line.number, err)
+		}
+
+		asn, ok := parseASN(fields[1])
+		if !ok {
+			value, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ASN '%s' at line %d", fields[1], line.number)
+			}
+			asn = uint32(value)
+		}
+
+		if asns[asn] {
+			networks[asn] = append(networks[asn], network)
+		}
+	}
+
+	for asn := range asns {
+		if len(networks[asn]) == 0 && h.logger != nil {
+			h.logger.Warn("No networks found for allowed ASN", zap.String("asn", fmt.Sprintf("AS%d", asn)), zap.String("asn_database", h.ASNDatabase))
+		}
+	}
+
+	return networks, nil
+}
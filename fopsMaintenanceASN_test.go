@@ -0,0 +1,124 @@
+package fopsMaintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sampleASNDatabase uses documentation ASNs and address ranges
+const sampleASNDatabase = `# Sample ASN dataset
+192.0.2.0/24     AS64496
+198.51.100.0/24  64496   # same AS without prefix
+203.0.113.0/24   AS64497
+2001:db8::/32    AS64496
+`
+
+func writeASNDatabase(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "asn.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestMaintenanceHandler_AllowedASN(t *testing.T) {
+	h := &MaintenanceHandler{
+		AllowedIPs:  []string{"as64496", "10.0.0.1"},
+		ASNDatabase: writeASNDatabase(t, sampleASNDatabase),
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	assert.True(t, h.isIPAllowed("192.0.2.10"))
+	assert.True(t, h.isIPAllowed("198.51.100.200"))
+	assert.True(t, h.isIPAllowed("2001:db8::1"))
+	assert.True(t, h.isIPAllowed("10.0.0.1"))
+	assert.False(t, h.isIPAllowed("203.0.113.5"), "networks of other ASNs are not allowed")
+	assert.False(t, h.isIPAllowed("8.8.8.8"))
+
+	h.enabled = true
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(rec, req, next))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestMaintenanceHandler_AllowedASN_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		database      string
+		errorContains string
+	}{
+		{name: "missing database", errorContains: "AS entries in allowed_ips require an asn_database"},
+		{name: "malformed line", database: "192.0.2.0/24\n", errorContains: "invalid ASN database entry at line 1"},
+		{name: "invalid CIDR", database: "# header\n192.0.2.0/33 AS64496\n", errorContains: "invalid CIDR notation '192.0.2.0/33' at line 2"},
+		{name: "invalid ASN", database: "192.0.2.0/24 ASX\n", errorContains: "invalid ASN 'ASX' at line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{AllowedIPs: []string{"AS64496"}}
+			if tt.database != "" {
+				h.ASNDatabase = writeASNDatabase(t, tt.database)
+			}
+
+			err := h.Provision(caddy.Context{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+		})
+	}
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"AS64496"}, ASNDatabase: filepath.Join(t.TempDir(), "missing.txt")}
+	err := h.Provision(caddy.Context{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read ASN database")
+}
+
+func TestParseASN(t *testing.T) {
+	tests := map[string]bool{
+		"AS64496":      true,
+		"as64496":      true,
+		"AS4294967295": true,
+		"AS4294967296": false,
+		"AS":           false,
+		"AS-1":         false,
+		"64496":        false,
+		"ASN64496":     false,
+	}
+
+	for value, valid := range tests {
+		_, ok := parseASN(value)
+		assert.Equal(t, valid, ok, value)
+	}
+
+	asn, _ := parseASN("AS64496")
+	assert.Equal(t, uint32(64496), asn)
+}
+
+func TestParseCaddyfile_ASNDatabase(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		allowed_ips AS64496 10.0.0.0/8
+		asn_database /etc/caddy/asn.txt
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, []string{"AS64496", "10.0.0.0/8"}, actualHandler.AllowedIPs)
+	assert.Equal(t, "/etc/caddy/asn.txt", actualHandler.ASNDatabase)
+}
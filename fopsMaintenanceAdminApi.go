@@ -2,20 +2,30 @@ package fopsMaintenance
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
 )
 
 var (
 	maintenanceHandlers []*MaintenanceHandler
 	instanceMux         sync.RWMutex
 	// For testing purposes only
-	jsonMarshalFunc = json.Marshal
+	jsonMarshalFunc     = json.Marshal
+	writeStatusFileFunc = atomicWriteFile
+	readBuildInfoFunc   = debug.ReadBuildInfo
 )
 
 // ResetJSONMarshal resets the JSON marshal function to the default
@@ -45,20 +55,219 @@ func (AdminHandler) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
+// adminDisabledEnv disables the maintenance admin endpoints when set to true
+const adminDisabledEnv = "FOPS_MAINTENANCE_ADMIN_DISABLED"
+
+// adminDisabled reports whether the maintenance admin endpoints are disabled.
+// Admin routes are collected before apps are provisioned, so the switch is
+// read from the environment rather than from the handler configuration.
+func adminDisabled() bool {
+	disabled, err := strconv.ParseBool(os.Getenv(adminDisabledEnv))
+	return err == nil && disabled
+}
+
+// adminBasePathEnv overrides the path prefix of the maintenance admin endpoints
+const adminBasePathEnv = "FOPS_MAINTENANCE_ADMIN_BASE_PATH"
+
+// defaultAdminBasePath is the path prefix of the maintenance admin endpoints
+const defaultAdminBasePath = "/maintenance"
+
+// adminBasePath returns the path prefix of the maintenance admin endpoints,
+// read from the environment like adminDisabled
+func adminBasePath() string {
+	basePath := strings.Trim(strings.TrimSpace(os.Getenv(adminBasePathEnv)), "/")
+	if basePath == "" {
+		return defaultAdminBasePath
+	}
+
+	return "/" + basePath
+}
+
 // Routes returns the admin router for the maintenance endpoints
 func (h AdminHandler) Routes() []caddy.AdminRoute {
+	if adminDisabled() {
+		return nil
+	}
+
+	basePath := adminBasePath()
 	return []caddy.AdminRoute{
 		{
-			Pattern: "/maintenance/status",
-			Handler: caddy.AdminHandlerFunc(h.getStatus),
+			Pattern: basePath + "/status",
+			Handler: withJSONErrors(h.getStatus),
+		},
+		{
+			Pattern: basePath + "/set",
+			Handler: withJSONErrors(audited("set", h.toggle)),
+		},
+		{
+			Pattern: basePath + "/hook",
+			Handler: withJSONErrors(audited("hook", h.hook)),
+		},
+		{
+			Pattern: basePath + "/set-all",
+			Handler: withJSONErrors(audited("set-all", h.setAll)),
+		},
+		{
+			Pattern: basePath + "/preview",
+			Handler: withJSONErrors(h.preview),
 		},
 		{
-			Pattern: "/maintenance/set",
-			Handler: caddy.AdminHandlerFunc(h.toggle),
+			Pattern: basePath + "/grant",
+			Handler: withJSONErrors(audited("grant", h.grant)),
+		},
+		{
+			Pattern: basePath + "/allowed-ips",
+			Handler: withJSONErrors(audited("allowed-ips", h.allowedIPs)),
+		},
+		{
+			Pattern: basePath + "/bypass-stats",
+			Handler: withJSONErrors(h.getBypassStats),
+		},
+		{
+			Pattern: basePath + "/export",
+			Handler: withJSONErrors(h.exportState),
+		},
+		{
+			Pattern: basePath + "/import",
+			Handler: withJSONErrors(audited("import", h.importState)),
+		},
+		{
+			Pattern: basePath + "/reload",
+			Handler: withJSONErrors(audited("reload", h.reload)),
+		},
+		{
+			Pattern: basePath + "/whoami",
+			Handler: withJSONErrors(h.whoami),
+		},
+		{
+			Pattern: basePath + "/openapi.json",
+			Handler: withJSONErrors(h.getOpenAPI),
+		},
+		{
+			Pattern: basePath + "/version",
+			Handler: withJSONErrors(h.getVersion),
 		},
 	}
 }
 
+// errorResponse is the JSON envelope written for failed admin requests
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+// withJSONErrors writes errors returned by an admin handler as an
+// errorResponse instead of leaving their formatting to Caddy
+func withJSONErrors(handler caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if err := handler(w, r); err != nil {
+			return writeAdminError(w, err)
+		}
+		return nil
+	}
+}
+
+// adminErrorStatus returns the status of an admin error, 500 unless it is a
+// caddy.APIError
+func adminErrorStatus(err error) int {
+	var apiErr caddy.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatus != 0 {
+		return apiErr.HTTPStatus
+	}
+
+	return http.StatusInternalServerError
+}
+
+// writeAdminError sets the status of an error, 500 unless it is a
+// caddy.APIError, and writes it as an errorResponse
+func writeAdminError(w http.ResponseWriter, err error) error {
+	code := adminErrorStatus(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	return json.NewEncoder(w).Encode(errorResponse{
+		Error: err.Error(),
+		Code:  code,
+	})
+}
+
+// toggleRequest is the payload accepted by the set endpoint
+type toggleRequest struct {
+	Enabled                     bool `json:"enabled"`
+	RequestRetentionModeTimeout int  `json:"request_retention_mode_timeout,omitempty"`
+	RetryAfter                  int  `json:"retry_after,omitempty"`
+	// Strict makes the request fail with 409 Conflict when it would not change the state
+	Strict bool `json:"strict,omitempty"`
+	// EstimatedEnd replaces the expected end of maintenance when present
+	EstimatedEnd *time.Time `json:"estimated_end,omitempty"`
+	// Message replaces the maintenance message when present, empty restores
+	// the default
+	Message *string `json:"message,omitempty"`
+	// Duration disables maintenance automatically after it elapsed (e.g. "15m")
+	Duration string `json:"duration,omitempty"`
+	// OverrideBusinessHours enables maintenance despite a reject policy
+	OverrideBusinessHours bool `json:"override_business_hours,omitempty"`
+	// GracePeriod replaces the grace period when present (e.g. "30s"), "0s"
+	// disables it
+	GracePeriod *string `json:"grace_period,omitempty"`
+}
+
+// patchRequest is the payload accepted by PATCH on the set endpoint.
+// Pointer fields distinguish omitted fields from zero values.
+type patchRequest struct {
+	Enabled                     *bool      `json:"enabled,omitempty"`
+	RequestRetentionModeTimeout *int       `json:"request_retention_mode_timeout,omitempty"`
+	RetryAfter                  *int       `json:"retry_after,omitempty"`
+	EstimatedEnd                *time.Time `json:"estimated_end,omitempty"`
+	Message                     *string    `json:"message,omitempty"`
+	OverrideBusinessHours       bool       `json:"override_business_hours,omitempty"`
+	GracePeriod                 *string    `json:"grace_period,omitempty"`
+}
+
+// setAllRequest is the payload accepted by the set-all endpoint
+type setAllRequest struct {
+	Enabled               bool `json:"enabled"`
+	OverrideBusinessHours bool `json:"override_business_hours,omitempty"`
+}
+
+// instanceResult reports the outcome of a set-all request for one instance
+type instanceResult struct {
+	// Name of the instance, empty for unnamed instances
+	Name    string `json:"name,omitempty"`
+	Enabled bool   `json:"enabled"`
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// setAllResponse is the payload returned by the set-all endpoint
+type setAllResponse struct {
+	Instances []instanceResult `json:"instances"`
+}
+
+// statusResponse is the payload returned by the status and set endpoints
+type statusResponse struct {
+	Enabled bool `json:"enabled"`
+	// Forced reports that maintenance is forced on by the environment
+	Forced bool `json:"forced,omitempty"`
+}
+
+// toggleResponse is the payload returned by POST on the set endpoint
+type toggleResponse struct {
+	Enabled bool `json:"enabled"`
+	// Changed reports whether the request changed the enabled state
+	Changed bool `json:"changed"`
+	// ExpiresAt is when maintenance gets disabled automatically
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// stateResponse is the full maintenance state returned by PATCH on the set endpoint
+type stateResponse struct {
+	Enabled                     bool `json:"enabled"`
+	RequestRetentionModeTimeout int  `json:"request_retention_mode_timeout"`
+	RetryAfter                  int  `json:"retry_after"`
+	// GracePeriod is omitted when no grace period is configured
+	GracePeriod string `json:"grace_period,omitempty"`
+}
+
 func (h AdminHandler) getStatus(w http.ResponseWriter, r *http.Request) error {
 	handlers := getMaintenanceHandlers()
 	if len(handlers) == 0 {
@@ -79,12 +288,17 @@ func (h AdminHandler) getStatus(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	return json.NewEncoder(w).Encode(map[string]bool{
-		"enabled": status,
+	return json.NewEncoder(w).Encode(statusResponse{
+		Enabled: status,
+		Forced:  anyForced(handlers),
 	})
 }
 
 func (h AdminHandler) toggle(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPatch {
+		return h.patch(w, r)
+	}
+
 	if r.Method != http.MethodPost {
 		return caddy.APIError{
 			HTTPStatus: http.StatusMethodNotAllowed,
@@ -92,59 +306,1095 @@ func (h AdminHandler) toggle(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	var req struct {
-		Enabled                     bool `json:"enabled"`
-		RequestRetentionModeTimeout int  `json:"request_retention_mode_timeout,omitempty"`
+	var req toggleRequest
+	if err := decodeAdminRequest(w, r, &req); err != nil {
+		return err
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return caddy.APIError{
+	response, err := applyToggle(req)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+// applyToggle validates a set request and applies it to every instance
+func applyToggle(req toggleRequest) (toggleResponse, error) {
+	if req.RetryAfter < 0 {
+		return toggleResponse{}, caddy.APIError{
 			HTTPStatus: http.StatusBadRequest,
-			Err:        err,
+			Err:        fmt.Errorf("retry_after must not be negative"),
 		}
 	}
 
+	gracePeriod, err := parseGracePeriod(req.GracePeriod)
+	if err != nil {
+		return toggleResponse{}, err
+	}
+
+	var expiresAt time.Time
+	if req.Duration != "" {
+		duration, err := caddy.ParseDuration(req.Duration)
+		if err != nil {
+			return toggleResponse{}, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid duration: %v", err),
+			}
+		}
+		if duration <= 0 {
+			return toggleResponse{}, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("duration must be positive"),
+			}
+		}
+		if !req.Enabled {
+			return toggleResponse{}, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("duration requires enabled to be true"),
+			}
+		}
+		expiresAt = time.Now().Add(duration)
+	}
+
 	handlers := getMaintenanceHandlers()
 	if len(handlers) == 0 {
-		return caddy.APIError{
+		return toggleResponse{}, caddy.APIError{
 			HTTPStatus: http.StatusNotFound,
 			Err:        fmt.Errorf("maintenance handler not found"),
 		}
 	}
 
-	status := struct {
-		Enabled bool `json:"enabled"`
-	}{
+	if err := forcedError(handlers, req.Enabled); err != nil {
+		return toggleResponse{}, err
+	}
+	if err := businessHoursError(handlers, req.Enabled, req.OverrideBusinessHours, time.Now()); err != nil {
+		return toggleResponse{}, err
+	}
+
+	// Hold every instance from the strict check until the new state is set,
+	// so that only one of concurrent strict requests can succeed
+	lockHandlers(handlers)
+	defer unlockHandlers(handlers)
+
+	if req.Strict && !enabledStateDiffersLocked(handlers, req.Enabled) {
+		return toggleResponse{}, caddy.APIError{
+			HTTPStatus: http.StatusConflict,
+			Err:        fmt.Errorf("maintenance mode is already %s", enabledStateName(req.Enabled)),
+		}
+	}
+
+	startedAt := enabledSinceLocked(handlers)
+	if err := persistEnabledStatus(handlers, req.Enabled, startedAt, expiresAt); err != nil {
+		return toggleResponse{}, err
+	}
+
+	changed := false
+	for _, maintenanceHandler := range handlers {
+		if maintenanceHandler.enabled != req.Enabled {
+			changed = true
+		}
+		if gracePeriod != nil {
+			maintenanceHandler.GracePeriod = *gracePeriod
+		}
+		maintenanceHandler.toggleEnabledLocked(req.Enabled, startedAt)
+		maintenanceHandler.setExpiryLocked(expiresAt)
+		maintenanceHandler.RequestRetentionModeTimeout = req.RequestRetentionModeTimeout
+		if req.RetryAfter > 0 {
+			maintenanceHandler.RetryAfter = maintenanceHandler.clampRetryAfter(req.RetryAfter)
+		}
+		if req.EstimatedEnd != nil {
+			maintenanceHandler.estimatedEnd = *req.EstimatedEnd
+		}
+		if req.Message != nil {
+			maintenanceHandler.Message = *req.Message
+		}
+	}
+
+	response := toggleResponse{
 		Enabled: req.Enabled,
+		Changed: changed,
 	}
-	statusFiles := getUniqueStatusFiles(handlers)
-	if len(statusFiles) > 0 {
-		statusData, err := jsonMarshalFunc(status)
-		if err != nil {
+	if !expiresAt.IsZero() {
+		response.ExpiresAt = &expiresAt
+	}
+
+	return response, nil
+}
+
+// adminMaxBodyBytesEnv overrides the maximum size of admin request bodies
+const adminMaxBodyBytesEnv = "FOPS_MAINTENANCE_ADMIN_MAX_BODY_BYTES"
+
+// defaultAdminMaxBodyBytes is the default maximum size of admin request bodies
+const defaultAdminMaxBodyBytes = 64 * 1024
+
+// adminMaxBodyBytes returns the configured admin request body cap
+func adminMaxBodyBytes() int64 {
+	if value := os.Getenv(adminMaxBodyBytesEnv); value != "" {
+		if limit, err := strconv.ParseInt(value, 10, 64); err == nil && limit > 0 {
+			return limit
+		}
+	}
+
+	return defaultAdminMaxBodyBytes
+}
+
+// decodeAdminRequest decodes a JSON request body of bounded size, answering
+// 413 when the body exceeds the cap and 400 when it is not valid JSON or
+// contains unknown fields
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminMaxBodyBytes()))
+	// Reject typos such as "enable" instead of silently ignoring them
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
 			return caddy.APIError{
-				HTTPStatus: http.StatusInternalServerError,
-				Err:        fmt.Errorf("failed to marshal status: %v", err),
+				HTTPStatus: http.StatusRequestEntityTooLarge,
+				Err:        fmt.Errorf("request body exceeds %d bytes", maxBytesErr.Limit),
 			}
 		}
-
-		if err := persistStatusFiles(statusFiles, statusData); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
 			return caddy.APIError{
-				HTTPStatus: http.StatusInternalServerError,
-				Err:        fmt.Errorf("failed to persist status: %v", err),
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("unknown field %s in request body", field),
 			}
 		}
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+
+	return nil
+}
+
+// setAll enables or disables every registered instance separately, so a
+// failure on one instance does not prevent updating the others
+func (h AdminHandler) setAll(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
 	}
 
+	var req setAllRequest
+	if err := decodeAdminRequest(w, r, &req); err != nil {
+		return err
+	}
+
+	handlers := getMaintenanceHandlers()
+	if len(handlers) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	response := setAllResponse{Instances: make([]instanceResult, 0, len(handlers))}
+	failed := false
 	for _, maintenanceHandler := range handlers {
+		result := instanceResult{Name: maintenanceHandler.Name}
+
+		if err := forcedError([]*MaintenanceHandler{maintenanceHandler}, req.Enabled); err != nil {
+			failed = true
+			result.Enabled = true
+			result.Error = err.Error()
+			response.Instances = append(response.Instances, result)
+			continue
+		}
+		if err := businessHoursError([]*MaintenanceHandler{maintenanceHandler}, req.Enabled, req.OverrideBusinessHours, time.Now()); err != nil {
+			failed = true
+			result.Enabled = currentState(maintenanceHandler).Enabled
+			result.Error = err.Error()
+			response.Instances = append(response.Instances, result)
+			continue
+		}
+
+		startedAt := enabledSince([]*MaintenanceHandler{maintenanceHandler})
+		if err := persistEnabledStatus([]*MaintenanceHandler{maintenanceHandler}, req.Enabled, startedAt, time.Time{}); err != nil {
+			failed = true
+			result.Enabled = currentState(maintenanceHandler).Enabled
+			result.Error = err.Error()
+			response.Instances = append(response.Instances, result)
+			continue
+		}
+
 		maintenanceHandler.enabledMux.Lock()
-		maintenanceHandler.enabled = req.Enabled
-		maintenanceHandler.RequestRetentionModeTimeout = req.RequestRetentionModeTimeout
+		result.Changed = maintenanceHandler.enabled != req.Enabled
+		maintenanceHandler.toggleEnabledLocked(req.Enabled, startedAt)
+		maintenanceHandler.setExpiryLocked(time.Time{})
+		result.Enabled = maintenanceHandler.enabled
 		maintenanceHandler.enabledMux.Unlock()
+
+		response.Instances = append(response.Instances, result)
 	}
 
-	return json.NewEncoder(w).Encode(map[string]bool{
-		"enabled": req.Enabled,
-	})
+	w.Header().Set("Content-Type", "application/json")
+	if failed {
+		w.WriteHeader(http.StatusMultiStatus)
+	}
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+// lockHandlers takes the enabledMux of every handler, in registration order
+// so that concurrent callers cannot deadlock
+func lockHandlers(handlers []*MaintenanceHandler) {
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.Lock()
+	}
+}
+
+// unlockHandlers releases the locks taken by lockHandlers
+func unlockHandlers(handlers []*MaintenanceHandler) {
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.Unlock()
+	}
+}
+
+// enabledStateDiffersLocked reports whether any handler is not in the given
+// enabled state. The caller must hold the enabledMux of every handler.
+func enabledStateDiffersLocked(handlers []*MaintenanceHandler, enabled bool) bool {
+	for _, maintenanceHandler := range handlers {
+		if maintenanceHandler.enabled != enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// anyForced reports whether one of the handlers is forced on by the environment
+func anyForced(handlers []*MaintenanceHandler) bool {
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.RLock()
+		forced := maintenanceHandler.forced
+		maintenanceHandler.enabledMux.RUnlock()
+		if forced {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forcedError returns a 409 Conflict when disabling maintenance on handlers
+// of which one is forced on by the environment
+func forcedError(handlers []*MaintenanceHandler, enabled bool) error {
+	if enabled || !anyForced(handlers) {
+		return nil
+	}
+
+	return caddy.APIError{
+		HTTPStatus: http.StatusConflict,
+		Err:        fmt.Errorf("maintenance mode is forced by %s, restart without it to disable", forceEnv),
+	}
+}
+
+func enabledStateName(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+
+	return "disabled"
+}
+
+// patch merges the fields present in the request into the current state,
+// leaving omitted fields untouched
+func (h AdminHandler) patch(w http.ResponseWriter, r *http.Request) error {
+	var req patchRequest
+	if err := decodeAdminRequest(w, r, &req); err != nil {
+		return err
+	}
+
+	if req.RequestRetentionModeTimeout != nil && *req.RequestRetentionModeTimeout < 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("request_retention_mode_timeout must not be negative"),
+		}
+	}
+	if req.RetryAfter != nil && *req.RetryAfter < 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("retry_after must not be negative"),
+		}
+	}
+	gracePeriod, err := parseGracePeriod(req.GracePeriod)
+	if err != nil {
+		return err
+	}
+
+	handlers := getMaintenanceHandlers()
+	if len(handlers) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	// Only the enabled state is persisted, skip writing when it is not patched
+	startedAt := enabledSince(handlers)
+	if req.Enabled != nil {
+		if err := forcedError(handlers, *req.Enabled); err != nil {
+			return err
+		}
+		if err := businessHoursError(handlers, *req.Enabled, req.OverrideBusinessHours, time.Now()); err != nil {
+			return err
+		}
+		if err := persistEnabledStatus(handlers, *req.Enabled, startedAt, expiresAtOf(handlers)); err != nil {
+			return err
+		}
+	}
+
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.Lock()
+		if gracePeriod != nil {
+			maintenanceHandler.GracePeriod = *gracePeriod
+		}
+		if req.Enabled != nil {
+			maintenanceHandler.toggleEnabledLocked(*req.Enabled, startedAt)
+		}
+		if req.RequestRetentionModeTimeout != nil {
+			maintenanceHandler.RequestRetentionModeTimeout = *req.RequestRetentionModeTimeout
+		}
+		if req.RetryAfter != nil {
+			// Zero restores the default, it is not clamped
+			maintenanceHandler.RetryAfter = *req.RetryAfter
+			if *req.RetryAfter > 0 {
+				maintenanceHandler.RetryAfter = maintenanceHandler.clampRetryAfter(*req.RetryAfter)
+			}
+		}
+		if req.EstimatedEnd != nil {
+			maintenanceHandler.estimatedEnd = *req.EstimatedEnd
+		}
+		if req.Message != nil {
+			maintenanceHandler.Message = *req.Message
+		}
+		maintenanceHandler.enabledMux.Unlock()
+	}
+
+	return json.NewEncoder(w).Encode(currentState(handlers[0]))
+}
+
+// currentState returns the full maintenance state of a handler
+func currentState(handler *MaintenanceHandler) stateResponse {
+	handler.enabledMux.RLock()
+	defer handler.enabledMux.RUnlock()
+
+	state := stateResponse{
+		Enabled:                     handler.enabled,
+		RequestRetentionModeTimeout: handler.RequestRetentionModeTimeout,
+		RetryAfter:                  handler.effectiveRetryAfterLocked(),
+	}
+	if handler.GracePeriod > 0 {
+		state.GracePeriod = time.Duration(handler.GracePeriod).String()
+	}
+
+	return state
+}
+
+// parseGracePeriod parses the grace_period of an admin request, nil when
+// the request does not set it
+func parseGracePeriod(value *string) (*caddy.Duration, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	gracePeriod, err := caddy.ParseDuration(*value)
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("invalid grace_period: %v", err),
+		}
+	}
+	if gracePeriod < 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("grace_period must not be negative"),
+		}
+	}
+	parsed := caddy.Duration(gracePeriod)
+
+	return &parsed, nil
+}
+
+// enabledSince returns when maintenance was first enabled among the handlers,
+// or the current time when none of them is enabled
+func enabledSince(handlers []*MaintenanceHandler) time.Time {
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.RLock()
+		defer maintenanceHandler.enabledMux.RUnlock()
+	}
+
+	return enabledSinceLocked(handlers)
+}
+
+// enabledSinceLocked is enabledSince for a caller holding the enabledMux of
+// every handler
+func enabledSinceLocked(handlers []*MaintenanceHandler) time.Time {
+	var since time.Time
+	for _, maintenanceHandler := range handlers {
+		startedAt := maintenanceHandler.startedAt
+		if maintenanceHandler.enabled && !startedAt.IsZero() && (since.IsZero() || startedAt.Before(since)) {
+			since = startedAt
+		}
+	}
+
+	if since.IsZero() {
+		return time.Now()
+	}
+
+	return since
+}
+
+// expiresAtOf returns the pending automatic disable time among the handlers
+func expiresAtOf(handlers []*MaintenanceHandler) time.Time {
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.RLock()
+		expiresAt := maintenanceHandler.expiresAt
+		maintenanceHandler.enabledMux.RUnlock()
+		if !expiresAt.IsZero() {
+			return expiresAt
+		}
+	}
+
+	return time.Time{}
+}
+
+// persistEnabledStatus writes the enabled state to every configured status file.
+// Nothing is written if any of the files cannot be persisted. Handlers with a
+// status file debounce get their write scheduled instead.
+func persistEnabledStatus(handlers []*MaintenanceHandler, enabled bool, startedAt time.Time, expiresAt time.Time) error {
+	var immediate, debounced []*MaintenanceHandler
+	for _, handler := range handlers {
+		if handler.StatusFileDebounce > 0 {
+			debounced = append(debounced, handler)
+		} else {
+			immediate = append(immediate, handler)
+		}
+	}
+
+	statusFiles := getUniqueStatusFiles(immediate)
+	if len(statusFiles) == 0 && len(getUniqueStatusFiles(debounced)) == 0 {
+		return nil
+	}
+
+	status := persistedStatus{
+		Enabled: enabled,
+	}
+	if enabled {
+		status.StartedAt = &startedAt
+		if !expiresAt.IsZero() {
+			status.ExpiresAt = &expiresAt
+		}
+	}
+	statusData, err := jsonMarshalFunc(status)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("failed to marshal status: %v", err),
+		}
+	}
+
+	if err := persistStatusFiles(statusFiles, statusData); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("failed to persist status: %v", err),
+		}
+	}
+
+	written := make(map[string]struct{})
+	for _, group := range statusFiles {
+		for _, statusFile := range group.paths {
+			written[statusFile] = struct{}{}
+		}
+	}
+	for _, handler := range debounced {
+		pending := false
+		for _, statusFile := range handler.statusFilePaths() {
+			if _, exists := written[statusFile]; !exists {
+				pending = true
+			}
+		}
+		if pending {
+			handler.schedulePersist(statusData)
+		}
+	}
+
+	return nil
+}
+
+// schedulePersist records the latest status and writes it once the debounce
+// window elapses, so rapid toggles result in a single write
+func (h *MaintenanceHandler) schedulePersist(data []byte) {
+	h.persistMux.Lock()
+	defer h.persistMux.Unlock()
+
+	h.pendingStatus = data
+	if h.persistTimer == nil {
+		h.persistTimer = time.AfterFunc(time.Duration(h.StatusFileDebounce), h.flushPendingStatus)
+	}
+}
+
+// flushPendingStatus writes the pending debounced status, if any
+func (h *MaintenanceHandler) flushPendingStatus() {
+	h.persistMux.Lock()
+	defer h.persistMux.Unlock()
+
+	if h.persistTimer != nil {
+		h.persistTimer.Stop()
+		h.persistTimer = nil
+	}
+
+	data := h.pendingStatus
+	h.pendingStatus = nil
+	if data == nil {
+		return
+	}
+
+	for _, statusFile := range h.statusFilePaths() {
+		if err := writeStatusFileFunc(statusFile, data, 0644); err != nil && h.logger != nil {
+			h.logger.Error("Failed to persist debounced maintenance status",
+				zap.String("status_file", statusFile),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// preview renders the HTML maintenance page exactly as it would be served,
+// whether or not maintenance mode is enabled
+func (h AdminHandler) preview(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	maintenanceHandler := getMaintenanceHandler()
+	if maintenanceHandler == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	// The page is selected and rendered like for site requests, only the
+	// status differs
+	data := maintenanceHandler.templateData()
+	data.Protocol = r.Proto
+	if !data.Lockdown && !maintenanceHandler.DisableRetryAfter {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", data.RetryAfter))
+	}
+	maintenanceHandler.setContentSecurityPolicy(w, &data)
+
+	return maintenanceHandler.writeHTMLMaintenancePage(w, r, http.StatusOK, data)
+}
+
+// whoamiResponse is the payload returned by the whoami endpoint
+type whoamiResponse struct {
+	// RemoteAddr is the address of the connection
+	RemoteAddr string `json:"remote_addr"`
+	// ClientIP is the client IP resolved with the trusted proxy settings
+	ClientIP string `json:"client_ip"`
+	// UseForwardedHeaders reports whether forwarded headers are read at all
+	UseForwardedHeaders bool `json:"use_forwarded_headers"`
+	// TrustedProxy reports whether the connection comes from a trusted proxy,
+	// whose forwarded headers are then honored
+	TrustedProxy bool `json:"trusted_proxy"`
+	// Allowed reports whether the client IP bypasses maintenance
+	Allowed bool `json:"allowed"`
+}
+
+// whoami resolves the client IP of the admin request the way the handler
+// resolves it for site requests, to debug why an IP does not bypass
+// maintenance. Forwarded headers can be set on the request to simulate a
+// proxy.
+func (h AdminHandler) whoami(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	maintenanceHandler := getMaintenanceHandler()
+	if maintenanceHandler == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	clientIP := maintenanceHandler.getClientIP(r)
+	response := whoamiResponse{
+		RemoteAddr:          r.RemoteAddr,
+		ClientIP:            clientIP,
+		UseForwardedHeaders: maintenanceHandler.UseForwardedHeaders,
+		TrustedProxy:        maintenanceHandler.isTrustedProxy(net.ParseIP(remoteHost(r.RemoteAddr))),
+		Allowed:             maintenanceHandler.isIPAllowed(clientIP),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
+
+func (h AdminHandler) getOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(openAPIDocument())
+}
+
+// versionResponse is the payload returned by the version endpoint
+type versionResponse struct {
+	// Module path and version of the maintenance plugin
+	Module  string `json:"module"`
+	Version string `json:"version"`
+	// GoVersion is the Go toolchain the binary was built with
+	GoVersion string `json:"go_version,omitempty"`
+	// VCS metadata of the main module, when stamped by the go command
+	Revision string `json:"vcs_revision,omitempty"`
+	Time     string `json:"vcs_time,omitempty"`
+	Modified bool   `json:"vcs_modified,omitempty"`
+}
+
+func (h AdminHandler) getVersion(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(buildVersion())
+}
+
+// buildVersion reads the plugin version from the binary's build info. The
+// plugin is a dependency of custom Caddy builds and the main module of its
+// own tests, so both are looked up.
+func buildVersion() versionResponse {
+	modulePath := reflect.TypeOf(AdminHandler{}).PkgPath()
+	response := versionResponse{Module: modulePath, Version: "unknown"}
+
+	info, ok := readBuildInfoFunc()
+	if !ok {
+		return response
+	}
+
+	response.GoVersion = info.GoVersion
+	if info.Main.Path == modulePath {
+		response.Version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		response.Version = dep.Version
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			response.Version = dep.Replace.Version
+		}
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			response.Revision = setting.Value
+		case "vcs.time":
+			response.Time = setting.Value
+		case "vcs.modified":
+			response.Modified = setting.Value == "true"
+		}
+	}
+
+	return response
+}
+
+// openAPIDocument describes the admin endpoints, with payload schemas
+// generated from the request and response structs
+func openAPIDocument() map[string]interface{} {
+	basePath := adminBasePath()
+	jsonContent := func(v interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": jsonSchemaFor(reflect.TypeOf(v)),
+			},
+		}
+	}
+	toggleResponses := map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "Requested maintenance status and whether it changed",
+			"content":     jsonContent(toggleResponse{}),
+		},
+		"404": map[string]interface{}{
+			"description": "No maintenance handler is configured",
+		},
+		"409": map[string]interface{}{
+			"description": "Strict mode request that would not change the state",
+		},
+	}
+	statusResponses := map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "Current maintenance status",
+			"content":     jsonContent(statusResponse{}),
+		},
+		"404": map[string]interface{}{
+			"description": "No maintenance handler is configured",
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "FroggOps Caddy Maintenance Admin API",
+			"version": "1.0.0",
+		},
+		"paths": map[string]interface{}{
+			basePath + "/status": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":   "Get the maintenance status",
+					"responses": statusResponses,
+				},
+			},
+			basePath + "/set": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Enable or disable maintenance mode",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(toggleRequest{}),
+					},
+					"responses": toggleResponses,
+				},
+				"patch": map[string]interface{}{
+					"summary": "Update individual fields of the maintenance state",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(patchRequest{}),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Resulting maintenance state",
+							"content":     jsonContent(stateResponse{}),
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+					},
+				},
+			},
+			basePath + "/hook": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Enable or disable maintenance mode with a payload signed by " + hookSignatureHeader,
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(hookRequest{}),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Requested maintenance status and whether it changed",
+							"content":     jsonContent(toggleResponse{}),
+						},
+						"401": map[string]interface{}{
+							"description": "Missing or invalid signature, or a stale timestamp",
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured, or no hook secret is set",
+						},
+						"409": map[string]interface{}{
+							"description": "Strict mode request that would not change the state",
+						},
+					},
+				},
+			},
+			basePath + "/set-all": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Enable or disable maintenance mode on every instance",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(setAllRequest{}),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Every instance was updated",
+							"content":     jsonContent(setAllResponse{}),
+						},
+						"207": map[string]interface{}{
+							"description": "Some instances failed, see the per-instance errors",
+							"content":     jsonContent(setAllResponse{}),
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+					},
+				},
+			},
+			basePath + "/preview": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Preview the HTML maintenance page",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "HTML maintenance page, with the Retry-After header it would be served with",
+							"content": map[string]interface{}{
+								"text/html": map[string]interface{}{},
+							},
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+					},
+				},
+			},
+			basePath + "/grant": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Issue a temporary token bypassing maintenance",
+					"requestBody": map[string]interface{}{
+						"required": false,
+						"content":  jsonContent(grantRequest{}),
+					},
+					"responses": map[string]interface{}{
+						"201": map[string]interface{}{
+							"description": "Grant issued",
+							"content":     jsonContent(grantResponse{}),
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid request body",
+						},
+					},
+				},
+				"delete": map[string]interface{}{
+					"summary": "Revoke a grant",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(revokeGrantRequest{}),
+					},
+					"responses": map[string]interface{}{
+						"204": map[string]interface{}{
+							"description": "Grant revoked",
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid request body",
+						},
+						"404": map[string]interface{}{
+							"description": "No active grant with this token",
+						},
+					},
+				},
+			},
+			basePath + "/allowed-ips": map[string]interface{}{
+				"put": map[string]interface{}{
+					"summary": "Replace the allowed IP list of every instance",
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":        "persist",
+							"in":          "query",
+							"description": "Also write the list to the allowed_ips_file of the instances",
+							"schema":      map[string]interface{}{"type": "boolean"},
+						},
+					},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent([]string{}),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Allowed IP list applied",
+							"content":     jsonContent(allowedIPsResponse{}),
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid request body or entry, no list was replaced",
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+					},
+				},
+			},
+			basePath + "/bypass-stats": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Count the requests each allowed_ips entry let through since startup",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Bypasses per allowed_ips entry, most used first",
+							"content":     jsonContent(bypassStatsResponse{}),
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+					},
+				},
+			},
+			basePath + "/export": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Export the maintenance state for backups and migrations",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Maintenance state of the instances",
+							"content":     jsonContent(exportedState{}),
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+					},
+				},
+			},
+			basePath + "/import": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Restore an exported maintenance state on every instance",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(exportedState{}),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "State restored",
+							"content":     jsonContent(stateResponse{}),
+						},
+						"400": map[string]interface{}{
+							"description": "Invalid state, no instance was changed",
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+						"409": map[string]interface{}{
+							"description": "Maintenance is forced or rejected by the business hours policy",
+						},
+					},
+				},
+			},
+			basePath + "/reload": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Re-read the templates, allowed_ips_file, htpasswd and status file of every instance",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Sources reloaded per instance",
+							"content":     jsonContent(reloadResponse{}),
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+						"500": map[string]interface{}{
+							"description": "A file could not be read or is invalid, no instance was changed",
+						},
+					},
+				},
+			},
+			basePath + "/whoami": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Resolve the client IP of this request as site requests are resolved",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Resolved client IP and whether it bypasses maintenance",
+							"content":     jsonContent(whoamiResponse{}),
+						},
+						"404": map[string]interface{}{
+							"description": "No maintenance handler is configured",
+						},
+					},
+				},
+			},
+			basePath + "/version": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get the plugin version and build metadata",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Plugin version",
+							"content":     jsonContent(versionResponse{}),
+						},
+					},
+				},
+			},
+			basePath + "/openapi.json": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Get this OpenAPI document",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "OpenAPI document",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// jsonSchemaFor builds a JSON schema from a Go type using its json struct tags
+func jsonSchemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{}, t.NumField())
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			// Promote the fields of embedded structs, as encoding/json does
+			if field.Anonymous && field.Tag.Get("json") == "" && field.Type.Kind() == reflect.Struct {
+				embedded := jsonSchemaFor(field.Type)
+				for name, property := range embedded["properties"].(map[string]interface{}) {
+					properties[name] = property
+				}
+				if embeddedRequired, ok := embedded["required"].([]string); ok {
+					required = append(required, embeddedRequired...)
+				}
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = jsonSchemaFor(field.Type)
+			if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+
+		schema := map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]interface{}{}
+	}
 }
 
 func getMaintenanceHandler() *MaintenanceHandler {
@@ -233,24 +1483,35 @@ func pruneInactiveHandlersLocked() {
 	maintenanceHandlers = kept
 }
 
-func getUniqueStatusFiles(handlers []*MaintenanceHandler) []string {
+// statusFileGroup holds the redundant copies of the status file of a handler
+type statusFileGroup struct {
+	paths  []string
+	logger *zap.Logger
+}
+
+// getUniqueStatusFiles returns the status files of the handlers grouped by
+// handler, a file shared by several handlers being written only once
+func getUniqueStatusFiles(handlers []*MaintenanceHandler) []statusFileGroup {
 	seen := make(map[string]struct{}, len(handlers))
-	files := make([]string, 0, len(handlers))
+	groups := make([]statusFileGroup, 0, len(handlers))
 
 	for _, handler := range handlers {
-		if handler.StatusFile == "" {
-			continue
-		}
+		group := statusFileGroup{logger: handler.logger}
+		for _, statusFile := range handler.statusFilePaths() {
+			if _, exists := seen[statusFile]; exists {
+				continue
+			}
 
-		if _, exists := seen[handler.StatusFile]; exists {
-			continue
+			seen[statusFile] = struct{}{}
+			group.paths = append(group.paths, statusFile)
 		}
 
-		seen[handler.StatusFile] = struct{}{}
-		files = append(files, handler.StatusFile)
+		if len(group.paths) > 0 {
+			groups = append(groups, group)
+		}
 	}
 
-	return files
+	return groups
 }
 
 type statusFileBackup struct {
@@ -261,39 +1522,66 @@ type statusFileBackup struct {
 	Written bool
 }
 
-func persistStatusFiles(paths []string, data []byte) error {
-	backups := make([]statusFileBackup, 0, len(paths))
+// persistStatusFiles writes the status to every group. Within a group, the
+// copies are redundant: failed writes are logged as long as one succeeded.
+// When every copy of a group fails, the files already written are rolled back.
+func persistStatusFiles(groups []statusFileGroup, data []byte) error {
+	var backups []statusFileBackup
 
-	for _, path := range paths {
-		backup := statusFileBackup{
-			Path: path,
-			Mode: 0644,
-		}
-
-		if info, err := os.Stat(path); err == nil {
-			backup.Exists = true
-			backup.Mode = info.Mode().Perm()
-			previousData, readErr := os.ReadFile(path)
-			if readErr != nil {
-				return fmt.Errorf("failed to read current status file '%s': %v", path, readErr)
+	for _, group := range groups {
+		var failures []error
+		for _, path := range group.paths {
+			backup, err := writeStatusFileWithBackup(path, data)
+			if err != nil {
+				failures = append(failures, err)
+				continue
 			}
-			backup.Data = previousData
-		} else if !os.IsNotExist(err) {
-			return fmt.Errorf("failed to stat status file '%s': %v", path, err)
+			backups = append(backups, backup)
 		}
 
-		if err := atomicWriteFile(path, data, 0644); err != nil {
+		if len(failures) == len(group.paths) {
 			rollbackPersistedStatusFiles(backups)
-			return fmt.Errorf("failed writing status file '%s': %v", path, err)
+			return errors.Join(failures...)
 		}
 
-		backup.Written = true
-		backups = append(backups, backup)
+		for _, failure := range failures {
+			if group.logger != nil {
+				group.logger.Warn("Failed to write redundant status file", zap.Error(failure))
+			}
+		}
 	}
 
 	return nil
 }
 
+// writeStatusFileWithBackup writes a status file, returning the previous
+// content so that the write can be rolled back
+func writeStatusFileWithBackup(path string, data []byte) (statusFileBackup, error) {
+	backup := statusFileBackup{
+		Path: path,
+		Mode: 0644,
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		backup.Exists = true
+		backup.Mode = info.Mode().Perm()
+		previousData, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return backup, fmt.Errorf("failed to read current status file '%s': %v", path, readErr)
+		}
+		backup.Data = previousData
+	} else if !os.IsNotExist(err) {
+		return backup, fmt.Errorf("failed to stat status file '%s': %v", path, err)
+	}
+
+	if err := writeStatusFileFunc(path, data, 0644); err != nil {
+		return backup, fmt.Errorf("failed writing status file '%s': %v", path, err)
+	}
+
+	backup.Written = true
+	return backup, nil
+}
+
 func rollbackPersistedStatusFiles(backups []statusFileBackup) {
 	for i := len(backups) - 1; i >= 0; i-- {
 		backup := backups[i]
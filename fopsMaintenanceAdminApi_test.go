@@ -9,11 +9,18 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func resetMaintenanceHandlersForTest(t *testing.T) {
@@ -30,11 +37,65 @@ func TestAdminHandler_Routes(t *testing.T) {
 	handler := AdminHandler{}
 	routes := handler.Routes()
 
-	if len(routes) != 2 {
-		t.Errorf("Expected 2 routes, got %d", len(routes))
+	if len(routes) != 14 {
+		t.Errorf("Expected 14 routes, got %d", len(routes))
 	}
 }
 
+func TestAdminHandler_Routes_Disabled(t *testing.T) {
+	handler := AdminHandler{}
+
+	t.Setenv(adminDisabledEnv, "true")
+	assert.Empty(t, handler.Routes())
+
+	t.Setenv(adminDisabledEnv, "false")
+	assert.Len(t, handler.Routes(), 14)
+
+	t.Setenv(adminDisabledEnv, "not-a-bool")
+	assert.Len(t, handler.Routes(), 14)
+}
+
+func TestAdminHandler_Routes_BasePath(t *testing.T) {
+	handler := AdminHandler{}
+	patterns := func() []string {
+		var patterns []string
+		for _, route := range handler.Routes() {
+			patterns = append(patterns, route.Pattern)
+		}
+		return patterns
+	}
+
+	assert.Contains(t, patterns(), "/maintenance/status")
+
+	for _, basePath := range []string{"/fops/maintenance", "fops/maintenance/", " /fops/maintenance "} {
+		t.Setenv(adminBasePathEnv, basePath)
+		assert.Equal(t, []string{
+			"/fops/maintenance/status",
+			"/fops/maintenance/set",
+			"/fops/maintenance/hook",
+			"/fops/maintenance/set-all",
+			"/fops/maintenance/preview",
+			"/fops/maintenance/grant",
+			"/fops/maintenance/allowed-ips",
+			"/fops/maintenance/bypass-stats",
+			"/fops/maintenance/export",
+			"/fops/maintenance/import",
+			"/fops/maintenance/reload",
+			"/fops/maintenance/whoami",
+			"/fops/maintenance/openapi.json",
+			"/fops/maintenance/version",
+		}, patterns(), basePath)
+	}
+
+	// The OpenAPI document describes the prefixed paths
+	paths := openAPIDocument()["paths"].(map[string]interface{})
+	assert.Contains(t, paths, "/fops/maintenance/set")
+	assert.NotContains(t, paths, "/maintenance/set")
+
+	t.Setenv(adminBasePathEnv, "/")
+	assert.Contains(t, patterns(), "/maintenance/status")
+}
+
 func TestAdminHandler_GetStatus(t *testing.T) {
 	resetMaintenanceHandlersForTest(t)
 
@@ -412,3 +473,1270 @@ func TestAdminHandler_Toggle_PersistFailureDoesNotChangeMemoryState(t *testing.T
 	require.NoError(t, json.Unmarshal(content, &status))
 	assert.False(t, status.Enabled)
 }
+
+func TestAdminHandler_GetOpenAPI(t *testing.T) {
+	handler := AdminHandler{}
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/openapi.json", nil)
+	w := httptest.NewRecorder()
+
+	require.NoError(t, handler.getOpenAPI(w, req))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var document map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+	assert.Equal(t, "3.0.3", document["openapi"])
+
+	paths, ok := document["paths"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Contains(t, paths, "/maintenance/status")
+	assert.Contains(t, paths, "/maintenance/set")
+
+	// The set payload schema is generated from toggleRequest
+	set := paths["/maintenance/set"].(map[string]interface{})["post"].(map[string]interface{})
+	schema := set["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	properties := schema["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "boolean"}, properties["enabled"])
+	assert.Equal(t, map[string]interface{}{"type": "integer"}, properties["request_retention_mode_timeout"])
+	assert.Equal(t, []interface{}{"enabled"}, schema["required"])
+}
+
+func TestAdminHandler_GetOpenAPI_InvalidMethod(t *testing.T) {
+	handler := AdminHandler{}
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/openapi.json", nil)
+	w := httptest.NewRecorder()
+
+	err := handler.getOpenAPI(w, req)
+	require.Error(t, err)
+}
+
+func TestAdminHandler_Patch(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		expectedState stateResponse
+	}{
+		{
+			name: "patch retry_after only",
+			body: `{"retry_after": 900}`,
+			expectedState: stateResponse{
+				Enabled:                     true,
+				RequestRetentionModeTimeout: 30,
+				RetryAfter:                  900,
+			},
+		},
+		{
+			name: "patch enabled only",
+			body: `{"enabled": false}`,
+			expectedState: stateResponse{
+				Enabled:                     false,
+				RequestRetentionModeTimeout: 30,
+				RetryAfter:                  600,
+			},
+		},
+		{
+			name: "patch request retention timeout to zero",
+			body: `{"request_retention_mode_timeout": 0}`,
+			expectedState: stateResponse{
+				Enabled:                     true,
+				RequestRetentionModeTimeout: 0,
+				RetryAfter:                  600,
+			},
+		},
+		{
+			name: "empty patch keeps state",
+			body: `{}`,
+			expectedState: stateResponse{
+				Enabled:                     true,
+				RequestRetentionModeTimeout: 30,
+				RetryAfter:                  600,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMaintenanceHandlersForTest(t)
+
+			handler := AdminHandler{}
+			maintenanceHandler := &MaintenanceHandler{
+				enabled:                     true,
+				RequestRetentionModeTimeout: 30,
+				RetryAfter:                  600,
+			}
+			setMaintenanceHandler(maintenanceHandler)
+
+			req := httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			require.NoError(t, handler.toggle(w, req))
+
+			var response stateResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, tt.expectedState, response)
+			assert.Equal(t, tt.expectedState, currentState(maintenanceHandler))
+		})
+	}
+}
+
+func TestAdminHandler_Patch_PersistsOnlyWhenEnabledPatched(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	setMaintenanceHandler(&MaintenanceHandler{StatusFile: statusFile})
+
+	req := httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(`{"retry_after": 120}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	_, err := os.Stat(statusFile)
+	assert.True(t, os.IsNotExist(err))
+
+	req = httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	content, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+	var status persistedStatus
+	require.NoError(t, json.Unmarshal(content, &status))
+	assert.True(t, status.Enabled)
+	assert.NotNil(t, status.StartedAt)
+}
+
+func TestAdminHandler_Patch_InvalidBody(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	setMaintenanceHandler(&MaintenanceHandler{})
+
+	for _, body := range []string{`{invalid`, `{"retry_after": -1}`, `{"request_retention_mode_timeout": -5}`} {
+		req := httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(body))
+		err := handler.toggle(httptest.NewRecorder(), req)
+		require.Error(t, err, body)
+
+		apiErr, ok := err.(caddy.APIError)
+		require.True(t, ok)
+		assert.Equal(t, http.StatusBadRequest, apiErr.HTTPStatus)
+	}
+}
+
+func TestAdminHandler_Toggle_RetryAfter(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	maintenanceHandler := &MaintenanceHandler{RetryAfter: 600}
+	setMaintenanceHandler(maintenanceHandler)
+
+	// Omitting retry_after keeps the configured value
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, 600, currentState(maintenanceHandler).RetryAfter)
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "retry_after": 60}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, 60, currentState(maintenanceHandler).RetryAfter)
+}
+
+func TestAdminHandler_Toggle_GracePeriod(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	maintenanceHandler := &MaintenanceHandler{GracePeriod: caddy.Duration(10 * time.Second)}
+	setMaintenanceHandler(maintenanceHandler)
+
+	// Omitting grace_period keeps the configured value
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, "10s", currentState(maintenanceHandler).GracePeriod)
+	// Switching maintenance on starts the grace period
+	assert.True(t, maintenanceHandler.inGracePeriodLocked(time.Now()))
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "grace_period": "1m"}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, "1m0s", currentState(maintenanceHandler).GracePeriod)
+
+	req = httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(`{"grace_period": "0s"}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, handler.toggle(w, req))
+	assert.NotContains(t, w.Body.String(), "grace_period")
+	assert.Equal(t, caddy.Duration(0), maintenanceHandler.GracePeriod)
+
+	for _, body := range []string{`{"enabled": true, "grace_period": "soon"}`, `{"enabled": true, "grace_period": "-1s"}`} {
+		for _, method := range []string{http.MethodPost, http.MethodPatch} {
+			req := httptest.NewRequest(method, "/maintenance/set", bytes.NewBufferString(body))
+			err := handler.toggle(httptest.NewRecorder(), req)
+			require.Error(t, err, body)
+
+			apiErr, ok := err.(caddy.APIError)
+			require.True(t, ok)
+			assert.Equal(t, http.StatusBadRequest, apiErr.HTTPStatus)
+		}
+	}
+}
+
+func TestAdminHandler_Toggle_RetryAfterBounds(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		retryAfter int
+		expected   int
+		clamped    bool
+	}{
+		{name: "below min", method: http.MethodPost, retryAfter: 5, expected: 60, clamped: true},
+		{name: "above max", method: http.MethodPost, retryAfter: 3000000, expected: 3600, clamped: true},
+		{name: "within range", method: http.MethodPost, retryAfter: 120, expected: 120},
+		{name: "patch above max", method: http.MethodPatch, retryAfter: 3000000, expected: 3600, clamped: true},
+		{name: "patch within range", method: http.MethodPatch, retryAfter: 600, expected: 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMaintenanceHandlersForTest(t)
+
+			core, logs := observer.New(zap.WarnLevel)
+			maintenanceHandler := &MaintenanceHandler{RetryAfterMin: 60, RetryAfterMax: 3600, logger: zap.New(core)}
+			setMaintenanceHandler(maintenanceHandler)
+
+			body := fmt.Sprintf(`{"enabled": true, "retry_after": %d}`, tt.retryAfter)
+			req := httptest.NewRequest(tt.method, "/maintenance/set", bytes.NewBufferString(body))
+			require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+			assert.Equal(t, tt.expected, currentState(maintenanceHandler).RetryAfter)
+
+			entries := logs.FilterMessage("Clamped retry_after supplied via admin API").All()
+			if !tt.clamped {
+				assert.Empty(t, entries)
+				return
+			}
+			require.Len(t, entries, 1)
+			assert.Equal(t, int64(tt.retryAfter), entries[0].ContextMap()["requested"])
+			assert.Equal(t, int64(tt.expected), entries[0].ContextMap()["retry_after"])
+		})
+	}
+
+	t.Run("patch to zero restores the default", func(t *testing.T) {
+		resetMaintenanceHandlersForTest(t)
+
+		maintenanceHandler := &MaintenanceHandler{RetryAfter: 600, RetryAfterMin: 60}
+		setMaintenanceHandler(maintenanceHandler)
+
+		req := httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(`{"retry_after": 0}`))
+		require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+		assert.Equal(t, defaultRetryAfter, currentState(maintenanceHandler).RetryAfter)
+	})
+}
+
+func TestAdminHandler_Toggle_EstimatedEnd(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "estimated_end": "2026-03-02T16:30:00Z"}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, "2026-03-02T16:30:00Z", maintenanceHandler.templateData().EstimatedEnd.Format(time.RFC3339))
+
+	// Omitting estimated_end keeps the current value
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, "2026-03-02T16:30:00Z", maintenanceHandler.templateData().EstimatedEnd.Format(time.RFC3339))
+
+	req = httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(`{"estimated_end": "2026-03-02T18:00:00Z"}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, "2026-03-02T18:00:00Z", maintenanceHandler.templateData().EstimatedEnd.Format(time.RFC3339))
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "estimated_end": "soon"}`))
+	err := handler.toggle(httptest.NewRecorder(), req)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(caddy.APIError).HTTPStatus)
+}
+
+func TestAdminHandler_Toggle_AllFieldsAtOnce(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+	require.NoError(t, os.WriteFile(templateFile, []byte(`<p>{{.Message}} until {{.EstimatedEnd.Format "15:04"}}</p>`), 0644))
+	maintenanceHandler := &MaintenanceHandler{HTMLTemplate: templateFile, Message: "Configured message"}
+	require.NoError(t, maintenanceHandler.Provision(caddy.Context{}))
+	setMaintenanceHandler(maintenanceHandler)
+
+	body := `{"enabled": true, "message": "Database upgrade", "retry_after": 900, "estimated_end": "2026-03-02T16:30:00Z"}`
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(body))
+	require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+
+	// The very first page served reflects every field
+	page := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, serveMaintenancePage(page, w, maintenanceHandler))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "900", w.Header().Get("Retry-After"))
+	assert.Equal(t, "<p>Database upgrade until 16:30</p>", w.Body.String())
+
+	page = httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	page.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	require.NoError(t, serveMaintenancePage(page, w, maintenanceHandler))
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Database upgrade", response["message"])
+	assert.Equal(t, float64(900), response["retry_after"])
+	assert.Equal(t, "2026-03-02T16:30:00Z", response["estimated_end"])
+
+	// Omitting message keeps the current one, an empty message restores the default
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, "Database upgrade", maintenanceHandler.templateData().Message)
+
+	req = httptest.NewRequest(http.MethodPatch, "/maintenance/set", bytes.NewBufferString(`{"message": ""}`))
+	require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, defaultMessage, maintenanceHandler.templateData().Message)
+}
+
+func TestAdminHandler_Toggle_OversizedBody(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	oversized := `{"enabled": true, "padding": "` + strings.Repeat("x", defaultAdminMaxBodyBytes) + `"}`
+
+	for _, method := range []string{http.MethodPost, http.MethodPatch} {
+		t.Run(method, func(t *testing.T) {
+			req := httptest.NewRequest(method, "/maintenance/set", bytes.NewBufferString(oversized))
+			err := handler.toggle(httptest.NewRecorder(), req)
+			require.Error(t, err)
+
+			apiErr, ok := err.(caddy.APIError)
+			require.True(t, ok)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, apiErr.HTTPStatus)
+			assert.False(t, currentState(maintenanceHandler).Enabled)
+		})
+	}
+
+	t.Run("configurable cap", func(t *testing.T) {
+		t.Setenv(adminMaxBodyBytesEnv, "8")
+
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+		err := handler.toggle(httptest.NewRecorder(), req)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, err.(caddy.APIError).HTTPStatus)
+		assert.Contains(t, err.Error(), "request body exceeds 8 bytes")
+	})
+}
+
+func TestAdminHandler_Toggle_UnknownField(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	for _, method := range []string{http.MethodPost, http.MethodPatch} {
+		t.Run(method, func(t *testing.T) {
+			req := httptest.NewRequest(method, "/maintenance/set", bytes.NewBufferString(`{"enable": true}`))
+			err := handler.toggle(httptest.NewRecorder(), req)
+			require.Error(t, err)
+
+			apiErr, ok := err.(caddy.APIError)
+			require.True(t, ok)
+			assert.Equal(t, http.StatusBadRequest, apiErr.HTTPStatus)
+			assert.Equal(t, `unknown field "enable" in request body`, apiErr.Err.Error())
+			assert.False(t, currentState(maintenanceHandler).Enabled)
+		})
+	}
+}
+
+func TestAdminHandler_Toggle_StrictMode(t *testing.T) {
+	tests := []struct {
+		name            string
+		strict          bool
+		expectedStatus  int
+		expectedChanged []bool
+	}{
+		{
+			name:            "non-strict repeated enable succeeds",
+			strict:          false,
+			expectedStatus:  http.StatusOK,
+			expectedChanged: []bool{true, false},
+		},
+		{
+			name:            "strict repeated enable conflicts",
+			strict:          true,
+			expectedStatus:  http.StatusConflict,
+			expectedChanged: []bool{true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMaintenanceHandlersForTest(t)
+
+			handler := AdminHandler{}
+			maintenanceHandler := &MaintenanceHandler{enabled: false}
+			setMaintenanceHandler(maintenanceHandler)
+
+			bodyBytes, err := json.Marshal(map[string]interface{}{
+				"enabled": true,
+				"strict":  tt.strict,
+			})
+			require.NoError(t, err)
+
+			var changed []bool
+			var lastErr error
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBuffer(bodyBytes))
+				w := httptest.NewRecorder()
+
+				lastErr = handler.toggle(w, req)
+				if lastErr != nil {
+					break
+				}
+
+				var response toggleResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				assert.True(t, response.Enabled)
+				changed = append(changed, response.Changed)
+			}
+
+			assert.Equal(t, tt.expectedChanged, changed)
+			if tt.expectedStatus == http.StatusOK {
+				require.NoError(t, lastErr)
+			} else {
+				require.Error(t, lastErr)
+				apiErr, ok := lastErr.(caddy.APIError)
+				require.True(t, ok)
+				assert.Equal(t, tt.expectedStatus, apiErr.HTTPStatus)
+				assert.Contains(t, apiErr.Err.Error(), "already enabled")
+			}
+
+			maintenanceHandler.enabledMux.RLock()
+			assert.True(t, maintenanceHandler.enabled)
+			maintenanceHandler.enabledMux.RUnlock()
+		})
+	}
+}
+
+func TestAdminHandler_Toggle_StrictModeConcurrent(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	// Slow status writes widen the window between the check and the update
+	writeStatusFileFunc = func(path string, data []byte, mode os.FileMode) error {
+		time.Sleep(10 * time.Millisecond)
+		return atomicWriteFile(path, data, mode)
+	}
+	t.Cleanup(func() {
+		writeStatusFileFunc = atomicWriteFile
+	})
+
+	maintenanceHandler := &MaintenanceHandler{StatusFile: filepath.Join(t.TempDir(), "status.json")}
+	setMaintenanceHandler(maintenanceHandler)
+
+	const requests = 8
+	var succeeded, conflicted int32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := applyToggle(toggleRequest{Enabled: true, Strict: true})
+			if err == nil {
+				atomic.AddInt32(&succeeded, 1)
+				return
+			}
+			if apiErr, ok := err.(caddy.APIError); ok && apiErr.HTTPStatus == http.StatusConflict {
+				atomic.AddInt32(&conflicted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), succeeded)
+	assert.Equal(t, int32(requests-1), conflicted)
+}
+
+// countStatusFileWrites counts status file writes for the duration of the test
+func countStatusFileWrites(t *testing.T) *int32 {
+	t.Helper()
+
+	var writes int32
+	writeStatusFileFunc = func(path string, data []byte, mode os.FileMode) error {
+		atomic.AddInt32(&writes, 1)
+		return atomicWriteFile(path, data, mode)
+	}
+	t.Cleanup(func() {
+		writeStatusFileFunc = atomicWriteFile
+	})
+
+	return &writes
+}
+
+func TestAdminHandler_Toggle_DebouncedPersistence(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	writes := countStatusFileWrites(t)
+
+	handler := AdminHandler{}
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	maintenanceHandler := &MaintenanceHandler{
+		StatusFile:         statusFile,
+		StatusFileDebounce: caddy.Duration(100 * time.Millisecond),
+	}
+	setMaintenanceHandler(maintenanceHandler)
+
+	for i := 0; i < 20; i++ {
+		body := fmt.Sprintf(`{"enabled": %t}`, i%2 == 0)
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(body))
+		require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+
+		// In-memory state is updated immediately
+		maintenanceHandler.enabledMux.RLock()
+		assert.Equal(t, i%2 == 0, maintenanceHandler.enabled)
+		maintenanceHandler.enabledMux.RUnlock()
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(writes))
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(writes) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	content, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"enabled":false}`, string(content))
+
+	// No further writes once the window elapsed
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(writes))
+}
+
+func TestMaintenanceHandler_Cleanup_FlushesDebouncedStatus(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	writes := countStatusFileWrites(t)
+
+	handler := AdminHandler{}
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	maintenanceHandler := &MaintenanceHandler{
+		StatusFile:         statusFile,
+		StatusFileDebounce: caddy.Duration(time.Hour),
+	}
+	setMaintenanceHandler(maintenanceHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.Equal(t, int32(0), atomic.LoadInt32(writes))
+
+	require.NoError(t, maintenanceHandler.Cleanup())
+	assert.Equal(t, int32(1), atomic.LoadInt32(writes))
+
+	content, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+	var status persistedStatus
+	require.NoError(t, json.Unmarshal(content, &status))
+	assert.True(t, status.Enabled)
+
+	// Nothing pending anymore
+	require.NoError(t, maintenanceHandler.Cleanup())
+	assert.Equal(t, int32(1), atomic.LoadInt32(writes))
+}
+
+func TestAdminHandler_Preview(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+	require.NoError(t, os.WriteFile(templateFile, []byte("<h1>Planned upgrade in progress</h1>"), 0644))
+
+	maintenanceHandler := &MaintenanceHandler{
+		HTMLTemplate: templateFile,
+		RetryAfter:   900,
+	}
+	require.NoError(t, maintenanceHandler.Provision(caddy.Context{}))
+	setMaintenanceHandler(maintenanceHandler)
+
+	// Maintenance mode is not enabled, the preview renders anyway
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/preview", nil)
+	w := httptest.NewRecorder()
+
+	require.NoError(t, handler.preview(w, req))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "900", w.Header().Get("Retry-After"))
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "<h1>Planned upgrade in progress</h1>", w.Body.String())
+}
+
+func TestAdminHandler_Preview_DefaultTemplate(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	setMaintenanceHandler(&MaintenanceHandler{})
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/preview", nil)
+	w := httptest.NewRecorder()
+
+	require.NoError(t, handler.preview(w, req))
+	assert.Equal(t, "300", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "We'll Be Back Soon!")
+}
+
+func TestAdminHandler_Preview_DisableRetryAfter(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	setMaintenanceHandler(&MaintenanceHandler{DisableRetryAfter: true})
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/preview", nil)
+	w := httptest.NewRecorder()
+
+	require.NoError(t, handler.preview(w, req))
+	assert.Empty(t, w.Header().Values("Retry-After"))
+}
+
+func TestAdminHandler_Preview_SameSelectionAsServed(t *testing.T) {
+	dir := t.TempDir()
+	templateFile := filepath.Join(dir, "maintenance.html")
+	lockdownFile := filepath.Join(dir, "lockdown.html")
+	snapshotFile := filepath.Join(dir, "snapshot.html")
+	require.NoError(t, os.WriteFile(templateFile, []byte(`<script nonce="{{.Nonce}}"></script>`), 0644))
+	require.NoError(t, os.WriteFile(lockdownFile, []byte("<h1>Locked</h1>"), 0644))
+	require.NoError(t, os.WriteFile(snapshotFile, []byte("<h1>Snapshot</h1>"), 0644))
+
+	preview := func(t *testing.T, maintenanceHandler *MaintenanceHandler) *httptest.ResponseRecorder {
+		t.Helper()
+		resetMaintenanceHandlersForTest(t)
+		require.NoError(t, maintenanceHandler.Provision(caddy.Context{}))
+		setMaintenanceHandler(maintenanceHandler)
+
+		w := httptest.NewRecorder()
+		require.NoError(t, AdminHandler{}.preview(w, httptest.NewRequest(http.MethodGet, "/maintenance/preview", nil)))
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	t.Run("lockdown", func(t *testing.T) {
+		w := preview(t, &MaintenanceHandler{HTMLTemplate: templateFile, LockdownTemplate: lockdownFile, Lockdown: true})
+		assert.Equal(t, "<h1>Locked</h1>", w.Body.String())
+		// A lockdown has no expected end
+		assert.Empty(t, w.Header().Values("Retry-After"))
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		w := preview(t, &MaintenanceHandler{HTMLTemplate: templateFile, SnapshotFile: snapshotFile})
+		assert.Equal(t, "<h1>Snapshot</h1>", w.Body.String())
+	})
+
+	t.Run("csp nonce", func(t *testing.T) {
+		w := preview(t, &MaintenanceHandler{HTMLTemplate: templateFile, CSP: true})
+		policy := w.Header().Get("Content-Security-Policy")
+		require.NotEmpty(t, policy)
+		nonce := strings.TrimSuffix(strings.TrimPrefix(w.Body.String(), `<script nonce="`), `"></script>`)
+		require.NotEmpty(t, nonce)
+		assert.Contains(t, policy, "'nonce-"+nonce+"'")
+	})
+}
+
+func TestAdminHandler_Whoami(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	maintenanceHandler := &MaintenanceHandler{
+		AllowedIPs:          []string{"203.0.113.0/24"},
+		UseForwardedHeaders: true,
+		TrustedProxies:      []string{"10.0.0.0/8"},
+	}
+	require.NoError(t, maintenanceHandler.Provision(caddy.Context{}))
+	setMaintenanceHandler(maintenanceHandler)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwarded    string
+		expectedIP   string
+		trustedProxy bool
+		allowed      bool
+	}{
+		{name: "behind trusted proxies", remoteAddr: "10.0.0.2:4242", forwarded: "203.0.113.7, 10.0.0.1", expectedIP: "203.0.113.7", trustedProxy: true, allowed: true},
+		{name: "forwarded IP not allowed", remoteAddr: "10.0.0.2:4242", forwarded: "198.51.100.9", expectedIP: "198.51.100.9", trustedProxy: true},
+		{name: "untrusted proxy", remoteAddr: "192.0.2.1:4242", forwarded: "203.0.113.7", expectedIP: "192.0.2.1"},
+		{name: "direct connection", remoteAddr: "203.0.113.8:4242", expectedIP: "203.0.113.8", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/maintenance/whoami", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, adminRouteHandler(t, "/maintenance/whoami").ServeHTTP(w, req))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var response whoamiResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, whoamiResponse{
+				RemoteAddr:          tt.remoteAddr,
+				ClientIP:            tt.expectedIP,
+				UseForwardedHeaders: true,
+				TrustedProxy:        tt.trustedProxy,
+				Allowed:             tt.allowed,
+			}, response)
+		})
+	}
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/whoami", nil)
+		err := AdminHandler{}.whoami(httptest.NewRecorder(), req)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusMethodNotAllowed, err.(caddy.APIError).HTTPStatus)
+	})
+
+	t.Run("no handler", func(t *testing.T) {
+		resetMaintenanceHandlersForTest(t)
+		err := AdminHandler{}.whoami(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/maintenance/whoami", nil))
+		require.Error(t, err)
+		assert.Equal(t, http.StatusNotFound, err.(caddy.APIError).HTTPStatus)
+	})
+}
+
+func TestAdminHandler_Preview_Errors(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/preview", nil)
+	err := handler.preview(httptest.NewRecorder(), req)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, err.(caddy.APIError).HTTPStatus)
+
+	setMaintenanceHandler(&MaintenanceHandler{})
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/preview", nil)
+	err = handler.preview(httptest.NewRecorder(), req)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, err.(caddy.APIError).HTTPStatus)
+}
+
+func TestAdminHandler_Toggle_RecordsStartedAt(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	maintenanceHandler := &MaintenanceHandler{StatusFile: statusFile}
+	setMaintenanceHandler(maintenanceHandler)
+
+	before := time.Now()
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+
+	startedAt := maintenanceHandler.templateData().StartedAt
+	assert.False(t, startedAt.Before(before))
+	assert.False(t, startedAt.After(time.Now()))
+
+	content, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+	var status persistedStatus
+	require.NoError(t, json.Unmarshal(content, &status))
+	require.NotNil(t, status.StartedAt)
+	assert.True(t, status.StartedAt.Equal(startedAt))
+
+	// Enabling again keeps the original start time
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.True(t, maintenanceHandler.templateData().StartedAt.Equal(startedAt))
+
+	// Disabling clears it
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": false}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.True(t, maintenanceHandler.templateData().StartedAt.IsZero())
+
+	content, err = os.ReadFile(statusFile)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"enabled":false}`, string(content))
+}
+
+func TestAdminHandler_SetAll(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	tmpDir := t.TempDir()
+	brokenStatusFile := filepath.Join(tmpDir, "broken.json")
+	writeStatusFileFunc = func(path string, data []byte, mode os.FileMode) error {
+		if path == brokenStatusFile {
+			return fmt.Errorf("disk full")
+		}
+		return atomicWriteFile(path, data, mode)
+	}
+	t.Cleanup(func() {
+		writeStatusFileFunc = atomicWriteFile
+	})
+
+	handler := AdminHandler{}
+	shop := &MaintenanceHandler{Name: "shop", StatusFile: filepath.Join(tmpDir, "shop.json")}
+	blog := &MaintenanceHandler{Name: "blog", StatusFile: brokenStatusFile}
+	api := &MaintenanceHandler{enabled: true}
+	registerMaintenanceHandler(shop)
+	registerMaintenanceHandler(blog)
+	registerMaintenanceHandler(api)
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set-all", bytes.NewBufferString(`{"enabled": true}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, handler.setAll(w, req))
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	var response setAllResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response.Instances, 3)
+
+	assert.Equal(t, instanceResult{Name: "shop", Enabled: true, Changed: true}, response.Instances[0])
+	assert.Equal(t, "blog", response.Instances[1].Name)
+	assert.False(t, response.Instances[1].Enabled)
+	assert.Contains(t, response.Instances[1].Error, "disk full")
+	assert.Equal(t, instanceResult{Enabled: true, Changed: false}, response.Instances[2])
+
+	// The failure did not prevent updating the other instances
+	assert.True(t, currentState(shop).Enabled)
+	assert.False(t, currentState(blog).Enabled)
+	assert.True(t, currentState(api).Enabled)
+
+	var status persistedStatus
+	data, err := os.ReadFile(shop.StatusFile)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &status))
+	assert.True(t, status.Enabled)
+
+	// Without failures the request succeeds
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set-all", bytes.NewBufferString(`{"enabled": false}`))
+	w = httptest.NewRecorder()
+	blog.StatusFile = ""
+	require.NoError(t, handler.setAll(w, req))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, currentState(shop).Enabled)
+	assert.False(t, currentState(api).Enabled)
+}
+
+func TestAdminHandler_SetAll_Errors(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	handler := AdminHandler{}
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/set-all", nil)
+	err := handler.setAll(httptest.NewRecorder(), req)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, err.(caddy.APIError).HTTPStatus)
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set-all", bytes.NewBufferString(`{"enabled": true}`))
+	err = handler.setAll(httptest.NewRecorder(), req)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, err.(caddy.APIError).HTTPStatus)
+}
+
+// adminRouteHandler returns the registered handler for an admin route pattern
+func adminRouteHandler(t *testing.T, pattern string) caddy.AdminHandler {
+	t.Helper()
+
+	for _, route := range (AdminHandler{}).Routes() {
+		if route.Pattern == pattern {
+			return route.Handler
+		}
+	}
+
+	t.Fatalf("no admin route for %s", pattern)
+	return nil
+}
+
+func TestAdminHandler_JSONErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		pattern       string
+		body          string
+		register      bool
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name:          "not found",
+			method:        http.MethodGet,
+			pattern:       "/maintenance/status",
+			expectedCode:  http.StatusNotFound,
+			expectedError: "maintenance handler not found",
+		},
+		{
+			name:          "bad request",
+			method:        http.MethodPost,
+			pattern:       "/maintenance/set",
+			body:          `{"enabled": "yes"}`,
+			register:      true,
+			expectedCode:  http.StatusBadRequest,
+			expectedError: "cannot unmarshal",
+		},
+		{
+			name:          "method not allowed",
+			method:        http.MethodDelete,
+			pattern:       "/maintenance/set",
+			register:      true,
+			expectedCode:  http.StatusMethodNotAllowed,
+			expectedError: "method not allowed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMaintenanceHandlersForTest(t)
+			if tt.register {
+				setMaintenanceHandler(&MaintenanceHandler{})
+			}
+
+			req := httptest.NewRequest(tt.method, tt.pattern, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			require.NoError(t, adminRouteHandler(t, tt.pattern).ServeHTTP(w, req))
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+			var response map[string]any
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Len(t, response, 2)
+			assert.Equal(t, float64(tt.expectedCode), response["code"])
+			assert.Contains(t, response["error"], tt.expectedError)
+		})
+	}
+}
+
+func TestWriteAdminError_PlainError(t *testing.T) {
+	w := httptest.NewRecorder()
+	require.NoError(t, writeAdminError(w, fmt.Errorf("boom")))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error": "boom", "code": 500}`, w.Body.String())
+}
+
+func TestAdminHandler_Toggle_Duration(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	maintenanceHandler := &MaintenanceHandler{StatusFile: statusFile}
+	setMaintenanceHandler(maintenanceHandler)
+	t.Cleanup(func() { _ = maintenanceHandler.Cleanup() })
+
+	readStatus := func() persistedStatus {
+		var status persistedStatus
+		data, err := os.ReadFile(statusFile)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &status))
+		return status
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "duration": "100ms"}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, handler.toggle(w, req))
+
+	var response toggleResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.NotNil(t, response.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(100*time.Millisecond), *response.ExpiresAt, time.Second)
+
+	status := readStatus()
+	assert.True(t, status.Enabled)
+	require.NotNil(t, status.ExpiresAt)
+	assert.True(t, status.ExpiresAt.Equal(*response.ExpiresAt))
+
+	require.Eventually(t, func() bool {
+		return !currentState(maintenanceHandler).Enabled
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return !readStatus().Enabled
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Nil(t, readStatus().ExpiresAt)
+}
+
+func TestAdminHandler_Toggle_DurationReplaced(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+	t.Cleanup(func() { _ = maintenanceHandler.Cleanup() })
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "duration": "50ms"}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+
+	// Enabling again without a duration cancels the automatic disable
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, currentState(maintenanceHandler).Enabled)
+}
+
+func TestAdminHandler_Toggle_InvalidDuration(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	handler := AdminHandler{}
+	setMaintenanceHandler(&MaintenanceHandler{})
+
+	tests := []struct {
+		body          string
+		expectedError string
+	}{
+		{body: `{"enabled": true, "duration": "soon"}`, expectedError: "invalid duration"},
+		{body: `{"enabled": true, "duration": "-5m"}`, expectedError: "duration must be positive"},
+		{body: `{"enabled": false, "duration": "5m"}`, expectedError: "duration requires enabled to be true"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(tt.body))
+		err := handler.toggle(httptest.NewRecorder(), req)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, err.(caddy.APIError).HTTPStatus)
+		assert.Contains(t, err.Error(), tt.expectedError)
+	}
+}
+
+func TestMaintenanceHandler_Provision_PersistedExpiry(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	t.Run("expired while stopped", func(t *testing.T) {
+		statusFile := filepath.Join(t.TempDir(), "status.json")
+		expiresAt := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+		require.NoError(t, os.WriteFile(statusFile, []byte(`{"enabled":true,"expires_at":"`+expiresAt+`"}`), 0644))
+
+		h := &MaintenanceHandler{StatusFile: statusFile}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+		assert.False(t, currentState(h).Enabled)
+	})
+
+	t.Run("still running", func(t *testing.T) {
+		statusFile := filepath.Join(t.TempDir(), "status.json")
+		expiresAt := time.Now().Add(200 * time.Millisecond).UTC().Format(time.RFC3339Nano)
+		require.NoError(t, os.WriteFile(statusFile, []byte(`{"enabled":true,"expires_at":"`+expiresAt+`"}`), 0644))
+
+		h := &MaintenanceHandler{StatusFile: statusFile}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+		assert.True(t, currentState(h).Enabled)
+
+		require.Eventually(t, func() bool {
+			return !currentState(h).Enabled
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestAdminHandler_ForcedMaintenance(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	t.Setenv(forceEnv, "1")
+
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	require.NoError(t, os.WriteFile(statusFile, []byte(`{"enabled": false}`), 0644))
+
+	maintenanceHandler := &MaintenanceHandler{StatusFile: statusFile}
+	require.NoError(t, maintenanceHandler.Provision(caddy.Context{}))
+	assert.True(t, currentState(maintenanceHandler).Enabled, "the environment overrides the persisted status")
+
+	handler := AdminHandler{}
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/status", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, handler.getStatus(w, req))
+	assert.JSONEq(t, `{"enabled": true, "forced": true}`, w.Body.String())
+
+	for _, tc := range []struct {
+		method string
+		body   string
+	}{
+		{method: http.MethodPost, body: `{"enabled": false}`},
+		{method: http.MethodPatch, body: `{"enabled": false}`},
+	} {
+		req = httptest.NewRequest(tc.method, "/maintenance/set", bytes.NewBufferString(tc.body))
+		err := handler.toggle(httptest.NewRecorder(), req)
+		require.Error(t, err, tc.method)
+		assert.Equal(t, http.StatusConflict, err.(caddy.APIError).HTTPStatus)
+		assert.Contains(t, err.Error(), forceEnv)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set-all", bytes.NewBufferString(`{"enabled": false}`))
+	w = httptest.NewRecorder()
+	require.NoError(t, handler.setAll(w, req))
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	var response setAllResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response.Instances, 1)
+	assert.True(t, response.Instances[0].Enabled)
+	assert.Contains(t, response.Instances[0].Error, forceEnv)
+
+	// Enabling is still accepted, and an expiry cannot end forced maintenance
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "retry_after": 120}`))
+	w = httptest.NewRecorder()
+	require.NoError(t, handler.toggle(w, req))
+	assert.Equal(t, 120, currentState(maintenanceHandler).RetryAfter)
+
+	maintenanceHandler.enabledMux.Lock()
+	maintenanceHandler.setEnabledLocked(false, time.Now())
+	maintenanceHandler.enabledMux.Unlock()
+	assert.True(t, currentState(maintenanceHandler).Enabled)
+
+	core, logs := observer.New(zap.InfoLevel)
+	maintenanceHandler.logger = zap.New(core)
+	t.Cleanup(func() { _ = maintenanceHandler.Cleanup() })
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "duration": "20ms"}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	// The expiry would have run by now, were it scheduled
+	maintenanceHandler.expire(expiresAtOf([]*MaintenanceHandler{maintenanceHandler}))
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, currentState(maintenanceHandler).Enabled)
+	assert.Zero(t, logs.FilterMessage("Maintenance duration elapsed, maintenance mode disabled").Len())
+
+	data, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"enabled":false`)
+}
+
+func TestMaintenanceForced(t *testing.T) {
+	for value, expected := range map[string]bool{"": false, "1": true, "true": true, "0": false, "yes": false} {
+		t.Setenv(forceEnv, value)
+		assert.Equal(t, expected, maintenanceForced(), value)
+	}
+}
+
+func TestAdminHandler_GetVersion(t *testing.T) {
+	handler := AdminHandler{}
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/version", nil)
+	w := httptest.NewRecorder()
+
+	require.NoError(t, handler.getVersion(w, req))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var response versionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "github.com/e-frogg/fops-caddy-maintenance", response.Module)
+	assert.NotEmpty(t, response.Version)
+	assert.NotEmpty(t, response.GoVersion)
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/version", nil)
+	err := handler.getVersion(httptest.NewRecorder(), req)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, err.(caddy.APIError).HTTPStatus)
+}
+
+func TestBuildVersion(t *testing.T) {
+	t.Cleanup(func() {
+		readBuildInfoFunc = debug.ReadBuildInfo
+	})
+
+	readBuildInfoFunc = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{
+			GoVersion: "go1.25.0",
+			Main:      debug.Module{Path: "caddy"},
+			Deps: []*debug.Module{
+				{Path: "github.com/caddyserver/caddy/v2", Version: "v2.10.2"},
+				{Path: "github.com/e-frogg/fops-caddy-maintenance", Version: "v1.4.0"},
+			},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "0123abcd"},
+				{Key: "vcs.time", Value: "2026-03-01T12:00:00Z"},
+				{Key: "vcs.modified", Value: "true"},
+			},
+		}, true
+	}
+	assert.Equal(t, versionResponse{
+		Module:    "github.com/e-frogg/fops-caddy-maintenance",
+		Version:   "v1.4.0",
+		GoVersion: "go1.25.0",
+		Revision:  "0123abcd",
+		Time:      "2026-03-01T12:00:00Z",
+		Modified:  true,
+	}, buildVersion())
+
+	readBuildInfoFunc = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{
+			Main: debug.Module{Path: "caddy"},
+			Deps: []*debug.Module{{
+				Path:    "github.com/e-frogg/fops-caddy-maintenance",
+				Version: "v1.4.0",
+				Replace: &debug.Module{Path: "../fops-caddy-maintenance", Version: "v1.5.0-dev"},
+			}},
+		}, true
+	}
+	assert.Equal(t, "v1.5.0-dev", buildVersion().Version)
+
+	readBuildInfoFunc = func() (*debug.BuildInfo, bool) { return nil, false }
+	assert.Equal(t, "unknown", buildVersion().Version)
+}
+
+func TestAdminHandler_Toggle_RedundantStatusFiles(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	tmpDir := t.TempDir()
+	primary := filepath.Join(tmpDir, "primary.json")
+	mirror := filepath.Join(tmpDir, "mirror.json")
+	failing := map[string]bool{}
+	writeStatusFileFunc = func(path string, data []byte, mode os.FileMode) error {
+		if failing[path] {
+			return fmt.Errorf("disk unavailable")
+		}
+		return atomicWriteFile(path, data, mode)
+	}
+	t.Cleanup(func() {
+		writeStatusFileFunc = atomicWriteFile
+	})
+
+	maintenanceHandler := &MaintenanceHandler{StatusFile: primary, StatusFiles: []string{mirror}}
+	registerMaintenanceHandler(maintenanceHandler)
+	handler := AdminHandler{}
+
+	readEnabled := func(path string) bool {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var status persistedStatus
+		require.NoError(t, json.Unmarshal(data, &status))
+		return status.Enabled
+	}
+
+	// Both copies are written
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.True(t, readEnabled(primary))
+	assert.True(t, readEnabled(mirror))
+
+	// One failing copy does not fail the request
+	failing[primary] = true
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": false}`))
+	require.NoError(t, handler.toggle(httptest.NewRecorder(), req))
+	assert.False(t, currentState(maintenanceHandler).Enabled)
+	assert.True(t, readEnabled(primary), "the failed copy keeps its previous content")
+	assert.False(t, readEnabled(mirror))
+
+	// Every copy failing fails the request
+	failing[mirror] = true
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+	err := handler.toggle(httptest.NewRecorder(), req)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "disk unavailable")
+	assert.False(t, currentState(maintenanceHandler).Enabled)
+}
+
+func TestPersistStatusFiles_RollbackWhenAllCopiesFail(t *testing.T) {
+	tmpDir := t.TempDir()
+	other := filepath.Join(tmpDir, "other.json")
+	require.NoError(t, os.WriteFile(other, []byte(`{"enabled":false}`), 0644))
+	broken := filepath.Join(tmpDir, "broken.json")
+	brokenMirror := filepath.Join(tmpDir, "broken-mirror.json")
+
+	writeStatusFileFunc = func(path string, data []byte, mode os.FileMode) error {
+		if path == broken || path == brokenMirror {
+			return fmt.Errorf("disk unavailable")
+		}
+		return atomicWriteFile(path, data, mode)
+	}
+	t.Cleanup(func() {
+		writeStatusFileFunc = atomicWriteFile
+	})
+
+	err := persistStatusFiles([]statusFileGroup{
+		{paths: []string{other}},
+		{paths: []string{broken, brokenMirror}},
+	}, []byte(`{"enabled":true}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), broken)
+	assert.Contains(t, err.Error(), brokenMirror)
+
+	data, readErr := os.ReadFile(other)
+	require.NoError(t, readErr)
+	assert.Equal(t, `{"enabled":false}`, string(data), "the other group is rolled back")
+}
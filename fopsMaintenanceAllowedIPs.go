@@ -0,0 +1,148 @@
+package fopsMaintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// allowedIPsResponse is the payload returned by PUT on the allowed-ips endpoint
+type allowedIPsResponse struct {
+	AllowedIPs []string `json:"allowed_ips"`
+	// Persisted lists the allowed_ips_file paths written, with persist=true
+	Persisted []string `json:"persisted,omitempty"`
+}
+
+// allowedIPs replaces the allow-list of every instance with the JSON array
+// of allowed_ips entries in the request body. The entries are validated for
+// every instance before any allow-list is replaced.
+func (h AdminHandler) allowedIPs(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPut {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	persist := false
+	if value := r.URL.Query().Get("persist"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid persist value: %v", err),
+			}
+		}
+		persist = parsed
+	}
+
+	var entries []string
+	if err := decodeAdminRequest(w, r, &entries); err != nil {
+		return err
+	}
+	if entries == nil {
+		entries = []string{}
+	}
+	for i, entry := range entries {
+		entries[i] = strings.TrimSpace(entry)
+	}
+
+	handlers := getMaintenanceHandlers()
+	if len(handlers) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	lists := make([]*allowList, len(handlers))
+	for i, maintenanceHandler := range handlers {
+		list, err := maintenanceHandler.compileAllowList(entries)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid allowed IP list: %v", err),
+			}
+		}
+		lists[i] = list
+	}
+
+	var persisted []string
+	if persist {
+		for _, maintenanceHandler := range handlers {
+			if maintenanceHandler.AllowedIPsFile != "" && !slices.Contains(persisted, maintenanceHandler.AllowedIPsFile) {
+				persisted = append(persisted, maintenanceHandler.AllowedIPsFile)
+			}
+		}
+		if len(persisted) == 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("persist requires an allowed_ips_file"),
+			}
+		}
+		for _, entry := range entries {
+			if !isIPListEntry(entry) {
+				return caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        fmt.Errorf("cannot persist '%s', allowed_ips_file only holds IPs and CIDR ranges", entry),
+				}
+			}
+		}
+		content := allowedIPsFileContent(entries)
+		for _, path := range persisted {
+			if err := atomicWriteFile(path, content, 0644); err != nil {
+				return caddy.APIError{
+					HTTPStatus: http.StatusInternalServerError,
+					Err:        fmt.Errorf("failed to persist allowed IPs to '%s': %v", path, err),
+				}
+			}
+		}
+	}
+
+	for i, maintenanceHandler := range handlers {
+		maintenanceHandler.setAllowList(lists[i])
+		maintenanceHandler.ipMux.Lock()
+		maintenanceHandler.AllowedIPs = slices.Clone(entries)
+		// The written file is not a change for the next reload
+		if persist && maintenanceHandler.AllowedIPsFile != "" {
+			maintenanceHandler.allowedIPsFileEntries = slices.Clone(entries)
+		}
+		maintenanceHandler.ipMux.Unlock()
+	}
+	pruneBypassStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(allowedIPsResponse{
+		AllowedIPs: entries,
+		Persisted:  persisted,
+	})
+}
+
+// isIPListEntry reports whether entry is an IP or a CIDR range, the entries
+// an allowed_ips_file is loaded with
+func isIPListEntry(entry string) bool {
+	if strings.Contains(entry, "/") {
+		_, _, err := net.ParseCIDR(entry)
+		return err == nil
+	}
+
+	return net.ParseIP(entry) != nil
+}
+
+// allowedIPsFileContent formats entries as an allowed_ips_file, one per line
+func allowedIPsFileContent(entries []string) []byte {
+	var content strings.Builder
+	content.WriteString("# Written by the maintenance admin API\n")
+	for _, entry := range entries {
+		content.WriteString(entry)
+		content.WriteString("\n")
+	}
+
+	return []byte(content.String())
+}
@@ -0,0 +1,214 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putAllowedIPsForTest pushes an allow-list through the admin endpoint
+func putAllowedIPsForTest(t *testing.T, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/maintenance/allowed-ips"+query, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.allowedIPs).ServeHTTP(w, req))
+	return w
+}
+
+func TestAdminHandler_AllowedIPs(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	first := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}}
+	require.NoError(t, first.Provision(caddy.Context{}))
+	second := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}}
+	require.NoError(t, second.Provision(caddy.Context{}))
+	registerMaintenanceHandler(first)
+	registerMaintenanceHandler(second)
+
+	w := putAllowedIPsForTest(t, "", `[" 198.51.100.0/24 ", "!198.51.100.7", "2001:db8::1"]`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response allowedIPsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{"198.51.100.0/24", "!198.51.100.7", "2001:db8::1"}, response.AllowedIPs)
+	assert.Empty(t, response.Persisted)
+
+	for _, h := range []*MaintenanceHandler{first, second} {
+		assert.False(t, h.isIPAllowed("192.0.2.10"))
+		assert.True(t, h.isIPAllowed("198.51.100.1"))
+		assert.False(t, h.isIPAllowed("198.51.100.7"))
+		assert.True(t, h.isIPAllowed("2001:db8::1"))
+		assert.Equal(t, response.AllowedIPs, h.AllowedIPs)
+	}
+
+	// An empty list denies every client
+	w = putAllowedIPsForTest(t, "", `[]`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"allowed_ips": []}`, w.Body.String())
+	assert.False(t, first.isIPAllowed("198.51.100.1"))
+}
+
+func TestAdminHandler_AllowedIPs_InvalidPayload(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	first := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}}
+	require.NoError(t, first.Provision(caddy.Context{}))
+	registerMaintenanceHandler(first)
+	second := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}}
+	require.NoError(t, second.Provision(caddy.Context{}))
+	registerMaintenanceHandler(second)
+
+	for name, body := range map[string]string{
+		"invalid IP":      `["192.0.2.300"]`,
+		"invalid CIDR":    `["192.0.2.0/33"]`,
+		"bare exception":  `["!"]`,
+		"empty entry":     `["198.51.100.1", ""]`,
+		"ASN without db":  `["198.51.100.1", "AS64496"]`,
+		"object":          `{"allowed_ips": ["198.51.100.1"]}`,
+		"malformed JSON":  `{invalid`,
+		"non-string item": `[42]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := putAllowedIPsForTest(t, "", body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+			for _, h := range []*MaintenanceHandler{first, second} {
+				assert.True(t, h.isIPAllowed("192.0.2.10"))
+				assert.False(t, h.isIPAllowed("198.51.100.1"))
+				assert.Equal(t, []string{"192.0.2.10"}, h.AllowedIPs)
+			}
+		})
+	}
+}
+
+func TestAdminHandler_AllowedIPs_Persist(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	file := filepath.Join(t.TempDir(), "allowed_ips.txt")
+	require.NoError(t, os.WriteFile(file, []byte("192.0.2.10\n"), 0644))
+	h := &MaintenanceHandler{AllowedIPsFile: file}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	w := putAllowedIPsForTest(t, "?persist=true", `["198.51.100.0/24", "2001:db8::1"]`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response allowedIPsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{file}, response.Persisted)
+
+	entries, err := h.loadIPsFromFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"198.51.100.0/24", "2001:db8::1"}, entries)
+
+	// The persisted list is the one loaded on the next provision
+	reloaded := &MaintenanceHandler{AllowedIPsFile: file}
+	require.NoError(t, reloaded.Provision(caddy.Context{}))
+	assert.False(t, reloaded.isIPAllowed("192.0.2.10"))
+	assert.True(t, reloaded.isIPAllowed("198.51.100.1"))
+	assert.True(t, reloaded.isIPAllowed("2001:db8::1"))
+
+	// Entries the file cannot hold are rejected before anything is written
+	w = putAllowedIPsForTest(t, "?persist=true", `["198.51.100.0/24", "!198.51.100.7"]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "!198.51.100.7")
+	entries, err = h.loadIPsFromFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"198.51.100.0/24", "2001:db8::1"}, entries)
+	assert.True(t, h.isIPAllowed("198.51.100.7"))
+}
+
+func TestAdminHandler_AllowedIPs_Errors(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	t.Run("no handler", func(t *testing.T) {
+		w := putAllowedIPsForTest(t, "", `["198.51.100.1"]`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/maintenance/allowed-ips", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, withJSONErrors(AdminHandler{}.allowedIPs).ServeHTTP(w, req))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	t.Run("persist without file", func(t *testing.T) {
+		w := putAllowedIPsForTest(t, "?persist=true", `["198.51.100.1"]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "allowed_ips_file")
+		assert.False(t, h.isIPAllowed("198.51.100.1"))
+	})
+
+	t.Run("invalid persist value", func(t *testing.T) {
+		w := putAllowedIPsForTest(t, "?persist=maybe", `["198.51.100.1"]`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.False(t, h.isIPAllowed("198.51.100.1"))
+	})
+}
+
+func TestAdminHandler_AllowedIPs_Audit(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	logs := observeAuditForTest(t)
+
+	h := &MaintenanceHandler{}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	req := httptest.NewRequest(http.MethodPut, "/maintenance/allowed-ips", bytes.NewBufferString(`["198.51.100.1"]`))
+	w := httptest.NewRecorder()
+	require.NoError(t, adminRouteHandler(t, "/maintenance/allowed-ips").ServeHTTP(w, req))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, h.isIPAllowed("198.51.100.1"))
+
+	entries := logs.FilterMessage("Maintenance admin action").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "allowed-ips", entries[0].ContextMap()["action"])
+	assert.Equal(t, map[string]any{"items": []any{"198.51.100.1"}}, entries[0].ContextMap()["params"])
+}
+
+func TestAdminHandler_AllowedIPs_ConcurrentChecks(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				h.isIPAllowed("192.0.2.10")
+			}
+		}
+	}()
+
+	for _, body := range []string{`["198.51.100.1"]`, `["192.0.2.10"]`, `["*", "!203.0.113.0/24"]`} {
+		w := putAllowedIPsForTest(t, "", body)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	close(done)
+	wg.Wait()
+
+	assert.True(t, h.isIPAllowed("192.0.2.10"))
+	assert.False(t, h.isIPAllowed("203.0.113.5"))
+}
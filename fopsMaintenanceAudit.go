@@ -0,0 +1,275 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// auditLoggerFunc returns the logger of admin audit events, replaceable in tests
+var auditLoggerFunc = func() *zap.Logger {
+	return caddy.Log().Named("maintenance.audit")
+}
+
+// auditRedacted replaces secret parameters in audit events
+const auditRedacted = "[redacted]"
+
+// auditSecretParams are request parameters never written to audit events
+var auditSecretParams = []string{"token"}
+
+// auditResponseWriter records the status written by an audited handler
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// audited logs an audit event for each call of an admin handler changing
+// the maintenance state. Every event carries the same fields: action,
+// method, actor_ip, params, status, result and error.
+func audited(action string, handler caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		params := auditParams(r)
+		recorder := &auditResponseWriter{ResponseWriter: w}
+
+		err := handler(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		result, message := "success", ""
+		if err != nil {
+			status = adminErrorStatus(err)
+			message = err.Error()
+		}
+		if status >= http.StatusBadRequest {
+			result = "failure"
+		}
+
+		event := auditEvent{
+			Time:    time.Now().UTC(),
+			Action:  action,
+			Method:  r.Method,
+			ActorIP: remoteHost(r.RemoteAddr),
+			Params:  params,
+			Status:  status,
+			Result:  result,
+			Error:   message,
+		}
+		logger := auditLoggerFunc()
+		logger.Info("Maintenance admin action",
+			zap.String("action", event.Action),
+			zap.String("method", event.Method),
+			zap.String("actor_ip", event.ActorIP),
+			zap.Any("params", event.Params),
+			zap.Int("status", event.Status),
+			zap.String("result", event.Result),
+			zap.String("error", event.Error),
+		)
+		writeAuditFiles(logger, event)
+
+		return err
+	}
+}
+
+// auditEvent is an audit event as written to audit files, one JSON object
+// per line
+type auditEvent struct {
+	Time    time.Time      `json:"time"`
+	Action  string         `json:"action"`
+	Method  string         `json:"method"`
+	ActorIP string         `json:"actor_ip"`
+	Params  map[string]any `json:"params"`
+	Status  int            `json:"status"`
+	Result  string         `json:"result"`
+	Error   string         `json:"error"`
+}
+
+// auditFile appends audit events to a JSON Lines file. The file is reopened
+// when it was moved or removed, e.g. by logrotate.
+type auditFile struct {
+	mux  sync.Mutex
+	path string
+	file *os.File
+	// closed is set once the last instance released the file
+	closed bool
+	// refs counts the instances using the file, guarded by auditFileMux
+	refs int
+}
+
+var (
+	// Audit files keyed by path, shared by the instances configuring the
+	// same file so that their writes are serialized
+	auditFiles   = make(map[string]*auditFile)
+	auditFileMux sync.Mutex
+)
+
+// acquireAuditFile returns the audit file of path, opened for writing. Every
+// call must be paired with a release.
+func acquireAuditFile(path string) (*auditFile, error) {
+	auditFileMux.Lock()
+	defer auditFileMux.Unlock()
+
+	current, ok := auditFiles[path]
+	if !ok {
+		current = &auditFile{path: path}
+	}
+	if err := current.open(); err != nil {
+		return nil, err
+	}
+	current.refs++
+	auditFiles[path] = current
+
+	return current, nil
+}
+
+// release closes the file once no instance uses it anymore
+func (a *auditFile) release() {
+	auditFileMux.Lock()
+	defer auditFileMux.Unlock()
+
+	a.refs--
+	if a.refs > 0 {
+		return
+	}
+	if auditFiles[a.path] == a {
+		delete(auditFiles, a.path)
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.closed = true
+	if a.file != nil {
+		_ = a.file.Close()
+		a.file = nil
+	}
+}
+
+// openLocked opens the file in append mode unless the open file is still
+// the one at its path. The caller must hold mux.
+func (a *auditFile) openLocked() error {
+	if a.file != nil {
+		openInfo, openErr := a.file.Stat()
+		pathInfo, pathErr := os.Stat(a.path)
+		if openErr == nil && pathErr == nil && os.SameFile(openInfo, pathInfo) {
+			return nil
+		}
+		_ = a.file.Close()
+		a.file = nil
+	}
+
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	a.file = file
+
+	return nil
+}
+
+// open checks that the file can be opened for writing
+func (a *auditFile) open() error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	return a.openLocked()
+}
+
+// append writes line to the file. Writes to a released file are dropped.
+func (a *auditFile) append(line []byte) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	if err := a.openLocked(); err != nil {
+		return err
+	}
+	_, err := a.file.Write(line)
+
+	return err
+}
+
+// writeAuditFiles appends the event to the audit_file of every instance,
+// once per file
+func writeAuditFiles(logger *zap.Logger, event auditEvent) {
+	var files []*auditFile
+	for _, maintenanceHandler := range getMaintenanceHandlers() {
+		if maintenanceHandler.auditFile != nil && !slices.Contains(files, maintenanceHandler.auditFile) {
+			files = append(files, maintenanceHandler.auditFile)
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("Failed to encode audit event", zap.Error(err))
+		return
+	}
+	line = append(line, '\n')
+
+	for _, file := range files {
+		if err := file.append(line); err != nil {
+			logger.Error("Failed to write audit file", zap.String("audit_file", file.path), zap.Error(err))
+		}
+	}
+}
+
+// auditParams returns the JSON object of the request body with secrets
+// redacted, restoring the body for the handler. Bodies that are not a JSON
+// object are reported as no parameters.
+func auditParams(r *http.Request) map[string]any {
+	params := map[string]any{}
+	if r.Body == nil {
+		return params
+	}
+
+	// Read one byte past the limit so that the handler still rejects
+	// oversized bodies
+	body, err := io.ReadAll(io.LimitReader(r.Body, adminMaxBodyBytes()+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return map[string]any{}
+	}
+	if json.Unmarshal(body, &params) != nil {
+		// The allowed-ips endpoint takes a JSON array
+		var items []any
+		if json.Unmarshal(body, &items) != nil {
+			return map[string]any{}
+		}
+		return map[string]any{"items": items}
+	}
+
+	for _, secret := range auditSecretParams {
+		if _, ok := params[secret]; ok {
+			params[secret] = auditRedacted
+		}
+	}
+
+	return params
+}
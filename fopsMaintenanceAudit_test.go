@@ -0,0 +1,269 @@
+package fopsMaintenance
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func observeAuditForTest(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zap.InfoLevel)
+	previous := auditLoggerFunc
+	auditLoggerFunc = func() *zap.Logger { return zap.New(core) }
+	t.Cleanup(func() { auditLoggerFunc = previous })
+	return logs
+}
+
+func TestAdminHandler_AuditToggle(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	logs := observeAuditForTest(t)
+
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "retry_after": 900}`))
+	req.RemoteAddr = "192.0.2.10:51234"
+	w := httptest.NewRecorder()
+	require.NoError(t, adminRouteHandler(t, "/maintenance/set").ServeHTTP(w, req))
+	assert.Equal(t, http.StatusOK, w.Code)
+	// The handler still reads the body after the audit
+	assert.True(t, currentState(maintenanceHandler).Enabled)
+	assert.Equal(t, 900, currentState(maintenanceHandler).RetryAfter)
+
+	entries := logs.FilterMessage("Maintenance admin action").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zap.InfoLevel, entries[0].Level)
+	assert.Equal(t, map[string]interface{}{
+		"action":   "set",
+		"method":   http.MethodPost,
+		"actor_ip": "192.0.2.10",
+		"params":   map[string]any{"enabled": true, "retry_after": float64(900)},
+		"status":   int64(http.StatusOK),
+		"result":   "success",
+		"error":    "",
+	}, entries[0].ContextMap())
+}
+
+func TestAdminHandler_AuditFailures(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	setMaintenanceHandler(&MaintenanceHandler{})
+
+	t.Run("rejected request", func(t *testing.T) {
+		logs := observeAuditForTest(t)
+
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "retry_after": -1}`))
+		w := httptest.NewRecorder()
+		require.NoError(t, adminRouteHandler(t, "/maintenance/set").ServeHTTP(w, req))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		entries := logs.All()
+		require.Len(t, entries, 1)
+		fields := entries[0].ContextMap()
+		assert.Equal(t, int64(http.StatusBadRequest), fields["status"])
+		assert.Equal(t, "failure", fields["result"])
+		assert.Equal(t, "retry_after must not be negative", fields["error"])
+	})
+
+	t.Run("oversized body is still rejected", func(t *testing.T) {
+		logs := observeAuditForTest(t)
+
+		oversized := `{"enabled": true, "padding": "` + strings.Repeat("x", defaultAdminMaxBodyBytes) + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(oversized))
+		w := httptest.NewRecorder()
+		require.NoError(t, adminRouteHandler(t, "/maintenance/set").ServeHTTP(w, req))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		entries := logs.All()
+		require.Len(t, entries, 1)
+		assert.Equal(t, map[string]any{}, entries[0].ContextMap()["params"])
+	})
+}
+
+func TestAdminHandler_AuditGrantRedactsToken(t *testing.T) {
+	resetGrantsForTest(t)
+	logs := observeAuditForTest(t)
+
+	token, _ := issueGrant(defaultGrantTTL, false, time.Now())
+	req := httptest.NewRequest(http.MethodDelete, "/maintenance/grant", bytes.NewBufferString(`{"token": "`+token+`"}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, adminRouteHandler(t, "/maintenance/grant").ServeHTTP(w, req))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "grant", fields["action"])
+	assert.Equal(t, http.MethodDelete, fields["method"])
+	assert.Equal(t, map[string]any{"token": auditRedacted}, fields["params"])
+	assert.Equal(t, int64(http.StatusNoContent), fields["status"])
+}
+
+func resetAuditFilesForTest(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		auditFileMux.Lock()
+		defer auditFileMux.Unlock()
+		for path, current := range auditFiles {
+			if current.file != nil {
+				_ = current.file.Close()
+			}
+			delete(auditFiles, path)
+		}
+	})
+}
+
+func readAuditFileForTest(t *testing.T, path string) []auditEvent {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var events []auditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event auditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event), scanner.Text())
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestAdminHandler_AuditFile(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetAuditFilesForTest(t)
+	observeAuditForTest(t)
+
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "audit.jsonl")
+	other := filepath.Join(dir, "other.jsonl")
+	for _, h := range []*MaintenanceHandler{
+		{Name: "shop", AuditFile: shared},
+		{Name: "blog", AuditFile: shared},
+		{Name: "api", AuditFile: other},
+		{Name: "docs"},
+	} {
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+	}
+
+	toggle := func(pattern, body string) {
+		req := httptest.NewRequest(http.MethodPost, pattern, bytes.NewBufferString(body))
+		req.RemoteAddr = "192.0.2.10:51234"
+		w := httptest.NewRecorder()
+		require.NoError(t, adminRouteHandler(t, pattern).ServeHTTP(w, req))
+	}
+
+	toggle("/maintenance/set", `{"enabled": true, "retry_after": 900}`)
+	toggle("/maintenance/set-all", `{"enabled": false}`)
+	toggle("/maintenance/set", `{"enabled": true, "retry_after": -1}`)
+
+	events := readAuditFileForTest(t, shared)
+	require.Len(t, events, 3, "an event is written once per file")
+	assert.Equal(t, "set", events[0].Action)
+	assert.Equal(t, http.MethodPost, events[0].Method)
+	assert.Equal(t, "192.0.2.10", events[0].ActorIP)
+	assert.Equal(t, map[string]any{"enabled": true, "retry_after": float64(900)}, events[0].Params)
+	assert.Equal(t, http.StatusOK, events[0].Status)
+	assert.Equal(t, "success", events[0].Result)
+	assert.WithinDuration(t, time.Now(), events[0].Time, time.Minute)
+	assert.Equal(t, "set-all", events[1].Action)
+	assert.Equal(t, "failure", events[2].Result)
+	assert.Equal(t, "retry_after must not be negative", events[2].Error)
+
+	assert.Len(t, readAuditFileForTest(t, other), 3)
+
+	t.Run("rotated file is reopened", func(t *testing.T) {
+		require.NoError(t, os.Rename(shared, shared+".1"))
+		toggle("/maintenance/set", `{"enabled": false}`)
+		assert.Len(t, readAuditFileForTest(t, shared+".1"), 3)
+		require.Len(t, readAuditFileForTest(t, shared), 1)
+
+		require.NoError(t, os.Remove(shared))
+		toggle("/maintenance/set", `{"enabled": false}`)
+		assert.Len(t, readAuditFileForTest(t, shared), 1)
+	})
+
+	t.Run("concurrent writes are serialized", func(t *testing.T) {
+		require.NoError(t, os.Remove(other))
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Go(func() {
+				toggle("/maintenance/set", `{"enabled": true, "message": "`+strings.Repeat("x", 4096)+`"}`)
+			})
+		}
+		wg.Wait()
+		assert.Len(t, readAuditFileForTest(t, other), 20)
+	})
+}
+
+func TestMaintenanceHandler_AuditFile_ClosedOnCleanup(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetAuditFilesForTest(t)
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	first := &MaintenanceHandler{AuditFile: path}
+	second := &MaintenanceHandler{AuditFile: path}
+	require.NoError(t, first.Provision(caddy.Context{}))
+	require.NoError(t, second.Provision(caddy.Context{}))
+	require.Same(t, first.auditFile, second.auditFile)
+	shared := first.auditFile
+
+	// Provisioning again does not leak a reference
+	require.NoError(t, first.Provision(caddy.Context{}))
+	assert.Equal(t, 2, shared.refs)
+
+	require.NoError(t, first.Cleanup())
+	assert.Nil(t, first.auditFile)
+	auditFileMux.Lock()
+	assert.Same(t, shared, auditFiles[path])
+	auditFileMux.Unlock()
+	assert.NotNil(t, shared.file, "the file stays open while an instance uses it")
+
+	require.NoError(t, second.Cleanup())
+	auditFileMux.Lock()
+	assert.NotContains(t, auditFiles, path)
+	auditFileMux.Unlock()
+	assert.Nil(t, shared.file)
+
+	// A late write to the released file does not reopen it
+	require.NoError(t, shared.append([]byte("{}\n")))
+	assert.Nil(t, shared.file)
+}
+
+func TestMaintenanceHandler_AuditFile_Invalid(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetAuditFilesForTest(t)
+
+	h := &MaintenanceHandler{AuditFile: filepath.Join(t.TempDir(), "missing", "audit.jsonl")}
+	err := h.Provision(caddy.Context{})
+	t.Cleanup(func() { _ = h.Cleanup() })
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to open audit file")
+}
+
+func TestParseCaddyfile_AuditFile(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		audit_file /var/log/caddy/maintenance-audit.jsonl
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, "/var/log/caddy/maintenance-audit.jsonl", h.AuditFile)
+
+	_, err = parseTestCaddyfile(t, "maintenance {\n\taudit_file\n}")
+	assert.Error(t, err)
+}
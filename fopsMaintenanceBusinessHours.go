@@ -0,0 +1,227 @@
+package fopsMaintenance
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// What happens when maintenance is enabled through the admin API during
+// business hours
+const (
+	// The request is applied and a warning logged
+	businessHoursPolicyWarn = "warn"
+	// The request fails with 409 Conflict unless it overrides business hours
+	businessHoursPolicyReject = "reject"
+)
+
+// BusinessHours are the weekly time ranges during which maintenance should
+// not be enabled, e.g. by a deploy pipeline
+type BusinessHours struct {
+	// IANA time zone of the ranges (default: the handler timezone), e.g.
+	// "Europe/Paris"
+	Timezone string `json:"timezone,omitempty"`
+
+	// Weekly ranges such as "mon-fri 09:00-18:00" or "sat 10:00-12:00". A
+	// range ending before it starts runs past midnight into the next day.
+	Ranges []string `json:"ranges,omitempty"`
+
+	// Policy when maintenance is enabled through the admin API within the
+	// ranges: "warn" (default) or "reject"
+	Policy string `json:"policy,omitempty"`
+}
+
+// weeklyRange is a parsed business hours range, in minutes since midnight
+type weeklyRange struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// businessHours is the parsed BusinessHours configuration
+type businessHours struct {
+	location *time.Location
+	ranges   []weeklyRange
+}
+
+// weekdayNames maps the day names of business hours ranges to weekdays
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// provisionBusinessHours parses the business hours configuration
+func (h *MaintenanceHandler) provisionBusinessHours() error {
+	h.businessHours = nil
+	if h.BusinessHours == nil {
+		return nil
+	}
+
+	location := h.timeLocation()
+	if h.BusinessHours.Timezone != "" {
+		loaded, err := time.LoadLocation(h.BusinessHours.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid business_hours timezone '%s': %v", h.BusinessHours.Timezone, err)
+		}
+		location = loaded
+	}
+
+	if len(h.BusinessHours.Ranges) == 0 {
+		return fmt.Errorf("business_hours requires at least one range")
+	}
+
+	parsed := &businessHours{location: location}
+	for _, value := range h.BusinessHours.Ranges {
+		weekly, err := parseWeeklyRange(value)
+		if err != nil {
+			return fmt.Errorf("invalid business_hours range '%s': %v", value, err)
+		}
+		parsed.ranges = append(parsed.ranges, weekly)
+	}
+	h.businessHours = parsed
+
+	return nil
+}
+
+// parseWeeklyRange parses a range such as "mon-fri 09:00-18:00"
+func parseWeeklyRange(value string) (weeklyRange, error) {
+	var weekly weeklyRange
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return weekly, fmt.Errorf("expected days and times, e.g. 'mon-fri 09:00-18:00'")
+	}
+
+	for _, dayRange := range strings.Split(strings.ToLower(fields[0]), ",") {
+		first, last, isRange := strings.Cut(dayRange, "-")
+		from, ok := weekdayNames[first]
+		if !ok {
+			return weekly, fmt.Errorf("unknown day '%s'", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdayNames[last]; !ok {
+				return weekly, fmt.Errorf("unknown day '%s'", last)
+			}
+		}
+		// Ranges may wrap around the week, e.g. "fri-mon"
+		for day := from; ; day = (day + 1) % 7 {
+			weekly.days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+
+	start, end, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return weekly, fmt.Errorf("expected times as 'HH:MM-HH:MM'")
+	}
+	var err error
+	if weekly.start, err = parseClock(start); err != nil {
+		return weekly, err
+	}
+	if weekly.end, err = parseClock(end); err != nil {
+		return weekly, err
+	}
+	if weekly.start == weekly.end {
+		return weekly, fmt.Errorf("empty time range")
+	}
+
+	return weekly, nil
+}
+
+// parseClock parses a HH:MM time of day into minutes since midnight, 24:00
+// being the end of the day
+func parseClock(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', expected HH:MM", value)
+	}
+
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// contains reports whether t falls within the business hours, evaluated on
+// the wall clock of their time zone so that DST changes are followed
+func (b *businessHours) contains(t time.Time) bool {
+	local := t.In(b.location)
+	day := local.Weekday()
+	previousDay := (day + 6) % 7
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, weekly := range b.ranges {
+		if weekly.start < weekly.end {
+			if weekly.days[day] && minute >= weekly.start && minute < weekly.end {
+				return true
+			}
+			continue
+		}
+
+		// Overnight range, from start on its days to end on the next day
+		if (weekly.days[day] && minute >= weekly.start) || (weekly.days[previousDay] && minute < weekly.end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inBusinessHours reports whether t falls within the configured business hours
+func (h *MaintenanceHandler) inBusinessHours(t time.Time) bool {
+	return h.businessHours != nil && h.businessHours.contains(t)
+}
+
+// businessHoursError applies the business hours policy of the handlers to a
+// request enabling maintenance at now. Handlers with the reject policy make
+// it fail with 409 Conflict unless override is set, the others log a warning.
+// Handlers already enabled are not concerned.
+func businessHoursError(handlers []*MaintenanceHandler, enabled, override bool, now time.Time) error {
+	if !enabled {
+		return nil
+	}
+
+	var concerned []*MaintenanceHandler
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.RLock()
+		alreadyEnabled := maintenanceHandler.enabled
+		maintenanceHandler.enabledMux.RUnlock()
+		if !alreadyEnabled && maintenanceHandler.inBusinessHours(now) {
+			concerned = append(concerned, maintenanceHandler)
+		}
+	}
+
+	for _, maintenanceHandler := range concerned {
+		if maintenanceHandler.BusinessHours.Policy == businessHoursPolicyReject && !override {
+			return caddy.APIError{
+				HTTPStatus: http.StatusConflict,
+				Err:        fmt.Errorf("maintenance cannot be enabled during business hours, set override_business_hours to enable it anyway"),
+			}
+		}
+	}
+
+	for _, maintenanceHandler := range concerned {
+		if maintenanceHandler.logger != nil {
+			maintenanceHandler.logger.Warn("Maintenance mode enabled during business hours",
+				zap.String("timezone", maintenanceHandler.businessHours.location.String()),
+				zap.Strings("business_hours", maintenanceHandler.BusinessHours.Ranges),
+				zap.Bool("override", override),
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func provisionBusinessHoursForTest(t *testing.T, businessHours *BusinessHours) *MaintenanceHandler {
+	t.Helper()
+	h := &MaintenanceHandler{BusinessHours: businessHours}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	require.NoError(t, h.Validate())
+	t.Cleanup(func() { _ = h.Cleanup() })
+	return h
+}
+
+func TestBusinessHours_Timezones(t *testing.T) {
+	h := provisionBusinessHoursForTest(t, &BusinessHours{
+		Timezone: "America/New_York",
+		Ranges:   []string{"mon-fri 09:00-18:00"},
+	})
+
+	tests := []struct {
+		name     string
+		at       string
+		expected bool
+	}{
+		{name: "weekday morning in New York", at: "2026-01-14T14:30:00Z", expected: true},
+		{name: "before opening in New York, already open in UTC", at: "2026-01-14T10:00:00Z", expected: false},
+		{name: "start is inclusive", at: "2026-01-14T14:00:00Z", expected: true},
+		{name: "end is exclusive", at: "2026-01-14T23:00:00Z", expected: false},
+		// Saturday 00:30 UTC is still Friday evening in New York
+		{name: "Friday in New York, Saturday in UTC", at: "2026-01-17T00:30:00Z", expected: false},
+		{name: "Friday afternoon in New York", at: "2026-01-16T22:30:00Z", expected: true},
+		// Monday 03:00 UTC is still Sunday in New York
+		{name: "Sunday in New York, Monday in UTC", at: "2026-01-19T03:00:00Z", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, h.inBusinessHours(at))
+		})
+	}
+}
+
+func TestBusinessHours_DST(t *testing.T) {
+	h := provisionBusinessHoursForTest(t, &BusinessHours{
+		Timezone: "Europe/Paris",
+		Ranges:   []string{"mon-fri 09:00-18:00", "sun 01:00-04:00"},
+	})
+
+	tests := []struct {
+		name     string
+		at       string
+		expected bool
+	}{
+		// Paris is UTC+1 in winter, 08:30 UTC is 09:30 local
+		{name: "winter opening", at: "2026-03-27T08:30:00Z", expected: true},
+		{name: "winter before opening", at: "2026-03-27T07:30:00Z", expected: false},
+		// Paris is UTC+2 from 29 March 2026, 07:30 UTC is 09:30 local
+		{name: "summer opening", at: "2026-03-30T07:30:00Z", expected: true},
+		{name: "summer closing", at: "2026-03-30T16:30:00Z", expected: false},
+		// Clocks jump from 02:00 to 03:00 local on 29 March 2026
+		{name: "before the spring forward gap", at: "2026-03-29T00:30:00Z", expected: true},
+		{name: "after the spring forward gap", at: "2026-03-29T01:30:00Z", expected: true},
+		{name: "after the shortened range", at: "2026-03-29T02:00:00Z", expected: false},
+		// Clocks fall back from 03:00 to 02:00 local on 25 October 2026, the
+		// range covers both 02:30 local
+		{name: "first 02:30 of fall back", at: "2026-10-25T00:30:00Z", expected: true},
+		{name: "second 02:30 of fall back", at: "2026-10-25T01:30:00Z", expected: true},
+		{name: "after the lengthened range", at: "2026-10-25T03:00:00Z", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, h.inBusinessHours(at))
+		})
+	}
+}
+
+func TestBusinessHours_OvernightAndWrappingRanges(t *testing.T) {
+	h := provisionBusinessHoursForTest(t, &BusinessHours{
+		Ranges: []string{"fri-mon 22:00-02:00", "wed,thu 12:00-24:00"},
+	})
+
+	tests := []struct {
+		at       string
+		expected bool
+	}{
+		{at: "2026-01-16T23:00:00Z", expected: true},  // Friday night
+		{at: "2026-01-17T01:00:00Z", expected: true},  // Saturday early, from Friday
+		{at: "2026-01-20T01:00:00Z", expected: true},  // Tuesday early, from Monday
+		{at: "2026-01-20T23:00:00Z", expected: false}, // Tuesday night
+		{at: "2026-01-15T23:59:00Z", expected: true},  // Thursday until midnight
+		{at: "2026-01-16T00:00:00Z", expected: false}, // Friday midnight
+	}
+
+	for _, tt := range tests {
+		at, err := time.Parse(time.RFC3339, tt.at)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, h.inBusinessHours(at), tt.at)
+	}
+}
+
+func TestBusinessHours_Invalid(t *testing.T) {
+	tests := []struct {
+		name          string
+		businessHours *BusinessHours
+		expected      string
+	}{
+		{name: "unknown timezone", businessHours: &BusinessHours{Timezone: "Mars/Olympus", Ranges: []string{"mon 09:00-18:00"}}, expected: "invalid business_hours timezone 'Mars/Olympus'"},
+		{name: "no range", businessHours: &BusinessHours{}, expected: "business_hours requires at least one range"},
+		{name: "unknown day", businessHours: &BusinessHours{Ranges: []string{"mon-fry 09:00-18:00"}}, expected: "unknown day 'fry'"},
+		{name: "invalid time", businessHours: &BusinessHours{Ranges: []string{"mon 9h-18h"}}, expected: "invalid time '9h'"},
+		{name: "missing times", businessHours: &BusinessHours{Ranges: []string{"mon"}}, expected: "expected days and times"},
+		{name: "empty range", businessHours: &BusinessHours{Ranges: []string{"mon 09:00-09:00"}}, expected: "empty time range"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{BusinessHours: tt.businessHours}
+			err := h.Provision(caddy.Context{})
+			t.Cleanup(func() { _ = h.Cleanup() })
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+
+	err := (&MaintenanceHandler{BusinessHours: &BusinessHours{Policy: "defer"}}).Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid business_hours policy 'defer'")
+}
+
+func TestAdminHandler_Toggle_BusinessHours(t *testing.T) {
+	// Every day, all day long
+	always := []string{"mon-sun 00:00-24:00"}
+
+	t.Run("reject policy", func(t *testing.T) {
+		resetMaintenanceHandlersForTest(t)
+		h := provisionBusinessHoursForTest(t, &BusinessHours{Ranges: always, Policy: businessHoursPolicyReject})
+		setMaintenanceHandler(h)
+
+		for _, method := range []string{http.MethodPost, http.MethodPatch} {
+			req := httptest.NewRequest(method, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+			err := AdminHandler{}.toggle(httptest.NewRecorder(), req)
+			require.Error(t, err, method)
+			assert.Equal(t, http.StatusConflict, err.(caddy.APIError).HTTPStatus)
+			assert.False(t, currentState(h).Enabled)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "override_business_hours": true}`))
+		require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+		assert.True(t, currentState(h).Enabled)
+
+		// Updating an enabled maintenance is not concerned
+		req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true, "retry_after": 60}`))
+		require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+
+		// Disabling is always possible
+		req = httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": false}`))
+		require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+		assert.False(t, currentState(h).Enabled)
+	})
+
+	t.Run("warn policy", func(t *testing.T) {
+		resetMaintenanceHandlersForTest(t)
+		h := provisionBusinessHoursForTest(t, &BusinessHours{Ranges: always, Timezone: "Europe/Paris"})
+		core, logs := observer.New(zap.WarnLevel)
+		h.logger = zap.New(core)
+		setMaintenanceHandler(h)
+
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set", bytes.NewBufferString(`{"enabled": true}`))
+		require.NoError(t, AdminHandler{}.toggle(httptest.NewRecorder(), req))
+		assert.True(t, currentState(h).Enabled)
+
+		entries := logs.FilterMessage("Maintenance mode enabled during business hours").All()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "Europe/Paris", entries[0].ContextMap()["timezone"])
+	})
+
+	t.Run("set-all reports rejected instances", func(t *testing.T) {
+		resetMaintenanceHandlersForTest(t)
+		rejecting := provisionBusinessHoursForTest(t, &BusinessHours{Ranges: always, Policy: businessHoursPolicyReject})
+		other := &MaintenanceHandler{Name: "other"}
+		registerMaintenanceHandler(rejecting)
+		registerMaintenanceHandler(other)
+
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/set-all", bytes.NewBufferString(`{"enabled": true}`))
+		w := httptest.NewRecorder()
+		require.NoError(t, AdminHandler{}.setAll(w, req))
+		assert.Equal(t, http.StatusMultiStatus, w.Code)
+		assert.False(t, currentState(rejecting).Enabled)
+		assert.True(t, currentState(other).Enabled)
+	})
+}
+
+func TestParseCaddyfile_BusinessHours(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		business_hours {
+			timezone Europe/Paris
+			policy reject
+			mon-fri 09:00-18:00
+			sat 10:00-12:00
+		}
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, &BusinessHours{
+		Timezone: "Europe/Paris",
+		Policy:   businessHoursPolicyReject,
+		Ranges:   []string{"mon-fri 09:00-18:00", "sat 10:00-12:00"},
+	}, h.BusinessHours)
+
+	for _, input := range []string{
+		"maintenance {\n\tbusiness_hours mon\n}",
+		"maintenance {\n\tbusiness_hours {\n\t\ttimezone\n\t}\n}",
+		"maintenance {\n\tbusiness_hours {\n\t\tmon 9-18\n\t}\n}",
+		"maintenance {\n\tbusiness_hours {\n\t\tmon 09:00-18:00 extra\n\t}\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
@@ -0,0 +1,132 @@
+package fopsMaintenance
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// bypassStat counts the requests an allowed_ips entry let through. It is
+// updated atomically so that allowed requests do not contend on a lock.
+type bypassStat struct {
+	count atomic.Uint64
+	// lastBypass is in nanoseconds since the Unix epoch
+	lastBypass atomic.Int64
+}
+
+// Bypasses keyed by allowed_ips entry, shared by every instance so that the
+// counts cover the whole session across config reloads. Entries no instance
+// configures anymore are pruned.
+var bypassStats sync.Map
+
+// recordBypass counts a request let through by an allowed_ips entry
+func recordBypass(entry string, now time.Time) {
+	value, ok := bypassStats.Load(entry)
+	if !ok {
+		value, _ = bypassStats.LoadOrStore(entry, &bypassStat{})
+	}
+	stat := value.(*bypassStat)
+	stat.count.Add(1)
+	stat.lastBypass.Store(now.UnixNano())
+}
+
+// configuredBypassEntries returns the allowed_ips entries of the handlers
+// that can let requests through, exceptions excluded, and the IP entries of
+// bypass_insecure_only and bypass_secure_only
+func configuredBypassEntries(handlers []*MaintenanceHandler) map[string]bool {
+	configured := make(map[string]bool)
+	for _, maintenanceHandler := range handlers {
+		for _, entry := range slices.Concat(maintenanceHandler.insecureBypass.entries, maintenanceHandler.secureBypass.entries) {
+			configured[entry] = true
+		}
+		maintenanceHandler.ipMux.RLock()
+		for _, rule := range maintenanceHandler.allowRules {
+			if !rule.negated {
+				configured[rule.entry] = true
+			}
+		}
+		maintenanceHandler.ipMux.RUnlock()
+	}
+
+	return configured
+}
+
+// pruneBypassStats drops the counts of entries no instance configures
+// anymore, returning the configured entries
+func pruneBypassStats() map[string]bool {
+	configured := configuredBypassEntries(getMaintenanceHandlers())
+	bypassStats.Range(func(key, _ any) bool {
+		if !configured[key.(string)] {
+			bypassStats.Delete(key)
+		}
+		return true
+	})
+
+	return configured
+}
+
+// bypassStatEntry reports the bypasses of one allowed_ips entry
+type bypassStatEntry struct {
+	Entry    string `json:"entry"`
+	Bypasses uint64 `json:"bypasses"`
+	// LastBypass is omitted for entries that let no request through
+	LastBypass *time.Time `json:"last_bypass,omitempty"`
+}
+
+// bypassStatsResponse is the payload returned by the bypass-stats endpoint
+type bypassStatsResponse struct {
+	Entries []bypassStatEntry `json:"entries"`
+}
+
+// getBypassStats reports how many requests each allowed_ips entry let
+// through since startup. Configured entries that were never used are listed
+// with zero bypasses, so stale entries can be removed from allowed_ips.
+func (h AdminHandler) getBypassStats(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	if len(getMaintenanceHandlers()) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	configured := pruneBypassStats()
+	entries := make([]bypassStatEntry, 0, len(configured))
+	for entry := range configured {
+		current := bypassStatEntry{Entry: entry}
+		// A stat is readable before the first bypass it counts is stored
+		if value, ok := bypassStats.Load(entry); ok {
+			stat := value.(*bypassStat)
+			if nanos := stat.lastBypass.Load(); nanos != 0 {
+				lastBypass := time.Unix(0, nanos)
+				current.Bypasses = stat.count.Load()
+				current.LastBypass = &lastBypass
+			}
+		}
+		entries = append(entries, current)
+	}
+
+	// Most used entries first
+	slices.SortFunc(entries, func(a, b bypassStatEntry) int {
+		if c := cmp.Compare(b.Bypasses, a.Bypasses); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Entry, b.Entry)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(bypassStatsResponse{Entries: entries})
+}
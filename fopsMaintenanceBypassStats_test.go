@@ -0,0 +1,196 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetBypassStatsForTest(t *testing.T) {
+	t.Helper()
+	bypassStats.Clear()
+	t.Cleanup(bypassStats.Clear)
+}
+
+// getBypassStatsForTest reads the bypass stats through the admin endpoint
+func getBypassStatsForTest(t *testing.T) []bypassStatEntry {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/bypass-stats", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.getBypassStats).ServeHTTP(w, req))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response bypassStatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Entries
+}
+
+func TestMaintenanceHandler_BypassStats(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetBypassStatsForTest(t)
+
+	h := &MaintenanceHandler{
+		AllowedIPs:     []string{"192.0.2.0/24", "198.51.100.7", "!192.0.2.99", "203.0.113.5"},
+		DefaultEnabled: true,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	for _, client := range []struct {
+		ip     string
+		status int
+	}{
+		{ip: "192.0.2.10", status: http.StatusOK},
+		{ip: "192.0.2.10", status: http.StatusOK},
+		{ip: "192.0.2.20", status: http.StatusOK},
+		{ip: "198.51.100.7", status: http.StatusOK},
+		// Denied by an exception, not counted
+		{ip: "192.0.2.99", status: http.StatusServiceUnavailable},
+		{ip: "203.0.113.9", status: http.StatusServiceUnavailable},
+	} {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = client.ip + ":51234"
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		require.Equal(t, client.status, w.Code, client.ip)
+	}
+
+	entries := getBypassStatsForTest(t)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "192.0.2.0/24", entries[0].Entry)
+	assert.Equal(t, uint64(3), entries[0].Bypasses)
+	require.NotNil(t, entries[0].LastBypass)
+	assert.WithinDuration(t, time.Now(), *entries[0].LastBypass, time.Minute)
+
+	assert.Equal(t, "198.51.100.7", entries[1].Entry)
+	assert.Equal(t, uint64(1), entries[1].Bypasses)
+
+	// Never used, a candidate for pruning
+	assert.Equal(t, bypassStatEntry{Entry: "203.0.113.5"}, entries[2])
+
+	// Counts of entries removed from the allow-list are dropped
+	list, err := h.compileAllowList([]string{"203.0.113.5", "198.51.100.7"})
+	require.NoError(t, err)
+	h.setAllowList(list)
+
+	entries = getBypassStatsForTest(t)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "198.51.100.7", entries[0].Entry)
+	assert.Equal(t, uint64(1), entries[0].Bypasses)
+	assert.Equal(t, bypassStatEntry{Entry: "203.0.113.5"}, entries[1])
+	_, kept := bypassStats.Load("192.0.2.0/24")
+	assert.False(t, kept)
+}
+
+func TestMaintenanceHandler_BypassStats_PrunedOnReplace(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetBypassStatsForTest(t)
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10", "198.51.100.7"}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+	recordBypass("192.0.2.10", time.Now())
+	recordBypass("198.51.100.7", time.Now())
+
+	req := httptest.NewRequest(http.MethodPut, "/maintenance/allowed-ips", bytes.NewBufferString(`["198.51.100.7"]`))
+	require.NoError(t, AdminHandler{}.allowedIPs(httptest.NewRecorder(), req))
+
+	// Pruned without reading the stats
+	_, kept := bypassStats.Load("192.0.2.10")
+	assert.False(t, kept)
+	_, kept = bypassStats.Load("198.51.100.7")
+	assert.True(t, kept)
+}
+
+func TestRecordBypass_Concurrent(t *testing.T) {
+	resetBypassStatsForTest(t)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 100 {
+				recordBypass("192.0.2.0/24", time.Now())
+			}
+		})
+	}
+	wg.Wait()
+
+	value, ok := bypassStats.Load("192.0.2.0/24")
+	require.True(t, ok)
+	assert.Equal(t, uint64(800), value.(*bypassStat).count.Load())
+}
+
+func TestMaintenanceHandler_BypassStats_SchemeEntries(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetBypassStatsForTest(t)
+
+	h := &MaintenanceHandler{
+		BypassInsecureOnly: []string{"10.0.0.0/8", "/healthz"},
+		BypassSecureOnly:   []string{"203.0.113.5"},
+		DefaultEnabled:     true,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	for range 2 {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = "10.1.2.3:51234"
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Counted under the configured entry, the unused one is not pruned
+	entries := getBypassStatsForTest(t)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "10.0.0.0/8", entries[0].Entry)
+	assert.Equal(t, uint64(2), entries[0].Bypasses)
+	assert.Equal(t, bypassStatEntry{Entry: "203.0.113.5"}, entries[1])
+}
+
+func TestMaintenanceHandler_BypassStats_WhoamiNotCounted(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetBypassStatsForTest(t)
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	// Checks outside ServeHTTP, such as whoami, are not bypasses
+	assert.True(t, h.isIPAllowed("192.0.2.10"))
+	assert.Equal(t, []bypassStatEntry{{Entry: "192.0.2.10"}}, getBypassStatsForTest(t))
+}
+
+func TestAdminHandler_BypassStats_Errors(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/bypass-stats", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.getBypassStats).ServeHTTP(w, req))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	setMaintenanceHandler(&MaintenanceHandler{})
+
+	req = httptest.NewRequest(http.MethodPost, "/maintenance/bypass-stats", nil)
+	w = httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.getBypassStats).ServeHTTP(w, req))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
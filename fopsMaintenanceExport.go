@@ -0,0 +1,234 @@
+package fopsMaintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// stateExportVersion is the version of the exported state format, imports
+// of other versions are rejected
+const stateExportVersion = 1
+
+// exportedState is the maintenance state returned by the export endpoint and
+// restored by the import endpoint
+type exportedState struct {
+	Version int  `json:"version"`
+	Enabled bool `json:"enabled"`
+	// StartedAt is when maintenance mode was enabled
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// ExpiresAt is when maintenance mode gets disabled automatically
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Message is empty for the default message
+	Message      string     `json:"message,omitempty"`
+	EstimatedEnd *time.Time `json:"estimated_end,omitempty"`
+	// RetryAfter is zero for the default Retry-After
+	RetryAfter                  int    `json:"retry_after,omitempty"`
+	RequestRetentionModeTimeout int    `json:"request_retention_mode_timeout,omitempty"`
+	GracePeriod                 string `json:"grace_period,omitempty"`
+	// AllowedIPs holds the allowed_ips entries, including those of the
+	// allowed_ips_file
+	AllowedIPs []string `json:"allowed_ips"`
+	// OverrideBusinessHours lets an import enable maintenance despite a
+	// reject policy, it is never exported
+	OverrideBusinessHours bool `json:"override_business_hours,omitempty"`
+}
+
+// exportState returns the maintenance state of every instance, as set by the
+// admin API, for backups and migrations between environments
+func (h AdminHandler) exportState(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	handlers := getMaintenanceHandlers()
+	if len(handlers) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	state := exportedState{Version: stateExportVersion}
+	for _, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.RLock()
+		if maintenanceHandler.enabled {
+			state.Enabled = true
+		}
+		maintenanceHandler.enabledMux.RUnlock()
+	}
+	if state.Enabled {
+		startedAt := enabledSince(handlers)
+		state.StartedAt = &startedAt
+		if expiresAt := expiresAtOf(handlers); !expiresAt.IsZero() {
+			state.ExpiresAt = &expiresAt
+		}
+	}
+
+	// Settings are applied to every instance by the admin API, those of the
+	// first one stand for all
+	first := handlers[0]
+	first.enabledMux.RLock()
+	state.Message = first.Message
+	if !first.estimatedEnd.IsZero() {
+		estimatedEnd := first.estimatedEnd
+		state.EstimatedEnd = &estimatedEnd
+	}
+	state.RetryAfter = first.RetryAfter
+	state.RequestRetentionModeTimeout = first.RequestRetentionModeTimeout
+	if first.GracePeriod > 0 {
+		state.GracePeriod = time.Duration(first.GracePeriod).String()
+	}
+	first.enabledMux.RUnlock()
+
+	first.ipMux.RLock()
+	state.AllowedIPs = slices.Clone(first.AllowedIPs)
+	first.ipMux.RUnlock()
+	if state.AllowedIPs == nil {
+		state.AllowedIPs = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(state)
+}
+
+// importState restores an exported state on every instance. The whole state
+// is validated before any instance is changed.
+func (h AdminHandler) importState(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	var state exportedState
+	if err := decodeAdminRequest(w, r, &state); err != nil {
+		return err
+	}
+
+	if state.Version != stateExportVersion {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("unsupported state version %d, expected %d", state.Version, stateExportVersion),
+		}
+	}
+	if state.RetryAfter < 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("retry_after must not be negative"),
+		}
+	}
+	if state.RequestRetentionModeTimeout < 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("request_retention_mode_timeout must not be negative"),
+		}
+	}
+	if state.ExpiresAt != nil && !state.Enabled {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("expires_at requires enabled to be true"),
+		}
+	}
+	gracePeriod := caddy.Duration(0)
+	if state.GracePeriod != "" {
+		parsed, err := parseGracePeriod(&state.GracePeriod)
+		if err != nil {
+			return err
+		}
+		gracePeriod = *parsed
+	}
+	if state.AllowedIPs == nil {
+		state.AllowedIPs = []string{}
+	}
+	for i, entry := range state.AllowedIPs {
+		state.AllowedIPs[i] = strings.TrimSpace(entry)
+	}
+
+	handlers := getMaintenanceHandlers()
+	if len(handlers) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	lists := make([]*allowList, len(handlers))
+	for i, maintenanceHandler := range handlers {
+		list, err := maintenanceHandler.compileAllowList(state.AllowedIPs)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid allowed IP list: %v", err),
+			}
+		}
+		lists[i] = list
+	}
+
+	// A maintenance window that expired since the export is over
+	enabled := state.Enabled
+	now := time.Now()
+	if enabled && state.ExpiresAt != nil && !state.ExpiresAt.After(now) {
+		enabled = false
+	}
+	startedAt := now
+	var expiresAt time.Time
+	if enabled {
+		if state.StartedAt != nil {
+			startedAt = *state.StartedAt
+		}
+		if state.ExpiresAt != nil {
+			expiresAt = *state.ExpiresAt
+		}
+	}
+
+	if err := forcedError(handlers, enabled); err != nil {
+		return err
+	}
+	if err := businessHoursError(handlers, enabled, state.OverrideBusinessHours, now); err != nil {
+		return err
+	}
+	if err := persistEnabledStatus(handlers, enabled, startedAt, expiresAt); err != nil {
+		return err
+	}
+
+	for i, maintenanceHandler := range handlers {
+		maintenanceHandler.enabledMux.Lock()
+		maintenanceHandler.setEnabledLocked(enabled, startedAt)
+		if maintenanceHandler.enabled {
+			// Restore the exported start even when already enabled
+			maintenanceHandler.startedAt = startedAt
+		}
+		maintenanceHandler.setExpiryLocked(expiresAt)
+		maintenanceHandler.Message = state.Message
+		maintenanceHandler.estimatedEnd = time.Time{}
+		if state.EstimatedEnd != nil {
+			maintenanceHandler.estimatedEnd = *state.EstimatedEnd
+		}
+		// Zero restores the default, it is not clamped
+		maintenanceHandler.RetryAfter = state.RetryAfter
+		if state.RetryAfter > 0 {
+			maintenanceHandler.RetryAfter = maintenanceHandler.clampRetryAfter(state.RetryAfter)
+		}
+		maintenanceHandler.RequestRetentionModeTimeout = state.RequestRetentionModeTimeout
+		maintenanceHandler.GracePeriod = gracePeriod
+		maintenanceHandler.enabledMux.Unlock()
+
+		maintenanceHandler.setAllowList(lists[i])
+		maintenanceHandler.ipMux.Lock()
+		maintenanceHandler.AllowedIPs = slices.Clone(state.AllowedIPs)
+		maintenanceHandler.ipMux.Unlock()
+	}
+	pruneBypassStats()
+
+	return json.NewEncoder(w).Encode(currentState(handlers[0]))
+}
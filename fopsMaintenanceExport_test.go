@@ -0,0 +1,226 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportStateForTest exports the state through the admin endpoint
+func exportStateForTest(t *testing.T) []byte {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/export", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.exportState).ServeHTTP(w, req))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return w.Body.Bytes()
+}
+
+// importStateForTest imports a state through the admin endpoint
+func importStateForTest(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/import", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.importState).ServeHTTP(w, req))
+	return w
+}
+
+func TestAdminHandler_ExportImport_RoundTrip(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	startedAt := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	estimatedEnd := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+
+	source := &MaintenanceHandler{
+		AllowedIPs:                  []string{"192.0.2.0/24", "!192.0.2.7"},
+		Message:                     "Database migration",
+		RetryAfter:                  600,
+		RequestRetentionModeTimeout: 5,
+		GracePeriod:                 caddy.Duration(30 * time.Second),
+	}
+	require.NoError(t, source.Provision(caddy.Context{}))
+	source.enabledMux.Lock()
+	source.setEnabledLocked(true, startedAt)
+	source.setExpiryLocked(expiresAt)
+	source.estimatedEnd = estimatedEnd
+	source.enabledMux.Unlock()
+	t.Cleanup(func() {
+		source.enabledMux.Lock()
+		source.setExpiryLocked(time.Time{})
+		source.enabledMux.Unlock()
+	})
+	setMaintenanceHandler(source)
+
+	exported := exportStateForTest(t)
+
+	var state exportedState
+	require.NoError(t, json.Unmarshal(exported, &state))
+	assert.Equal(t, stateExportVersion, state.Version)
+	assert.True(t, state.Enabled)
+	require.NotNil(t, state.StartedAt)
+	assert.True(t, startedAt.Equal(*state.StartedAt))
+	require.NotNil(t, state.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*state.ExpiresAt))
+	require.NotNil(t, state.EstimatedEnd)
+	assert.True(t, estimatedEnd.Equal(*state.EstimatedEnd))
+	assert.Equal(t, "Database migration", state.Message)
+	assert.Equal(t, 600, state.RetryAfter)
+	assert.Equal(t, 5, state.RequestRetentionModeTimeout)
+	assert.Equal(t, "30s", state.GracePeriod)
+	assert.Equal(t, []string{"192.0.2.0/24", "!192.0.2.7"}, state.AllowedIPs)
+	assert.False(t, state.OverrideBusinessHours)
+
+	// Restore on a freshly configured environment
+	target := &MaintenanceHandler{AllowedIPs: []string{"198.51.100.1"}}
+	require.NoError(t, target.Provision(caddy.Context{}))
+	t.Cleanup(func() {
+		target.enabledMux.Lock()
+		target.setExpiryLocked(time.Time{})
+		target.enabledMux.Unlock()
+	})
+	setMaintenanceHandler(target)
+
+	w := importStateForTest(t, string(exported))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"enabled": true, "request_retention_mode_timeout": 5, "retry_after": 600, "grace_period": "30s"}`, w.Body.String())
+
+	assert.JSONEq(t, string(exported), string(exportStateForTest(t)))
+	assert.True(t, target.isIPAllowed("192.0.2.1"))
+	assert.False(t, target.isIPAllowed("192.0.2.7"))
+	assert.False(t, target.isIPAllowed("198.51.100.1"))
+
+	target.enabledMux.RLock()
+	defer target.enabledMux.RUnlock()
+	assert.True(t, startedAt.Equal(target.startedAt))
+	assert.True(t, expiresAt.Equal(target.expiresAt))
+	assert.NotNil(t, target.expiryTimer)
+}
+
+func TestAdminHandler_Import_RestoresDefaults(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	h := &MaintenanceHandler{
+		Message:     "Database migration",
+		RetryAfter:  600,
+		GracePeriod: caddy.Duration(30 * time.Second),
+		AllowedIPs:  []string{"192.0.2.10"},
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabledMux.Lock()
+	h.setEnabledLocked(true, time.Now())
+	h.estimatedEnd = time.Now().Add(time.Hour)
+	h.enabledMux.Unlock()
+	setMaintenanceHandler(h)
+
+	w := importStateForTest(t, `{"version": 1, "enabled": false, "allowed_ips": []}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.JSONEq(t, `{"version": 1, "enabled": false, "allowed_ips": []}`, string(exportStateForTest(t)))
+	assert.False(t, h.isIPAllowed("192.0.2.10"))
+	h.enabledMux.RLock()
+	defer h.enabledMux.RUnlock()
+	assert.Equal(t, defaultMessage, h.message())
+	assert.Equal(t, defaultRetryAfter, h.effectiveRetryAfterLocked())
+	assert.True(t, h.estimatedEnd.IsZero())
+}
+
+func TestAdminHandler_Import_ExpiredWindow(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	h := &MaintenanceHandler{}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	past := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	w := importStateForTest(t, `{"version": 1, "enabled": true, "expires_at": "`+past+`", "allowed_ips": []}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.False(t, currentState(h).Enabled)
+}
+
+func TestAdminHandler_Import_PersistsStatus(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	h := &MaintenanceHandler{StatusFile: statusFile}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	w := importStateForTest(t, `{"version": 1, "enabled": true, "started_at": "2026-03-02T14:00:00Z", "allowed_ips": []}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	data, err := os.ReadFile(statusFile)
+	require.NoError(t, err)
+	var status persistedStatus
+	require.NoError(t, json.Unmarshal(data, &status))
+	assert.True(t, status.Enabled)
+	require.NotNil(t, status.StartedAt)
+	assert.True(t, time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC).Equal(*status.StartedAt))
+}
+
+func TestAdminHandler_Import_InvalidState(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}, Message: "Unchanged"}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	for name, body := range map[string]string{
+		"missing version":         `{"enabled": true, "allowed_ips": []}`,
+		"unsupported version":     `{"version": 2, "enabled": true, "allowed_ips": []}`,
+		"negative retry_after":    `{"version": 1, "enabled": true, "retry_after": -1, "allowed_ips": []}`,
+		"negative retention":      `{"version": 1, "enabled": true, "request_retention_mode_timeout": -1, "allowed_ips": []}`,
+		"invalid grace_period":    `{"version": 1, "enabled": true, "grace_period": "soon", "allowed_ips": []}`,
+		"negative grace_period":   `{"version": 1, "enabled": true, "grace_period": "-1s", "allowed_ips": []}`,
+		"expires_at when disable": `{"version": 1, "enabled": false, "expires_at": "2030-01-01T00:00:00Z", "allowed_ips": []}`,
+		"invalid allowed IP":      `{"version": 1, "enabled": true, "allowed_ips": ["192.0.2.300"]}`,
+		"unknown field":           `{"version": 1, "enabled": true, "allowed_ips": [], "lockdown": true}`,
+		"malformed JSON":          `{invalid`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := importStateForTest(t, body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+			assert.False(t, currentState(h).Enabled)
+			assert.True(t, h.isIPAllowed("192.0.2.10"))
+			assert.Equal(t, "Unchanged", h.message())
+		})
+	}
+}
+
+func TestAdminHandler_ExportImport_Errors(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	t.Run("no handler", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/maintenance/export", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, withJSONErrors(AdminHandler{}.exportState).ServeHTTP(w, req))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = importStateForTest(t, `{"version": 1, "enabled": true, "allowed_ips": []}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	setMaintenanceHandler(&MaintenanceHandler{})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/maintenance/export", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, withJSONErrors(AdminHandler{}.exportState).ServeHTTP(w, req))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+		req = httptest.NewRequest(http.MethodGet, "/maintenance/import", nil)
+		w = httptest.NewRecorder()
+		require.NoError(t, withJSONErrors(AdminHandler{}.importState).ServeHTTP(w, req))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -0,0 +1,106 @@
+package fopsMaintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultFlagPollInterval is how often the flag file is read by default
+const defaultFlagPollInterval = 5 * time.Second
+
+// readFlag returns the boolean found at FlagKey in the flag file
+func (h *MaintenanceHandler) readFlag() (bool, error) {
+	data, err := os.ReadFile(h.FlagFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read flag file: %v", err)
+	}
+
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return false, fmt.Errorf("invalid flag file: %v", err)
+	}
+
+	for _, key := range strings.Split(h.FlagKey, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return false, fmt.Errorf("flag '%s' not found", h.FlagKey)
+		}
+		if value, ok = object[key]; !ok {
+			return false, fmt.Errorf("flag '%s' not found", h.FlagKey)
+		}
+	}
+
+	flag, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("flag '%s' is not a boolean", h.FlagKey)
+	}
+
+	return flag, nil
+}
+
+// applyFlag reads the flag file and updates the maintenance state when the
+// flag changed since it was last applied, so that admin API changes stand
+// until the flag itself changes. An unreadable or malformed file is logged
+// and leaves the state untouched.
+func (h *MaintenanceHandler) applyFlag() {
+	flag, err := h.readFlag()
+	if err != nil {
+		if h.logger != nil {
+			h.logger.Warn("Ignoring flag file", zap.String("file", h.FlagFile), zap.Error(err))
+		}
+		return
+	}
+
+	h.enabledMux.Lock()
+	defer h.enabledMux.Unlock()
+	if h.flagValue != nil && *h.flagValue == flag {
+		return
+	}
+	// The first read applies the state found at startup, only later
+	// changes of the flag start the grace period
+	if h.flagValue != nil {
+		h.toggleEnabledLocked(flag, time.Now())
+	} else {
+		h.setEnabledLocked(flag, time.Now())
+	}
+	h.flagValue = &flag
+
+	if h.logger != nil {
+		h.logger.Info("Maintenance mode set by flag file",
+			zap.String("file", h.FlagFile),
+			zap.String("key", h.FlagKey),
+			zap.Bool("enabled", flag),
+		)
+	}
+}
+
+// startFlagWatcher applies the flag file, then polls it in the background
+func (h *MaintenanceHandler) startFlagWatcher() error {
+	h.stopFlagWatcher()
+	// A missing flag_key is reported by Validate
+	if h.FlagFile == "" || h.FlagKey == "" {
+		return nil
+	}
+
+	h.applyFlag()
+
+	interval := time.Duration(h.FlagPollInterval)
+	if interval <= 0 {
+		interval = defaultFlagPollInterval
+	}
+
+	h.flagWatcher = runTicker(interval, h.applyFlag)
+
+	return nil
+}
+
+// stopFlagWatcher stops polling the flag file and waits for the watcher to exit
+func (h *MaintenanceHandler) stopFlagWatcher() {
+	h.flagWatcher.stop()
+	h.flagWatcher = nil
+}
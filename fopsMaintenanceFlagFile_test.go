@@ -0,0 +1,194 @@
+package fopsMaintenance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFlagFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestMaintenanceHandler_FlagFile(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "flags.json")
+	writeFlagFile(t, flagFile, `{"shop": {"maintenance": true}, "other": false}`)
+
+	h := &MaintenanceHandler{
+		FlagFile:         flagFile,
+		FlagKey:          "shop.maintenance",
+		FlagPollInterval: caddy.Duration(10 * time.Millisecond),
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	// The flag is applied synchronously at provision time
+	assert.True(t, currentState(h).Enabled)
+
+	writeFlagFile(t, flagFile, `{"shop": {"maintenance": false}}`)
+	assert.Eventually(t, func() bool { return !currentState(h).Enabled }, time.Second, 5*time.Millisecond)
+
+	// A malformed file leaves the state untouched
+	writeFlagFile(t, flagFile, `{"shop": `)
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, currentState(h).Enabled)
+
+	writeFlagFile(t, flagFile, `{"shop": {"maintenance": true}}`)
+	assert.Eventually(t, func() bool { return currentState(h).Enabled }, time.Second, 5*time.Millisecond)
+}
+
+func TestMaintenanceHandler_FlagFile_AdminChangeStandsUntilFlagChanges(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "flags.json")
+	writeFlagFile(t, flagFile, `{"maintenance": false}`)
+
+	h := &MaintenanceHandler{
+		FlagFile:         flagFile,
+		FlagKey:          "maintenance",
+		FlagPollInterval: caddy.Duration(10 * time.Millisecond),
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	h.enabledMux.Lock()
+	h.setEnabledLocked(true, time.Now())
+	h.enabledMux.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, currentState(h).Enabled, "an unchanged flag does not override the admin API")
+
+	writeFlagFile(t, flagFile, `{"maintenance": true}`)
+	time.Sleep(50 * time.Millisecond)
+	writeFlagFile(t, flagFile, `{"maintenance": false}`)
+	assert.Eventually(t, func() bool { return !currentState(h).Enabled }, time.Second, 5*time.Millisecond)
+}
+
+func TestMaintenanceHandler_FlagFile_GracePeriod(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "flags.json")
+	writeFlagFile(t, flagFile, `{"maintenance": true}`)
+
+	h := &MaintenanceHandler{
+		FlagFile:         flagFile,
+		FlagKey:          "maintenance",
+		FlagPollInterval: caddy.Duration(10 * time.Millisecond),
+		GracePeriod:      caddy.Duration(time.Hour),
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+	inGracePeriod := func() bool {
+		h.enabledMux.RLock()
+		defer h.enabledMux.RUnlock()
+		return h.inGracePeriodLocked(time.Now())
+	}
+
+	// The flag found at startup does not start the grace period
+	assert.True(t, currentState(h).Enabled)
+	assert.False(t, inGracePeriod())
+
+	writeFlagFile(t, flagFile, `{"maintenance": false}`)
+	assert.Eventually(t, func() bool { return !currentState(h).Enabled }, time.Second, 5*time.Millisecond)
+	writeFlagFile(t, flagFile, `{"maintenance": true}`)
+	assert.Eventually(t, func() bool { return currentState(h).Enabled }, time.Second, 5*time.Millisecond)
+	assert.True(t, inGracePeriod())
+}
+
+func TestMaintenanceHandler_ReadFlag(t *testing.T) {
+	flagFile := filepath.Join(t.TempDir(), "flags.json")
+
+	tests := []struct {
+		name          string
+		content       string
+		key           string
+		expected      bool
+		errorContains string
+	}{
+		{name: "top-level key", content: `{"maintenance": true}`, key: "maintenance", expected: true},
+		{name: "nested key", content: `{"a": {"b": {"c": true}}}`, key: "a.b.c", expected: true},
+		{name: "false value", content: `{"maintenance": false}`, key: "maintenance", expected: false},
+		{name: "missing key", content: `{"other": true}`, key: "maintenance", errorContains: "flag 'maintenance' not found"},
+		{name: "missing nested key", content: `{"a": true}`, key: "a.b", errorContains: "flag 'a.b' not found"},
+		{name: "not a boolean", content: `{"maintenance": "yes"}`, key: "maintenance", errorContains: "is not a boolean"},
+		{name: "malformed JSON", content: `{"maintenance": tru`, key: "maintenance", errorContains: "invalid flag file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFlagFile(t, flagFile, tt.content)
+			h := &MaintenanceHandler{FlagFile: flagFile, FlagKey: tt.key}
+
+			flag, err := h.readFlag()
+			if tt.errorContains != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, flag)
+		})
+	}
+
+	h := &MaintenanceHandler{FlagFile: filepath.Join(t.TempDir(), "missing.json"), FlagKey: "maintenance"}
+	_, err := h.readFlag()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read flag file")
+}
+
+func TestMaintenanceHandler_FlagFile_Provision(t *testing.T) {
+	t.Run("missing file keeps the default state", func(t *testing.T) {
+		h := &MaintenanceHandler{
+			DefaultEnabled: true,
+			FlagFile:       filepath.Join(t.TempDir(), "missing.json"),
+			FlagKey:        "maintenance",
+		}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+		assert.True(t, currentState(h).Enabled)
+	})
+
+	t.Run("key is required", func(t *testing.T) {
+		h := &MaintenanceHandler{FlagFile: filepath.Join(t.TempDir(), "flags.json")}
+		err := h.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "flag_file requires a flag_key")
+	})
+}
+
+func TestParseCaddyfile_FlagFile(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		flag_file /etc/flags.json shop.maintenance
+		flag_poll_interval 30s
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "/etc/flags.json", actualHandler.FlagFile)
+	assert.Equal(t, "shop.maintenance", actualHandler.FlagKey)
+	assert.Equal(t, caddy.Duration(30*time.Second), actualHandler.FlagPollInterval)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		flag_file /etc/flags.json
+		flag_key maintenance
+	}`)
+	actual, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+	assert.Equal(t, "maintenance", actual.(*MaintenanceHandler).FlagKey)
+
+	for _, input := range []string{
+		"maintenance {\n\tflag_poll_interval 0s\n}",
+		"maintenance {\n\tflag_poll_interval soon\n}",
+		"maintenance {\n\tflag_key\n}",
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(input)})
+		assert.Error(t, err, input)
+	}
+}
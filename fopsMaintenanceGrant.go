@@ -0,0 +1,238 @@
+package fopsMaintenance
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Where clients present a bypass grant
+const (
+	grantHeader = "X-Maintenance-Grant"
+	grantCookie = "fops_maintenance_grant"
+)
+
+// defaultGrantTTL is how long a grant is valid when the request sets no ttl
+const defaultGrantTTL = time.Hour
+
+// bypassReasonGrant is the bypass_header value for requests with a grant
+const bypassReasonGrant = "grant"
+
+// grant is a temporary bypass issued through the admin API
+type grant struct {
+	expiresAt time.Time
+	// oneTime grants are revoked by their first use
+	oneTime bool
+}
+
+var (
+	// Active grants keyed by token, shared by every instance so that a grant
+	// survives config reloads
+	grants   = make(map[string]grant)
+	grantMux sync.Mutex
+)
+
+// issueGrant stores a new grant and returns its token
+func issueGrant(ttl time.Duration, oneTime bool, now time.Time) (string, time.Time) {
+	token := rand.Text()
+	expiresAt := now.Add(ttl)
+
+	grantMux.Lock()
+	defer grantMux.Unlock()
+	pruneExpiredGrantsLocked(now)
+	grants[token] = grant{expiresAt: expiresAt, oneTime: oneTime}
+
+	return token, expiresAt
+}
+
+// useGrant reports whether token is an active grant, revoking one-time grants
+// and dropping the grants that expired
+func useGrant(token string, now time.Time) bool {
+	if token == "" {
+		return false
+	}
+
+	grantMux.Lock()
+	defer grantMux.Unlock()
+	pruneExpiredGrantsLocked(now)
+	current, ok := grants[token]
+	if !ok {
+		return false
+	}
+	if current.oneTime {
+		delete(grants, token)
+	}
+
+	return true
+}
+
+// revokeGrant removes a grant, reporting whether it was active
+func revokeGrant(token string, now time.Time) bool {
+	grantMux.Lock()
+	defer grantMux.Unlock()
+	pruneExpiredGrantsLocked(now)
+	if _, ok := grants[token]; !ok {
+		return false
+	}
+	delete(grants, token)
+
+	return true
+}
+
+// pruneExpiredGrantsLocked drops expired grants. The caller must hold grantMux.
+func pruneExpiredGrantsLocked(now time.Time) {
+	for token, current := range grants {
+		if !now.Before(current.expiresAt) {
+			delete(grants, token)
+		}
+	}
+}
+
+// requestGrant returns the grant token presented by the request, from the
+// grant header or cookie
+func requestGrant(r *http.Request) string {
+	if token := r.Header.Get(grantHeader); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(grantCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// bypassGrant reports whether the request presents an active grant. The
+// grant header and cookie are removed so that the token is not forwarded
+// upstream.
+func bypassGrant(r *http.Request) bool {
+	token := requestGrant(r)
+	r.Header.Del(grantHeader)
+	removeRequestCookie(r, grantCookie)
+
+	return useGrant(token, time.Now())
+}
+
+// removeRequestCookie drops the cookie called name from the request, keeping
+// the other cookies
+func removeRequestCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	kept := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			kept = append(kept, cookie.String())
+		}
+	}
+	if len(kept) == len(cookies) {
+		return
+	}
+
+	r.Header.Del("Cookie")
+	if len(kept) > 0 {
+		r.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// grantRequest is the payload accepted by POST on the grant endpoint
+type grantRequest struct {
+	// TTL is how long the grant is valid (e.g. "30m", default: 1h)
+	TTL string `json:"ttl,omitempty"`
+	// OneTime revokes the grant once it let a request through
+	OneTime bool `json:"one_time,omitempty"`
+}
+
+// grantResponse is the payload returned by POST on the grant endpoint
+type grantResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	OneTime   bool      `json:"one_time"`
+	// Header and Cookie name where the token is presented
+	Header string `json:"header"`
+	Cookie string `json:"cookie"`
+}
+
+// revokeGrantRequest is the payload accepted by DELETE on the grant endpoint
+type revokeGrantRequest struct {
+	Token string `json:"token"`
+}
+
+func (h AdminHandler) grant(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPost:
+		return h.postGrant(w, r)
+	case http.MethodDelete:
+		return h.deleteGrant(w, r)
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+}
+
+func (h AdminHandler) postGrant(w http.ResponseWriter, r *http.Request) error {
+	var req grantRequest
+	if r.ContentLength != 0 {
+		if err := decodeAdminRequest(w, r, &req); err != nil {
+			return err
+		}
+	}
+
+	ttl := defaultGrantTTL
+	if req.TTL != "" {
+		duration, err := caddy.ParseDuration(req.TTL)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid ttl: %v", err),
+			}
+		}
+		if duration <= 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("ttl must be positive"),
+			}
+		}
+		ttl = duration
+	}
+
+	token, expiresAt := issueGrant(ttl, req.OneTime, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(grantResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+		OneTime:   req.OneTime,
+		Header:    grantHeader,
+		Cookie:    grantCookie,
+	})
+}
+
+func (h AdminHandler) deleteGrant(w http.ResponseWriter, r *http.Request) error {
+	var req revokeGrantRequest
+	if err := decodeAdminRequest(w, r, &req); err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("token is required"),
+		}
+	}
+
+	if !revokeGrant(req.Token, time.Now()) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("grant not found or expired"),
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
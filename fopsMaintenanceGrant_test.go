@@ -0,0 +1,183 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetGrantsForTest(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		grantMux.Lock()
+		grants = make(map[string]grant)
+		grantMux.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// postGrantForTest issues a grant through the admin endpoint
+func postGrantForTest(t *testing.T, body string) grantResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/grant", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	require.NoError(t, AdminHandler{}.grant(w, req))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var response grantResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func TestMaintenanceHandler_Grant(t *testing.T) {
+	resetGrantsForTest(t)
+
+	h := &MaintenanceHandler{DefaultEnabled: true, BypassHeader: "X-Maintenance-Bypass"}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	var forwarded http.Header
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		forwarded = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	serve := func(configure func(r *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		configure(req)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w
+	}
+	withHeader := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set(grantHeader, token) }
+	}
+
+	t.Run("valid grant", func(t *testing.T) {
+		issued := postGrantForTest(t, `{"ttl": "30m"}`)
+		assert.NotEmpty(t, issued.Token)
+		assert.WithinDuration(t, time.Now().Add(30*time.Minute), issued.ExpiresAt, time.Minute)
+		assert.Equal(t, grantHeader, issued.Header)
+		assert.Equal(t, grantCookie, issued.Cookie)
+
+		w := serve(withHeader(issued.Token))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, bypassReasonGrant, w.Header().Get("X-Maintenance-Bypass"))
+		// The token is not forwarded upstream
+		assert.Empty(t, forwarded.Get(grantHeader))
+
+		// A time-limited grant is reusable, also as a cookie
+		w = serve(func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			r.AddCookie(&http.Cookie{Name: grantCookie, Value: issued.Token})
+			r.AddCookie(&http.Cookie{Name: "lang", Value: "fr"})
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+		// The grant cookie is not forwarded either, other cookies are kept
+		assert.Equal(t, "session=abc; lang=fr", forwarded.Get("Cookie"))
+
+		w = serve(func(r *http.Request) { r.AddCookie(&http.Cookie{Name: grantCookie, Value: issued.Token}) })
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, forwarded.Values("Cookie"))
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		assert.Equal(t, http.StatusServiceUnavailable, serve(withHeader("not-a-grant")).Code)
+		assert.Equal(t, http.StatusServiceUnavailable, serve(func(r *http.Request) {}).Code)
+	})
+
+	t.Run("expired grant", func(t *testing.T) {
+		issued := postGrantForTest(t, `{"ttl": "20ms"}`)
+		time.Sleep(40 * time.Millisecond)
+		assert.Equal(t, http.StatusServiceUnavailable, serve(withHeader(issued.Token)).Code)
+	})
+
+	t.Run("one-time grant", func(t *testing.T) {
+		issued := postGrantForTest(t, `{"one_time": true}`)
+		assert.True(t, issued.OneTime)
+		assert.WithinDuration(t, time.Now().Add(defaultGrantTTL), issued.ExpiresAt, time.Minute)
+
+		assert.Equal(t, http.StatusOK, serve(withHeader(issued.Token)).Code)
+		assert.Equal(t, http.StatusServiceUnavailable, serve(withHeader(issued.Token)).Code)
+	})
+
+	t.Run("revoked grant", func(t *testing.T) {
+		issued := postGrantForTest(t, "")
+		assert.Equal(t, http.StatusOK, serve(withHeader(issued.Token)).Code)
+
+		req := httptest.NewRequest(http.MethodDelete, "/maintenance/grant", bytes.NewBufferString(`{"token": "`+issued.Token+`"}`))
+		w := httptest.NewRecorder()
+		require.NoError(t, AdminHandler{}.grant(w, req))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		assert.Equal(t, http.StatusServiceUnavailable, serve(withHeader(issued.Token)).Code)
+
+		// Revoking again reports the grant as gone
+		req = httptest.NewRequest(http.MethodDelete, "/maintenance/grant", bytes.NewBufferString(`{"token": "`+issued.Token+`"}`))
+		err := AdminHandler{}.grant(httptest.NewRecorder(), req)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusNotFound, err.(caddy.APIError).HTTPStatus)
+	})
+}
+
+func TestAdminHandler_Grant_InvalidRequests(t *testing.T) {
+	resetGrantsForTest(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		expected int
+	}{
+		{name: "invalid ttl", method: http.MethodPost, body: `{"ttl": "soon"}`, expected: http.StatusBadRequest},
+		{name: "negative ttl", method: http.MethodPost, body: `{"ttl": "-5m"}`, expected: http.StatusBadRequest},
+		{name: "unknown field", method: http.MethodPost, body: `{"tl": "5m"}`, expected: http.StatusBadRequest},
+		{name: "revoke without token", method: http.MethodDelete, body: `{}`, expected: http.StatusBadRequest},
+		{name: "method not allowed", method: http.MethodGet, expected: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/maintenance/grant", bytes.NewBufferString(tt.body))
+			err := AdminHandler{}.grant(httptest.NewRecorder(), req)
+			require.Error(t, err)
+			assert.Equal(t, tt.expected, err.(caddy.APIError).HTTPStatus)
+		})
+	}
+}
+
+func TestPruneExpiredGrants(t *testing.T) {
+	resetGrantsForTest(t)
+
+	now := time.Now()
+	expired, _ := issueGrant(time.Minute, false, now.Add(-time.Hour))
+	active, _ := issueGrant(time.Hour, false, now)
+
+	grantMux.Lock()
+	assert.NotContains(t, grants, expired)
+	assert.Contains(t, grants, active)
+	grantMux.Unlock()
+}
+
+func TestUseGrant_PrunesExpiredGrants(t *testing.T) {
+	resetGrantsForTest(t)
+
+	now := time.Now()
+	expired, _ := issueGrant(time.Minute, false, now)
+	active, _ := issueGrant(time.Hour, false, now)
+
+	assert.True(t, useGrant(active, now.Add(2*time.Minute)))
+
+	grantMux.Lock()
+	assert.NotContains(t, grants, expired)
+	assert.Contains(t, grants, active)
+	grantMux.Unlock()
+}
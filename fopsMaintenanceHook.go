@@ -0,0 +1,168 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// hookSecretEnv holds the secret signing the payloads of the hook endpoint,
+// read from the environment like the other admin settings
+const hookSecretEnv = "FOPS_MAINTENANCE_HOOK_SECRET"
+
+// hookSignatureHeader carries the HMAC-SHA256 of the request body, as
+// "sha256=<hex>"
+const hookSignatureHeader = "X-Maintenance-Signature"
+
+// hookMaxClockSkew bounds the age of a signed payload, so that a captured
+// request cannot be replayed later
+const hookMaxClockSkew = 5 * time.Minute
+
+var (
+	// Signatures of the accepted payloads with the time they stop being
+	// accepted anyway, so that a payload is not replayed within the window
+	// of hookMaxClockSkew
+	hookSignatures   = make(map[string]time.Time)
+	hookSignatureMux sync.Mutex
+)
+
+// hookRequest is the payload accepted by the hook endpoint: a set request
+// with the time it was signed
+type hookRequest struct {
+	toggleRequest
+	// Timestamp is when the payload was signed, in Unix seconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// hook enables or disables maintenance like the set endpoint, for external
+// systems such as CI/CD pipelines that sign their requests with a shared
+// secret instead of holding admin credentials
+func (h AdminHandler) hook(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	secret := os.Getenv(hookSecretEnv)
+	if secret == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance hook is not configured, set %s", hookSecretEnv),
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, adminMaxBodyBytes()))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return caddy.APIError{
+				HTTPStatus: http.StatusRequestEntityTooLarge,
+				Err:        fmt.Errorf("request body exceeds %d bytes", maxBytesErr.Limit),
+			}
+		}
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("failed to read request body: %v", err),
+		}
+	}
+
+	// Nothing in the payload is trusted before the signature is verified
+	if !validHookSignature(secret, body, r.Header.Get(hookSignatureHeader)) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusUnauthorized,
+			Err:        fmt.Errorf("invalid %s", hookSignatureHeader),
+		}
+	}
+
+	var req hookRequest
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := decodeAdminRequest(w, r, &req); err != nil {
+		return err
+	}
+	if req.Timestamp == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("timestamp is required"),
+		}
+	}
+	signedAt := time.Unix(req.Timestamp, 0)
+	if skew := time.Since(signedAt).Abs(); skew > hookMaxClockSkew {
+		return caddy.APIError{
+			HTTPStatus: http.StatusUnauthorized,
+			Err:        fmt.Errorf("timestamp %s is more than %s away from the current time", signedAt.UTC().Format(time.RFC3339), hookMaxClockSkew),
+		}
+	}
+
+	// A replayed payload is rejected even when the first one failed, so
+	// that it cannot succeed later once the state allows it
+	if !useHookSignature(hookSignature(secret, body), signedAt.Add(hookMaxClockSkew), time.Now()) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusUnauthorized,
+			Err:        fmt.Errorf("payload was already used"),
+		}
+	}
+
+	response, err := applyToggle(req.toggleRequest)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+// validHookSignature reports whether signature is the HMAC-SHA256 of body
+// with secret, formatted as "sha256=<hex>"
+func validHookSignature(secret string, body []byte, signature string) bool {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(signature), "sha256=")
+	if !ok {
+		return false
+	}
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(decoded, hookSignature(secret, body))
+}
+
+// hookSignature returns the HMAC-SHA256 of body with secret
+func hookSignature(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return mac.Sum(nil)
+}
+
+// useHookSignature records the signature of an accepted payload until
+// expiresAt, reporting false when it was already seen
+func useHookSignature(signature []byte, expiresAt time.Time, now time.Time) bool {
+	hookSignatureMux.Lock()
+	defer hookSignatureMux.Unlock()
+
+	for seen, seenExpiresAt := range hookSignatures {
+		if !now.Before(seenExpiresAt) {
+			delete(hookSignatures, seen)
+		}
+	}
+	key := string(signature)
+	if _, ok := hookSignatures[key]; ok {
+		return false
+	}
+	hookSignatures[key] = expiresAt
+
+	return true
+}
@@ -0,0 +1,192 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHookSecret = "hook-secret"
+
+func signHookBodyForTest(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newHookRequestForTest(body []byte, signature string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/hook", bytes.NewReader(body))
+	if signature != "" {
+		req.Header.Set(hookSignatureHeader, signature)
+	}
+	return req
+}
+
+func hookErrorStatus(t *testing.T, err error) int {
+	t.Helper()
+	require.Error(t, err)
+	apiErr, ok := err.(caddy.APIError)
+	require.True(t, ok, "expected caddy.APIError, got %T", err)
+	return apiErr.HTTPStatus
+}
+
+func resetHookSignaturesForTest(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		hookSignatureMux.Lock()
+		hookSignatures = make(map[string]time.Time)
+		hookSignatureMux.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func hookEnabledForTest(h *MaintenanceHandler) bool {
+	h.enabledMux.RLock()
+	defer h.enabledMux.RUnlock()
+	return h.enabled
+}
+
+func TestAdminHandler_Hook(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetHookSignaturesForTest(t)
+	t.Setenv(hookSecretEnv, testHookSecret)
+
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	body := []byte(fmt.Sprintf(`{"enabled": true, "retry_after": 600, "timestamp": %d}`, time.Now().Unix()))
+	w := httptest.NewRecorder()
+	err := AdminHandler{}.hook(w, newHookRequestForTest(body, signHookBodyForTest(testHookSecret, body)))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"enabled": true, "changed": true}`, w.Body.String())
+	assert.True(t, hookEnabledForTest(maintenanceHandler))
+	assert.Equal(t, 600, maintenanceHandler.RetryAfter)
+}
+
+func TestAdminHandler_Hook_InvalidSignature(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetHookSignaturesForTest(t)
+	t.Setenv(hookSecretEnv, testHookSecret)
+
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	body := []byte(fmt.Sprintf(`{"enabled": true, "timestamp": %d}`, time.Now().Unix()))
+	tampered := bytes.Replace(body, []byte("true"), []byte("false"), 1)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+	}{
+		{name: "missing signature", body: body},
+		{name: "tampered body", body: tampered, signature: signHookBodyForTest(testHookSecret, body)},
+		{name: "wrong secret", body: body, signature: signHookBodyForTest("other-secret", body)},
+		{name: "missing prefix", body: body, signature: signHookBodyForTest(testHookSecret, body)[len("sha256="):]},
+		{name: "not hexadecimal", body: body, signature: "sha256=not-hex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AdminHandler{}.hook(httptest.NewRecorder(), newHookRequestForTest(tt.body, tt.signature))
+			assert.Equal(t, http.StatusUnauthorized, hookErrorStatus(t, err))
+			assert.False(t, hookEnabledForTest(maintenanceHandler))
+		})
+	}
+}
+
+func TestAdminHandler_Hook_StaleTimestamp(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetHookSignaturesForTest(t)
+	t.Setenv(hookSecretEnv, testHookSecret)
+
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	for _, signedAt := range []time.Time{
+		time.Now().Add(-hookMaxClockSkew - time.Minute),
+		time.Now().Add(hookMaxClockSkew + time.Minute),
+	} {
+		body := []byte(fmt.Sprintf(`{"enabled": true, "timestamp": %d}`, signedAt.Unix()))
+		err := AdminHandler{}.hook(httptest.NewRecorder(), newHookRequestForTest(body, signHookBodyForTest(testHookSecret, body)))
+		assert.Equal(t, http.StatusUnauthorized, hookErrorStatus(t, err))
+	}
+
+	body := []byte(`{"enabled": true}`)
+	err := AdminHandler{}.hook(httptest.NewRecorder(), newHookRequestForTest(body, signHookBodyForTest(testHookSecret, body)))
+	assert.Equal(t, http.StatusBadRequest, hookErrorStatus(t, err))
+
+	assert.False(t, hookEnabledForTest(maintenanceHandler))
+}
+
+func TestAdminHandler_Hook_Replay(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	resetHookSignaturesForTest(t)
+	t.Setenv(hookSecretEnv, testHookSecret)
+
+	maintenanceHandler := &MaintenanceHandler{}
+	setMaintenanceHandler(maintenanceHandler)
+
+	now := time.Now()
+	enable := []byte(fmt.Sprintf(`{"enabled": true, "timestamp": %d}`, now.Unix()))
+	disable := []byte(fmt.Sprintf(`{"enabled": false, "timestamp": %d}`, now.Unix()))
+	send := func(body []byte) error {
+		return AdminHandler{}.hook(httptest.NewRecorder(), newHookRequestForTest(body, signHookBodyForTest(testHookSecret, body)))
+	}
+
+	require.NoError(t, send(enable))
+	require.NoError(t, send(disable))
+
+	// Replaying the captured enable request is rejected
+	assert.Equal(t, http.StatusUnauthorized, hookErrorStatus(t, send(enable)))
+	assert.False(t, hookEnabledForTest(maintenanceHandler))
+
+	// A different payload is accepted
+	again := []byte(fmt.Sprintf(`{"enabled": true, "timestamp": %d, "message": "again"}`, now.Unix()))
+	require.NoError(t, send(again))
+	assert.True(t, hookEnabledForTest(maintenanceHandler))
+}
+
+func TestUseHookSignature(t *testing.T) {
+	resetHookSignaturesForTest(t)
+
+	now := time.Now()
+	assert.True(t, useHookSignature([]byte("first"), now.Add(time.Minute), now))
+	assert.False(t, useHookSignature([]byte("first"), now.Add(time.Minute), now))
+	assert.True(t, useHookSignature([]byte("second"), now.Add(time.Minute), now))
+
+	// Expired signatures are forgotten, their payloads fail the timestamp
+	// check anyway
+	assert.True(t, useHookSignature([]byte("third"), now.Add(3*time.Minute), now.Add(2*time.Minute)))
+	hookSignatureMux.Lock()
+	assert.Equal(t, []string{"third"}, slices.Collect(maps.Keys(hookSignatures)))
+	hookSignatureMux.Unlock()
+}
+
+func TestAdminHandler_Hook_NotConfigured(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	t.Setenv(hookSecretEnv, "")
+	setMaintenanceHandler(&MaintenanceHandler{})
+
+	body := []byte(fmt.Sprintf(`{"enabled": true, "timestamp": %d}`, time.Now().Unix()))
+	err := AdminHandler{}.hook(httptest.NewRecorder(), newHookRequestForTest(body, signHookBodyForTest("", body)))
+	assert.Equal(t, http.StatusNotFound, hookErrorStatus(t, err))
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/hook", nil)
+	err = AdminHandler{}.hook(httptest.NewRecorder(), req)
+	assert.Equal(t, http.StatusMethodNotAllowed, hookErrorStatus(t, err))
+}
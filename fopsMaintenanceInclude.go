@@ -0,0 +1,85 @@
+package fopsMaintenance
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// includeDirective is the subdirective merging a fragment file of
+// maintenance subdirectives into the block
+const includeDirective = "include"
+
+// expandIncludes returns the tokens of d with every include line of the
+// maintenance block replaced by the tokens of its fragment. Fragments are
+// inserted at the start of the block, in order, so that inline subdirectives
+// override the included values. nesting is the block depth d starts at.
+func expandIncludes(d *caddyfile.Dispenser, nesting int, visited []string) ([]caddyfile.Token, error) {
+	var (
+		tokens   []caddyfile.Token
+		previous caddyfile.Token
+		insertAt int
+	)
+
+	for d.Next() {
+		token := d.Token()
+		startsLine := token.File != previous.File || token.Line != previous.Line
+		previous = token
+
+		switch {
+		case token.Text == "{":
+			nesting++
+			tokens = append(tokens, token)
+			if nesting == 1 {
+				insertAt = len(tokens)
+			}
+			continue
+		case token.Text == "}":
+			nesting--
+		case nesting == 1 && startsLine && token.Text == includeDirective:
+			args := d.RemainingArgs()
+			if len(args) != 1 {
+				return nil, d.Errf("include expects exactly one file")
+			}
+			fragment, err := loadInclude(d, args[0], visited)
+			if err != nil {
+				return nil, err
+			}
+			tokens = slices.Insert(tokens, insertAt, fragment...)
+			insertAt += len(fragment)
+			previous = d.Token()
+			continue
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// loadInclude reads and tokenizes a fragment file, resolving a relative path
+// against the directory of the file including it
+func loadInclude(d *caddyfile.Dispenser, file string, visited []string) ([]caddyfile.Token, error) {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(d.File()), file)
+	}
+	if absolute, err := filepath.Abs(file); err == nil {
+		file = absolute
+	}
+	if slices.Contains(visited, file) {
+		return nil, d.Errf("include cycle detected with '%s'", file)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, d.Errf("failed to read include file: %v", err)
+	}
+	fragment, err := caddyfile.Tokenize(content, file)
+	if err != nil {
+		return nil, d.Errf("failed to parse include file '%s': %v", file, err)
+	}
+
+	return expandIncludes(caddyfile.NewDispenser(fragment), 1, append(slices.Clone(visited), file))
+}
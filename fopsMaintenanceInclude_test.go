@@ -0,0 +1,127 @@
+package fopsMaintenance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseTestCaddyfile(t *testing.T, input string) (*MaintenanceHandler, error) {
+	t.Helper()
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(input)})
+	if err != nil {
+		return nil, err
+	}
+	handler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	return handler, nil
+}
+
+func TestParseCaddyfile_Include(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared.conf")
+	require.NoError(t, os.WriteFile(shared, []byte(`# Shared by every site
+allowed_ips 10.0.0.0/8
+htpasswd_file /etc/caddy/ops.htpasswd
+retry_after 600
+lockdown true
+templates_by_lang {
+	fr /etc/caddy/fr.html
+}
+`), 0644))
+
+	t.Run("included values", func(t *testing.T) {
+		h, err := parseTestCaddyfile(t, `maintenance {
+			include `+shared+`
+		}`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.0/8"}, h.AllowedIPs)
+		assert.Equal(t, "/etc/caddy/ops.htpasswd", h.HtpasswdFile)
+		assert.Equal(t, 600, h.RetryAfter)
+		assert.True(t, h.Lockdown)
+		assert.Equal(t, map[string]string{"fr": "/etc/caddy/fr.html"}, h.TemplatesByLang)
+	})
+
+	t.Run("inline values override included ones wherever the include is", func(t *testing.T) {
+		h, err := parseTestCaddyfile(t, `maintenance {
+			retry_after 60
+			lockdown false
+			include `+shared+`
+			allowed_ips 192.168.1.1
+		}`)
+		require.NoError(t, err)
+		assert.Equal(t, 60, h.RetryAfter)
+		assert.False(t, h.Lockdown)
+		assert.Equal(t, "/etc/caddy/ops.htpasswd", h.HtpasswdFile)
+		// Repeatable subdirectives accumulate, as when written inline
+		assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1"}, h.AllowedIPs)
+	})
+
+	t.Run("later includes override earlier ones", func(t *testing.T) {
+		override := filepath.Join(dir, "override.conf")
+		require.NoError(t, os.WriteFile(override, []byte("retry_after 30\n"), 0644))
+
+		h, err := parseTestCaddyfile(t, `maintenance {
+			include `+shared+`
+			include `+override+`
+		}`)
+		require.NoError(t, err)
+		assert.Equal(t, 30, h.RetryAfter)
+	})
+
+	t.Run("nested include relative to the including file", func(t *testing.T) {
+		nestedDir := filepath.Join(dir, "nested")
+		require.NoError(t, os.Mkdir(nestedDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(nestedDir, "base.conf"), []byte("response_delay 250\n"), 0644))
+		outer := filepath.Join(nestedDir, "outer.conf")
+		require.NoError(t, os.WriteFile(outer, []byte("include base.conf\nretry_after 90\n"), 0644))
+
+		h, err := parseTestCaddyfile(t, `maintenance {
+			include `+outer+`
+		}`)
+		require.NoError(t, err)
+		assert.Equal(t, 250, h.ResponseDelay)
+		assert.Equal(t, 90, h.RetryAfter)
+	})
+
+	t.Run("include as an argument is not a subdirective", func(t *testing.T) {
+		h, err := parseTestCaddyfile(t, `maintenance {
+			bypass_users include
+		}`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"include"}, h.BypassUsers)
+	})
+}
+
+func TestParseCaddyfile_IncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	cycle := filepath.Join(dir, "cycle.conf")
+	require.NoError(t, os.WriteFile(cycle, []byte("include cycle.conf\n"), 0644))
+	invalid := filepath.Join(dir, "invalid.conf")
+	require.NoError(t, os.WriteFile(invalid, []byte("retry_after soon\n"), 0644))
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "missing file", input: "include " + filepath.Join(dir, "missing.conf"), expected: "failed to read include file"},
+		{name: "missing argument", input: "include", expected: "include expects exactly one file"},
+		{name: "too many arguments", input: "include a.conf b.conf", expected: "include expects exactly one file"},
+		{name: "cycle", input: "include " + cycle, expected: "include cycle detected"},
+		{name: "invalid fragment", input: "include " + invalid, expected: invalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestCaddyfile(t, "maintenance {\n\t"+tt.input+"\n}")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
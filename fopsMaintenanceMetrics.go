@@ -0,0 +1,88 @@
+package fopsMaintenance
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// How a request held by the request retention mode was resolved
+const (
+	// Maintenance was disabled and the request forwarded
+	retentionOutcomeReleased = "released"
+	// The retention timeout elapsed and the maintenance page was served
+	retentionOutcomeTimedOut = "timed_out"
+	// The client went away
+	retentionOutcomeCancelled = "cancelled"
+	// Caddy reloaded or shut down and the maintenance page was served
+	retentionOutcomeShutdown = "shutdown"
+	// Caddy reloaded or shut down and the request was forwarded, with
+	// release_on_shutdown
+	retentionOutcomeShutdownReleased = "shutdown_released"
+)
+
+// retentionMetrics instruments the request retention mode
+type retentionMetrics struct {
+	held         prometheus.Gauge
+	holdDuration *prometheus.HistogramVec
+}
+
+// newRetentionMetrics creates the retention metrics and registers them with
+// Caddy's metrics registry. Handlers of the same config share the registered
+// collectors. Without a registry the metrics are kept but not exposed.
+func newRetentionMetrics(registry *prometheus.Registry) *retentionMetrics {
+	m := &retentionMetrics{
+		held: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "fops_maintenance",
+			Subsystem: "retention",
+			Name:      "held_requests",
+			Help:      "Number of requests currently held by the request retention mode.",
+		}),
+		holdDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "fops_maintenance",
+			Subsystem: "retention",
+			Name:      "hold_duration_seconds",
+			Help:      "Time requests were held by the request retention mode, by outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+		}, []string{"outcome"}),
+	}
+	if registry == nil {
+		return m
+	}
+
+	m.held = registerCollector(registry, m.held)
+	m.holdDuration = registerCollector(registry, m.holdDuration)
+
+	return m
+}
+
+// registerCollector registers c, returning the already registered collector
+// when an identical one exists
+func registerCollector[C prometheus.Collector](registry *prometheus.Registry, c C) C {
+	if err := registry.Register(c); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+	}
+
+	return c
+}
+
+// hold records a request entering the retention mode and returns the
+// function recording how it was resolved
+func (m *retentionMetrics) hold() func(outcome string) {
+	if m == nil {
+		return func(string) {}
+	}
+
+	m.held.Inc()
+	heldSince := time.Now()
+	return func(outcome string) {
+		m.held.Dec()
+		m.holdDuration.WithLabelValues(outcome).Observe(time.Since(heldSince).Seconds())
+	}
+}
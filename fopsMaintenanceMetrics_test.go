@@ -0,0 +1,98 @@
+package fopsMaintenance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatherMetric returns the gathered family of the given name, or nil
+func gatherMetric(t *testing.T, ctx caddy.Context, name string) *dto.MetricFamily {
+	t.Helper()
+	families, err := ctx.GetMetricsRegistry().Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			return family
+		}
+	}
+	return nil
+}
+
+func TestMaintenanceHandler_RetentionMetrics_Released(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &MaintenanceHandler{RequestRetentionModeTimeout: 10}
+	require.NoError(t, h.Provision(ctx))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	h.enabledMux.Lock()
+	h.setEnabledLocked(true, time.Now())
+	h.enabledMux.Unlock()
+
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusOK)
+			return nil
+		})
+		assert.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}()
+
+	require.Eventually(t, func() bool {
+		family := gatherMetric(t, ctx, "fops_maintenance_retention_held_requests")
+		return family != nil && family.GetMetric()[0].GetGauge().GetValue() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	h.enabledMux.Lock()
+	h.setEnabledLocked(false, time.Now())
+	h.enabledMux.Unlock()
+
+	select {
+	case <-held:
+	case <-time.After(5 * time.Second):
+		t.Fatal("held request was not released")
+	}
+
+	family := gatherMetric(t, ctx, "fops_maintenance_retention_hold_duration_seconds")
+	require.NotNil(t, family)
+	require.Len(t, family.GetMetric(), 1)
+	metric := family.GetMetric()[0]
+	require.Len(t, metric.GetLabel(), 1)
+	assert.Equal(t, "outcome", metric.GetLabel()[0].GetName())
+	assert.Equal(t, retentionOutcomeReleased, metric.GetLabel()[0].GetValue())
+	assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+	assert.Greater(t, metric.GetHistogram().GetSampleSum(), 0.0)
+
+	family = gatherMetric(t, ctx, "fops_maintenance_retention_held_requests")
+	require.NotNil(t, family)
+	assert.Equal(t, 0.0, family.GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestRetentionMetrics_SharedRegistration(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	first := newRetentionMetrics(ctx.GetMetricsRegistry())
+	second := newRetentionMetrics(ctx.GetMetricsRegistry())
+	second.hold()(retentionOutcomeTimedOut)
+
+	assert.Same(t, first.holdDuration, second.holdDuration)
+
+	// Without a registry nothing is recorded nor does it panic
+	var none *retentionMetrics
+	none.hold()(retentionOutcomeCancelled)
+}
@@ -0,0 +1,148 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PathAuth is a set of maintenance credentials applying to the requests
+// under a path prefix, e.g. to the section of one team on a large site
+type PathAuth struct {
+	// Path prefix of the section, e.g. "/team-a"
+	PathPrefix string `json:"path_prefix"`
+
+	// Realm announced to the section (default: auth_realm)
+	Realm string `json:"realm,omitempty"`
+
+	// Htpasswd file holding the credentials of the section
+	HtpasswdFile string `json:"htpasswd_file"`
+}
+
+// authCredentials are the parsed htpasswd credentials checked for a request
+// and the realm announced when they are missing
+type authCredentials struct {
+	prefix string
+	realm  string
+	// Htpasswd source, described for logs
+	source  string
+	entries map[string][]byte
+	expiry  map[string]time.Time
+}
+
+// validatePathAuth checks the path_auth sections
+func (h *MaintenanceHandler) validatePathAuth() error {
+	prefixes := make(map[string]bool, len(h.PathAuth))
+	for _, section := range h.PathAuth {
+		if !strings.HasPrefix(section.PathPrefix, "/") {
+			return fmt.Errorf("invalid path_auth prefix '%s', expected a path starting with '/'", section.PathPrefix)
+		}
+		prefix := pathAuthPrefix(section.PathPrefix)
+		if prefixes[prefix] {
+			return fmt.Errorf("duplicate path_auth prefix '%s'", section.PathPrefix)
+		}
+		prefixes[prefix] = true
+		if section.HtpasswdFile == "" {
+			return fmt.Errorf("path_auth '%s' requires an htpasswd_file", section.PathPrefix)
+		}
+	}
+
+	return nil
+}
+
+// provisionPathAuth loads the htpasswd file of every path_auth section
+func (h *MaintenanceHandler) provisionPathAuth() error {
+	credentials, err := h.readPathCredentials()
+	if err != nil {
+		return err
+	}
+	h.pathCredentials = credentials
+
+	return nil
+}
+
+// readPathCredentials reads and parses the htpasswd file of every path_auth
+// section
+func (h *MaintenanceHandler) readPathCredentials() ([]*authCredentials, error) {
+	var pathCredentials []*authCredentials
+	for _, section := range h.PathAuth {
+		content, err := os.ReadFile(section.HtpasswdFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read htpasswd file '%s' of path_auth '%s': %v", section.HtpasswdFile, section.PathPrefix, err)
+		}
+
+		credentials := &authCredentials{
+			prefix:  pathAuthPrefix(section.PathPrefix),
+			realm:   section.Realm,
+			source:  section.HtpasswdFile,
+			entries: make(map[string][]byte),
+			expiry:  make(map[string]time.Time),
+		}
+		if strings.TrimSpace(credentials.realm) == "" {
+			credentials.realm = h.authRealm()
+		}
+		loadedUsers, err := h.loadHtpasswd(bytes.NewReader(content), credentials.entries, credentials.expiry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse htpasswd file of path_auth '%s': %v", section.PathPrefix, err)
+		}
+		pathCredentials = append(pathCredentials, credentials)
+
+		if h.logger != nil {
+			h.logger.Info("Path htpasswd file loaded successfully",
+				zap.String("path_prefix", section.PathPrefix),
+				zap.String("realm", credentials.realm),
+				zap.String("file", section.HtpasswdFile),
+				zap.Int("users_loaded", loadedUsers),
+			)
+		}
+	}
+
+	return pathCredentials, nil
+}
+
+// pathAuthPrefix normalizes a path_auth prefix, without trailing slash
+func pathAuthPrefix(prefix string) string {
+	return strings.TrimSuffix(cleanRequestPath(prefix), "/")
+}
+
+// credentialsFor returns the credentials applying to the request: those of
+// the longest path_auth prefix it falls under, or the handler htpasswd. A
+// prefix covers its own path and the paths below it, "/team-a" covering
+// "/team-a/docs" but not "/team-abc".
+func (h *MaintenanceHandler) credentialsFor(r *http.Request) *authCredentials {
+	requestPath := cleanRequestPath(r.URL.Path)
+
+	// The credentials are replaced as a whole on reload, never modified
+	h.filesMux.RLock()
+	pathCredentials := h.pathCredentials
+	h.filesMux.RUnlock()
+
+	var selected *authCredentials
+	for _, credentials := range pathCredentials {
+		if requestPath != credentials.prefix && !strings.HasPrefix(requestPath, credentials.prefix+"/") {
+			continue
+		}
+		if selected == nil || len(credentials.prefix) > len(selected.prefix) {
+			selected = credentials
+		}
+	}
+	if selected != nil {
+		return selected
+	}
+
+	source, _ := h.htpasswdSource()
+	// The maps are replaced as a whole on reload, never modified
+	h.filesMux.RLock()
+	defer h.filesMux.RUnlock()
+	return &authCredentials{
+		realm:   h.authRealm(),
+		source:  source,
+		entries: h.htpasswdEntries,
+		expiry:  h.htpasswdExpiry,
+	}
+}
@@ -0,0 +1,184 @@
+package fopsMaintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeHtpasswdForTest writes an htpasswd file with a user per password
+func writeHtpasswdForTest(t *testing.T, users map[string]string) string {
+	t.Helper()
+	var content strings.Builder
+	for username, password := range users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		require.NoError(t, err)
+		content.WriteString(username + ":" + string(hash) + "\n")
+	}
+	file := filepath.Join(t.TempDir(), "users.htpasswd")
+	require.NoError(t, os.WriteFile(file, []byte(content.String()), 0644))
+
+	return file
+}
+
+func TestMaintenanceHandler_PathAuth(t *testing.T) {
+	h := &MaintenanceHandler{
+		HtpasswdFile:   writeHtpasswdForTest(t, map[string]string{"admin": "admin-password"}),
+		AuthRealm:      "Site",
+		DefaultEnabled: true,
+		PathAuth: []PathAuth{
+			{PathPrefix: "/team-a", Realm: "Team A", HtpasswdFile: writeHtpasswdForTest(t, map[string]string{"alice": "alice-password"})},
+			{PathPrefix: "/team-a/private/", HtpasswdFile: writeHtpasswdForTest(t, map[string]string{"root": "root-password"})},
+		},
+	}
+	require.NoError(t, h.Validate())
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		username       string
+		password       string
+		expectedStatus int
+		expectedRealm  string
+	}{
+		{name: "site credentials on the site", path: "/", username: "admin", password: "admin-password", expectedStatus: http.StatusOK},
+		{name: "section credentials on the site", path: "/shop", username: "alice", password: "alice-password", expectedStatus: http.StatusUnauthorized, expectedRealm: "Site"},
+		{name: "section credentials in the section", path: "/team-a", username: "alice", password: "alice-password", expectedStatus: http.StatusOK},
+		{name: "section credentials below the section", path: "/team-a/docs", username: "alice", password: "alice-password", expectedStatus: http.StatusOK},
+		{name: "site credentials in the section", path: "/team-a/docs", username: "admin", password: "admin-password", expectedStatus: http.StatusUnauthorized, expectedRealm: "Team A"},
+		{name: "prefix covers whole segments only", path: "/team-abc", username: "admin", password: "admin-password", expectedStatus: http.StatusOK},
+		{name: "unauthenticated in the section", path: "/team-a/docs", expectedStatus: http.StatusUnauthorized, expectedRealm: "Team A"},
+		{name: "longest prefix wins", path: "/team-a/private/keys", username: "root", password: "root-password", expectedStatus: http.StatusOK},
+		{name: "shorter prefix credentials under the longest", path: "/team-a/private/keys", username: "alice", password: "alice-password", expectedStatus: http.StatusUnauthorized, expectedRealm: "Site"},
+		{name: "dot segments are cleaned", path: "/team-a/../shop", username: "admin", password: "admin-password", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.URL.Path = tt.path
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedRealm != "" {
+				assert.Equal(t, `Basic realm="`+tt.expectedRealm+`"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestMaintenanceHandler_PathAuth_WithoutSiteCredentials(t *testing.T) {
+	h := &MaintenanceHandler{
+		DefaultEnabled: true,
+		PathAuth: []PathAuth{
+			{PathPrefix: "/team-a", HtpasswdFile: writeHtpasswdForTest(t, map[string]string{"alice": "alice-password"})},
+		},
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	w := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/shop", nil), caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+
+	w = httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/team-a", nil), caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="`+defaultAuthRealm+`"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestMaintenanceHandler_PathAuth_Expiry(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "team-a.htpasswd")
+	content := "alice:" + string(hash) + ":expires=" + time.Now().Add(-time.Hour).Format(time.RFC3339) + "\n"
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+
+	h := &MaintenanceHandler{PathAuth: []PathAuth{{PathPrefix: "/team-a", HtpasswdFile: file}}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	req := httptest.NewRequest("GET", "http://example.com/team-a", nil)
+	req.SetBasicAuth("alice", "alice-password")
+	assert.False(t, h.isAuthenticated(req))
+}
+
+func TestMaintenanceHandler_PathAuth_Errors(t *testing.T) {
+	htpasswdFile := writeHtpasswdForTest(t, map[string]string{"alice": "alice-password"})
+
+	for name, pathAuth := range map[string][]PathAuth{
+		"relative prefix":  {{PathPrefix: "team-a", HtpasswdFile: htpasswdFile}},
+		"duplicate prefix": {{PathPrefix: "/team-a", HtpasswdFile: htpasswdFile}, {PathPrefix: "/team-a/", HtpasswdFile: htpasswdFile}},
+		"missing file":     {{PathPrefix: "/team-a"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := &MaintenanceHandler{PathAuth: pathAuth}
+			assert.Error(t, h.Validate())
+		})
+	}
+
+	t.Run("unreadable file", func(t *testing.T) {
+		h := &MaintenanceHandler{PathAuth: []PathAuth{{PathPrefix: "/team-a", HtpasswdFile: filepath.Join(t.TempDir(), "missing")}}}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "path_auth '/team-a'")
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "invalid.htpasswd")
+		require.NoError(t, os.WriteFile(file, []byte("not an entry\n"), 0644))
+		h := &MaintenanceHandler{PathAuth: []PathAuth{{PathPrefix: "/team-a", HtpasswdFile: file}}}
+		assert.Error(t, h.Provision(caddy.Context{}))
+	})
+}
+
+func TestParseCaddyfile_PathAuth(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		path_auth /team-a {
+			realm "Team A"
+			htpasswd_file /etc/caddy/team-a.htpasswd
+		}
+		path_auth /team-b {
+			htpasswd_file /etc/caddy/team-b.htpasswd
+		}
+	}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []PathAuth{
+		{PathPrefix: "/team-a", Realm: "Team A", HtpasswdFile: "/etc/caddy/team-a.htpasswd"},
+		{PathPrefix: "/team-b", HtpasswdFile: "/etc/caddy/team-b.htpasswd"},
+	}, h.PathAuth)
+
+	for _, input := range []string{
+		"maintenance {\n\tpath_auth\n}",
+		"maintenance {\n\tpath_auth /team-a extra\n}",
+		"maintenance {\n\tpath_auth /team-a {\n\t\tusers alice\n\t}\n}",
+		"maintenance {\n\tpath_auth /team-a {\n\t\trealm\n\t}\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
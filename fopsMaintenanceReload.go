@@ -0,0 +1,279 @@
+package fopsMaintenance
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// reloadedInstance reports the inputs reloaded for one instance
+type reloadedInstance struct {
+	// Name of the instance, empty for unnamed instances
+	Name string `json:"name,omitempty"`
+	// Sources lists the files read, with "env:<name>" for htpasswd_env
+	Sources []string `json:"sources"`
+	Enabled bool     `json:"enabled"`
+}
+
+// reloadResponse is the payload returned by the reload endpoint
+type reloadResponse struct {
+	Instances []reloadedInstance `json:"instances"`
+}
+
+// reloadInputs holds the file-backed inputs of an instance, read and
+// validated before any of them replaces the one in use
+type reloadInputs struct {
+	sources []string
+
+	htmlTemplate      *string
+	jsonTemplate      *string
+	lockdownTemplate  *string
+	langTemplates     map[string]string
+	protocolTemplates map[string]string
+
+	// allowList is nil when the allowed_ips_file did not change
+	allowedIPs     []string
+	allowedIPsFile []string
+	allowList      *allowList
+
+	htpasswdEntries map[string][]byte
+	htpasswdExpiry  map[string]time.Time
+	pathCredentials []*authCredentials
+
+	status *persistedStatus
+}
+
+// reload re-reads the file-backed inputs of every instance: the templates,
+// including the localized and protocol ones, the allowed_ips_file, the
+// htpasswd, the path_auth htpasswd files and the status file. Every input of
+// every instance is validated first, a single failure leaves them all
+// unchanged. The template_url is not fetched again.
+func (h AdminHandler) reload(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	handlers := getMaintenanceHandlers()
+	if len(handlers) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("maintenance handler not found"),
+		}
+	}
+
+	inputs := make([]reloadInputs, len(handlers))
+	for i, maintenanceHandler := range handlers {
+		read, err := maintenanceHandler.readReloadInputs()
+		if err != nil {
+			if maintenanceHandler.Name != "" {
+				err = fmt.Errorf("instance '%s': %v", maintenanceHandler.Name, err)
+			}
+			return caddy.APIError{
+				HTTPStatus: http.StatusInternalServerError,
+				Err:        fmt.Errorf("reload aborted, nothing was changed: %v", err),
+			}
+		}
+		inputs[i] = read
+	}
+
+	response := reloadResponse{Instances: make([]reloadedInstance, 0, len(handlers))}
+	for i, maintenanceHandler := range handlers {
+		maintenanceHandler.applyReloadInputs(inputs[i])
+
+		maintenanceHandler.enabledMux.RLock()
+		enabled := maintenanceHandler.enabled
+		maintenanceHandler.enabledMux.RUnlock()
+
+		sources := inputs[i].sources
+		if sources == nil {
+			sources = []string{}
+		}
+		response.Instances = append(response.Instances, reloadedInstance{
+			Name:    maintenanceHandler.Name,
+			Sources: sources,
+			Enabled: enabled,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(response)
+}
+
+// readReloadInputs reads and validates the file-backed inputs of the
+// instance, the error naming the file that failed
+func (h *MaintenanceHandler) readReloadInputs() (reloadInputs, error) {
+	var inputs reloadInputs
+
+	readTemplate := func(kind, path string) (*string, error) {
+		if path == "" {
+			return nil, nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s file '%s': %v", kind, path, err)
+		}
+		inputs.sources = append(inputs.sources, path)
+		text := string(content)
+		return &text, nil
+	}
+
+	var err error
+	if inputs.htmlTemplate, err = readTemplate("template", h.htmlTemplateFile); err != nil {
+		return reloadInputs{}, err
+	}
+	if inputs.htmlTemplate != nil {
+		if err := h.checkHTMLTemplate(*inputs.htmlTemplate, fmt.Sprintf("template file '%s'", h.htmlTemplateFile)); err != nil {
+			return reloadInputs{}, err
+		}
+	}
+	if inputs.jsonTemplate, err = readTemplate("JSON template", h.jsonTemplateFile); err != nil {
+		return reloadInputs{}, err
+	}
+	if inputs.jsonTemplate != nil {
+		sample := templateData{StartedAt: time.Now(), EstimatedEnd: time.Now(), RetryAfter: defaultRetryAfter, Message: h.message()}
+		if _, err := renderJSONTemplate(*inputs.jsonTemplate, sample); err != nil {
+			return reloadInputs{}, fmt.Errorf("invalid JSON template file '%s': %v", h.jsonTemplateFile, err)
+		}
+	}
+	if inputs.lockdownTemplate, err = readTemplate("lockdown template", h.lockdownTemplateFile); err != nil {
+		return reloadInputs{}, err
+	}
+	if inputs.lockdownTemplate != nil {
+		if err := h.checkHTMLTemplate(*inputs.lockdownTemplate, fmt.Sprintf("lockdown template file '%s'", h.lockdownTemplateFile)); err != nil {
+			return reloadInputs{}, err
+		}
+	}
+
+	if inputs.langTemplates, err = h.readLangTemplates(); err != nil {
+		return reloadInputs{}, err
+	}
+	for _, lang := range slices.Sorted(maps.Keys(h.TemplatesByLang)) {
+		inputs.sources = append(inputs.sources, h.TemplatesByLang[lang])
+	}
+	for lang, content := range inputs.langTemplates {
+		if err := h.checkHTMLTemplate(content, fmt.Sprintf("template for language '%s'", lang)); err != nil {
+			return reloadInputs{}, err
+		}
+	}
+	if inputs.protocolTemplates, err = h.readProtocolTemplates(); err != nil {
+		return reloadInputs{}, err
+	}
+	for _, protocol := range slices.Sorted(maps.Keys(h.ProtocolOverrides)) {
+		inputs.sources = append(inputs.sources, h.ProtocolOverrides[protocol])
+	}
+	for protocol, content := range inputs.protocolTemplates {
+		if err := h.checkHTMLTemplate(content, fmt.Sprintf("template for protocol '%s'", protocol)); err != nil {
+			return reloadInputs{}, err
+		}
+	}
+
+	if h.AllowedIPsFile != "" {
+		fileIPs, err := h.loadIPsFromFile(h.AllowedIPsFile)
+		if err != nil {
+			return reloadInputs{}, fmt.Errorf("failed to load IPs from file '%s': %v", h.AllowedIPsFile, err)
+		}
+		h.ipMux.RLock()
+		changed := !slices.Equal(fileIPs, h.allowedIPsFileEntries)
+		h.ipMux.RUnlock()
+		// An unchanged file keeps the allow-list in use, which may have
+		// been replaced through the admin API since
+		if changed {
+			inputs.allowedIPs = append(slices.Clone(h.configuredAllowedIPs), fileIPs...)
+			inputs.allowedIPsFile = fileIPs
+			if inputs.allowList, err = h.compileAllowList(inputs.allowedIPs); err != nil {
+				return reloadInputs{}, fmt.Errorf("invalid allowed IP list with file '%s': %v", h.AllowedIPsFile, err)
+			}
+		}
+		inputs.sources = append(inputs.sources, h.AllowedIPsFile)
+	}
+
+	if source, open := h.htpasswdSource(); open != nil {
+		reader, err := open()
+		if err != nil {
+			return reloadInputs{}, err
+		}
+		inputs.htpasswdEntries = make(map[string][]byte)
+		inputs.htpasswdExpiry = make(map[string]time.Time)
+		if _, err := h.loadHtpasswd(reader, inputs.htpasswdEntries, inputs.htpasswdExpiry); err != nil {
+			return reloadInputs{}, fmt.Errorf("failed to parse htpasswd '%s': %v", source, err)
+		}
+		inputs.sources = append(inputs.sources, source)
+	}
+
+	if inputs.pathCredentials, err = h.readPathCredentials(); err != nil {
+		return reloadInputs{}, err
+	}
+	for _, section := range h.PathAuth {
+		inputs.sources = append(inputs.sources, section.HtpasswdFile)
+	}
+
+	// Unlike at startup, a status file that cannot be used is an error
+	// rather than a reason to fall back to default_enabled
+	status, statusFile, invalid := h.readPersistedStatus()
+	if status == nil && len(invalid) > 0 {
+		return reloadInputs{}, fmt.Errorf("invalid status file '%s': %v", invalid[0].path, invalid[0].err)
+	}
+	if status != nil {
+		inputs.status = status
+		inputs.sources = append(inputs.sources, statusFile)
+	}
+
+	return inputs, nil
+}
+
+// applyReloadInputs replaces the inputs in use with validated ones
+func (h *MaintenanceHandler) applyReloadInputs(inputs reloadInputs) {
+	h.filesMux.Lock()
+	if inputs.htmlTemplate != nil {
+		h.HTMLTemplate = *inputs.htmlTemplate
+	}
+	if inputs.jsonTemplate != nil {
+		h.JSONTemplate = *inputs.jsonTemplate
+	}
+	if inputs.lockdownTemplate != nil {
+		h.LockdownTemplate = *inputs.lockdownTemplate
+	}
+	if inputs.langTemplates != nil {
+		h.langTemplates = inputs.langTemplates
+	}
+	if inputs.protocolTemplates != nil {
+		h.protocolTemplates = inputs.protocolTemplates
+	}
+	if inputs.htpasswdEntries != nil {
+		h.htpasswdEntries = inputs.htpasswdEntries
+		h.htpasswdExpiry = inputs.htpasswdExpiry
+	}
+	if inputs.pathCredentials != nil {
+		h.pathCredentials = inputs.pathCredentials
+	}
+	h.filesMux.Unlock()
+	// Drop the templates parsed from the replaced contents
+	h.templateCache.Clear()
+
+	if inputs.allowList != nil {
+		h.setAllowList(inputs.allowList)
+		h.ipMux.Lock()
+		h.AllowedIPs = inputs.allowedIPs
+		h.allowedIPsFileEntries = inputs.allowedIPsFile
+		h.ipMux.Unlock()
+		pruneBypassStats()
+	}
+
+	if inputs.status != nil {
+		h.applyPersistedStatus(*inputs.status)
+	}
+
+	if h.logger != nil {
+		h.logger.Info("Maintenance inputs reloaded", zap.Strings("sources", inputs.sources))
+	}
+}
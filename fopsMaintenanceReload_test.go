@@ -0,0 +1,296 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reloadForTest reloads the inputs through the admin endpoint
+func reloadForTest(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/maintenance/reload", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.reload).ServeHTTP(w, req))
+	return w
+}
+
+// reloadFixture is an instance whose inputs are all backed by files
+type reloadFixture struct {
+	handler          *MaintenanceHandler
+	templateFile     string
+	allowedIPsFile   string
+	htpasswdFile     string
+	statusFile       string
+	lockdownTemplate string
+}
+
+func newReloadFixture(t *testing.T, name string) reloadFixture {
+	t.Helper()
+	dir := t.TempDir()
+	fixture := reloadFixture{
+		templateFile:     filepath.Join(dir, "maintenance.html"),
+		allowedIPsFile:   filepath.Join(dir, "allowed_ips.txt"),
+		statusFile:       filepath.Join(dir, "status.json"),
+		lockdownTemplate: filepath.Join(dir, "lockdown.html"),
+	}
+	require.NoError(t, os.WriteFile(fixture.templateFile, []byte("<p>Old page</p>"), 0644))
+	require.NoError(t, os.WriteFile(fixture.lockdownTemplate, []byte("<p>Locked</p>"), 0644))
+	require.NoError(t, os.WriteFile(fixture.allowedIPsFile, []byte("192.0.2.10\n"), 0644))
+	require.NoError(t, os.WriteFile(fixture.statusFile, []byte(`{"enabled": false}`), 0644))
+	fixture.htpasswdFile = writeHtpasswdForTest(t, map[string]string{"alice": "alice-password"})
+
+	fixture.handler = &MaintenanceHandler{
+		Name:             name,
+		HTMLTemplate:     fixture.templateFile,
+		LockdownTemplate: fixture.lockdownTemplate,
+		AllowedIPs:       []string{"198.51.100.1"},
+		AllowedIPsFile:   fixture.allowedIPsFile,
+		HtpasswdFile:     fixture.htpasswdFile,
+		StatusFile:       fixture.statusFile,
+	}
+	require.NoError(t, fixture.handler.Provision(caddy.Context{}))
+
+	return fixture
+}
+
+func authenticatedForTest(h *MaintenanceHandler, username, password string) bool {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth(username, password)
+	return h.isAuthenticated(req)
+}
+
+func TestAdminHandler_Reload(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	fixture := newReloadFixture(t, "shop")
+	h := fixture.handler
+	setMaintenanceHandler(h)
+
+	require.NoError(t, os.WriteFile(fixture.templateFile, []byte("<p>New page</p>"), 0644))
+	require.NoError(t, os.WriteFile(fixture.allowedIPsFile, []byte("192.0.2.20\n"), 0644))
+	require.NoError(t, os.WriteFile(fixture.statusFile, []byte(`{"enabled": true}`), 0644))
+	newHtpasswd := writeHtpasswdForTest(t, map[string]string{"bob": "bob-password"})
+	content, err := os.ReadFile(newHtpasswd)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fixture.htpasswdFile, content, 0644))
+
+	w := reloadForTest(t)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response reloadResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Instances, 1)
+	assert.Equal(t, "shop", response.Instances[0].Name)
+	assert.True(t, response.Instances[0].Enabled)
+	assert.Equal(t, []string{fixture.templateFile, fixture.lockdownTemplate, fixture.allowedIPsFile, fixture.htpasswdFile, fixture.statusFile}, response.Instances[0].Sources)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	assert.Equal(t, "<p>New page</p>", h.selectHTMLTemplate(req))
+	assert.True(t, h.isIPAllowed("192.0.2.20"))
+	assert.True(t, h.isIPAllowed("198.51.100.1"))
+	assert.False(t, h.isIPAllowed("192.0.2.10"))
+	assert.Equal(t, []string{"198.51.100.1", "192.0.2.20"}, h.AllowedIPs)
+	assert.True(t, authenticatedForTest(h, "bob", "bob-password"))
+	assert.False(t, authenticatedForTest(h, "alice", "alice-password"))
+	assert.True(t, currentState(h).Enabled)
+}
+
+func TestAdminHandler_Reload_Rollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, fixture reloadFixture)
+		errText string
+	}{
+		{
+			name: "invalid allowed IP",
+			corrupt: func(t *testing.T, fixture reloadFixture) {
+				require.NoError(t, os.WriteFile(fixture.allowedIPsFile, []byte("192.0.2.300\n"), 0644))
+			},
+			errText: "allowed_ips.txt",
+		},
+		{
+			name: "missing template",
+			corrupt: func(t *testing.T, fixture reloadFixture) {
+				require.NoError(t, os.Remove(fixture.templateFile))
+			},
+			errText: "maintenance.html",
+		},
+		{
+			name: "invalid template",
+			corrupt: func(t *testing.T, fixture reloadFixture) {
+				fixture.handler.TemplateFailure = failureModeError
+				require.NoError(t, os.WriteFile(fixture.templateFile, []byte("{{.Unclosed"), 0644))
+			},
+			errText: "maintenance.html",
+		},
+		{
+			name: "invalid htpasswd",
+			corrupt: func(t *testing.T, fixture reloadFixture) {
+				require.NoError(t, os.WriteFile(fixture.htpasswdFile, []byte("not an htpasswd line\n"), 0644))
+			},
+			errText: "users.htpasswd",
+		},
+		{
+			name: "invalid status",
+			corrupt: func(t *testing.T, fixture reloadFixture) {
+				require.NoError(t, os.WriteFile(fixture.statusFile, []byte("{invalid"), 0644))
+			},
+			errText: "status.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetMaintenanceHandlersForTest(t)
+
+			// The first instance has valid new inputs, the second one fails
+			first := newReloadFixture(t, "shop")
+			second := newReloadFixture(t, "blog")
+			setMaintenanceHandler(first.handler)
+			registerMaintenanceHandler(second.handler)
+
+			for _, fixture := range []reloadFixture{first, second} {
+				require.NoError(t, os.WriteFile(fixture.templateFile, []byte("<p>New page</p>"), 0644))
+				require.NoError(t, os.WriteFile(fixture.allowedIPsFile, []byte("192.0.2.20\n"), 0644))
+				require.NoError(t, os.WriteFile(fixture.statusFile, []byte(`{"enabled": true}`), 0644))
+			}
+			tt.corrupt(t, second)
+
+			w := reloadForTest(t)
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			assert.Contains(t, w.Body.String(), "instance 'blog'")
+			assert.Contains(t, w.Body.String(), tt.errText)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			for _, fixture := range []reloadFixture{first, second} {
+				h := fixture.handler
+				assert.Equal(t, "<p>Old page</p>", h.selectHTMLTemplate(req))
+				assert.True(t, h.isIPAllowed("192.0.2.10"))
+				assert.False(t, h.isIPAllowed("192.0.2.20"))
+				assert.True(t, authenticatedForTest(h, "alice", "alice-password"))
+				assert.False(t, currentState(h).Enabled)
+			}
+		})
+	}
+}
+
+func TestAdminHandler_Reload_AdminAllowList(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	fixture := newReloadFixture(t, "shop")
+	h := fixture.handler
+	setMaintenanceHandler(h)
+	putAllowedIPs := func(target, body string) {
+		req := httptest.NewRequest(http.MethodPut, target, bytes.NewBufferString(body))
+		require.NoError(t, AdminHandler{}.allowedIPs(httptest.NewRecorder(), req))
+	}
+
+	// An allow-list pushed without persist survives a reload of the
+	// unchanged file
+	putAllowedIPs("/maintenance/allowed-ips", `["203.0.113.5"]`)
+	require.Equal(t, http.StatusOK, reloadForTest(t).Code)
+	assert.True(t, h.isIPAllowed("203.0.113.5"))
+	assert.False(t, h.isIPAllowed("192.0.2.10"))
+	assert.Equal(t, []string{"203.0.113.5"}, h.AllowedIPs)
+
+	// A persisted allow-list is not a change of the file either
+	putAllowedIPs("/maintenance/allowed-ips?persist=true", `["203.0.113.6"]`)
+	require.Equal(t, http.StatusOK, reloadForTest(t).Code)
+	assert.Equal(t, []string{"203.0.113.6"}, h.AllowedIPs)
+
+	// Editing the file replaces the allow-list
+	require.NoError(t, os.WriteFile(fixture.allowedIPsFile, []byte("192.0.2.20\n"), 0644))
+	require.Equal(t, http.StatusOK, reloadForTest(t).Code)
+	assert.True(t, h.isIPAllowed("192.0.2.20"))
+	assert.True(t, h.isIPAllowed("198.51.100.1"))
+	assert.False(t, h.isIPAllowed("203.0.113.6"))
+}
+
+func TestAdminHandler_Reload_TemplateOverridesAndPathAuth(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	dir := t.TempDir()
+	langFile := filepath.Join(dir, "fr.html")
+	protocolFile := filepath.Join(dir, "http1.html")
+	require.NoError(t, os.WriteFile(langFile, []byte("<p>Ancienne page</p>"), 0644))
+	require.NoError(t, os.WriteFile(protocolFile, []byte("Old plain page"), 0644))
+	teamFile := writeHtpasswdForTest(t, map[string]string{"alice": "alice-password"})
+
+	h := &MaintenanceHandler{
+		TemplatesByLang:   map[string]string{"fr": langFile},
+		ProtocolOverrides: map[string]string{"HTTP/1.0": protocolFile},
+		PathAuth:          []PathAuth{{PathPrefix: "/team-a", HtpasswdFile: teamFile}},
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	french := httptest.NewRequest("GET", "/", nil)
+	french.Header.Set("Accept-Language", "fr")
+	http10 := httptest.NewRequest("GET", "/", nil)
+	http10.Proto, http10.ProtoMajor, http10.ProtoMinor = "HTTP/1.0", 1, 0
+	teamAuthenticated := func(username, password string) bool {
+		req := httptest.NewRequest("GET", "/team-a/docs", nil)
+		req.SetBasicAuth(username, password)
+		return h.isAuthenticated(req)
+	}
+
+	require.NoError(t, os.WriteFile(langFile, []byte("<p>Nouvelle page</p>"), 0644))
+	require.NoError(t, os.WriteFile(protocolFile, []byte("New plain page"), 0644))
+	content, err := os.ReadFile(writeHtpasswdForTest(t, map[string]string{"bob": "bob-password"}))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(teamFile, content, 0644))
+
+	w := reloadForTest(t)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response reloadResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{langFile, protocolFile, teamFile}, response.Instances[0].Sources)
+
+	assert.Equal(t, "<p>Nouvelle page</p>", h.selectHTMLTemplate(french))
+	assert.Equal(t, "New plain page", h.selectHTMLTemplate(http10))
+	assert.True(t, teamAuthenticated("bob", "bob-password"))
+	assert.False(t, teamAuthenticated("alice", "alice-password"))
+
+	// An invalid path_auth file leaves every input unchanged
+	require.NoError(t, os.WriteFile(langFile, []byte("<p>Autre page</p>"), 0644))
+	require.NoError(t, os.WriteFile(teamFile, []byte("not an htpasswd line\n"), 0644))
+	w = reloadForTest(t)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "path_auth '/team-a'")
+	assert.Equal(t, "<p>Nouvelle page</p>", h.selectHTMLTemplate(french))
+	assert.True(t, teamAuthenticated("bob", "bob-password"))
+}
+
+func TestAdminHandler_Reload_Errors(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	w := reloadForTest(t)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/maintenance/reload", nil)
+	w = httptest.NewRecorder()
+	require.NoError(t, withJSONErrors(AdminHandler{}.reload).ServeHTTP(w, req))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestAdminHandler_Reload_NoFiles(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.0.2.10"}, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	setMaintenanceHandler(h)
+
+	w := reloadForTest(t)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"instances": [{"sources": [], "enabled": true}]}`, w.Body.String())
+	assert.True(t, h.isIPAllowed("192.0.2.10"))
+}
@@ -0,0 +1,179 @@
+package fopsMaintenance
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// defaultPreNoticeText is the banner text shown before a scheduled maintenance
+const defaultPreNoticeText = `Scheduled maintenance starts at {{.ScheduledStart.Format "15:04 MST"}}`
+
+// preNoticeBanner wraps the rendered pre-notice text injected into HTML pages
+const preNoticeBanner = `<div class="fops-maintenance-notice" role="status" style="padding:0.75em 1em;background:#fff3cd;color:#664d03;border-bottom:1px solid #ffecb5;font-family:sans-serif;text-align:center">%s</div>`
+
+// provisionSchedule parses the scheduled start and pre-notice settings and
+// arms the timer enabling maintenance at the scheduled start
+func (h *MaintenanceHandler) provisionSchedule() error {
+	h.scheduledStart = time.Time{}
+	h.preNoticeTemplate = nil
+
+	if h.ScheduledStart != "" {
+		scheduledStart, err := time.Parse(time.RFC3339, h.ScheduledStart)
+		if err != nil {
+			return fmt.Errorf("invalid scheduled_start '%s': %v", h.ScheduledStart, err)
+		}
+		h.scheduledStart = scheduledStart
+	}
+
+	if h.PreNotice < 0 {
+		return fmt.Errorf("pre_notice must not be negative")
+	}
+	if h.PreNotice > 0 {
+		if h.scheduledStart.IsZero() {
+			return fmt.Errorf("pre_notice requires a scheduled_start")
+		}
+
+		text := h.PreNoticeText
+		if text == "" {
+			text = defaultPreNoticeText
+		}
+		tmpl, err := template.New("pre_notice").Parse(text)
+		if err != nil {
+			return fmt.Errorf("failed to parse pre_notice_text: %v", err)
+		}
+		h.preNoticeTemplate = tmpl
+	}
+
+	h.startScheduleTimer()
+
+	return nil
+}
+
+// startScheduleTimer enables maintenance at the scheduled start. A start
+// already in the past is ignored, so that a reload does not re-enable a
+// maintenance that was ended in the meantime.
+func (h *MaintenanceHandler) startScheduleTimer() {
+	h.stopScheduleTimer()
+
+	scheduledStart := h.scheduledStart
+	if scheduledStart.IsZero() || !scheduledStart.After(time.Now()) {
+		return
+	}
+
+	h.enabledMux.Lock()
+	h.scheduleTimer = time.AfterFunc(time.Until(scheduledStart), func() {
+		h.startScheduled(scheduledStart)
+	})
+	h.enabledMux.Unlock()
+}
+
+// startScheduled enables maintenance once the scheduled start is reached
+func (h *MaintenanceHandler) startScheduled(scheduledStart time.Time) {
+	h.enabledMux.Lock()
+	h.scheduleTimer = nil
+	if h.enabled {
+		h.enabledMux.Unlock()
+		return
+	}
+	h.setEnabledLocked(true, scheduledStart)
+	startedAt, expiresAt := h.startedAt, h.expiresAt
+	h.enabledMux.Unlock()
+
+	if h.logger != nil {
+		h.logger.Info("Scheduled maintenance started, maintenance mode enabled", zap.Time("scheduled_start", scheduledStart))
+	}
+	if err := persistEnabledStatus([]*MaintenanceHandler{h}, true, startedAt, expiresAt); err != nil && h.logger != nil {
+		h.logger.Error("Failed to persist maintenance status after scheduled start", zap.Error(err))
+	}
+}
+
+// stopScheduleTimer cancels a pending scheduled start
+func (h *MaintenanceHandler) stopScheduleTimer() {
+	h.enabledMux.Lock()
+	defer h.enabledMux.Unlock()
+
+	if h.scheduleTimer != nil {
+		h.scheduleTimer.Stop()
+		h.scheduleTimer = nil
+	}
+}
+
+// inPreNotice reports whether now falls in the pre-notice window preceding
+// the scheduled start
+func (h *MaintenanceHandler) inPreNotice(now time.Time) bool {
+	if h.preNoticeTemplate == nil || !now.Before(h.scheduledStart) {
+		return false
+	}
+
+	return !now.Before(h.scheduledStart.Add(-time.Duration(h.PreNotice)))
+}
+
+// serveWithPreNotice forwards the request and injects the pre-notice banner
+// into HTML responses. Other responses, and compressed HTML which cannot be
+// rewritten, are streamed untouched.
+func (h *MaintenanceHandler) serveWithPreNotice(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	buf := new(bytes.Buffer)
+	shouldBuffer := func(status int, header http.Header) bool {
+		if header.Get("Content-Encoding") != "" {
+			return false
+		}
+		mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+		return err == nil && mediaType == "text/html"
+	}
+	rec := caddyhttp.NewResponseRecorder(w, buf, shouldBuffer)
+
+	if err := next.ServeHTTP(rec, r); err != nil {
+		return err
+	}
+	if !rec.Buffered() {
+		return nil
+	}
+
+	body, err := h.injectPreNotice(buf.Bytes())
+	if err != nil {
+		if h.logger != nil {
+			h.logger.Warn("Failed to render pre-notice banner", zap.Error(err))
+		}
+		body = buf.Bytes()
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(rec.Status())
+	_, err = w.Write(body)
+
+	return err
+}
+
+// injectPreNotice inserts the rendered banner right after the opening body
+// tag. A document without a body tag is returned unchanged.
+func (h *MaintenanceHandler) injectPreNotice(body []byte) ([]byte, error) {
+	start := bytes.Index(bytes.ToLower(body), []byte("<body"))
+	if start < 0 {
+		return body, nil
+	}
+	end := bytes.IndexByte(body[start:], '>')
+	if end < 0 {
+		return body, nil
+	}
+	insertAt := start + end + 1
+
+	var text bytes.Buffer
+	if err := h.preNoticeTemplate.Execute(&text, h.templateData()); err != nil {
+		return nil, err
+	}
+
+	banner := fmt.Sprintf(preNoticeBanner, text.String())
+	result := make([]byte, 0, len(body)+len(banner))
+	result = append(result, body[:insertAt]...)
+	result = append(result, banner...)
+
+	return append(result, body[insertAt:]...), nil
+}
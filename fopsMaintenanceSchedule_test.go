@@ -0,0 +1,271 @@
+package fopsMaintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func htmlBackend(body string) caddyhttp.Handler {
+	return caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err := w.Write([]byte(body))
+		return err
+	})
+}
+
+func TestMaintenanceHandler_PreNotice(t *testing.T) {
+	scheduledStart := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	h := &MaintenanceHandler{
+		ScheduledStart: scheduledStart.Format(time.RFC3339),
+		PreNotice:      caddy.Duration(30 * time.Minute),
+		PreNoticeText:  `Maintenance at {{.ScheduledStart.Format "15:04"}} <strong>tonight</strong>`,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	t.Run("banner is injected within the window", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, htmlBackend("<html><BODY class=\"app\"><p>shop</p></BODY></html>")))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		expected := `<BODY class="app"><div class="fops-maintenance-notice"`
+		assert.Contains(t, w.Body.String(), expected)
+		assert.Contains(t, w.Body.String(), "Maintenance at "+scheduledStart.Format("15:04")+" <strong>tonight</strong></div><p>shop</p>")
+		assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+	})
+
+	t.Run("non-HTML responses are untouched", func(t *testing.T) {
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(`{"body":"<body>"}`))
+			return err
+		})
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, `{"body":"<body>"}`, w.Body.String())
+	})
+
+	t.Run("compressed HTML is untouched", func(t *testing.T) {
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, err := w.Write([]byte("<body>compressed"))
+			return err
+		})
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, "<body>compressed", w.Body.String())
+	})
+
+	t.Run("window boundaries", func(t *testing.T) {
+		assert.False(t, h.inPreNotice(scheduledStart.Add(-31*time.Minute)))
+		assert.True(t, h.inPreNotice(scheduledStart.Add(-30*time.Minute)))
+		assert.True(t, h.inPreNotice(scheduledStart.Add(-time.Second)))
+		assert.False(t, h.inPreNotice(scheduledStart))
+	})
+}
+
+func TestMaintenanceHandler_PreNotice_OutsideWindow(t *testing.T) {
+	h := &MaintenanceHandler{
+		ScheduledStart: time.Now().Add(2 * time.Hour).Format(time.RFC3339),
+		PreNotice:      caddy.Duration(30 * time.Minute),
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, req, htmlBackend("<body>shop</body>")))
+	assert.Equal(t, "<body>shop</body>", w.Body.String())
+}
+
+func TestMaintenanceHandler_ScheduledStart(t *testing.T) {
+	scheduledStart := time.Now().Add(50 * time.Millisecond)
+	h := &MaintenanceHandler{ScheduledStart: scheduledStart.Format(time.RFC3339Nano)}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	assert.False(t, currentState(h).Enabled)
+	assert.Eventually(t, func() bool { return currentState(h).Enabled }, time.Second, 5*time.Millisecond)
+	assert.True(t, h.templateData().StartedAt.Equal(scheduledStart))
+
+	// A start in the past does not enable maintenance
+	h = &MaintenanceHandler{ScheduledStart: time.Now().Add(-time.Minute).Format(time.RFC3339)}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, currentState(h).Enabled)
+
+	// Cleanup cancels a pending start
+	h = &MaintenanceHandler{ScheduledStart: time.Now().Add(20 * time.Millisecond).Format(time.RFC3339Nano)}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	require.NoError(t, h.Cleanup())
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, currentState(h).Enabled)
+}
+
+func TestMaintenanceHandler_Schedule_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  *MaintenanceHandler
+		expected string
+	}{
+		{
+			name:     "invalid scheduled start",
+			handler:  &MaintenanceHandler{ScheduledStart: "tomorrow"},
+			expected: "invalid scheduled_start 'tomorrow'",
+		},
+		{
+			name:     "pre-notice without scheduled start",
+			handler:  &MaintenanceHandler{PreNotice: caddy.Duration(time.Minute)},
+			expected: "pre_notice requires a scheduled_start",
+		},
+		{
+			name: "invalid pre-notice text",
+			handler: &MaintenanceHandler{
+				ScheduledStart: time.Now().Add(time.Hour).Format(time.RFC3339),
+				PreNotice:      caddy.Duration(time.Minute),
+				PreNoticeText:  "{{.Unclosed",
+			},
+			expected: "failed to parse pre_notice_text",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Provision(caddy.Context{})
+			t.Cleanup(func() { _ = tt.handler.Cleanup() })
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestMaintenanceHandler_Timezone(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	scheduledStart := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	h := &MaintenanceHandler{
+		Timezone:       "Europe/Paris",
+		ScheduledStart: scheduledStart.Format(time.RFC3339),
+		EstimatedEnd:   "2026-07-01T12:00:00Z",
+		PreNotice:      caddy.Duration(30 * time.Minute),
+		BusinessHours:  &BusinessHours{Ranges: []string{"mon-fri 09:00-18:00"}},
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	t.Run("pre-notice banner", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, htmlBackend("<body>shop</body>")))
+		assert.Contains(t, w.Body.String(), "Scheduled maintenance starts at "+scheduledStart.In(paris).Format("15:04 MST"))
+	})
+
+	t.Run("template variables", func(t *testing.T) {
+		data := h.templateData()
+		assert.Equal(t, paris, data.ScheduledStart.Location())
+		assert.Equal(t, "2026-07-01T14:00:00+02:00", data.EstimatedEnd.Format(time.RFC3339))
+	})
+
+	t.Run("JSON response", func(t *testing.T) {
+		h.enabledMux.Lock()
+		h.setEnabledLocked(true, time.Date(2026, 1, 14, 8, 0, 0, 0, time.UTC))
+		h.enabledMux.Unlock()
+		t.Cleanup(func() {
+			h.enabledMux.Lock()
+			h.setEnabledLocked(false, time.Time{})
+			h.enabledMux.Unlock()
+		})
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, htmlBackend("<body>shop</body>")))
+		assert.Contains(t, w.Body.String(), `"started_at":"2026-01-14T09:00:00+01:00"`)
+		assert.Contains(t, w.Body.String(), `"estimated_end":"2026-07-01T14:00:00+02:00"`)
+	})
+
+	t.Run("business hours default to the handler timezone", func(t *testing.T) {
+		// 08:30 UTC is 09:30 in Paris in winter
+		assert.True(t, h.inBusinessHours(time.Date(2026, 1, 14, 8, 30, 0, 0, time.UTC)))
+		assert.False(t, h.inBusinessHours(time.Date(2026, 1, 14, 17, 30, 0, 0, time.UTC)))
+
+		own := &MaintenanceHandler{Timezone: "Europe/Paris", BusinessHours: &BusinessHours{Timezone: "UTC", Ranges: []string{"mon-fri 09:00-18:00"}}}
+		require.NoError(t, own.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = own.Cleanup() })
+		assert.False(t, own.inBusinessHours(time.Date(2026, 1, 14, 8, 30, 0, 0, time.UTC)))
+	})
+
+	t.Run("UTC by default", func(t *testing.T) {
+		utc := &MaintenanceHandler{EstimatedEnd: "2026-07-01T14:00:00+02:00"}
+		require.NoError(t, utc.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = utc.Cleanup() })
+		assert.Equal(t, "2026-07-01T12:00:00Z", utc.templateData().EstimatedEnd.Format(time.RFC3339))
+	})
+
+	t.Run("invalid timezone", func(t *testing.T) {
+		invalid := &MaintenanceHandler{Timezone: "Europe/Atlantis"}
+		err := invalid.Provision(caddy.Context{})
+		t.Cleanup(func() { _ = invalid.Cleanup() })
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid timezone 'Europe/Atlantis'")
+	})
+}
+
+func TestInjectPreNotice_NoBodyTag(t *testing.T) {
+	h := &MaintenanceHandler{
+		ScheduledStart: time.Now().Add(time.Hour).Format(time.RFC3339),
+		PreNotice:      caddy.Duration(time.Minute),
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	body, err := h.injectPreNotice([]byte("<p>fragment</p>"))
+	require.NoError(t, err)
+	assert.Equal(t, "<p>fragment</p>", string(body))
+}
+
+func TestParseCaddyfile_Schedule(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		timezone Europe/Paris
+		scheduled_start 2026-03-02T14:00:00Z
+		pre_notice 30m
+		pre_notice_text "Maintenance tonight"
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "Europe/Paris", actualHandler.Timezone)
+	assert.Equal(t, "2026-03-02T14:00:00Z", actualHandler.ScheduledStart)
+	assert.Equal(t, caddy.Duration(30*time.Minute), actualHandler.PreNotice)
+	assert.Equal(t, "Maintenance tonight", actualHandler.PreNoticeText)
+
+	for _, input := range []string{
+		"maintenance {\n\ttimezone\n}",
+		"maintenance {\n\tscheduled_start tomorrow\n}",
+		"maintenance {\n\tpre_notice 0s\n}",
+		"maintenance {\n\tpre_notice soon\n}",
+		"maintenance {\n\tpre_notice_text\n}",
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(input)})
+		assert.Error(t, err, input)
+	}
+}
@@ -0,0 +1,103 @@
+package fopsMaintenance
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// schemeBypass is a compiled bypass_insecure_only or bypass_secure_only list
+type schemeBypass struct {
+	networks []*net.IPNet
+	// entries are the configured entries of networks, in the same order
+	entries []string
+	paths   []string
+}
+
+// compileSchemeBypass parses the entries of a per-scheme bypass list: paths
+// start with "/", the other entries are IPs or CIDR ranges
+func compileSchemeBypass(option string, entries []string) (schemeBypass, error) {
+	var bypass schemeBypass
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case strings.HasPrefix(entry, "/"):
+			// r.URL.Path is already decoded, decode configured paths the same way
+			decoded, err := url.PathUnescape(entry)
+			if err != nil {
+				return schemeBypass{}, fmt.Errorf("invalid %s path '%s': %v", option, entry, err)
+			}
+			bypass.paths = append(bypass.paths, decoded)
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return schemeBypass{}, fmt.Errorf("invalid %s CIDR notation '%s': %v", option, entry, err)
+			}
+			bypass.networks = append(bypass.networks, ipNet)
+			bypass.entries = append(bypass.entries, entry)
+		default:
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return schemeBypass{}, fmt.Errorf("invalid %s entry '%s', expected a path, an IP or a CIDR range", option, entry)
+			}
+			bypass.networks = append(bypass.networks, singleIPNetwork(ip))
+			bypass.entries = append(bypass.entries, entry)
+		}
+	}
+
+	return bypass, nil
+}
+
+// provisionSchemeBypass compiles bypass_insecure_only and bypass_secure_only
+func (h *MaintenanceHandler) provisionSchemeBypass() error {
+	var err error
+	if h.insecureBypass, err = compileSchemeBypass("bypass_insecure_only", h.BypassInsecureOnly); err != nil {
+		return err
+	}
+	if h.secureBypass, err = compileSchemeBypass("bypass_secure_only", h.BypassSecureOnly); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// schemeBypassFor returns the per-scheme bypass list applying to the
+// request, depending on whether it came over TLS
+func (h *MaintenanceHandler) schemeBypassFor(r *http.Request) schemeBypass {
+	if r.TLS == nil {
+		return h.insecureBypass
+	}
+
+	return h.secureBypass
+}
+
+// isSchemePathBypassed reports whether target matches a path bypassing
+// maintenance for the scheme of the request
+func (h *MaintenanceHandler) isSchemePathBypassed(r *http.Request, target string) bool {
+	return matchPaths(target, h.schemeBypassFor(r).paths)
+}
+
+// schemeAllowedEntry returns the entry letting clientIP bypass maintenance
+// for the scheme of the request, reporting whether there is one
+func (h *MaintenanceHandler) schemeAllowedEntry(r *http.Request, clientIP string) (string, bool) {
+	bypass := h.schemeBypassFor(r)
+	if len(bypass.networks) == 0 {
+		return "", false
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return "", false
+	}
+	for i, network := range bypass.networks {
+		if network.Contains(ip) {
+			return bypass.entries[i], true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,105 @@
+package fopsMaintenance
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceHandler_SchemeBypass(t *testing.T) {
+	h := &MaintenanceHandler{
+		BypassInsecureOnly: []string{"10.0.0.0/8", "/healthz"},
+		BypassSecureOnly:   []string{"203.0.113.5", "/api/status%20page"},
+		BypassHeader:       "X-Maintenance-Bypass",
+		DefaultEnabled:     true,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	tests := []struct {
+		name       string
+		secure     bool
+		remoteIP   string
+		path       string
+		wantStatus int
+		wantReason string
+	}{
+		{name: "insecure IP over http", remoteIP: "10.1.2.3", path: "/", wantStatus: http.StatusOK, wantReason: "ip"},
+		{name: "insecure IP over https", secure: true, remoteIP: "10.1.2.3", path: "/", wantStatus: http.StatusServiceUnavailable},
+		{name: "insecure path over http", remoteIP: "198.51.100.1", path: "/healthz", wantStatus: http.StatusOK, wantReason: "path"},
+		{name: "insecure path over https", secure: true, remoteIP: "198.51.100.1", path: "/healthz", wantStatus: http.StatusServiceUnavailable},
+		{name: "secure IP over https", secure: true, remoteIP: "203.0.113.5", path: "/", wantStatus: http.StatusOK, wantReason: "ip"},
+		{name: "secure IP over http", remoteIP: "203.0.113.5", path: "/", wantStatus: http.StatusServiceUnavailable},
+		{name: "secure path over https", secure: true, remoteIP: "198.51.100.1", path: "/api/status%20page", wantStatus: http.StatusOK, wantReason: "path"},
+		{name: "secure path over http", remoteIP: "198.51.100.1", path: "/api/status%20page", wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com"+tt.path, nil)
+			req.RemoteAddr = tt.remoteIP + ":51234"
+			if tt.secure {
+				req.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantReason, w.Header().Get("X-Maintenance-Bypass"))
+		})
+	}
+}
+
+func TestMaintenanceHandler_SchemeBypass_InvalidEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *MaintenanceHandler
+		errText string
+	}{
+		{name: "hostname", handler: &MaintenanceHandler{BypassInsecureOnly: []string{"probe.internal"}}, errText: "invalid bypass_insecure_only entry 'probe.internal'"},
+		{name: "CIDR", handler: &MaintenanceHandler{BypassSecureOnly: []string{"10.0.0.0/33"}}, errText: "invalid bypass_secure_only CIDR notation"},
+		{name: "path", handler: &MaintenanceHandler{BypassSecureOnly: []string{"/bad%zz"}}, errText: "invalid bypass_secure_only path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Provision(caddy.Context{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errText)
+		})
+	}
+}
+
+func TestParseCaddyfile_SchemeBypass(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		bypass_insecure_only 10.0.0.0/8 /healthz
+		bypass_insecure_only /readyz
+		bypass_secure_only 203.0.113.5
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, []string{"10.0.0.0/8", "/healthz", "/readyz"}, actualHandler.BypassInsecureOnly)
+	assert.Equal(t, []string{"203.0.113.5"}, actualHandler.BypassSecureOnly)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		bypass_secure_only
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+}
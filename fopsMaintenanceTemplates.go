@@ -0,0 +1,205 @@
+package fopsMaintenance
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.html
+var builtinTemplatesFS embed.FS
+
+// builtinTemplates holds the templates compiled into the binary, keyed by name
+var builtinTemplates = loadBuiltinTemplates()
+
+// defaultHTMLTemplate is the page served when no template is configured
+var defaultHTMLTemplate = builtinTemplates[defaultTemplateName]
+
+const defaultTemplateName = "default"
+
+// defaultLockdownTemplate is the page served in lockdown mode when no
+// lockdown_template is configured
+//
+//go:embed templates/lockdown/default.html
+var defaultLockdownTemplate string
+
+// loadBuiltinTemplates reads the embedded templates
+func loadBuiltinTemplates() map[string]string {
+	entries, err := builtinTemplatesFS.ReadDir("templates")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read embedded templates: %v", err))
+	}
+
+	templates := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		content, err := builtinTemplatesFS.ReadFile(path.Join("templates", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("failed to read embedded template '%s': %v", entry.Name(), err))
+		}
+		templates[strings.TrimSuffix(entry.Name(), ".html")] = string(content)
+	}
+
+	return templates
+}
+
+// builtinTemplate returns the content of an embedded template by name
+func builtinTemplate(name string) (string, error) {
+	content, ok := builtinTemplates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown default_template '%s', available templates: %s", name, strings.Join(builtinTemplateNames(), ", "))
+	}
+
+	return content, nil
+}
+
+// builtinTemplateNames returns the sorted names of the embedded templates
+func builtinTemplateNames() []string {
+	names := make([]string, 0, len(builtinTemplates))
+	for name := range builtinTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// defaultTemplateFetchTimeout bounds fetching template_url
+const defaultTemplateFetchTimeout = 10 * time.Second
+
+// maxTemplateSize caps the size of a template fetched from template_url
+const maxTemplateSize = 1 << 20
+
+// startTemplateFetch fetches the template from TemplateURL in the background,
+// so that a slow server never delays provisioning. The cached copy, or else
+// the built-in page, is served until the fetch succeeds.
+func (h *MaintenanceHandler) startTemplateFetch() {
+	h.stopTemplateFetch()
+
+	if h.TemplateCacheFile != "" {
+		cached, err := os.ReadFile(h.TemplateCacheFile)
+		if err == nil {
+			err = h.checkHTMLTemplate(string(cached), "template_cache_file")
+		}
+		if err == nil {
+			h.setFetchedTemplate(string(cached))
+		} else if h.logger != nil {
+			h.logger.Warn("No usable cached copy of the template", zap.String("file", h.TemplateCacheFile), zap.Error(err))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	h.templateFetchCancel = cancel
+	h.templateFetchDone = done
+
+	go func() {
+		defer close(done)
+		h.fetchTemplate(ctx)
+	}()
+}
+
+// stopTemplateFetch cancels a pending fetch and waits for it to exit
+func (h *MaintenanceHandler) stopTemplateFetch() {
+	if h.templateFetchCancel == nil {
+		return
+	}
+	h.templateFetchCancel()
+	<-h.templateFetchDone
+	h.templateFetchCancel = nil
+	h.templateFetchDone = nil
+}
+
+// fetchTemplate downloads the template from TemplateURL, refreshing the
+// cache file on success. A failure is logged and leaves the page in use.
+func (h *MaintenanceHandler) fetchTemplate(ctx context.Context) {
+	content, err := h.downloadTemplate(ctx)
+	if err == nil {
+		err = h.checkHTMLTemplate(content, "template_url")
+	}
+	if err != nil {
+		if ctx.Err() == nil && h.logger != nil {
+			h.logger.Error("Failed to fetch template, serving the cached copy or the built-in page",
+				zap.String("url", h.TemplateURL),
+				zap.String("file", h.TemplateCacheFile),
+				zap.Error(err),
+			)
+		}
+		return
+	}
+
+	if h.TemplateCacheFile != "" {
+		if err := os.WriteFile(h.TemplateCacheFile, []byte(content), 0644); err != nil && h.logger != nil {
+			h.logger.Warn("Failed to write template cache", zap.String("file", h.TemplateCacheFile), zap.Error(err))
+		}
+	}
+	h.setFetchedTemplate(content)
+}
+
+// setFetchedTemplate replaces the template served for template_url
+func (h *MaintenanceHandler) setFetchedTemplate(content string) {
+	h.filesMux.Lock()
+	defer h.filesMux.Unlock()
+	h.fetchedTemplate = content
+}
+
+// parseTemplateURL accepts only http(s) URLs
+func parseTemplateURL(rawURL string) (*url.URL, error) {
+	templateURL, err := url.Parse(rawURL)
+	if err != nil || (templateURL.Scheme != "http" && templateURL.Scheme != "https") || templateURL.Host == "" {
+		return nil, fmt.Errorf("invalid template_url '%s': expected an http(s) URL", rawURL)
+	}
+
+	return templateURL, nil
+}
+
+// downloadTemplate fetches TemplateURL, accepting only HTML responses
+func (h *MaintenanceHandler) downloadTemplate(ctx context.Context) (string, error) {
+	templateURL, err := parseTemplateURL(h.TemplateURL)
+	if err != nil {
+		return "", err
+	}
+
+	timeout := time.Duration(h.TemplateFetchTimeout)
+	if timeout <= 0 {
+		timeout = defaultTemplateFetchTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, templateURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch template: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch template: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch template: unexpected status %d", resp.StatusCode)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != "text/html" {
+		return "", fmt.Errorf("failed to fetch template: unexpected content type '%s'", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTemplateSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch template: %v", err)
+	}
+	if len(body) > maxTemplateSize {
+		return "", fmt.Errorf("failed to fetch template: larger than %d bytes", maxTemplateSize)
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,124 @@
+package fopsMaintenance
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// upstreamErrorWriter holds back the response of the next handlers until
+// its status is known. Responses with one of the configured statuses are
+// discarded so that the maintenance page can be served in their place,
+// others are written through.
+type upstreamErrorWriter struct {
+	w        http.ResponseWriter
+	header   http.Header
+	statuses []int
+
+	wroteHeader bool
+	// status of the discarded response, zero when written through
+	intercepted int
+}
+
+func newUpstreamErrorWriter(w http.ResponseWriter, statuses []int) *upstreamErrorWriter {
+	return &upstreamErrorWriter{
+		w: w,
+		// Headers of earlier handlers are kept, those of a discarded
+		// response never reach the client
+		header:   w.Header().Clone(),
+		statuses: statuses,
+	}
+}
+
+func (u *upstreamErrorWriter) Header() http.Header {
+	// Once written through, e.g. for trailers
+	if u.wroteHeader && u.intercepted == 0 {
+		return u.w.Header()
+	}
+
+	return u.header
+}
+
+func (u *upstreamErrorWriter) WriteHeader(status int) {
+	if u.wroteHeader {
+		return
+	}
+	// Informational responses are sent through, the final status follows
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		maps.Copy(u.w.Header(), u.header)
+		u.w.WriteHeader(status)
+		return
+	}
+
+	u.wroteHeader = true
+	if slices.Contains(u.statuses, status) {
+		u.intercepted = status
+		return
+	}
+
+	dst := u.w.Header()
+	clear(dst)
+	maps.Copy(dst, u.header)
+	u.w.WriteHeader(status)
+}
+
+func (u *upstreamErrorWriter) Write(b []byte) (int, error) {
+	if !u.wroteHeader {
+		u.WriteHeader(http.StatusOK)
+	}
+	if u.intercepted != 0 {
+		return len(b), nil
+	}
+
+	return u.w.Write(b)
+}
+
+// Flush sends the buffered response unless it is discarded
+func (u *upstreamErrorWriter) Flush() {
+	if !u.wroteHeader {
+		u.WriteHeader(http.StatusOK)
+	}
+	if u.intercepted != 0 {
+		return
+	}
+
+	_ = http.NewResponseController(u.w).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// hijack the connection
+func (u *upstreamErrorWriter) Unwrap() http.ResponseWriter {
+	return u.w
+}
+
+// serveWithUpstreamErrorPage forwards a request while maintenance is
+// disabled, serving the maintenance page in place of responses and errors of
+// the next handlers with one of the upstream_error_statuses, such as a 502
+// when reverse_proxy cannot reach the backend
+func (h *MaintenanceHandler) serveWithUpstreamErrorPage(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	uw := newUpstreamErrorWriter(w, h.UpstreamErrorStatuses)
+	err := h.serveDisabled(uw, r, next)
+
+	status := uw.intercepted
+	var handlerErr caddyhttp.HandlerError
+	if status == 0 && err != nil && !uw.wroteHeader && errors.As(err, &handlerErr) && slices.Contains(h.UpstreamErrorStatuses, handlerErr.StatusCode) {
+		status = handlerErr.StatusCode
+	}
+	if status == 0 {
+		return err
+	}
+
+	if h.logger != nil {
+		h.logger.Debug("Serving maintenance page in place of upstream error",
+			zap.String("path", r.URL.Path),
+			zap.Int("upstream_status", status),
+			zap.Error(err),
+		)
+	}
+
+	return writeMaintenancePage(r, w, h, http.StatusServiceUnavailable)
+}
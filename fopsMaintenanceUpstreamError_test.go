@@ -0,0 +1,149 @@
+package fopsMaintenance
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceHandler_UpstreamErrorStatuses(t *testing.T) {
+	h := &MaintenanceHandler{
+		UpstreamErrorStatuses: []int{502, 504},
+		DefaultTemplate:       "branded",
+		Lockdown:              true,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	require.NoError(t, h.Validate())
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	serve := func(next caddyhttp.Handler) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest("GET", "http://example.com/shop", nil)
+		w := httptest.NewRecorder()
+		w.Header().Set("Alt-Svc", `h3=":443"`)
+		return w, h.ServeHTTP(w, req, next)
+	}
+
+	t.Run("written 502 is replaced", func(t *testing.T) {
+		w, err := serve(caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Backend", "app-1")
+			w.WriteHeader(http.StatusBadGateway)
+			_, err := w.Write([]byte("raw upstream error"))
+			return err
+		}))
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), `class="banner"`)
+		assert.NotContains(t, w.Body.String(), "raw upstream error")
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "300", w.Header().Get("Retry-After"))
+		assert.Empty(t, w.Header().Get("X-Backend"))
+		assert.Equal(t, `h3=":443"`, w.Header().Get("Alt-Svc"))
+	})
+
+	t.Run("returned 502 is replaced", func(t *testing.T) {
+		w, err := serve(caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("dial tcp 127.0.0.1:8080: connection refused"))
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), `class="banner"`)
+	})
+
+	t.Run("other statuses pass through", func(t *testing.T) {
+		w, err := serve(caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("X-Backend", "app-1")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, err := w.Write([]byte("raw upstream error"))
+			return err
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "raw upstream error", w.Body.String())
+		assert.Equal(t, "app-1", w.Header().Get("X-Backend"))
+
+		w, err = serve(caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			_, err := w.Write([]byte("shop"))
+			return err
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "shop", w.Body.String())
+
+		_, err = serve(caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return caddyhttp.Error(http.StatusServiceUnavailable, fmt.Errorf("no upstreams available"))
+		}))
+		require.Error(t, err)
+	})
+
+	t.Run("errors after the response was written are returned", func(t *testing.T) {
+		w, err := serve(caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusOK)
+			return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("upstream went away"))
+		}))
+		require.Error(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("flushed responses are written through", func(t *testing.T) {
+		w, err := serve(caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			_, err := w.Write([]byte("streamed"))
+			require.NoError(t, http.NewResponseController(w).Flush())
+			return err
+		}))
+		require.NoError(t, err)
+		assert.True(t, w.Flushed)
+		assert.Equal(t, "streamed", w.Body.String())
+	})
+}
+
+func TestMaintenanceHandler_UpstreamErrorStatuses_NoAuthPrompt(t *testing.T) {
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(htpasswd, []byte("admin:$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi\n"), 0644))
+
+	h := &MaintenanceHandler{UpstreamErrorStatuses: []int{502}, HtpasswdFile: htpasswd}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, req, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("connection refused"))
+	})))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestMaintenanceHandler_Validate_UpstreamErrorStatuses(t *testing.T) {
+	assert.NoError(t, (&MaintenanceHandler{UpstreamErrorStatuses: []int{500, 502, 599}}).Validate())
+
+	err := (&MaintenanceHandler{UpstreamErrorStatuses: []int{502, 404}}).Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid upstream_error_statuses 404, expected a 5xx status")
+}
+
+func TestParseCaddyfile_UpstreamErrorStatuses(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		upstream_error_statuses 502 503
+		upstream_error_statuses 504
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, []int{502, 503, 504}, h.UpstreamErrorStatuses)
+
+	for _, input := range []string{
+		"maintenance {\n\tupstream_error_statuses\n}",
+		"maintenance {\n\tupstream_error_statuses 502 bad\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
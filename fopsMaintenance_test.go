@@ -3,23 +3,40 @@ package fopsMaintenance
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	_ "github.com/caddyserver/caddy/v2/modules/caddyhttp/caddyauth"
+	_ "github.com/caddyserver/caddy/v2/modules/caddyhttp/headers"
+	_ "github.com/caddyserver/caddy/v2/modules/caddyhttp/rewrite"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestMaintenanceHandler(t *testing.T) {
@@ -768,8 +785,7 @@ func TestParseAllowedIPsMultipleCalls(t *testing.T) {
 	require.NoError(t, err)
 
 	// Verify first call populated slices correctly
-	assert.Equal(t, 1, len(h.allowedIndividualIPs), "Should have 1 individual IP")
-	assert.Equal(t, 1, len(h.allowedNetworks), "Should have 1 network")
+	assert.Equal(t, 2, len(h.allowRules), "Should have 1 individual IP and 1 network")
 
 	// Second call with different IPs
 	h.AllowedIPs = []string{"10.0.0.1", "10.0.1.0/24"}
@@ -777,11 +793,10 @@ func TestParseAllowedIPsMultipleCalls(t *testing.T) {
 	require.NoError(t, err)
 
 	// Verify that slices were reset and contain new values
-	assert.Equal(t, 1, len(h.allowedIndividualIPs), "Should have 1 individual IP after reset")
-	assert.Equal(t, 1, len(h.allowedNetworks), "Should have 1 network after reset")
+	assert.Equal(t, 2, len(h.allowRules), "Should have 1 individual IP and 1 network after reset")
 
 	// Verify the content is from the second call, not accumulated
-	assert.Equal(t, "10.0.0.1", h.allowedIndividualIPs[0].String(), "Should contain IP from second call")
+	assert.Equal(t, "10.0.0.1/32", h.allowRules[0].networks[0].String(), "Should contain IP from second call")
 }
 
 // TestIsIPAllowedDirect tests the IP checking functionality directly
@@ -1174,6 +1189,108 @@ func TestMaintenanceHandlerRequestRetentionModeWithDisable(t *testing.T) {
 	// Verify that the request was processed by the next handler
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "request-processed", w.Header().Get("X-Test"))
+
+	// The released request tells how long it was held
+	held, err := strconv.ParseFloat(w.Header().Get(heldHeader), 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, held, 2.0)
+}
+
+func TestMaintenanceHandlerRequestRetentionModeHeldHeader(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &MaintenanceHandler{
+		HTMLTemplate:                defaultHTMLTemplate,
+		RequestRetentionModeTimeout: 1,
+		ctx:                         ctx,
+		enabled:                     true,
+	}
+
+	w := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil), nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	held, err := strconv.ParseFloat(w.Header().Get(heldHeader), 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, held, 1.0)
+	assert.Less(t, held, 2.0)
+
+	// Requests answered without being held do not get the header
+	h.RequestRetentionModeTimeout = 0
+	w = httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil), nil))
+	assert.Empty(t, w.Header().Get(heldHeader))
+}
+
+func TestMaintenanceHandlerRequestRetentionModeClientDisconnect(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancel       func(cancelRequest, cancelServer context.CancelFunc)
+		expectedLog  string
+		expectedBody bool
+	}{
+		{
+			name:        "client disconnect writes nothing",
+			cancel:      func(cancelRequest, _ context.CancelFunc) { cancelRequest() },
+			expectedLog: "Client disconnected during request retention",
+		},
+		{
+			name:         "server shutdown serves the maintenance page",
+			cancel:       func(_, cancelServer context.CancelFunc) { cancelServer() },
+			expectedLog:  "Server shutting down during request retention, serving maintenance page",
+			expectedBody: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverCtx, cancelServer := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancelServer()
+
+			core, logs := observer.New(zap.DebugLevel)
+			h := &MaintenanceHandler{
+				HTMLTemplate:                defaultHTMLTemplate,
+				RequestRetentionModeTimeout: 30,
+				ctx:                         serverCtx,
+				logger:                      zap.New(core),
+				enabled:                     true,
+			}
+
+			requestCtx, cancelRequest := context.WithCancel(context.Background())
+			defer cancelRequest()
+			req := httptest.NewRequest("GET", "http://example.com", nil).WithContext(requestCtx)
+			w := httptest.NewRecorder()
+
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				t.Error("next handler must not be called")
+				return nil
+			})
+
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- h.ServeHTTP(w, req, next)
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+			tt.cancel(cancelRequest, cancelServer)
+
+			select {
+			case err := <-errChan:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Request did not complete in time")
+			}
+
+			assert.Equal(t, 1, logs.FilterMessage(tt.expectedLog).Len())
+			if tt.expectedBody {
+				assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+				assert.NotEmpty(t, w.Body.String())
+			} else {
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
 }
 
 func TestMaintenanceHandlerRequestRetentionModeWithPeriodicCheck(t *testing.T) {
@@ -1233,6 +1350,119 @@ func TestMaintenanceHandlerRequestRetentionModeWithPeriodicCheck(t *testing.T) {
 	assert.Equal(t, "request-processed", w.Header().Get("X-Test"))
 }
 
+func TestMaintenanceHandlerRequestRetentionModeReleaseOnShutdown(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Test", "request-processed")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	tests := []struct {
+		name              string
+		releaseOnShutdown bool
+		expectedStatus    int
+	}{
+		{name: "maintenance page by default", expectedStatus: http.StatusServiceUnavailable},
+		{name: "forwarded with release_on_shutdown", releaseOnShutdown: true, expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+
+			h := &MaintenanceHandler{
+				HTMLTemplate:                defaultHTMLTemplate,
+				RequestRetentionModeTimeout: 30,
+				ReleaseOnShutdown:           tt.releaseOnShutdown,
+				ctx:                         ctx,
+			}
+			h.enabledMux.Lock()
+			h.enabled = true
+			h.enabledMux.Unlock()
+
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			w := httptest.NewRecorder()
+
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- h.ServeHTTP(w, req, next)
+			}()
+
+			// Caddy shuts down while the request is retained
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+
+			select {
+			case err := <-errChan:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Request did not complete in time")
+			}
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.releaseOnShutdown, w.Header().Get("X-Test") == "request-processed")
+		})
+	}
+
+	t.Run("client gone with the shutdown", func(t *testing.T) {
+		ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+		defer cancel()
+
+		h := &MaintenanceHandler{
+			RequestRetentionModeTimeout: 30,
+			ReleaseOnShutdown:           true,
+			ctx:                         ctx,
+		}
+		h.enabledMux.Lock()
+		h.enabled = true
+		h.enabledMux.Unlock()
+
+		reqCtx, reqCancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest("GET", "http://example.com", nil).WithContext(reqCtx)
+		w := httptest.NewRecorder()
+		forwarded := false
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			forwarded = true
+			return nil
+		})
+
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- h.ServeHTTP(w, req, next)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		reqCancel()
+		cancel()
+
+		select {
+		case err := <-errChan:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Request did not complete in time")
+		}
+		assert.False(t, forwarded)
+	})
+}
+
+func TestParseCaddyfile_ReleaseOnShutdown(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		request_retention_mode_timeout 10
+		release_on_shutdown true
+	}`)
+	require.NoError(t, err)
+	assert.True(t, h.ReleaseOnShutdown)
+
+	for _, input := range []string{
+		"maintenance {\n\trelease_on_shutdown\n}",
+		"maintenance {\n\trelease_on_shutdown maybe\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
 func TestMaintenanceHandler_DefaultEnabled(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -1302,6 +1532,34 @@ func TestMaintenanceHandler_StatusFile(t *testing.T) {
 			defaultEnabled: true,
 			expectedState:  true,
 		},
+		{
+			name:           "Enabled as String",
+			setupFile:      true,
+			fileContent:    `{"enabled": "true"}`,
+			defaultEnabled: false,
+			expectedState:  true,
+		},
+		{
+			name:           "Disabled as String",
+			setupFile:      true,
+			fileContent:    `{"enabled": "False"}`,
+			defaultEnabled: true,
+			expectedState:  false,
+		},
+		{
+			name:           "Unexpected Enabled Value",
+			setupFile:      true,
+			fileContent:    `{"enabled": "yes"}`,
+			defaultEnabled: true,
+			expectedState:  true,
+		},
+		{
+			name:           "Array in File",
+			setupFile:      true,
+			fileContent:    `[true]`,
+			defaultEnabled: true,
+			expectedState:  true,
+		},
 		{
 			name:           "No File Present",
 			setupFile:      false,
@@ -2168,6 +2426,23 @@ func TestLoadIPsFromFile(t *testing.T) {
 				"10.0.0.1",
 			},
 		},
+		{
+			name:        "CRLF line endings",
+			fileContent: "192.168.1.100\r\n# Comment\r\n10.0.0.1 # Server\r\n192.168.5.0/22\r\n",
+			expectedIPs: []string{
+				"192.168.1.100",
+				"10.0.0.1",
+				"192.168.5.0/22",
+			},
+		},
+		{
+			name:        "UTF-8 BOM",
+			fileContent: "\xEF\xBB\xBF192.168.1.100\n10.0.0.1\n",
+			expectedIPs: []string{
+				"192.168.1.100",
+				"10.0.0.1",
+			},
+		},
 		{
 			name: "Empty lines and whitespace",
 			fileContent: `
@@ -2673,6 +2948,18 @@ func TestMaintenanceHandler_BypassPaths(t *testing.T) {
 			requestPath:    "/",
 			expectedBypass: true,
 		},
+		{
+			name:           "Pattern without trailing slash matches request with one",
+			bypassPaths:    []string{"/health"},
+			requestPath:    "/health/",
+			expectedBypass: true,
+		},
+		{
+			name:           "Pattern with trailing slash matches request without one",
+			bypassPaths:    []string{"/health/"},
+			requestPath:    "/health",
+			expectedBypass: true,
+		},
 		{
 			name:           "Multiple bypass paths",
 			bypassPaths:    []string{"/.well-known/*", "/health", "/status"},
@@ -2698,6 +2985,7 @@ func TestMaintenanceHandler_BypassPaths(t *testing.T) {
 			h := &MaintenanceHandler{
 				BypassPaths: tt.bypassPaths,
 			}
+			require.NoError(t, h.decodePaths())
 
 			result := h.isPathBypassed(tt.requestPath)
 			if result != tt.expectedBypass {
@@ -2725,6 +3013,7 @@ func TestMaintenanceHandler_ServeHTTP_BypassPaths(t *testing.T) {
 		enabled:     true,
 		BypassPaths: []string{"/.well-known/*", "/health"},
 	}
+	require.NoError(t, h.decodePaths())
 
 	// Test request to bypassed path
 	req := httptest.NewRequest("GET", "/.well-known/mercure", nil)
@@ -2767,6 +3056,40 @@ func TestMaintenanceHandler_ServeHTTP_BypassPaths(t *testing.T) {
 	if w.Code != http.StatusServiceUnavailable {
 		t.Errorf("Response code = %v, want %v", w.Code, http.StatusServiceUnavailable)
 	}
+
+	// Test request to a bypassed path with a trailing slash
+	req = httptest.NewRequest("GET", "/health/", nil)
+	w = httptest.NewRecorder()
+
+	err = h.ServeHTTP(w, req, testHandler)
+	if err != nil {
+		t.Fatalf("ServeHTTP() error = %v", err)
+	}
+
+	if !handlerCalled {
+		t.Error("Handler was not called for bypassed path with a trailing slash")
+	}
+}
+
+func TestMaintenanceHandler_DecodePaths_Reprovision(t *testing.T) {
+	h := &MaintenanceHandler{
+		BypassPaths:      []string{"/a%2525b"},
+		APIPaths:         []string{"/api%2525"},
+		AlwaysBlockPaths: []string{"/old%2525"},
+	}
+
+	// Provisioning the same configuration twice decodes it once
+	for i := 0; i < 2; i++ {
+		require.NoError(t, h.Provision(caddy.Context{}))
+		assert.Equal(t, []string{"/a%2525b"}, h.BypassPaths)
+		assert.Equal(t, []string{"/api%2525"}, h.APIPaths)
+		assert.Equal(t, []string{"/old%2525"}, h.AlwaysBlockPaths)
+
+		assert.True(t, h.isPathBypassed("/a%25b"))
+		assert.False(t, h.isPathBypassed("/a%b"))
+		assert.Equal(t, []string{"/api%25"}, h.apiPathsDecoded)
+		assert.True(t, h.isPathBlocked(httptest.NewRequest("GET", "/old%2525", nil)))
+	}
 }
 
 func TestParseCaddyfile_BypassPaths(t *testing.T) {
@@ -2802,6 +3125,14 @@ func TestParseCaddyfile_BypassPaths(t *testing.T) {
 			expectError: false,
 			expected:    []string{"/.well-known/*"},
 		},
+		{
+			name: "Quoted bypass path with a space",
+			caddyfile: `maintenance {
+				bypass_paths "/my docs/*" /health
+			}`,
+			expectError: false,
+			expected:    []string{"/my docs/*", "/health"},
+		},
 		{
 			name: "Empty bypass paths",
 			caddyfile: `maintenance {
@@ -2837,3 +3168,4351 @@ func TestParseCaddyfile_BypassPaths(t *testing.T) {
 		})
 	}
 }
+
+func TestMaintenanceHandler_ServeHTTP_BypassMatchFullURI(t *testing.T) {
+	tests := []struct {
+		name               string
+		bypassMatchFullURI bool
+		target             string
+		expectedStatus     int
+	}{
+		{
+			name:               "query ignored by default",
+			bypassMatchFullURI: false,
+			target:             "/export?format=csv",
+			expectedStatus:     http.StatusServiceUnavailable,
+		},
+		{
+			name:               "query considered when enabled",
+			bypassMatchFullURI: true,
+			target:             "/export?format=csv",
+			expectedStatus:     http.StatusOK,
+		},
+		{
+			name:               "different query does not match",
+			bypassMatchFullURI: true,
+			target:             "/export?format=pdf",
+			expectedStatus:     http.StatusServiceUnavailable,
+		},
+		{
+			name:               "missing query does not match",
+			bypassMatchFullURI: true,
+			target:             "/export",
+			expectedStatus:     http.StatusServiceUnavailable,
+		},
+		{
+			name:               "trailing slash before the query is ignored",
+			bypassMatchFullURI: true,
+			target:             "/export/?format=csv",
+			expectedStatus:     http.StatusOK,
+		},
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{
+				enabled:            true,
+				BypassPaths:        []string{"/export?format=csv"},
+				BypassMatchFullURI: tt.bypassMatchFullURI,
+			}
+			require.NoError(t, h.decodePaths())
+
+			req := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func TestParseCaddyfile_BypassMatchFullURI(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		bypass_paths /export?format=csv
+		bypass_match_full_uri true
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.True(t, actualHandler.BypassMatchFullURI)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		bypass_match_full_uri maybe
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid bypass_match_full_uri value")
+}
+
+func TestMaintenanceHandler_TemplatesByLang(t *testing.T) {
+	tmpDir := t.TempDir()
+	defaultTemplate := filepath.Join(tmpDir, "default.html")
+	frTemplate := filepath.Join(tmpDir, "fr.html")
+	enTemplate := filepath.Join(tmpDir, "en.html")
+	require.NoError(t, os.WriteFile(defaultTemplate, []byte("<p>default page</p>"), 0644))
+	require.NoError(t, os.WriteFile(frTemplate, []byte("<p>page en maintenance</p>"), 0644))
+	require.NoError(t, os.WriteFile(enTemplate, []byte("<p>page under maintenance</p>"), 0644))
+
+	h := &MaintenanceHandler{
+		HTMLTemplate: defaultTemplate,
+		TemplatesByLang: map[string]string{
+			"fr": frTemplate,
+			"EN": enTemplate,
+		},
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	h.enabledMux.Lock()
+	h.enabled = true
+	h.enabledMux.Unlock()
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		expectedBody   string
+	}{
+		{
+			name:           "French",
+			acceptLanguage: "fr",
+			expectedBody:   "page en maintenance",
+		},
+		{
+			name:           "French regional variant",
+			acceptLanguage: "fr-CA,fr;q=0.8",
+			expectedBody:   "page en maintenance",
+		},
+		{
+			name:           "English",
+			acceptLanguage: "en-US",
+			expectedBody:   "page under maintenance",
+		},
+		{
+			name:           "Quality values select preferred language",
+			acceptLanguage: "fr;q=0.4, en;q=0.9",
+			expectedBody:   "page under maintenance",
+		},
+		{
+			name:           "Language refused with q=0 is skipped",
+			acceptLanguage: "en;q=0, fr;q=0.1",
+			expectedBody:   "page en maintenance",
+		},
+		{
+			name:           "Unmatched language falls back to default",
+			acceptLanguage: "de-DE, *;q=0.5",
+			expectedBody:   "default page",
+		},
+		{
+			name:           "No Accept-Language falls back to default",
+			acceptLanguage: "",
+			expectedBody:   "default page",
+		},
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedBody)
+		})
+	}
+}
+
+func TestMaintenanceHandler_TemplatesByLang_MissingFile(t *testing.T) {
+	h := &MaintenanceHandler{
+		TemplatesByLang: map[string]string{"fr": filepath.Join(t.TempDir(), "missing.html")},
+	}
+
+	err := h.Provision(caddy.Context{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read template file for language 'fr'")
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Equal(t, []string{"fr-ch", "fr", "en", "de"}, parseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5"))
+	assert.Equal(t, []string{"en", "fr"}, parseAcceptLanguage("fr;q=0.5, en"))
+	assert.Equal(t, []string{"fr"}, parseAcceptLanguage("en;q=0, fr;q=invalid-ignored, fr"))
+	assert.Empty(t, parseAcceptLanguage(""))
+}
+
+func TestParseCaddyfile_TemplatesByLang(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		templates_by_lang de /path/to/de.html
+		templates_by_lang {
+			fr /path/to/fr.html
+			en /path/to/en.html
+		}
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{
+		"de": "/path/to/de.html",
+		"fr": "/path/to/fr.html",
+		"en": "/path/to/en.html",
+	}, actualHandler.TemplatesByLang)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		templates_by_lang {
+			fr
+		}
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+}
+
+func TestParseCaddyfile_StatusFileDebounce(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		status_file /var/lib/caddy/maintenance.json
+		status_file_debounce 500ms
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, caddy.Duration(500*time.Millisecond), actualHandler.StatusFileDebounce)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		status_file_debounce soon
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid status_file_debounce value")
+}
+
+func TestMaintenanceHandler_IsJSONRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		jsonMediaTypes []string
+		accept         string
+		contentType    string
+		expectedJSON   bool
+	}{
+		{name: "exact application/json", accept: "application/json", expectedJSON: true},
+		{name: "JSON:API media type", accept: "application/vnd.api+json", expectedJSON: true},
+		{name: "JSON-LD media type", accept: "application/ld+json", expectedJSON: true},
+		{name: "problem details with parameters", accept: "application/problem+json; charset=utf-8", expectedJSON: true},
+		{name: "most preferred type is JSON", accept: "text/html;q=0.5, application/hal+json", expectedJSON: true},
+		{name: "browser accept header", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", expectedJSON: false},
+		{name: "HTML preferred over JSON", accept: "text/html, application/json;q=0.9", expectedJSON: false},
+		{name: "configured media type", jsonMediaTypes: []string{"application/x-ndjson"}, accept: "application/x-ndjson", expectedJSON: true},
+		{name: "unconfigured media type", accept: "application/x-ndjson", expectedJSON: false},
+		{name: "JSON content type", contentType: "application/json; charset=utf-8", expectedJSON: true},
+		{name: "+json content type", contentType: "application/merge-patch+json", expectedJSON: true},
+		{name: "no headers", expectedJSON: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{JSONMediaTypes: tt.jsonMediaTypes}
+
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			assert.Equal(t, tt.expectedJSON, h.isJSONRequest(req))
+		})
+	}
+}
+
+func TestMaintenanceHandler_ServeHTTP_JSONVariants(t *testing.T) {
+	h := &MaintenanceHandler{enabled: true}
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	for _, accept := range []string{"application/vnd.api+json", "application/ld+json"} {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var response map[string]any
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "error", response["status"])
+	}
+}
+
+func TestParseCaddyfile_JSONMediaTypes(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		json_media_types application/x-ndjson text/json
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, []string{"application/x-ndjson", "text/json"}, actualHandler.JSONMediaTypes)
+}
+
+func TestMaintenanceHandler_ServeHTTP_VaryHeader(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	t.Run("content negotiation only", func(t *testing.T) {
+		h := &MaintenanceHandler{enabled: true}
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, "Accept, Content-Type", w.Header().Get("Vary"))
+	})
+
+	t.Run("localized templates", func(t *testing.T) {
+		frTemplate := filepath.Join(t.TempDir(), "fr.html")
+		require.NoError(t, os.WriteFile(frTemplate, []byte("<p>maintenance</p>"), 0644))
+
+		h := &MaintenanceHandler{TemplatesByLang: map[string]string{"fr": frTemplate}}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		h.enabledMux.Lock()
+		h.enabled = true
+		h.enabledMux.Unlock()
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, "Accept, Content-Type, Accept-Language", w.Header().Get("Vary"))
+	})
+
+	t.Run("passthrough is untouched", func(t *testing.T) {
+		h := &MaintenanceHandler{enabled: false}
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Empty(t, w.Header().Get("Vary"))
+	})
+}
+
+func TestMaintenanceHandler_StartedAt(t *testing.T) {
+	startedAt := time.Date(2026, 3, 2, 14, 5, 0, 0, time.UTC)
+	statusFile := filepath.Join(t.TempDir(), "status.json")
+	require.NoError(t, os.WriteFile(statusFile, []byte(`{"enabled":true,"started_at":"2026-03-02T14:05:00Z"}`), 0644))
+
+	h := &MaintenanceHandler{StatusFile: statusFile}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.True(t, h.templateData().StartedAt.Equal(startedAt))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	t.Run("default template shows start time", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Contains(t, w.Body.String(), "In maintenance since 14:05 UTC")
+	})
+
+	t.Run("JSON includes start time", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		var response map[string]any
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "2026-03-02T14:05:00Z", response["started_at"])
+	})
+
+	t.Run("custom template variable", func(t *testing.T) {
+		templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+		require.NoError(t, os.WriteFile(templateFile, []byte(`<p>Down since {{.StartedAt.Format "2006-01-02 15:04"}}</p>`), 0644))
+
+		custom := &MaintenanceHandler{HTMLTemplate: templateFile, StatusFile: statusFile}
+		require.NoError(t, custom.Provision(caddy.Context{}))
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, custom.ServeHTTP(w, req, next))
+		assert.Equal(t, "<p>Down since 2026-03-02 14:05</p>", w.Body.String())
+	})
+}
+
+func TestMaintenanceHandler_StartedAt_DefaultEnabled(t *testing.T) {
+	before := time.Now()
+	h := &MaintenanceHandler{DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	startedAt := h.templateData().StartedAt
+	assert.False(t, startedAt.Before(before))
+
+	h = &MaintenanceHandler{}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.True(t, h.templateData().StartedAt.IsZero())
+
+	// The start time is hidden while maintenance is disabled
+	w := httptest.NewRecorder()
+	require.NoError(t, h.serveHTML(w, http.StatusOK, "", h.templateData()))
+	assert.NotContains(t, w.Body.String(), "In maintenance since")
+}
+
+func TestMaintenanceHandler_InvalidTemplateSyntax(t *testing.T) {
+	templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+	require.NoError(t, os.WriteFile(templateFile, []byte(`<p>{{.StartedAt</p>`), 0644))
+
+	h := &MaintenanceHandler{HTMLTemplate: templateFile, TemplateFailure: failureModeError}
+	err := h.Provision(caddy.Context{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse template")
+}
+
+func TestMaintenanceHandler_NonGoTemplateServedAsIs(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	// Vue markup is not a valid Go template
+	page := `<div id="app"><p>{{ message }}</p></div>`
+	templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+	require.NoError(t, os.WriteFile(templateFile, []byte(page), 0644))
+
+	h := &MaintenanceHandler{HTMLTemplate: templateFile, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	w := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil), next))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, page, w.Body.String())
+
+	core, logs := observer.New(zap.WarnLevel)
+	h.logger = zap.New(core)
+	require.NoError(t, h.checkHTMLTemplate(page, "template"))
+	assert.Equal(t, 1, logs.FilterMessage("Maintenance template is not a valid Go template, serving it as is").Len())
+}
+
+func TestMaintenanceHandler_TemplateCachePerHandler(t *testing.T) {
+	h := &MaintenanceHandler{HTMLTemplate: "<p>{{.Message}}</p>"}
+
+	first, err := h.parseHTMLTemplate(h.HTMLTemplate)
+	require.NoError(t, err)
+	second, err := h.parseHTMLTemplate(h.HTMLTemplate)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+
+	// Another handler does not share the cache
+	other, err := (&MaintenanceHandler{}).parseHTMLTemplate(h.HTMLTemplate)
+	require.NoError(t, err)
+	assert.NotSame(t, first, other)
+
+	require.NoError(t, h.Cleanup())
+	_, cached := h.templateCache.Load(h.HTMLTemplate)
+	assert.False(t, cached)
+}
+
+func TestMaintenanceHandler_DefaultTemplate(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		contains string
+	}{
+		{name: "default", template: "default", contains: `class="maintenance-container"`},
+		{name: "minimal", template: "minimal", contains: "max-width: 36rem"},
+		{name: "branded", template: "branded", contains: `class="banner"`},
+		{name: "dark", template: "dark", contains: `<meta name="color-scheme" content="dark">`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{DefaultTemplate: tt.template, DefaultEnabled: true}
+			require.NoError(t, h.Provision(caddy.Context{}))
+			assert.Equal(t, builtinTemplates[tt.template], h.HTMLTemplate)
+
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Contains(t, w.Body.String(), tt.contains)
+			assert.Contains(t, w.Body.String(), "In maintenance since")
+		})
+	}
+
+	t.Run("html_template takes precedence", func(t *testing.T) {
+		templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+		require.NoError(t, os.WriteFile(templateFile, []byte("<p>custom page</p>"), 0644))
+
+		h := &MaintenanceHandler{HTMLTemplate: templateFile, DefaultTemplate: "dark"}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		assert.Equal(t, "<p>custom page</p>", h.HTMLTemplate)
+	})
+
+	t.Run("unknown template", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultTemplate: "neon"}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown default_template 'neon'")
+		assert.Contains(t, err.Error(), "branded, dark, default, minimal")
+	})
+}
+
+func TestMaintenanceHandler_TemplateTexts(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	serve := func(t *testing.T, h *MaintenanceHandler) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w.Body.String()
+	}
+
+	for _, name := range builtinTemplateNames() {
+		t.Run(name, func(t *testing.T) {
+			h := &MaintenanceHandler{
+				DefaultTemplate: name,
+				DefaultEnabled:  true,
+				Title:           "Shop offline",
+				Heading:         "Back at 16:00",
+				BodyText:        "Orders <resume> shortly",
+				ButtonText:      "Try again",
+			}
+			require.NoError(t, h.Provision(caddy.Context{}))
+
+			body := serve(t, h)
+			assert.Contains(t, body, "<title>Shop offline</title>")
+			assert.Contains(t, body, "<h1>Back at 16:00</h1>")
+			assert.Contains(t, body, "Orders &lt;resume&gt; shortly")
+			assert.NotContains(t, body, "Maintenance in Progress")
+			assert.NotContains(t, body, "We'll Be Back Soon!")
+			assert.NotContains(t, body, "upgrading our system")
+			if strings.Contains(builtinTemplates[name], "refresh-button") {
+				assert.Contains(t, body, `onclick="location.reload()">Try again</button>`)
+				assert.NotContains(t, body, "Refresh Page")
+			}
+		})
+	}
+
+	t.Run("defaults are kept when unset", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		body := serve(t, h)
+		assert.Contains(t, body, "<title>Maintenance in Progress</title>")
+		assert.Contains(t, body, "<h1>We'll Be Back Soon!</h1>")
+		assert.Contains(t, body, "<br>We appreciate your patience")
+		assert.Contains(t, body, ">Refresh Page</button>")
+	})
+
+	t.Run("custom templates are left alone", func(t *testing.T) {
+		templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+		require.NoError(t, os.WriteFile(templateFile, []byte("<h1>custom page</h1>"), 0644))
+
+		h := &MaintenanceHandler{HTMLTemplate: templateFile, DefaultEnabled: true, Heading: "Back at 16:00"}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		assert.Equal(t, "<h1>custom page</h1>", serve(t, h))
+	})
+}
+
+func TestParseCaddyfile_TemplateTexts(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		title "Shop offline"
+		heading "Back at 16:00"
+		body_text "Orders resume shortly"
+		button_text "Try again"
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "Shop offline", actualHandler.Title)
+	assert.Equal(t, "Back at 16:00", actualHandler.Heading)
+	assert.Equal(t, "Orders resume shortly", actualHandler.BodyText)
+	assert.Equal(t, "Try again", actualHandler.ButtonText)
+
+	for _, directive := range []string{"title", "heading", "body_text", "button_text"} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser("maintenance {\n\t" + directive + "\n}")})
+		assert.Error(t, err, directive)
+	}
+}
+
+func TestMaintenanceHandler_RefreshButton(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	for _, name := range []string{"default", "branded", "dark"} {
+		for _, mode := range []string{"", refreshButtonScript, refreshButtonLink, refreshButtonNone} {
+			t.Run(name+"/"+mode, func(t *testing.T) {
+				h := &MaintenanceHandler{DefaultTemplate: name, DefaultEnabled: true, RefreshButton: mode}
+				require.NoError(t, h.Provision(caddy.Context{}))
+
+				req := httptest.NewRequest("GET", "http://example.com", nil)
+				w := httptest.NewRecorder()
+				require.NoError(t, h.ServeHTTP(w, req, next))
+				body := w.Body.String()
+
+				switch mode {
+				case "", refreshButtonScript:
+					assert.Contains(t, body, `<button class="refresh-button" onclick="location.reload()">Refresh Page</button>`)
+				case refreshButtonLink:
+					assert.NotContains(t, body, "onclick")
+					assert.Contains(t, body, `<a class="refresh-button" href="">Refresh Page</a>`)
+				case refreshButtonNone:
+					assert.NotContains(t, body, "onclick")
+					assert.NotContains(t, body, "Refresh Page")
+				}
+			})
+		}
+	}
+
+	t.Run("invalid mode", func(t *testing.T) {
+		h := &MaintenanceHandler{RefreshButton: "js"}
+		err := h.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid refresh_button 'js'")
+	})
+}
+
+func TestMaintenanceHandler_ContentSecurityPolicy(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	h := &MaintenanceHandler{DefaultEnabled: true, ContentSecurityPolicy: "default-src 'none'; style-src 'unsafe-inline'"}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	for _, accept := range []string{"text/html", "application/json", "text/plain"} {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, "default-src 'none'; style-src 'unsafe-inline'", w.Header().Get("Content-Security-Policy"), accept)
+	}
+
+	// Passed-through responses are left alone
+	h.enabledMux.Lock()
+	h.setEnabledLocked(false, time.Time{})
+	h.enabledMux.Unlock()
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	w := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, req, next))
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+}
+
+func TestMaintenanceHandler_CSPNonce(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	serve := func(t *testing.T, h *MaintenanceHandler) (string, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w.Header().Get("Content-Security-Policy"), w.Body.String()
+	}
+	nonceRe := regexp.MustCompile(`'nonce-([^']+)'`)
+
+	for _, name := range builtinTemplateNames() {
+		t.Run(name, func(t *testing.T) {
+			h := &MaintenanceHandler{DefaultTemplate: name, DefaultEnabled: true, CSP: true}
+			require.NoError(t, h.Provision(caddy.Context{}))
+
+			policy, body := serve(t, h)
+			match := nonceRe.FindStringSubmatch(policy)
+			require.NotNil(t, match, policy)
+			nonce := match[1]
+			assert.Equal(t, "default-src 'none'; style-src 'nonce-"+nonce+"'; script-src 'nonce-"+nonce+"'", policy)
+			assert.Contains(t, body, `<style nonce="`+nonce+`">`)
+			assert.NotContains(t, body, "onclick")
+			if strings.Contains(builtinTemplates[name], "refresh-button") {
+				assert.Contains(t, body, `<script nonce="`+nonce+`">`)
+			}
+
+			// A fresh nonce is generated for every response
+			nextPolicy, _ := serve(t, h)
+			assert.NotEqual(t, policy, nextPolicy)
+		})
+	}
+
+	t.Run("lockdown page", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultEnabled: true, Lockdown: true, CSP: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		policy, body := serve(t, h)
+		match := nonceRe.FindStringSubmatch(policy)
+		require.NotNil(t, match, policy)
+		assert.Contains(t, body, `<style nonce="`+match[1]+`">`)
+	})
+
+	t.Run("custom policy", func(t *testing.T) {
+		h := &MaintenanceHandler{
+			DefaultEnabled:        true,
+			CSP:                   true,
+			ContentSecurityPolicy: "default-src 'self'; style-src 'nonce-{nonce}'",
+		}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		policy, body := serve(t, h)
+		match := nonceRe.FindStringSubmatch(policy)
+		require.NotNil(t, match, policy)
+		assert.Equal(t, "default-src 'self'; style-src 'nonce-"+match[1]+"'", policy)
+		assert.Contains(t, body, `nonce="`+match[1]+`"`)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		policy, body := serve(t, h)
+		assert.Empty(t, policy)
+		assert.Contains(t, body, "    <style>\n")
+		assert.NotContains(t, body, "nonce")
+	})
+}
+
+func TestParseCaddyfile_RefreshButtonAndCSP(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		refresh_button link
+		content_security_policy "default-src 'none'"
+		csp true
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, refreshButtonLink, actualHandler.RefreshButton)
+	assert.Equal(t, "default-src 'none'", actualHandler.ContentSecurityPolicy)
+	assert.True(t, actualHandler.CSP)
+
+	for _, input := range []string{
+		"maintenance {\n\trefresh_button js\n}",
+		"maintenance {\n\trefresh_button\n}",
+		"maintenance {\n\tcontent_security_policy\n}",
+		"maintenance {\n\tcsp maybe\n}",
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(input)})
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseCaddyfile_DefaultTemplate(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		default_template dark
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "dark", actualHandler.DefaultTemplate)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		default_template
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+}
+
+// stubLookupIP replaces the hostname resolver for the duration of a test
+func stubLookupIP(t *testing.T, lookup func(host string) ([]net.IP, error)) {
+	t.Helper()
+	original := lookupIPFunc
+	lookupIPFunc = lookup
+	t.Cleanup(func() { lookupIPFunc = original })
+}
+
+func TestMaintenanceHandler_AllowedHostnames(t *testing.T) {
+	stubLookupIP(t, func(host string) ([]net.IP, error) {
+		switch host {
+		case "bastion.example.com":
+			return []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("2001:db8::10")}, nil
+		default:
+			return nil, fmt.Errorf("no such host")
+		}
+	})
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Test", "passed")
+		return nil
+	})
+
+	t.Run("resolved addresses are allowed", func(t *testing.T) {
+		h := &MaintenanceHandler{
+			AllowedIPs:     []string{"192.168.1.100", "bastion.example.com"},
+			DefaultEnabled: true,
+		}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		for _, clientIP := range []string{"203.0.113.10", "[2001:db8::10]:1234", "192.168.1.100"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = clientIP
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, "passed", w.Header().Get("X-Test"), clientIP)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "203.0.113.11"
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("lookup failure is an error by default", func(t *testing.T) {
+		h := &MaintenanceHandler{AllowedIPs: []string{"gone.example.com"}}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to resolve allowed hostname 'gone.example.com'")
+	})
+
+	t.Run("lookup failure can be a warning", func(t *testing.T) {
+		h := &MaintenanceHandler{
+			AllowedIPs:            []string{"gone.example.com", "bastion.example.com"},
+			HostnameLookupFailure: "warn",
+		}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		assert.True(t, h.isIPAllowed("203.0.113.10"))
+	})
+
+	t.Run("invalid failure mode", func(t *testing.T) {
+		h := &MaintenanceHandler{HostnameLookupFailure: "ignore"}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid hostname_lookup_failure")
+	})
+}
+
+func TestIsHostname(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{value: "bastion.example.com", expected: true},
+		{value: "bastion.example.com.", expected: true},
+		{value: "vpn-1.corp.example", expected: true},
+		{value: "invalid-ip", expected: false},
+		{value: "192.168.1.256", expected: false},
+		{value: "-bad.example.com", expected: false},
+		{value: "bad..example.com", expected: false},
+		{value: "under_score.example.com", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isHostname(tt.value))
+		})
+	}
+}
+
+func TestParseCaddyfile_HostnameLookupFailure(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		allowed_ips bastion.example.com
+		hostname_lookup_failure warn
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, []string{"bastion.example.com"}, actualHandler.AllowedIPs)
+	assert.Equal(t, "warn", actualHandler.HostnameLookupFailure)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		hostname_lookup_failure ignore
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid hostname_lookup_failure value")
+}
+
+func TestMaintenanceHandler_HostnameRefresh(t *testing.T) {
+	var lookups atomic.Int32
+	stubLookupIP(t, func(host string) ([]net.IP, error) {
+		switch lookups.Add(1) {
+		case 1:
+			return []net.IP{net.ParseIP("203.0.113.10")}, nil
+		case 2:
+			return nil, fmt.Errorf("temporary failure")
+		default:
+			return []net.IP{net.ParseIP("203.0.113.20")}, nil
+		}
+	})
+
+	h := &MaintenanceHandler{
+		AllowedIPs:              []string{"bastion.example.com"},
+		HostnameRefreshInterval: caddy.Duration(5 * time.Millisecond),
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	assert.True(t, h.isIPAllowed("203.0.113.10"))
+
+	require.Eventually(t, func() bool {
+		return h.isIPAllowed("203.0.113.20")
+	}, time.Second, time.Millisecond)
+	assert.False(t, h.isIPAllowed("203.0.113.10"))
+
+	require.NoError(t, h.Cleanup())
+	stopped := lookups.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, lookups.Load(), "no lookups after Cleanup")
+}
+
+func TestMaintenanceHandler_HostnameRefresh_KeepsAddressesOnFailure(t *testing.T) {
+	var lookups atomic.Int32
+	stubLookupIP(t, func(host string) ([]net.IP, error) {
+		if lookups.Add(1) == 1 {
+			return []net.IP{net.ParseIP("203.0.113.10")}, nil
+		}
+		return nil, fmt.Errorf("temporary failure")
+	})
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"bastion.example.com"}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	require.NoError(t, h.resolveAllowedHostnames(true))
+	assert.True(t, h.isIPAllowed("203.0.113.10"))
+}
+
+func TestParseCaddyfile_HostnameRefreshInterval(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		hostname_refresh_interval 5m
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, caddy.Duration(5*time.Minute), actualHandler.HostnameRefreshInterval)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		hostname_refresh_interval -1s
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+}
+
+func TestMaintenanceHandler_ServeHTTP_JSONTiming(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	serve := func(t *testing.T, h *MaintenanceHandler) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		var response map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w, response
+	}
+
+	t.Run("retry_after matches the header", func(t *testing.T) {
+		for _, retryAfter := range []int{0, 3600} {
+			h := &MaintenanceHandler{RetryAfter: retryAfter, DefaultEnabled: true}
+			require.NoError(t, h.Provision(caddy.Context{}))
+
+			w, response := serve(t, h)
+			assert.Equal(t, w.Header().Get("Retry-After"), fmt.Sprint(response["retry_after"]))
+			assert.NotContains(t, response, "estimated_end")
+		}
+	})
+
+	t.Run("estimated_end when configured", func(t *testing.T) {
+		h := &MaintenanceHandler{EstimatedEnd: "2026-03-02T16:30:00Z", DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		_, response := serve(t, h)
+		assert.Equal(t, "2026-03-02T16:30:00Z", response["estimated_end"])
+	})
+
+	t.Run("invalid estimated_end", func(t *testing.T) {
+		h := &MaintenanceHandler{EstimatedEnd: "tomorrow"}
+		err := h.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid estimated_end")
+	})
+}
+
+func TestParseCaddyfile_EstimatedEnd(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		estimated_end 2026-03-02T16:30:00Z
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "2026-03-02T16:30:00Z", actualHandler.EstimatedEnd)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		estimated_end tomorrow
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid estimated_end value")
+}
+
+func TestMaintenanceHandler_ServeHTTP_ResponseDelay(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	h := &MaintenanceHandler{ResponseDelay: 50, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	t.Run("delays the maintenance page", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		start := time.Now()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("cancellation short-circuits the delay", func(t *testing.T) {
+		h := &MaintenanceHandler{ResponseDelay: 10000, DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest("GET", "http://example.com", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+		}()
+
+		start := time.Now()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Empty(t, w.Body.String())
+	})
+}
+
+func TestParseCaddyfile_ResponseDelay(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		response_delay 250
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, 250, actualHandler.ResponseDelay)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		response_delay -1
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "response_delay value must not be negative")
+}
+
+func TestMaintenanceHandler_DefaultRepresentation(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	tests := []struct {
+		name                  string
+		defaultRepresentation string
+		accept                string
+		expectedContentType   string
+	}{
+		{name: "unset default with */*", accept: "*/*", expectedContentType: "text/html; charset=utf-8"},
+		{name: "html default with */*", defaultRepresentation: "html", accept: "*/*", expectedContentType: "text/html; charset=utf-8"},
+		{name: "json default with */*", defaultRepresentation: "json", accept: "*/*", expectedContentType: "application/json"},
+		{name: "text default with */*", defaultRepresentation: "text", accept: "*/*", expectedContentType: "text/plain; charset=utf-8"},
+		{name: "json default without Accept", defaultRepresentation: "json", expectedContentType: "application/json"},
+		{name: "json default respects explicit html", defaultRepresentation: "json", accept: "text/html, */*;q=0.8", expectedContentType: "text/html; charset=utf-8"},
+		{name: "text default respects explicit json", defaultRepresentation: "text", accept: "application/json", expectedContentType: "application/json"},
+		{name: "html default respects explicit text", defaultRepresentation: "html", accept: "text/plain", expectedContentType: "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{DefaultRepresentation: tt.defaultRepresentation, DefaultEnabled: true}
+			require.NoError(t, h.Provision(caddy.Context{}))
+
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, tt.expectedContentType, w.Header().Get("Content-Type"))
+		})
+	}
+
+	t.Run("text body", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultRepresentation: "text", RetryAfter: 120, DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Contains(t, w.Body.String(), "Service temporarily unavailable for maintenance")
+		assert.Contains(t, w.Body.String(), "Retry after 120 seconds")
+	})
+
+	t.Run("invalid representation", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultRepresentation: "xml"}
+		err := h.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid default_representation")
+	})
+}
+
+func TestParseCaddyfile_DefaultRepresentation(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		default_representation json
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "json", actualHandler.DefaultRepresentation)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		default_representation xml
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid default_representation value")
+}
+
+func TestMaintenanceHandler_APIPaths(t *testing.T) {
+	h := &MaintenanceHandler{APIPaths: []string{"/api/*", "/graphql", "/v1%20beta/*"}, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	tests := []struct {
+		name                string
+		path                string
+		accept              string
+		expectedContentType string
+	}{
+		{name: "API path with HTML Accept", path: "/api/orders?page=2", accept: "text/html,application/xhtml+xml,*/*;q=0.8", expectedContentType: "application/json"},
+		{name: "exact API path with HTML Accept", path: "/graphql/", accept: "text/html", expectedContentType: "application/json"},
+		{name: "API path with text Accept", path: "/api/orders", accept: "text/plain", expectedContentType: "application/json"},
+		{name: "encoded API path", path: "/v1%20beta/users", accept: "text/html", expectedContentType: "application/json"},
+		{name: "dot segments", path: "/api/../shop", accept: "text/html", expectedContentType: "text/html; charset=utf-8"},
+		{name: "other path", path: "/shop", accept: "text/html", expectedContentType: "text/html; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com"+tt.path, nil)
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, nil))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, tt.expectedContentType, w.Header().Get("Content-Type"))
+		})
+	}
+
+	t.Run("JSON body", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/api/orders", nil)
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, nil))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "error", body["status"])
+	})
+}
+
+func TestParseCaddyfile_APIPaths(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		api_paths /api/* /graphql
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, []string{"/api/*", "/graphql"}, actualHandler.APIPaths)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		api_paths
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+}
+
+func TestMaintenanceHandler_ServeHTTP_RangeRequest(t *testing.T) {
+	h := &MaintenanceHandler{DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	for _, accept := range []string{"text/html", "application/json", "text/plain"} {
+		t.Run(accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/large.iso", nil)
+			req.Header.Set("Accept", accept)
+			req.Header.Set("Range", "bytes=100-199")
+			w := httptest.NewRecorder()
+			w.Header().Set("Accept-Ranges", "bytes")
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Empty(t, w.Header().Get("Accept-Ranges"))
+			assert.Empty(t, w.Header().Get("Content-Range"))
+			assert.Contains(t, w.Body.String(), "maintenance")
+		})
+	}
+}
+
+func TestParseCaddyfile_Name(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		name shop
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "shop", actualHandler.Name)
+}
+
+func TestMaintenanceHandler_ServeHTTP_MarksAccessLog(t *testing.T) {
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.168.1.100"}, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	newRequest := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.RemoteAddr = remoteAddr
+		ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]any{})
+		ctx = context.WithValue(ctx, caddyhttp.ExtraLogFieldsCtxKey, new(caddyhttp.ExtraLogFields))
+		return req.WithContext(ctx)
+	}
+
+	t.Run("maintenance page", func(t *testing.T) {
+		req := newRequest("192.0.2.1:1234")
+		require.NoError(t, h.ServeHTTP(httptest.NewRecorder(), req, next))
+		assert.Equal(t, true, caddyhttp.GetVar(req.Context(), "maintenance"))
+	})
+
+	t.Run("allowed request", func(t *testing.T) {
+		req := newRequest("192.168.1.100:1234")
+		require.NoError(t, h.ServeHTTP(httptest.NewRecorder(), req, next))
+		assert.Nil(t, caddyhttp.GetVar(req.Context(), "maintenance"))
+	})
+
+	t.Run("without Caddy request context", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestMaintenanceHandler_ServeHTTP_EnabledPlaceholder(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.168.1.100"}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	var seen string
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		seen = repl.ReplaceAll("{http.maintenance.enabled}", "")
+		return nil
+	})
+
+	serve := func(t *testing.T, remoteAddr string) *caddy.Replacer {
+		t.Helper()
+		repl := caddy.NewReplacer()
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.RemoteAddr = remoteAddr
+		req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, repl))
+		seen = ""
+		require.NoError(t, h.ServeHTTP(httptest.NewRecorder(), req, next))
+		return repl
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		serve(t, "192.0.2.1:1234")
+		assert.Equal(t, "false", seen)
+	})
+
+	h.enabledMux.Lock()
+	h.setEnabledLocked(true, time.Now())
+	h.enabledMux.Unlock()
+
+	t.Run("enabled and allowed", func(t *testing.T) {
+		serve(t, "192.168.1.100:1234")
+		assert.Equal(t, "true", seen)
+	})
+
+	t.Run("enabled maintenance page", func(t *testing.T) {
+		repl := serve(t, "192.0.2.1:1234")
+		assert.Empty(t, seen)
+		assert.Equal(t, "true", repl.ReplaceAll("{http.maintenance.enabled}", ""))
+	})
+}
+
+func TestMaintenanceHandler_ServeHTTP_MinimalResponse(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	tests := []struct {
+		name            string
+		minimalResponse string
+		accept          string
+		expectEmpty     bool
+	}{
+		{name: "disabled", accept: "", expectEmpty: false},
+		{name: "always with browser Accept", minimalResponse: "always", accept: "text/html", expectEmpty: true},
+		{name: "always with JSON Accept", minimalResponse: "always", accept: "application/json", expectEmpty: true},
+		{name: "auto without Accept", minimalResponse: "auto", accept: "", expectEmpty: true},
+		{name: "auto with browser Accept", minimalResponse: "auto", accept: "text/html", expectEmpty: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{MinimalResponse: tt.minimalResponse, RetryAfter: 120, DefaultEnabled: true}
+			require.NoError(t, h.Provision(caddy.Context{}))
+
+			req := httptest.NewRequest("GET", "http://example.com/health", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, "120", w.Header().Get("Retry-After"))
+			if tt.expectEmpty {
+				assert.Empty(t, w.Body.String())
+				assert.Equal(t, "0", w.Header().Get("Content-Length"))
+			} else {
+				assert.NotEmpty(t, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("invalid value", func(t *testing.T) {
+		h := &MaintenanceHandler{MinimalResponse: "sometimes"}
+		err := h.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid minimal_response")
+	})
+}
+
+func TestParseCaddyfile_MinimalResponse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: `maintenance {
+			minimal_response
+		}`, expected: "always"},
+		{input: `maintenance {
+			minimal_response auto
+		}`, expected: "auto"},
+	}
+
+	for _, tt := range tests {
+		actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(tt.input)})
+		require.NoError(t, err)
+
+		actualHandler, ok := actual.(*MaintenanceHandler)
+		require.True(t, ok)
+		assert.Equal(t, tt.expected, actualHandler.MinimalResponse)
+	}
+
+	_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`maintenance {
+		minimal_response sometimes
+	}`)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid minimal_response value")
+}
+
+func TestMaintenanceHandler_ServeHTTP_AckCookie(t *testing.T) {
+	h := &MaintenanceHandler{AckCookie: "maintenance_ack", AckCookieTTL: caddy.Duration(10 * time.Minute), RetryAfter: 120}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabledMux.Lock()
+	h.setEnabledLocked(true, time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC))
+	h.enabledMux.Unlock()
+
+	serve := func(accept string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Accept", accept)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "120", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Header().Get("Vary"), "Cookie")
+		return w
+	}
+
+	// The first page is served in full along with the cookie
+	first := serve("text/html")
+	assert.Contains(t, first.Body.String(), "<html")
+	cookies := first.Result().Cookies()
+	require.Len(t, cookies, 1)
+	ack := cookies[0]
+	assert.Equal(t, "maintenance_ack", ack.Name)
+	assert.Equal(t, 600, ack.MaxAge)
+	assert.True(t, ack.HttpOnly)
+
+	// Subsequent pages are empty
+	second := serve("text/html", ack)
+	assert.Empty(t, second.Body.String())
+	assert.Equal(t, "0", second.Header().Get("Content-Length"))
+	assert.Empty(t, second.Result().Cookies())
+
+	// JSON clients are not concerned
+	assert.NotEmpty(t, serve("application/json", ack).Body.String())
+
+	// The cookie of an earlier maintenance does not hide the page
+	h.enabledMux.Lock()
+	h.setEnabledLocked(false, time.Time{})
+	h.setEnabledLocked(true, time.Date(2026, 3, 9, 14, 0, 0, 0, time.UTC))
+	h.enabledMux.Unlock()
+	third := serve("text/html", ack)
+	assert.Contains(t, third.Body.String(), "<html")
+	assert.Len(t, third.Result().Cookies(), 1)
+}
+
+func TestMaintenanceHandler_ServeHTTP_AckCookieDisabled(t *testing.T) {
+	h := &MaintenanceHandler{DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.AddCookie(&http.Cookie{Name: "maintenance_ack", Value: "0"})
+	w := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(w, req, nil))
+
+	assert.Contains(t, w.Body.String(), "<html")
+	assert.Empty(t, w.Result().Cookies())
+	assert.NotContains(t, w.Header().Get("Vary"), "Cookie")
+}
+
+func TestMaintenanceHandler_Validate_AckCookie(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler *MaintenanceHandler
+		errText string
+	}{
+		{name: "invalid name", handler: &MaintenanceHandler{AckCookie: "maintenance ack"}, errText: "invalid ack_cookie"},
+		{name: "negative ttl", handler: &MaintenanceHandler{AckCookie: "ack", AckCookieTTL: -1}, errText: "ack_cookie_ttl must not be negative"},
+		{name: "ttl without cookie", handler: &MaintenanceHandler{AckCookieTTL: caddy.Duration(time.Minute)}, errText: "ack_cookie_ttl requires ack_cookie"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errText)
+		})
+	}
+}
+
+func TestParseCaddyfile_AckCookie(t *testing.T) {
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`maintenance {
+		ack_cookie maintenance_ack
+		ack_cookie_ttl 15m
+	}`)})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "maintenance_ack", actualHandler.AckCookie)
+	assert.Equal(t, caddy.Duration(15*time.Minute), actualHandler.AckCookieTTL)
+
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`maintenance {
+		ack_cookie_ttl 0s
+	}`)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ack_cookie_ttl value must be positive")
+}
+
+func TestMaintenanceHandler_ServeHTTP_StatusPath(t *testing.T) {
+	h := &MaintenanceHandler{StatusPath: "/__maintenance_status", RetryAfter: 120}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	nextCalled := false
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		nextCalled = true
+		return nil
+	})
+
+	getStatus := func(t *testing.T) map[string]any {
+		req := httptest.NewRequest("GET", "http://example.com/__maintenance_status", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		var status map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		status := getStatus(t)
+		assert.Equal(t, false, status["enabled"])
+		assert.NotContains(t, status, "started_at")
+		assert.False(t, nextCalled)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		h.enabledMux.Lock()
+		h.setEnabledLocked(true, time.Date(2026, 3, 2, 14, 5, 0, 0, time.UTC))
+		h.enabledMux.Unlock()
+
+		status := getStatus(t)
+		assert.Equal(t, true, status["enabled"])
+		assert.Equal(t, float64(120), status["retry_after"])
+		assert.Equal(t, "2026-03-02T14:05:00Z", status["started_at"])
+		assert.False(t, nextCalled)
+	})
+
+	t.Run("other paths are still blocked", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/__maintenance_status/other", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("only read methods", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "http://example.com/__maintenance_status", nil)
+		w := httptest.NewRecorder()
+
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestParseCaddyfile_StatusPath(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		status_path /__maintenance_status
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "/__maintenance_status", actualHandler.StatusPath)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		status_path __maintenance_status
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status_path must start with '/'")
+}
+
+func TestRemoteHost(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{remoteAddr: "192.168.1.100:1234", expected: "192.168.1.100"},
+		{remoteAddr: "192.168.1.100", expected: "192.168.1.100"},
+		{remoteAddr: "[2001:db8::1]:1234", expected: "2001:db8::1"},
+		{remoteAddr: "[2001:db8::1]", expected: "2001:db8::1"},
+		{remoteAddr: "2001:db8::1", expected: "2001:db8::1"},
+		{remoteAddr: "@", expected: "@"},
+		{remoteAddr: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			assert.Equal(t, tt.expected, remoteHost(tt.remoteAddr))
+		})
+	}
+}
+
+func TestMaintenanceHandler_ServeHTTP_BareRemoteAddr(t *testing.T) {
+	h := &MaintenanceHandler{
+		AllowedIPs:          []string{"192.168.1.100", "2001:db8::1"},
+		UseForwardedHeaders: true,
+		TrustedProxies:      []string{"10.0.0.1"},
+		DefaultEnabled:      true,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Test", "passed")
+		return nil
+	})
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		expectBlocked bool
+	}{
+		{name: "bare IPv4", remoteAddr: "192.168.1.100"},
+		{name: "bare IPv6", remoteAddr: "2001:db8::1"},
+		{name: "bracketed IPv6 without port", remoteAddr: "[2001:db8::1]"},
+		{name: "bare trusted proxy", remoteAddr: "10.0.0.1", forwardedFor: "192.168.1.100"},
+		{name: "bare blocked IP", remoteAddr: "192.0.2.1", expectBlocked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			if tt.expectBlocked {
+				assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			} else {
+				assert.Equal(t, "passed", w.Header().Get("X-Test"))
+			}
+		})
+	}
+}
+
+func TestMaintenanceHandler_WWWAuthenticate(t *testing.T) {
+	htpasswdFile := filepath.Join(t.TempDir(), "test.htpasswd")
+	require.NoError(t, os.WriteFile(htpasswdFile, []byte("admin:$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi\n"), 0644))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	tests := []struct {
+		name            string
+		authRealm       string
+		authCharsetUTF8 bool
+		expected        string
+	}{
+		{name: "default realm", expected: `Basic realm="Maintenance Mode"`},
+		{name: "custom realm", authRealm: "Staff only", expected: `Basic realm="Staff only"`},
+		{name: "charset", authRealm: "Staff only", authCharsetUTF8: true, expected: `Basic realm="Staff only", charset="UTF-8"`},
+		{name: "charset with default realm", authCharsetUTF8: true, expected: `Basic realm="Maintenance Mode", charset="UTF-8"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{
+				HtpasswdFile:    htpasswdFile,
+				AuthRealm:       tt.authRealm,
+				AuthCharsetUTF8: tt.authCharsetUTF8,
+				DefaultEnabled:  true,
+			}
+			require.NoError(t, h.Provision(caddy.Context{}))
+
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, tt.expected, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestParseCaddyfile_AuthCharsetUTF8(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		auth_charset_utf8 true
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.True(t, actualHandler.AuthCharsetUTF8)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		auth_charset_utf8 sometimes
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid auth_charset_utf8 value")
+}
+
+func TestMaintenanceHandler_Provision_DefaultAuthRealm(t *testing.T) {
+	htpasswdFile := filepath.Join(t.TempDir(), "test.htpasswd")
+	require.NoError(t, os.WriteFile(htpasswdFile, []byte("admin:$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi\n"), 0644))
+
+	for _, realm := range []string{"", "   "} {
+		h := &MaintenanceHandler{HtpasswdFile: htpasswdFile, AuthRealm: realm, DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		assert.Equal(t, "Maintenance Mode", h.AuthRealm)
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})))
+		assert.Equal(t, `Basic realm="Maintenance Mode"`, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// Without htpasswd the realm is left alone
+	h := &MaintenanceHandler{}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.Empty(t, h.AuthRealm)
+}
+
+func TestMaintenanceHandler_HtpasswdExpiry(t *testing.T) {
+	hash := "$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi" // password: password
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	htpasswdFile := filepath.Join(t.TempDir(), "test.htpasswd")
+	content := fmt.Sprintf("admin:%s\ncontractor:%s:expires=%s\nformer:%s:expires=%s  # left in March\n", hash, hash, future, hash, past)
+	require.NoError(t, os.WriteFile(htpasswdFile, []byte(content), 0644))
+
+	h := &MaintenanceHandler{HtpasswdFile: htpasswdFile, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.Equal(t, []byte(hash), h.htpasswdEntries["contractor"])
+	assert.NotContains(t, h.htpasswdExpiry, "admin")
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Test", "request-processed")
+		return nil
+	})
+
+	tests := []struct {
+		username     string
+		expectAccess bool
+	}{
+		{username: "admin", expectAccess: true},
+		{username: "contractor", expectAccess: true},
+		{username: "former", expectAccess: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			req.SetBasicAuth(tt.username, "password")
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			if tt.expectAccess {
+				assert.Equal(t, "request-processed", w.Header().Get("X-Test"))
+			} else {
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+			}
+		})
+	}
+
+	t.Run("invalid expiry", func(t *testing.T) {
+		invalidFile := filepath.Join(t.TempDir(), "invalid.htpasswd")
+		require.NoError(t, os.WriteFile(invalidFile, []byte("contractor:"+hash+":expires=next-week\n"), 0644))
+
+		h := &MaintenanceHandler{HtpasswdFile: invalidFile}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid expiry at line 1")
+	})
+}
+
+func TestMaintenanceHandler_BcryptCost(t *testing.T) {
+	weakHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	adequateHash := "$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi"
+
+	htpasswdFile := filepath.Join(t.TempDir(), "test.htpasswd")
+	require.NoError(t, os.WriteFile(htpasswdFile, []byte("weak:"+string(weakHash)+"\nadmin:"+adequateHash+"\n"), 0644))
+
+	t.Run("weak hash is a warning by default", func(t *testing.T) {
+		core, logs := observer.New(zap.WarnLevel)
+		h := &MaintenanceHandler{HtpasswdFile: htpasswdFile, logger: zap.New(core)}
+		require.NoError(t, h.parseHtpasswdFile())
+
+		warnings := logs.FilterMessage("Weak bcrypt cost in htpasswd").All()
+		require.Len(t, warnings, 1)
+		assert.Equal(t, "weak", warnings[0].ContextMap()["username"])
+		assert.Equal(t, int64(bcrypt.MinCost), warnings[0].ContextMap()["cost"])
+		assert.Contains(t, h.htpasswdEntries, "weak")
+	})
+
+	t.Run("weak hash can be rejected", func(t *testing.T) {
+		h := &MaintenanceHandler{HtpasswdFile: htpasswdFile, WeakBcryptCost: "error"}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "bcrypt cost 4 of user 'weak' is below the minimum of 10 at line 1")
+	})
+
+	t.Run("adequate hash passes a raised minimum check", func(t *testing.T) {
+		adequateFile := filepath.Join(t.TempDir(), "adequate.htpasswd")
+		require.NoError(t, os.WriteFile(adequateFile, []byte("admin:"+adequateHash+"\n"), 0644))
+
+		core, logs := observer.New(zap.WarnLevel)
+		h := &MaintenanceHandler{HtpasswdFile: adequateFile, MinBcryptCost: 10, WeakBcryptCost: "error", logger: zap.New(core)}
+		require.NoError(t, h.parseHtpasswdFile())
+		assert.Zero(t, logs.Len())
+
+		h = &MaintenanceHandler{HtpasswdFile: adequateFile, MinBcryptCost: 12, WeakBcryptCost: "error"}
+		require.Error(t, h.parseHtpasswdFile())
+	})
+
+	t.Run("invalid policy", func(t *testing.T) {
+		h := &MaintenanceHandler{HtpasswdFile: htpasswdFile, WeakBcryptCost: "ignore"}
+		err := h.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid weak_bcrypt_cost")
+	})
+}
+
+func TestMaintenanceHandler_Validate_MinBcryptCost(t *testing.T) {
+	for _, cost := range []int{0, bcrypt.MinCost, 12, bcrypt.MaxCost} {
+		assert.NoError(t, (&MaintenanceHandler{MinBcryptCost: cost}).Validate(), cost)
+	}
+
+	for _, cost := range []int{-1, bcrypt.MinCost - 1, bcrypt.MaxCost + 1, 99} {
+		err := (&MaintenanceHandler{MinBcryptCost: cost}).Validate()
+		require.Error(t, err, cost)
+		assert.Contains(t, err.Error(), "invalid min_bcrypt_cost")
+	}
+}
+
+func TestParseCaddyfile_BcryptCost(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		min_bcrypt_cost 12
+		weak_bcrypt_cost error
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, 12, actualHandler.MinBcryptCost)
+	assert.Equal(t, "error", actualHandler.WeakBcryptCost)
+
+	for _, input := range []string{
+		"maintenance {\n\tmin_bcrypt_cost 2\n}",
+		"maintenance {\n\tmin_bcrypt_cost high\n}",
+		"maintenance {\n\tweak_bcrypt_cost ignore\n}",
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(input)})
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_ParseHtpasswdFile_WindowsFile(t *testing.T) {
+	hash := "$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi"
+	htpasswdFile := filepath.Join(t.TempDir(), "windows.htpasswd")
+	content := "\xEF\xBB\xBFadmin:" + hash + "\r\n# Comment\r\nuser1:" + hash + "\r\n"
+	require.NoError(t, os.WriteFile(htpasswdFile, []byte(content), 0644))
+
+	h := &MaintenanceHandler{HtpasswdFile: htpasswdFile}
+	require.NoError(t, h.parseHtpasswdFile())
+
+	require.Len(t, h.htpasswdEntries, 2)
+	assert.Equal(t, []byte(hash), h.htpasswdEntries["admin"])
+	assert.Equal(t, []byte(hash), h.htpasswdEntries["user1"])
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("admin", "password")
+	assert.True(t, h.isAuthenticated(req))
+}
+
+func TestSplitLines(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", ""}, splitLines([]byte("\xEF\xBB\xBFa\r\nb\r\n")))
+	assert.Equal(t, []string{"a", "b"}, splitLines([]byte("a\nb")))
+}
+
+func TestScanLines(t *testing.T) {
+	content := "# header\n\n  first  \nsecond # inline\n   # indented comment\n#\n\t\nthird#tight\n"
+	assert.Equal(t, []fileLine{
+		{number: 3, text: "first"},
+		{number: 4, text: "second"},
+		{number: 8, text: "third"},
+	}, scanLines([]byte(content)))
+	assert.Empty(t, scanLines(nil))
+}
+
+func TestFileParsers_CommentHandlingIsConsistent(t *testing.T) {
+	hash := "$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi"
+
+	// Each layout is rendered with an IP entry and an htpasswd entry so both
+	// parsers see exactly the same comments, blank lines and whitespace
+	layouts := []struct {
+		name   string
+		layout string
+		want   int
+	}{
+		{name: "full-line comments", layout: "# comment\n%s\n# comment\n", want: 1},
+		{name: "inline comments", layout: "%s # comment\n%s#tight\n", want: 2},
+		{name: "indented comments", layout: "   # comment\n\t%s\n", want: 1},
+		{name: "bare hash lines", layout: "#\n%s\n #\n", want: 1},
+		{name: "blank and whitespace lines", layout: "\n \n\t\n%s\n\n", want: 1},
+		{name: "comments only", layout: "# nothing\n\n# here\n", want: 0},
+	}
+
+	for _, tc := range layouts {
+		t.Run(tc.name, func(t *testing.T) {
+			render := func(entries ...string) string {
+				args := make([]any, strings.Count(tc.layout, "%s"))
+				for n := range args {
+					args[n] = entries[n]
+				}
+				return fmt.Sprintf(tc.layout, args...)
+			}
+
+			ips, err := parseIPList(strings.NewReader(render("10.0.0.1", "10.0.0.2")))
+			require.NoError(t, err)
+
+			h := &MaintenanceHandler{}
+			loaded, err := h.parseHtpasswd(strings.NewReader(render("admin:"+hash, "user1:"+hash)))
+			require.NoError(t, err)
+
+			assert.Len(t, ips, tc.want)
+			assert.Equal(t, tc.want, loaded)
+			assert.Len(t, h.htpasswdEntries, tc.want)
+		})
+	}
+}
+
+func TestParseIPList(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+		err      string
+	}{
+		{name: "IPs and CIDR ranges", content: "192.168.1.1\n10.0.0.0/8 # office\n2001:db8::/32\n", expected: []string{"192.168.1.1", "10.0.0.0/8", "2001:db8::/32"}},
+		{name: "BOM and CRLF", content: "\xEF\xBB\xBF192.168.1.1\r\n192.168.1.2\r\n", expected: []string{"192.168.1.1", "192.168.1.2"}},
+		{name: "empty", content: ""},
+		{name: "invalid IP", content: "192.168.1.1\n\n192.168.1.300\n", err: "invalid IP address '192.168.1.300' at line 3"},
+		{name: "invalid CIDR", content: "10.0.0.0/33", err: "invalid CIDR notation '10.0.0.0/33' at line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, err := parseIPList(strings.NewReader(tt.content))
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ips)
+		})
+	}
+
+	_, err := parseIPList(iotest.ErrReader(errors.New("disk failure")))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read file: disk failure")
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	const hash = "$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi"
+
+	tests := []struct {
+		name    string
+		content string
+		users   []string
+		err     string
+	}{
+		{name: "users with comments", content: "# ops\nadmin:" + hash + "\nuser1:" + hash + " # on call\n", users: []string{"admin", "user1"}},
+		{name: "expiry", content: "admin:" + hash + ":expires=2030-01-01T00:00:00Z\n", users: []string{"admin"}},
+		{name: "missing separator", content: "admin:" + hash + "\nuser1\n", err: "invalid htpasswd format at line 2"},
+		{name: "empty username", content: ":" + hash, err: "empty username at line 1"},
+		{name: "empty hash", content: "admin:", err: "empty password hash at line 1"},
+		{name: "invalid expiry", content: "admin:" + hash + ":expires=soon", err: "invalid expiry at line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{}
+			loaded, err := h.parseHtpasswd(strings.NewReader(tt.content))
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.users), loaded)
+			for _, user := range tt.users {
+				assert.Equal(t, []byte(hash), h.htpasswdEntries[user], user)
+			}
+		})
+	}
+
+	t.Run("parsing again replaces the users", func(t *testing.T) {
+		h := &MaintenanceHandler{}
+		_, err := h.parseHtpasswd(strings.NewReader("admin:" + hash + ":expires=2030-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		_, err = h.parseHtpasswd(strings.NewReader("user1:" + hash))
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]byte{"user1": []byte(hash)}, h.htpasswdEntries)
+		assert.Empty(t, h.htpasswdExpiry)
+	})
+}
+
+func TestMaintenanceHandler_ServeHTTP_BypassPathWithSpace(t *testing.T) {
+	for _, bypassPath := range []string{"/my docs/*", "/my%20docs/*"} {
+		t.Run(bypassPath, func(t *testing.T) {
+			h := &MaintenanceHandler{
+				BypassPaths: []string{bypassPath},
+				enabled:     true,
+			}
+			require.NoError(t, h.Provision(caddy.Context{}))
+			h.enabled = true
+
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusOK)
+				return nil
+			})
+
+			req := httptest.NewRequest("GET", "/my%20docs/readme.txt", nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(rec, req, next))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			req = httptest.NewRequest("GET", "/other/readme.txt", nil)
+			rec = httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(rec, req, next))
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		})
+	}
+}
+
+func TestMaintenanceHandler_Provision_InvalidBypassPathEscape(t *testing.T) {
+	h := &MaintenanceHandler{BypassPaths: []string{"/bad%zz"}}
+	err := h.Provision(caddy.Context{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid bypass path '/bad%zz'")
+}
+
+func TestMaintenanceHandler_ServeHTTP_BypassEncodedPaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantBypass bool
+	}{
+		{name: "encoded dot", target: "/%2Ehealth", wantBypass: true},
+		{name: "plain path", target: "/.health", wantBypass: true},
+		{name: "encoded slash", target: "/public%2Fstyle.css", wantBypass: true},
+		{name: "current directory segment", target: "/public/./style.css", wantBypass: true},
+		{name: "duplicate slashes", target: "//public//style.css", wantBypass: true},
+		{name: "parent segment escaping the bypass", target: "/public/../admin", wantBypass: false},
+		{name: "encoded parent segment", target: "/public/%2E%2E/admin", wantBypass: false},
+		{name: "unrelated path", target: "/admin", wantBypass: false},
+	}
+
+	h := &MaintenanceHandler{BypassPaths: []string{"/.health", "/public/*"}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabled = true
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(rec, req, next))
+
+			if tt.wantBypass {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCleanRequestPath(t *testing.T) {
+	assert.Equal(t, "/", cleanRequestPath(""))
+	assert.Equal(t, "/", cleanRequestPath("/"))
+	assert.Equal(t, "/admin", cleanRequestPath("/public/../admin"))
+	assert.Equal(t, "/admin", cleanRequestPath("/../../admin"))
+	assert.Equal(t, "/a/b", cleanRequestPath("//a/./b/"))
+}
+
+func TestMaintenanceHandler_Lockdown(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	newHandler := func(t *testing.T, lockdown bool) *MaintenanceHandler {
+		h := &MaintenanceHandler{
+			Lockdown:    lockdown,
+			AllowedIPs:  []string{"10.0.0.1"},
+			BypassPaths: []string{"/health"},
+		}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		h.enabled = true
+		return h
+	}
+
+	t.Run("lockdown and maintenance answer differently", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+
+		rec := httptest.NewRecorder()
+		require.NoError(t, newHandler(t, false).ServeHTTP(rec, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+		rec = httptest.NewRecorder()
+		require.NoError(t, newHandler(t, true).ServeHTTP(rec, req, next))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Body.String(), "Access Restricted")
+	})
+
+	t.Run("allowed IPs and bypass paths still pass", func(t *testing.T) {
+		h := newHandler(t, true)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, req, next))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		req = httptest.NewRequest("GET", "/health", nil)
+		req.RemoteAddr = "192.168.1.1:1234"
+		rec = httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, req, next))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("JSON and text", func(t *testing.T) {
+		h := newHandler(t, true)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, req, next))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "message": "Access restricted"}`, rec.Body.String())
+
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "text/plain")
+		rec = httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, req, next))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, "Access restricted\n", rec.Body.String())
+	})
+
+	t.Run("requests are not retained", func(t *testing.T) {
+		h := newHandler(t, true)
+		h.RequestRetentionModeTimeout = 10
+
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil), next))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("custom lockdown template", func(t *testing.T) {
+		templateFile := filepath.Join(t.TempDir(), "lockdown.html")
+		require.NoError(t, os.WriteFile(templateFile, []byte("<h1>Incident in progress</h1>"), 0644))
+
+		h := &MaintenanceHandler{Lockdown: true, LockdownTemplate: templateFile}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		h.enabled = true
+
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil), next))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, "<h1>Incident in progress</h1>", rec.Body.String())
+	})
+
+	t.Run("invalid lockdown template", func(t *testing.T) {
+		templateFile := filepath.Join(t.TempDir(), "lockdown.html")
+		require.NoError(t, os.WriteFile(templateFile, []byte("{{.Broken"), 0644))
+
+		h := &MaintenanceHandler{Lockdown: true, LockdownTemplate: templateFile, TemplateFailure: failureModeError}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to parse lockdown template")
+	})
+}
+
+func TestParseCaddyfile_Lockdown(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		lockdown true
+		lockdown_template /etc/caddy/lockdown.html
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.True(t, actualHandler.Lockdown)
+	assert.Equal(t, "/etc/caddy/lockdown.html", actualHandler.LockdownTemplate)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		lockdown maybe
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid lockdown value")
+}
+
+func TestMaintenanceHandler_BypassHeader(t *testing.T) {
+	htpasswdFile := filepath.Join(t.TempDir(), "test.htpasswd")
+	require.NoError(t, os.WriteFile(htpasswdFile, []byte("admin:$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi\n"), 0644))
+
+	h := &MaintenanceHandler{
+		AllowedIPs:   []string{"10.0.0.1"},
+		BypassPaths:  []string{"/health"},
+		HtpasswdFile: htpasswdFile,
+		BypassHeader: "X-Maintenance-Bypass",
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		auth       bool
+		wantCode   int
+		wantHeader string
+	}{
+		{name: "allowed IP", path: "/", remoteAddr: "10.0.0.1:1234", wantCode: http.StatusOK, wantHeader: "ip"},
+		{name: "authenticated", path: "/", remoteAddr: "192.168.1.1:1234", auth: true, wantCode: http.StatusOK, wantHeader: "auth"},
+		{name: "bypass path", path: "/health", remoteAddr: "192.168.1.1:1234", wantCode: http.StatusOK, wantHeader: "path"},
+		{name: "denied", path: "/", remoteAddr: "192.168.1.1:1234", wantCode: http.StatusUnauthorized, wantHeader: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.enabled = true
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.auth {
+				req.SetBasicAuth("admin", "password")
+			}
+
+			rec := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(rec, req, next))
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, tt.wantHeader, rec.Header().Get("X-Maintenance-Bypass"))
+		})
+	}
+
+	t.Run("not set outside maintenance", func(t *testing.T) {
+		h.enabled = false
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, req, next))
+		assert.Empty(t, rec.Header().Get("X-Maintenance-Bypass"))
+	})
+
+	t.Run("not set without the option", func(t *testing.T) {
+		h := &MaintenanceHandler{AllowedIPs: []string{"10.0.0.1"}}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		h.enabled = true
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, req, next))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("X-Maintenance-Bypass"))
+	})
+}
+
+func TestParseCaddyfile_BypassHeader(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		bypass_header X-Maintenance-Bypass
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+	assert.Equal(t, "X-Maintenance-Bypass", actual.(*MaintenanceHandler).BypassHeader)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		bypass_header
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+}
+
+func TestMaintenanceHandler_JSONTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	templateFile := filepath.Join(tmpDir, "maintenance.json")
+	require.NoError(t, os.WriteFile(templateFile, []byte(`{
+	"error": {"code": "maintenance", "message": {{json .Message}}},
+	"retry_in": {{.RetryAfter}}{{if not .EstimatedEnd.IsZero}},
+	"until": {{json .EstimatedEnd}}{{end}}
+}`), 0644))
+
+	h := &MaintenanceHandler{
+		JSONTemplate: templateFile,
+		Message:      `Upgrading "shop" database`,
+		RetryAfter:   120,
+		EstimatedEnd: "2026-03-01T12:00:00Z",
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabled = true
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(rec, req, nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"error": {"code": "maintenance", "message": "Upgrading \"shop\" database"},
+		"retry_in": 120,
+		"until": "2026-03-01T12:00:00Z"
+	}`, rec.Body.String())
+
+	t.Run("template syntax error", func(t *testing.T) {
+		invalidFile := filepath.Join(tmpDir, "invalid.json")
+		require.NoError(t, os.WriteFile(invalidFile, []byte(`{"retry": {{.RetryAfter}`), 0644))
+
+		err := (&MaintenanceHandler{JSONTemplate: invalidFile}).Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to parse JSON template")
+	})
+
+	t.Run("template rendering invalid JSON", func(t *testing.T) {
+		invalidFile := filepath.Join(tmpDir, "not-json.json")
+		require.NoError(t, os.WriteFile(invalidFile, []byte(`{"message": {{.Message}}}`), 0644))
+
+		err := (&MaintenanceHandler{JSONTemplate: invalidFile}).Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "JSON template does not render valid JSON")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		err := (&MaintenanceHandler{JSONTemplate: filepath.Join(tmpDir, "missing.json")}).Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read JSON template file")
+	})
+}
+
+func TestMaintenanceHandler_Message(t *testing.T) {
+	h := &MaintenanceHandler{Message: "Back at noon"}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabled = true
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(rec, req, nil))
+
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "Back at noon", body["message"])
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/plain")
+	rec = httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(rec, req, nil))
+	assert.True(t, strings.HasPrefix(rec.Body.String(), "Back at noon\n"))
+}
+
+func TestParseCaddyfile_MessageAndJSONTemplate(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		message "Back at noon"
+		json_template /etc/caddy/maintenance.json
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "Back at noon", actualHandler.Message)
+	assert.Equal(t, "/etc/caddy/maintenance.json", actualHandler.JSONTemplate)
+}
+
+func TestMaintenanceHandler_JSONKeys(t *testing.T) {
+	h := &MaintenanceHandler{
+		Message:      "Back at noon",
+		RetryAfter:   120,
+		EstimatedEnd: "2026-03-01T12:00:00Z",
+		RequestID:    true,
+		JSONKeys:     map[string]string{"status": "error", "message": "detail", "request_id": "trace_id"},
+	}
+	require.NoError(t, h.Validate())
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabled = true
+
+	serve := func() map[string]any {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, req, nil))
+
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	body := serve()
+	assert.Equal(t, "error", body["error"])
+	assert.Equal(t, "Back at noon", body["detail"])
+	assert.NotEmpty(t, body["trace_id"])
+	// Keys that are not renamed keep their name
+	assert.Equal(t, float64(120), body["retry_after"])
+	assert.Equal(t, "2026-03-01T12:00:00Z", body["estimated_end"])
+	for _, key := range []string{"status", "message", "request_id"} {
+		assert.NotContains(t, body, key)
+	}
+
+	t.Run("lockdown", func(t *testing.T) {
+		h.Lockdown = true
+		defer func() { h.Lockdown = false }()
+
+		body := serve()
+		assert.Equal(t, "error", body["error"])
+		assert.Equal(t, "Access restricted", body["detail"])
+		assert.NotContains(t, body, "message")
+	})
+}
+
+func TestMaintenanceHandler_Validate_JSONKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     map[string]string
+		expected string
+	}{
+		{name: "unknown key", keys: map[string]string{"detail": "message"}, expected: "invalid json_keys key 'detail'"},
+		{name: "empty key", keys: map[string]string{"message": " "}, expected: "json_keys key 'message' cannot be renamed to an empty key"},
+		{name: "key already in use", keys: map[string]string{"message": "status"}, expected: "json_keys renames 'status' and 'message' to the same key 'status'"},
+		{name: "keys renamed alike", keys: map[string]string{"status": "error", "message": "error"}, expected: "to the same key 'error'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&MaintenanceHandler{JSONKeys: tt.keys}).Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+
+	// Swapping two keys leaves distinct keys
+	assert.NoError(t, (&MaintenanceHandler{JSONKeys: map[string]string{"status": "message", "message": "status"}}).Validate())
+}
+
+func TestParseCaddyfile_JSONKeys(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		json_keys message detail
+		json_keys {
+			status error
+			request_id trace_id
+		}
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"message": "detail", "status": "error", "request_id": "trace_id"}, h.JSONKeys)
+
+	for _, input := range []string{
+		"maintenance {\n\tjson_keys message\n}",
+		"maintenance {\n\tjson_keys message detail extra\n}",
+		"maintenance {\n\tjson_keys {\n\t\tstatus\n\t}\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceDirectiveOrder(t *testing.T) {
+	// Directives are deliberately listed out of order
+	input := `:8080 {
+		respond "live site"
+		basic_auth {
+			admin $2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi
+		}
+		maintenance {
+			retry_after 60
+		}
+		rewrite * /index.html
+		header X-Frame-Options DENY
+	}`
+
+	adapter := caddyconfig.GetAdapter("caddyfile")
+	require.NotNil(t, adapter)
+	config, _, err := adapter.Adapt([]byte(input), nil)
+	require.NoError(t, err)
+
+	adapted := string(config)
+	position := func(handler string) int {
+		index := strings.Index(adapted, `"handler":"`+handler+`"`)
+		require.NotEqual(t, -1, index, "handler %s not found in %s", handler, adapted)
+		return index
+	}
+
+	maintenance := position("fops_maintenance")
+	assert.Less(t, position("headers"), maintenance)
+	assert.Less(t, position("rewrite"), maintenance)
+	assert.Less(t, maintenance, position("authentication"))
+	assert.Less(t, maintenance, position("static_response"))
+}
+
+func TestCaddyValidate(t *testing.T) {
+	// Caddy recognizes module maps by their json.RawMessage type, which is
+	// an alias of jsontext.Value when built with GOEXPERIMENT=jsonv2
+	if reflect.TypeOf(json.RawMessage{}).PkgPath() != "encoding/json" {
+		t.Skip("Caddy cannot load modules when json.RawMessage is an alias")
+	}
+
+	templateFile := filepath.Join(t.TempDir(), "maintenance.html")
+	require.NoError(t, os.WriteFile(templateFile, []byte("<h1>{{.Message}}</h1>"), 0644))
+
+	validate := func(t *testing.T, maintenanceBlock string) error {
+		t.Helper()
+		input := ":8080 {\n\tmaintenance {\n" + maintenanceBlock + "\n\t}\n\trespond \"live site\"\n}"
+		config, _, err := caddyconfig.GetAdapter("caddyfile").Adapt([]byte(input), nil)
+		require.NoError(t, err)
+
+		var cfg caddy.Config
+		require.NoError(t, json.Unmarshal(config, &cfg))
+		return caddy.Validate(&cfg)
+	}
+
+	t.Run("good config", func(t *testing.T) {
+		err := validate(t, "template "+templateFile+"\nallowed_ips 10.0.0.0/8 192.168.1.1\nmessage \"Back soon\"")
+		assert.NoError(t, err)
+	})
+
+	tests := []struct {
+		name     string
+		block    string
+		expected string
+	}{
+		{name: "missing template file", block: "template " + filepath.Join(t.TempDir(), "missing.html"), expected: "failed to read template file"},
+		{name: "AS entry without database", block: "allowed_ips AS64500", expected: "AS entries in allowed_ips require an asn_database"},
+		{name: "invalid template URL", block: "template_url ftp://example.com/page.html", expected: "invalid template_url"},
+		{name: "status file in a missing directory", block: "status_file " + filepath.Join(t.TempDir(), "missing", "status.json"), expected: "invalid status_file"},
+		{name: "flag file without key", block: "flag_file " + filepath.Join(t.TempDir(), "flags.json"), expected: "flag_file requires a flag_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(t, tt.block)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+
+	t.Run("JSON config checked by Validate", func(t *testing.T) {
+		var cfg caddy.Config
+		require.NoError(t, json.Unmarshal([]byte(`{"apps": {"http": {"servers": {"srv0": {
+			"listen": [":8080"],
+			"routes": [{"handle": [{"handler": "fops_maintenance", "minimal_response": "sometimes"}]}]
+		}}}}}`), &cfg))
+
+		err := caddy.Validate(&cfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid minimal_response 'sometimes'")
+	})
+}
+
+func TestMaintenanceHandler_Validate_WrittenFiles(t *testing.T) {
+	dir := t.TempDir()
+	notADirectory := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(notADirectory, nil, 0644))
+
+	assert.NoError(t, (&MaintenanceHandler{StatusFile: filepath.Join(dir, "status.json"), TemplateCacheFile: filepath.Join(dir, "cache.html")}).Validate())
+
+	tests := []struct {
+		name     string
+		handler  *MaintenanceHandler
+		expected string
+	}{
+		{name: "missing status directory", handler: &MaintenanceHandler{StatusFile: filepath.Join(dir, "missing", "status.json")}, expected: "invalid status_file"},
+		{name: "missing redundant status directory", handler: &MaintenanceHandler{StatusFiles: []string{filepath.Join(dir, "missing", "status.json")}}, expected: "invalid status_file"},
+		{name: "status parent is a file", handler: &MaintenanceHandler{StatusFile: filepath.Join(notADirectory, "status.json")}, expected: "is not a directory"},
+		{name: "missing template cache directory", handler: &MaintenanceHandler{TemplateCacheFile: filepath.Join(dir, "missing", "cache.html")}, expected: "invalid template_cache_file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestMaintenanceHandler_TemplateURL(t *testing.T) {
+	var (
+		fail        atomic.Bool
+		contentType atomic.Value
+	)
+	contentType.Store("text/html; charset=utf-8")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", contentType.Load().(string))
+		fmt.Fprint(w, "<h1>Central maintenance page</h1>")
+	}))
+	t.Cleanup(server.Close)
+
+	cacheFile := filepath.Join(t.TempDir(), "template-cache.html")
+
+	// waitForFetch waits for the background fetch started by Provision
+	waitForFetch := func(t *testing.T, h *MaintenanceHandler) {
+		t.Helper()
+		require.NotNil(t, h.templateFetchDone)
+		<-h.templateFetchDone
+	}
+
+	t.Run("fetched and cached", func(t *testing.T) {
+		h := &MaintenanceHandler{TemplateURL: server.URL, TemplateCacheFile: cacheFile}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+		waitForFetch(t, h)
+		assert.Equal(t, "<h1>Central maintenance page</h1>", h.htmlTemplate())
+		assert.Empty(t, h.HTMLTemplate, "the configuration is left as written")
+
+		cached, err := os.ReadFile(cacheFile)
+		require.NoError(t, err)
+		assert.Equal(t, "<h1>Central maintenance page</h1>", string(cached))
+
+		// Provisioning the same configuration again fetches it again
+		require.NoError(t, h.Provision(caddy.Context{}))
+		waitForFetch(t, h)
+		assert.Equal(t, "<h1>Central maintenance page</h1>", h.htmlTemplate())
+	})
+
+	t.Run("falls back to the cache", func(t *testing.T) {
+		fail.Store(true)
+		t.Cleanup(func() { fail.Store(false) })
+
+		h := &MaintenanceHandler{TemplateURL: server.URL, TemplateCacheFile: cacheFile}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+		assert.Equal(t, "<h1>Central maintenance page</h1>", h.htmlTemplate())
+
+		waitForFetch(t, h)
+		assert.Equal(t, "<h1>Central maintenance page</h1>", h.htmlTemplate())
+	})
+
+	t.Run("serves the built-in page without cache", func(t *testing.T) {
+		fail.Store(true)
+		t.Cleanup(func() { fail.Store(false) })
+
+		h := &MaintenanceHandler{TemplateURL: server.URL, TemplateCacheFile: filepath.Join(t.TempDir(), "missing.html"), DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+		waitForFetch(t, h)
+		assert.Empty(t, h.htmlTemplate())
+
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil), next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), `class="maintenance-container"`)
+	})
+
+	t.Run("rejects other content types", func(t *testing.T) {
+		contentType.Store("application/json")
+		t.Cleanup(func() { contentType.Store("text/html; charset=utf-8") })
+
+		h := &MaintenanceHandler{TemplateURL: server.URL}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+		waitForFetch(t, h)
+		assert.Empty(t, h.htmlTemplate())
+
+		_, err := h.downloadTemplate(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unexpected content type 'application/json'")
+	})
+
+	t.Run("rejects non-HTTP URLs", func(t *testing.T) {
+		for _, templateURL := range []string{"file:///etc/passwd", "not a url", "http://"} {
+			err := (&MaintenanceHandler{TemplateURL: templateURL}).Validate()
+			require.Error(t, err, templateURL)
+			assert.Contains(t, err.Error(), "invalid template_url")
+		}
+	})
+
+	t.Run("does not delay provisioning", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		t.Cleanup(slow.Close)
+
+		h := &MaintenanceHandler{TemplateURL: slow.URL}
+		start := time.Now()
+		require.NoError(t, h.Provision(caddy.Context{}))
+		assert.Less(t, time.Since(start), time.Second)
+
+		// Cleanup cancels the pending fetch
+		require.NoError(t, h.Cleanup())
+		assert.Nil(t, h.templateFetchDone)
+		assert.Empty(t, h.htmlTemplate())
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		t.Cleanup(slow.Close)
+
+		h := &MaintenanceHandler{TemplateURL: slow.URL, TemplateFetchTimeout: caddy.Duration(50 * time.Millisecond)}
+		_, err := h.downloadTemplate(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to fetch template")
+	})
+
+	t.Run("a template file takes precedence", func(t *testing.T) {
+		templateFile := filepath.Join(t.TempDir(), "local.html")
+		require.NoError(t, os.WriteFile(templateFile, []byte("<h1>Local</h1>"), 0644))
+
+		h := &MaintenanceHandler{HTMLTemplate: templateFile, TemplateURL: "http://127.0.0.1:1"}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		assert.Equal(t, "<h1>Local</h1>", h.HTMLTemplate)
+	})
+}
+
+func TestParseCaddyfile_TemplateURL(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		template_url https://status.example.com/maintenance.html
+		template_cache_file /var/cache/caddy/maintenance.html
+		template_fetch_timeout 5s
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "https://status.example.com/maintenance.html", actualHandler.TemplateURL)
+	assert.Equal(t, "/var/cache/caddy/maintenance.html", actualHandler.TemplateCacheFile)
+	assert.Equal(t, caddy.Duration(5*time.Second), actualHandler.TemplateFetchTimeout)
+
+	for _, input := range []string{
+		"maintenance {\n\ttemplate_fetch_timeout 0\n}",
+		"maintenance {\n\ttemplate_fetch_timeout later\n}",
+		"maintenance {\n\ttemplate_url\n}",
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(input)})
+		assert.Error(t, err, input)
+	}
+}
+
+// failingResponseWriter records headers but fails every body write
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+	err error
+}
+
+func (w *failingResponseWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestMaintenanceHandler_ServeHTTP_WriteErrors(t *testing.T) {
+	brokenPipe := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+	connectionReset := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}
+
+	tests := []struct {
+		name        string
+		accept      string
+		writeErr    error
+		expectError bool
+	}{
+		{name: "broken pipe on HTML", accept: "text/html", writeErr: brokenPipe},
+		{name: "connection reset on JSON", accept: "application/json", writeErr: connectionReset},
+		{name: "closed connection on text", accept: "text/plain", writeErr: net.ErrClosed},
+		{name: "canceled request", accept: "text/html", writeErr: fmt.Errorf("write: %w", context.Canceled)},
+		{name: "other write error", accept: "text/html", writeErr: errors.New("disk quota exceeded"), expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.DebugLevel)
+			h := &MaintenanceHandler{enabled: true, logger: zap.New(core)}
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", tt.accept)
+			w := &failingResponseWriter{ResponseRecorder: httptest.NewRecorder(), err: tt.writeErr}
+
+			err := h.ServeHTTP(w, req, nil)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, tt.writeErr)
+				assert.Zero(t, logs.FilterMessage("Client went away while writing maintenance page").Len())
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Equal(t, 1, logs.FilterMessage("Client went away while writing maintenance page").Len())
+		})
+	}
+}
+
+func TestMaintenanceHandler_ServeHTTP_RenderErrorsAreReported(t *testing.T) {
+	h := &MaintenanceHandler{enabled: true, HTMLTemplate: "{{.Missing.Field}}", TemplateFailure: "error"}
+
+	w := httptest.NewRecorder()
+	err := h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to render template")
+	assert.Empty(t, w.Body.String())
+}
+
+func TestMaintenanceHandler_ServeHTTP_RenderErrorsFallBack(t *testing.T) {
+	t.Run("maintenance page", func(t *testing.T) {
+		core, logs := observer.New(zap.DebugLevel)
+		h := &MaintenanceHandler{enabled: true, HTMLTemplate: "<p>{{.Missing.Field}}</p>", logger: zap.New(core)}
+
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil), nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, defaultHTMLContentType, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "Be Back Soon!")
+
+		entries := logs.FilterMessage("Maintenance template failed to render, serving the built-in page").All()
+		require.Len(t, entries, 1)
+		assert.Equal(t, zap.WarnLevel, entries[0].Level)
+		assert.Contains(t, entries[0].ContextMap()["error"], "failed to render template")
+	})
+
+	t.Run("lockdown page", func(t *testing.T) {
+		h := &MaintenanceHandler{Lockdown: true, LockdownTemplate: "{{.Missing.Field}}", enabled: true}
+
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil), nil))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "<title>Access Restricted</title>")
+	})
+}
+
+func TestMaintenanceHandler_ServeHTTP_FailOpen(t *testing.T) {
+	nextCalled := false
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		nextCalled = true
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		nextCalled = false
+		h := &MaintenanceHandler{enabled: true, HTMLTemplate: "{{.Missing.Field}}", TemplateFailure: "error"}
+
+		err := h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), next)
+		require.Error(t, err)
+		assert.False(t, nextCalled)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		nextCalled = false
+		core, logs := observer.New(zap.DebugLevel)
+		h := &MaintenanceHandler{enabled: true, HTMLTemplate: "{{.Missing.Field}}", TemplateFailure: "error", FailOpen: true, logger: zap.New(core)}
+
+		w := httptest.NewRecorder()
+		w.Header().Set("X-Upstream", "kept")
+		require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil), next))
+
+		assert.True(t, nextCalled)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Retry-After"))
+		assert.Empty(t, w.Header().Get("Vary"))
+		assert.Equal(t, "kept", w.Header().Get("X-Upstream"))
+		assert.Equal(t, 1, logs.FilterMessage("Failed to serve maintenance page, forwarding request").Len())
+	})
+
+	t.Run("page already written", func(t *testing.T) {
+		nextCalled = false
+		writeErr := errors.New("disk full")
+		h := &MaintenanceHandler{enabled: true, FailOpen: true}
+
+		w := &failingResponseWriter{ResponseRecorder: httptest.NewRecorder(), err: writeErr}
+		err := h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil), next)
+		require.ErrorIs(t, err, writeErr)
+		assert.False(t, nextCalled)
+	})
+}
+
+func TestParseCaddyfile_TemplateFailure(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		template_failure error
+		fail_open true
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "error", actualHandler.TemplateFailure)
+	assert.True(t, actualHandler.FailOpen)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		template_failure ignore
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid template_failure value")
+
+	h := &MaintenanceHandler{TemplateFailure: "ignore"}
+	err = h.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid template_failure")
+}
+
+func TestMaintenanceHandler_HTMLContentType(t *testing.T) {
+	h := &MaintenanceHandler{HTMLContentType: "application/xhtml+xml; charset=iso-8859-1"}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabled = true
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(rec, req, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "application/xhtml+xml; charset=iso-8859-1", rec.Header().Get("Content-Type"))
+
+	// The JSON response keeps its own content type
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec = httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(rec, req, nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	// Default unchanged
+	h = &MaintenanceHandler{enabled: true}
+	rec = httptest.NewRecorder()
+	require.NoError(t, h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil), nil))
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	err := (&MaintenanceHandler{HTMLContentType: "text/html; charset"}).Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid html_content_type")
+}
+
+func TestParseCaddyfile_HTMLContentType(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		html_content_type "text/html; charset=iso-8859-1"
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=iso-8859-1", actual.(*MaintenanceHandler).HTMLContentType)
+}
+
+func TestMaintenanceHandler_Provision_RedundantStatusFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	primary := filepath.Join(tmpDir, "primary.json")
+	mirror := filepath.Join(tmpDir, "mirror.json")
+	require.NoError(t, os.WriteFile(mirror, []byte(`{"enabled": true}`), 0644))
+
+	// A missing primary falls back to the mirror
+	h := &MaintenanceHandler{StatusFile: primary, StatusFiles: []string{mirror}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.True(t, h.enabled)
+
+	// So does a primary that does not parse
+	require.NoError(t, os.WriteFile(primary, []byte(`{"enabled": tr`), 0644))
+	h = &MaintenanceHandler{StatusFile: primary, StatusFiles: []string{mirror}}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.True(t, h.enabled)
+
+	// The first readable copy wins
+	require.NoError(t, os.WriteFile(primary, []byte(`{"enabled": false}`), 0644))
+	h = &MaintenanceHandler{StatusFile: primary, StatusFiles: []string{mirror}, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	assert.False(t, h.enabled)
+}
+
+func TestParseCaddyfile_StatusFiles(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		status_file /var/lib/caddy/maintenance.json /mnt/backup/maintenance.json
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+
+	actualHandler, ok := actual.(*MaintenanceHandler)
+	require.True(t, ok)
+	assert.Equal(t, "/var/lib/caddy/maintenance.json", actualHandler.StatusFile)
+	assert.Equal(t, []string{"/mnt/backup/maintenance.json"}, actualHandler.StatusFiles)
+}
+
+func TestMaintenanceHandler_MaxDurationWarn(t *testing.T) {
+	setEnabled := func(h *MaintenanceHandler, enabled bool) {
+		h.enabledMux.Lock()
+		defer h.enabledMux.Unlock()
+		h.setEnabledLocked(enabled, time.Now())
+	}
+
+	t.Run("warning fires once past the threshold", func(t *testing.T) {
+		core, logs := observer.New(zap.WarnLevel)
+		h := &MaintenanceHandler{MaxDurationWarn: caddy.Duration(20 * time.Millisecond), logger: zap.New(core)}
+		setEnabled(h, true)
+		h.startDurationWatcher()
+		defer h.stopDurationWatcher()
+
+		assert.Eventually(t, func() bool {
+			return logs.FilterMessage("Maintenance mode enabled for longer than max_duration_warn").Len() > 0
+		}, time.Second, 5*time.Millisecond)
+
+		time.Sleep(50 * time.Millisecond)
+		warnings := logs.FilterMessage("Maintenance mode enabled for longer than max_duration_warn").All()
+		require.Len(t, warnings, 1)
+		assert.Equal(t, 20*time.Millisecond, warnings[0].ContextMap()["max_duration_warn"])
+	})
+
+	t.Run("warning resets when disabled", func(t *testing.T) {
+		core, logs := observer.New(zap.WarnLevel)
+		h := &MaintenanceHandler{MaxDurationWarn: caddy.Duration(time.Hour), logger: zap.New(core)}
+		start := time.Now()
+		setEnabled(h, true)
+
+		h.checkMaxDuration(start.Add(30 * time.Minute))
+		assert.Zero(t, logs.Len())
+
+		h.checkMaxDuration(start.Add(2 * time.Hour))
+		h.checkMaxDuration(start.Add(3 * time.Hour))
+		assert.Equal(t, 1, logs.Len())
+
+		setEnabled(h, false)
+		h.checkMaxDuration(start.Add(4 * time.Hour))
+		assert.Equal(t, 1, logs.Len())
+
+		setEnabled(h, true)
+		h.checkMaxDuration(time.Now().Add(2 * time.Hour))
+		assert.Equal(t, 2, logs.Len())
+	})
+
+	t.Run("cleanup stops the watcher", func(t *testing.T) {
+		h := &MaintenanceHandler{MaxDurationWarn: caddy.Duration(time.Minute)}
+		h.startDurationWatcher()
+		require.NotNil(t, h.durationWatcher)
+		require.NoError(t, h.Cleanup())
+		assert.Nil(t, h.durationWatcher)
+	})
+}
+
+func TestRunTicker(t *testing.T) {
+	var calls atomic.Int32
+	ticker := runTicker(5*time.Millisecond, func() {
+		calls.Add(1)
+	})
+
+	require.Eventually(t, func() bool {
+		return calls.Load() >= 2
+	}, time.Second, time.Millisecond)
+
+	ticker.stop()
+	stopped := calls.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, calls.Load())
+
+	// Stopping a ticker that was never started does nothing
+	var none *backgroundTicker
+	none.stop()
+}
+
+func TestParseCaddyfile_MaxDurationWarn(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		max_duration_warn 2h
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+	assert.Equal(t, caddy.Duration(2*time.Hour), actual.(*MaintenanceHandler).MaxDurationWarn)
+
+	for _, input := range []string{
+		"maintenance {\n\tmax_duration_warn 0s\n}",
+		"maintenance {\n\tmax_duration_warn later\n}",
+		"maintenance {\n\tmax_duration_warn\n}",
+	} {
+		_, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(input)})
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_ProtocolOverrides(t *testing.T) {
+	tmpDir := t.TempDir()
+	defaultTemplate := filepath.Join(tmpDir, "default.html")
+	lightTemplate := filepath.Join(tmpDir, "light.html")
+	http2Template := filepath.Join(tmpDir, "http2.html")
+	frTemplate := filepath.Join(tmpDir, "fr.html")
+	require.NoError(t, os.WriteFile(defaultTemplate, []byte("<p>default page over {{.Protocol}}</p>"), 0644))
+	require.NoError(t, os.WriteFile(lightTemplate, []byte("<p>light page over {{.Protocol}}</p>"), 0644))
+	require.NoError(t, os.WriteFile(http2Template, []byte("<p>http2 page</p>"), 0644))
+	require.NoError(t, os.WriteFile(frTemplate, []byte("<p>page en maintenance</p>"), 0644))
+
+	h := &MaintenanceHandler{
+		HTMLTemplate: defaultTemplate,
+		ProtocolOverrides: map[string]string{
+			"http/1.0": lightTemplate,
+			"HTTP/2":   http2Template,
+		},
+		TemplatesByLang: map[string]string{"fr": frTemplate},
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	h.enabledMux.Lock()
+	h.enabled = true
+	h.enabledMux.Unlock()
+
+	tests := []struct {
+		name           string
+		proto          string
+		major, minor   int
+		acceptLanguage string
+		expectedBody   string
+	}{
+		{name: "Exact protocol", proto: "HTTP/1.0", major: 1, minor: 0, expectedBody: "light page over HTTP/1.0"},
+		{name: "Exact protocol wins over language", proto: "HTTP/1.0", major: 1, minor: 0, acceptLanguage: "fr", expectedBody: "light page"},
+		{name: "Major version", proto: "HTTP/2.0", major: 2, minor: 0, expectedBody: "http2 page"},
+		{name: "Unmatched protocol uses language", proto: "HTTP/1.1", major: 1, minor: 1, acceptLanguage: "fr", expectedBody: "page en maintenance"},
+		{name: "Unmatched protocol uses default", proto: "HTTP/3.0", major: 3, minor: 0, expectedBody: "default page over HTTP/3.0"},
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			req.Proto, req.ProtoMajor, req.ProtoMinor = tt.proto, tt.major, tt.minor
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedBody)
+		})
+	}
+}
+
+func TestMaintenanceHandler_ProtocolOverrides_Invalid(t *testing.T) {
+	h := &MaintenanceHandler{
+		ProtocolOverrides: map[string]string{"SPDY/3": filepath.Join(t.TempDir(), "spdy.html")},
+	}
+	err := h.Provision(caddy.Context{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid protocol 'SPDY/3' in protocol_overrides")
+
+	h = &MaintenanceHandler{
+		ProtocolOverrides: map[string]string{"HTTP/1.0": filepath.Join(t.TempDir(), "missing.html")},
+	}
+	err = h.Provision(caddy.Context{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read template file for protocol 'HTTP/1.0'")
+}
+
+func TestParseCaddyfile_ProtocolOverrides(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		protocol_overrides HTTP/1.0 /path/to/light.html
+		protocol_overrides {
+			HTTP/3 /path/to/h3.html
+		}
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"HTTP/1.0": "/path/to/light.html",
+		"HTTP/3":   "/path/to/h3.html",
+	}, actual.(*MaintenanceHandler).ProtocolOverrides)
+
+	d = caddyfile.NewTestDispenser(`maintenance {
+		protocol_overrides HTTP/1.0
+	}`)
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.Error(t, err)
+}
+
+func TestIsIPAllowed_IndividualIPsAsNetworks(t *testing.T) {
+	h := &MaintenanceHandler{
+		AllowedIPs: []string{"192.168.1.100", "2001:db8::1", "::ffff:10.0.0.1"},
+	}
+	require.NoError(t, h.parseAllowedIPs())
+
+	assert.Equal(t, []string{"192.168.1.100/32", "2001:db8::1/128", "10.0.0.1/32"}, func() []string {
+		networks := make([]string, 0, len(h.allowRules))
+		for _, rule := range h.allowRules {
+			for _, network := range rule.networks {
+				networks = append(networks, network.String())
+			}
+		}
+		return networks
+	}())
+
+	tests := []struct {
+		clientIP string
+		expected bool
+	}{
+		{"192.168.1.100", true},
+		{"::ffff:192.168.1.100", true},
+		{"192.168.1.101", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+		{"10.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"10.0.0.2", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, h.isIPAllowed(tt.clientIP), tt.clientIP)
+	}
+
+	// Parse errors are unchanged
+	for input, expected := range map[string]string{
+		"192.168.1.0/33": "invalid CIDR notation '192.168.1.0/33'",
+		"999.1.1.1":      "invalid IP address '999.1.1.1'",
+	} {
+		h := &MaintenanceHandler{AllowedIPs: []string{input}}
+		err := h.parseAllowedIPs()
+		require.Error(t, err, input)
+		assert.Contains(t, err.Error(), expected)
+	}
+}
+
+func TestIsIPAllowed_Negation(t *testing.T) {
+	stubLookupIP(t, func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.168.1.60")}, nil
+	})
+
+	tests := []struct {
+		name       string
+		allowedIPs []string
+		clientIP   string
+		expected   bool
+	}{
+		{name: "negated IP inside an allowed CIDR", allowedIPs: []string{"192.168.1.0/24", "!192.168.1.50"}, clientIP: "192.168.1.50", expected: false},
+		{name: "other IPs of the CIDR", allowedIPs: []string{"192.168.1.0/24", "!192.168.1.50"}, clientIP: "192.168.1.51", expected: true},
+		{name: "negated CIDR inside an allowed CIDR", allowedIPs: []string{"10.0.0.0/8", "!10.1.0.0/16"}, clientIP: "10.1.2.3", expected: false},
+		{name: "later entry allows again", allowedIPs: []string{"10.0.0.0/8", "!10.1.0.0/16", "10.1.2.0/24"}, clientIP: "10.1.2.3", expected: true},
+		{name: "negation before the allowed CIDR is overridden", allowedIPs: []string{"!192.168.1.50", "192.168.1.0/24"}, clientIP: "192.168.1.50", expected: true},
+		{name: "negation alone allows nothing", allowedIPs: []string{"!192.168.1.50"}, clientIP: "192.168.1.51", expected: false},
+		{name: "wildcard allows any address", allowedIPs: []string{"*"}, clientIP: "2001:db8::1", expected: true},
+		{name: "wildcard with exception", allowedIPs: []string{"*", "!203.0.113.7"}, clientIP: "203.0.113.7", expected: false},
+		{name: "wildcard with IPv6 exception", allowedIPs: []string{"*", "!2001:db8::/32"}, clientIP: "2001:db8::1", expected: false},
+		{name: "negated wildcard", allowedIPs: []string{"10.0.0.0/8", "!*"}, clientIP: "10.0.0.1", expected: false},
+		{name: "negated hostname", allowedIPs: []string{"192.168.1.0/24", "!kiosk.example.com"}, clientIP: "192.168.1.60", expected: false},
+		{name: "stray spaces", allowedIPs: []string{" 192.168.1.0/24 ", " !192.168.1.50 "}, clientIP: "192.168.1.50", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{AllowedIPs: tt.allowedIPs}
+			require.NoError(t, h.parseAllowedIPs())
+			assert.Equal(t, tt.expected, h.isIPAllowed(tt.clientIP))
+		})
+	}
+
+	for _, input := range []string{"!", "!999.1.1.1", "!10.0.0.0/33", "!!10.0.0.1"} {
+		h := &MaintenanceHandler{AllowedIPs: []string{input}}
+		assert.Error(t, h.parseAllowedIPs(), input)
+	}
+}
+
+func TestMaintenanceHandler_ServeHTTP_NegatedAllowedIP(t *testing.T) {
+	h := &MaintenanceHandler{AllowedIPs: []string{"192.168.1.0/24", "!192.168.1.50"}, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	for remoteAddr, expected := range map[string]int{
+		"192.168.1.10:1234": http.StatusOK,
+		"192.168.1.50:1234": http.StatusServiceUnavailable,
+	} {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, expected, w.Code, remoteAddr)
+	}
+}
+
+func TestMaintenanceHandler_OnlyUnknownHosts(t *testing.T) {
+	h := &MaintenanceHandler{
+		OnlyUnknownHosts: true,
+		KnownHosts:       []string{"example.com", "*.Shop.Example.", "www.example.org"},
+		DefaultEnabled:   true,
+	}
+	require.NoError(t, h.Validate())
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	tests := []struct {
+		host     string
+		expected int
+	}{
+		{host: "example.com", expected: http.StatusOK},
+		{host: "EXAMPLE.com:8443", expected: http.StatusOK},
+		{host: "example.com.", expected: http.StatusOK},
+		{host: "eu.shop.example", expected: http.StatusOK},
+		{host: "shop.example", expected: http.StatusServiceUnavailable},
+		{host: "www.example.org", expected: http.StatusOK},
+		{host: "example.org", expected: http.StatusServiceUnavailable},
+		{host: "examp1e.com", expected: http.StatusServiceUnavailable},
+		{host: "sub.example.com", expected: http.StatusServiceUnavailable},
+		{host: "parked-domain.net", expected: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(w, req, next))
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+
+	t.Run("unknown hosts served while maintenance is disabled", func(t *testing.T) {
+		h := &MaintenanceHandler{OnlyUnknownHosts: true, KnownHosts: []string{"example.com"}}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		req := httptest.NewRequest("GET", "http://parked-domain.net", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("allowed IPs still bypass on unknown hosts", func(t *testing.T) {
+		h := &MaintenanceHandler{OnlyUnknownHosts: true, KnownHosts: []string{"example.com"}, AllowedIPs: []string{"192.168.1.100"}, DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		req := httptest.NewRequest("GET", "http://parked-domain.net", nil)
+		req.RemoteAddr = "192.168.1.100:1234"
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestMaintenanceHandler_Validate_OnlyUnknownHosts(t *testing.T) {
+	assert.Error(t, (&MaintenanceHandler{OnlyUnknownHosts: true}).Validate())
+	assert.Error(t, (&MaintenanceHandler{KnownHosts: []string{"example.com"}}).Validate())
+}
+
+func TestParseCaddyfile_OnlyUnknownHosts(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		only_unknown_hosts true
+		known_hosts example.com www.example.com
+		known_hosts *.example.org
+	}`)
+	require.NoError(t, err)
+	assert.True(t, h.OnlyUnknownHosts)
+	assert.Equal(t, []string{"example.com", "www.example.com", "*.example.org"}, h.KnownHosts)
+
+	for _, input := range []string{
+		"maintenance {\n\tonly_unknown_hosts\n}",
+		"maintenance {\n\tonly_unknown_hosts maybe\n}",
+		"maintenance {\n\tknown_hosts\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_GracePeriod(t *testing.T) {
+	h := &MaintenanceHandler{GracePeriod: caddy.Duration(200 * time.Millisecond)}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	serve := func() int {
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil), next))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve())
+
+	h.enabledMux.Lock()
+	h.toggleEnabledLocked(true, time.Now())
+	h.enabledMux.Unlock()
+
+	// Requests are forwarded during the grace period, then get the page
+	assert.Equal(t, http.StatusOK, serve())
+	require.Eventually(t, func() bool {
+		return serve() == http.StatusServiceUnavailable
+	}, 2*time.Second, 20*time.Millisecond)
+
+	t.Run("maintenance already enabled", func(t *testing.T) {
+		h := &MaintenanceHandler{GracePeriod: caddy.Duration(time.Hour)}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		h.enabledMux.Lock()
+		h.setEnabledLocked(true, time.Now().Add(-2*time.Hour))
+		// Enabling again is not a transition
+		h.toggleEnabledLocked(true, time.Now())
+		h.enabledMux.Unlock()
+
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil), next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("enabled at startup", func(t *testing.T) {
+		statusFile := filepath.Join(t.TempDir(), "status.json")
+		require.NoError(t, os.WriteFile(statusFile, []byte(`{"enabled": true}`), 0600))
+
+		for name, h := range map[string]*MaintenanceHandler{
+			"default_enabled":                {GracePeriod: caddy.Duration(time.Hour), DefaultEnabled: true},
+			"status file without started_at": {GracePeriod: caddy.Duration(time.Hour), StatusFile: statusFile},
+		} {
+			require.NoError(t, h.Provision(caddy.Context{}), name)
+			w := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil), next))
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code, name)
+		}
+	})
+}
+
+func TestParseCaddyfile_GracePeriod(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		grace_period 30s
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, caddy.Duration(30*time.Second), h.GracePeriod)
+
+	for _, input := range []string{
+		"maintenance {\n\tgrace_period\n}",
+		"maintenance {\n\tgrace_period soon\n}",
+		"maintenance {\n\tgrace_period -5s\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+
+	assert.Error(t, (&MaintenanceHandler{GracePeriod: caddy.Duration(-time.Second)}).Validate())
+}
+
+func TestMaintenanceHandler_BypassUsers(t *testing.T) {
+	h := &MaintenanceHandler{
+		BypassUsers:    []string{"alice", "ops-bot"},
+		BypassHeader:   "X-Maintenance-Bypass",
+		DefaultEnabled: true,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	newRequest := func(userID string) *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		repl := caddy.NewReplacer()
+		if userID != "" {
+			// As set by the authentication handler
+			repl.Set("http.auth.user.id", userID)
+		}
+		return req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, repl))
+	}
+
+	tests := []struct {
+		name       string
+		userID     string
+		wantCode   int
+		wantHeader string
+	}{
+		{name: "listed user", userID: "alice", wantCode: http.StatusOK, wantHeader: "user"},
+		{name: "other listed user", userID: "ops-bot", wantCode: http.StatusOK, wantHeader: "user"},
+		{name: "usernames are case sensitive", userID: "Alice", wantCode: http.StatusServiceUnavailable},
+		{name: "unlisted user", userID: "mallory", wantCode: http.StatusServiceUnavailable},
+		{name: "anonymous", wantCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(rec, newRequest(tt.userID), next))
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, tt.wantHeader, rec.Header().Get("X-Maintenance-Bypass"))
+		})
+	}
+
+	t.Run("request without replacer", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com", nil), next))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
+
+func TestParseCaddyfile_BypassUsers(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		bypass_users alice bob
+		bypass_users ops-bot
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob", "ops-bot"}, actual.(*MaintenanceHandler).BypassUsers)
+
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser("maintenance {\n\tbypass_users\n}")})
+	assert.Error(t, err)
+}
+
+func TestMaintenanceHandler_BypassClientCert(t *testing.T) {
+	h := &MaintenanceHandler{
+		BypassClientCertCN: []string{"ops-client", "deploy.ops.example.com", "oncall@example.com"},
+		BypassHeader:       "X-Maintenance-Bypass",
+		DefaultEnabled:     true,
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	newRequest := func(cert *x509.Certificate, verified bool) *http.Request {
+		req := httptest.NewRequest("GET", "https://example.com", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return req
+	}
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		verified bool
+		wantCode int
+	}{
+		{name: "common name", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "ops-client"}}, verified: true, wantCode: http.StatusOK},
+		{name: "DNS SAN", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"deploy.ops.example.com"}}, verified: true, wantCode: http.StatusOK},
+		{name: "email SAN", cert: &x509.Certificate{EmailAddresses: []string{"oncall@example.com"}}, verified: true, wantCode: http.StatusOK},
+		{name: "unlisted name", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}, verified: true, wantCode: http.StatusServiceUnavailable},
+		{name: "unverified certificate", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "ops-client"}}, verified: false, wantCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(rec, newRequest(tt.cert, tt.verified), next))
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, "cert", rec.Header().Get("X-Maintenance-Bypass"))
+			}
+		})
+	}
+
+	t.Run("plain HTTP request", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com", nil), next))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
+
+func TestParseCaddyfile_BypassClientCertCN(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`maintenance {
+		bypass_client_cert_cn ops-client deploy.ops.example.com
+	}`)
+
+	actual, err := parseCaddyfile(httpcaddyfile.Helper{Dispenser: d})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ops-client", "deploy.ops.example.com"}, actual.(*MaintenanceHandler).BypassClientCertCN)
+
+	_, err = parseCaddyfile(httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser("maintenance {\n\tbypass_client_cert_cn\n}")})
+	assert.Error(t, err)
+}
+
+func TestMaintenanceHandler_Snapshot(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	snapshot := "<html><body><h1>Shop</h1><p>{{not a template}}</p></body></html>"
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot.html")
+	require.NoError(t, os.WriteFile(snapshotFile, []byte(snapshot), 0644))
+
+	tests := []struct {
+		name           string
+		snapshotStatus int
+		expectedStatus int
+	}{
+		{name: "default status", expectedStatus: http.StatusServiceUnavailable},
+		{name: "configured 200", snapshotStatus: http.StatusOK, expectedStatus: http.StatusOK},
+		{name: "configured 503", snapshotStatus: http.StatusServiceUnavailable, expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &MaintenanceHandler{SnapshotFile: snapshotFile, SnapshotStatus: tt.snapshotStatus, DefaultEnabled: true, RetryAfter: 120}
+			require.NoError(t, h.Provision(caddy.Context{}))
+			require.NoError(t, h.Validate())
+
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			w := httptest.NewRecorder()
+			require.NoError(t, h.ServeHTTP(w, req, next))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, snapshot, w.Body.String())
+			assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+			assert.Equal(t, strconv.Itoa(len(snapshot)), w.Header().Get("Content-Length"))
+			assert.Equal(t, "120", w.Header().Get("Retry-After"))
+		})
+	}
+
+	t.Run("JSON clients still get the JSON response", func(t *testing.T) {
+		h := &MaintenanceHandler{SnapshotFile: snapshotFile, SnapshotStatus: http.StatusOK, DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.NotContains(t, w.Body.String(), "<h1>Shop</h1>")
+	})
+
+	t.Run("missing snapshot file", func(t *testing.T) {
+		h := &MaintenanceHandler{SnapshotFile: filepath.Join(t.TempDir(), "missing.html")}
+		err := h.Provision(caddy.Context{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read snapshot file")
+	})
+
+	t.Run("invalid combinations", func(t *testing.T) {
+		for _, tt := range []struct {
+			handler  *MaintenanceHandler
+			expected string
+		}{
+			{handler: &MaintenanceHandler{SnapshotStatus: http.StatusOK}, expected: "snapshot_status requires a snapshot_file"},
+			{handler: &MaintenanceHandler{SnapshotFile: snapshotFile, SnapshotStatus: 42}, expected: "invalid snapshot_status 42"},
+			{handler: &MaintenanceHandler{SnapshotFile: snapshotFile, DefaultTemplate: "dark"}, expected: "snapshot_file cannot be combined"},
+		} {
+			err := tt.handler.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		}
+	})
+}
+
+func TestParseCaddyfile_Snapshot(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		snapshot_file /var/www/snapshot.html
+		snapshot_status 200
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, "/var/www/snapshot.html", h.SnapshotFile)
+	assert.Equal(t, http.StatusOK, h.SnapshotStatus)
+
+	for _, input := range []string{
+		"maintenance {\n\tsnapshot_file\n}",
+		"maintenance {\n\tsnapshot_status\n}",
+		"maintenance {\n\tsnapshot_status ok\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_DisableRetryAfter(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		handler *MaintenanceHandler
+		accept  string
+	}{
+		{name: "HTML page", handler: &MaintenanceHandler{DisableRetryAfter: true, RetryAfter: 600, DefaultEnabled: true}},
+		{name: "JSON response", handler: &MaintenanceHandler{DisableRetryAfter: true, DefaultEnabled: true}, accept: "application/json"},
+		{name: "minimal response", handler: &MaintenanceHandler{DisableRetryAfter: true, MinimalResponse: minimalResponseAlways, DefaultEnabled: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.handler.Provision(caddy.Context{}))
+
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			require.NoError(t, tt.handler.ServeHTTP(w, req, next))
+
+			assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+			_, present := w.Header()["Retry-After"]
+			assert.False(t, present)
+		})
+	}
+
+	t.Run("header is sent by default", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, "300", w.Header().Get("Retry-After"))
+	})
+}
+
+func TestParseCaddyfile_DisableRetryAfter(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		disable_retry_after true
+	}`)
+	require.NoError(t, err)
+	assert.True(t, h.DisableRetryAfter)
+
+	for _, input := range []string{
+		"maintenance {\n\tdisable_retry_after\n}",
+		"maintenance {\n\tdisable_retry_after sometimes\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_Validate_RetryAfterBounds(t *testing.T) {
+	assert.NoError(t, (&MaintenanceHandler{RetryAfterMin: 60, RetryAfterMax: 3600}).Validate())
+	assert.NoError(t, (&MaintenanceHandler{RetryAfterMax: 3600}).Validate())
+
+	err := (&MaintenanceHandler{RetryAfterMin: 3600, RetryAfterMax: 60}).Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "retry_after_min 3600 is greater than retry_after_max 60")
+
+	assert.Error(t, (&MaintenanceHandler{RetryAfterMin: -1}).Validate())
+}
+
+func TestParseCaddyfile_RetryAfterBounds(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		retry_after_min 60
+		retry_after_max 3600
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, 60, h.RetryAfterMin)
+	assert.Equal(t, 3600, h.RetryAfterMax)
+
+	for _, input := range []string{
+		"maintenance {\n\tretry_after_min\n}",
+		"maintenance {\n\tretry_after_min soon\n}",
+		"maintenance {\n\tretry_after_max 0\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_InstanceLogger(t *testing.T) {
+	resetMaintenanceHandlersForTest(t)
+	// Forcing maintenance makes Provision log a warning
+	t.Setenv(forceEnv, "1")
+
+	core, logs := observer.New(zap.WarnLevel)
+	previous := contextLoggerFunc
+	contextLoggerFunc = func(caddy.Context) *zap.Logger { return zap.New(core) }
+	t.Cleanup(func() { contextLoggerFunc = previous })
+
+	h := &MaintenanceHandler{Name: "shop", RetryAfterMax: 60}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	entries := logs.FilterMessage("Maintenance mode forced by environment").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "shop", entries[0].ContextMap()["instance"])
+
+	// Entries logged while serving are tagged as well
+	h.clampRetryAfter(600)
+	entries = logs.FilterMessage("Clamped retry_after supplied via admin API").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "shop", entries[0].ContextMap()["instance"])
+
+	// Unnamed instances keep the logger untouched
+	logs.TakeAll()
+	unnamed := &MaintenanceHandler{}
+	require.NoError(t, unnamed.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = unnamed.Cleanup() })
+
+	entries = logs.FilterMessage("Maintenance mode forced by environment").All()
+	require.Len(t, entries, 1)
+	assert.NotContains(t, entries[0].ContextMap(), "instance")
+}
+
+func TestMaintenanceHandler_StatusFile_UnexpectedContentWarning(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`{"enabled": "yes"}`), 0644))
+	valid := filepath.Join(dir, "valid.json")
+	require.NoError(t, os.WriteFile(valid, []byte(`{"enabled": true}`), 0644))
+
+	core, logs := observer.New(zap.WarnLevel)
+	h := &MaintenanceHandler{StatusFile: invalid, StatusFiles: []string{valid}, logger: zap.New(core)}
+	h.loadEnabledState()
+
+	// The redundant copy is used instead of the ignored file
+	assert.True(t, currentState(h).Enabled)
+
+	entries := logs.FilterMessage("Ignoring status file with unexpected content").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, invalid, entries[0].ContextMap()["status_file"])
+	assert.Contains(t, entries[0].ContextMap()["error"], `invalid enabled value "yes"`)
+}
+
+// Regression test: ServeHTTP decides on the networks parsed at provision
+// time, never on the raw allowed_ips strings
+func TestServeHTTP_CIDRBypassUsesParsedNetworks(t *testing.T) {
+	h := &MaintenanceHandler{
+		AllowedIPs:   []string{"192.168.4.0/22"},
+		BypassHeader: "X-Maintenance-Bypass",
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	h.enabledMux.Lock()
+	h.setEnabledLocked(true, time.Now())
+	h.enabledMux.Unlock()
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w
+	}
+
+	w := serve("192.168.7.254:4242")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, bypassReasonIP, w.Header().Get("X-Maintenance-Bypass"))
+
+	// An exact string match on the configured entries is not consulted
+	h.AllowedIPs = []string{"192.168.8.1"}
+	assert.Equal(t, http.StatusServiceUnavailable, serve("192.168.8.1:4242").Code)
+	assert.Equal(t, http.StatusOK, serve("192.168.4.1:4242").Code)
+}
+
+func TestMaintenanceHandler_RequestID(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	h := &MaintenanceHandler{RequestID: true, DefaultEnabled: true}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	serve := func(requestID, accept string, withReplacer bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if withReplacer {
+			repl := caddy.NewReplacer()
+			repl.Set("http.request.uuid", "0f6a3c1e-5b7d-4c2a-9e8f-1a2b3c4d5e6f")
+			req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, repl))
+		}
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w
+	}
+
+	t.Run("passthrough", func(t *testing.T) {
+		w := serve("support-1234", "application/json", true)
+		assert.Equal(t, "support-1234", w.Header().Get("X-Request-ID"))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "support-1234", body["request_id"])
+
+		w = serve("support-1234", "", false)
+		assert.Contains(t, w.Body.String(), "Reference: support-1234")
+
+		w = serve("support-1234", "text/plain", false)
+		assert.Contains(t, w.Body.String(), "Reference: support-1234\n")
+	})
+
+	t.Run("Caddy request ID", func(t *testing.T) {
+		w := serve("", "application/json", true)
+		assert.Equal(t, "0f6a3c1e-5b7d-4c2a-9e8f-1a2b3c4d5e6f", w.Header().Get("X-Request-ID"))
+		assert.Contains(t, w.Body.String(), `"request_id":"0f6a3c1e-5b7d-4c2a-9e8f-1a2b3c4d5e6f"`)
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		first := serve("", "application/json", false)
+		second := serve("", "application/json", false)
+		id := first.Header().Get("X-Request-ID")
+		assert.NotEmpty(t, id)
+		assert.NotEqual(t, id, second.Header().Get("X-Request-ID"))
+		assert.Contains(t, first.Body.String(), `"request_id":"`+id+`"`)
+	})
+
+	t.Run("invalid client IDs are replaced", func(t *testing.T) {
+		for _, invalid := range []string{"<script>alert(1)</script> x", strings.Repeat("a", 129)} {
+			w := serve(invalid, "", true)
+			assert.Equal(t, "0f6a3c1e-5b7d-4c2a-9e8f-1a2b3c4d5e6f", w.Header().Get("X-Request-ID"), invalid)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		h := &MaintenanceHandler{DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("X-Request-ID", "support-1234")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Empty(t, w.Header().Get("X-Request-ID"))
+		assert.NotContains(t, w.Body.String(), "request_id")
+	})
+}
+
+func TestParseCaddyfile_RequestID(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		request_id true
+	}`)
+	require.NoError(t, err)
+	assert.True(t, h.RequestID)
+
+	for _, input := range []string{
+		"maintenance {\n\trequest_id\n}",
+		"maintenance {\n\trequest_id maybe\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_AlwaysBlockPaths(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := w.Write([]byte("live"))
+		return err
+	})
+	h := &MaintenanceHandler{
+		AlwaysBlockPaths: []string{"/api/v1/*", "/old%20export"},
+		AllowedIPs:       []string{"192.0.2.10"},
+	}
+	require.NoError(t, h.Provision(caddy.Context{}))
+	require.NoError(t, h.Validate())
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com"+path, nil)
+		req.RemoteAddr = "192.0.2.10:51234"
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w
+	}
+
+	for _, path := range []string{"/api/v1", "/api/v1/orders", "/api/v2/../v1/orders", "/old%20export"} {
+		w := serve(path)
+		assert.Equal(t, http.StatusGone, w.Code, path)
+		assert.Contains(t, w.Body.String(), "<html", path)
+		assert.Empty(t, w.Header().Get("Retry-After"), path)
+	}
+	assert.Equal(t, "live", serve("/api/v2/orders").Body.String())
+
+	// Allowed clients are blocked too while maintenance is enabled
+	h.enabledMux.Lock()
+	h.setEnabledLocked(true, time.Now())
+	h.enabledMux.Unlock()
+	assert.Equal(t, http.StatusGone, serve("/api/v1/orders").Code)
+	assert.Equal(t, "live", serve("/api/v2/orders").Body.String())
+
+	t.Run("configured status", func(t *testing.T) {
+		h := &MaintenanceHandler{AlwaysBlockPaths: []string{"/legacy"}, AlwaysBlockStatus: http.StatusServiceUnavailable, Lockdown: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+
+		req := httptest.NewRequest("GET", "http://example.com/legacy", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "300", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), `"message":"Service temporarily unavailable for maintenance"`)
+	})
+}
+
+func TestMaintenanceHandler_Validate_AlwaysBlockPaths(t *testing.T) {
+	assert.NoError(t, (&MaintenanceHandler{AlwaysBlockPaths: []string{"/old"}, AlwaysBlockStatus: 404}).Validate())
+
+	err := (&MaintenanceHandler{AlwaysBlockStatus: 410}).Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "always_block_status requires always_block_paths")
+
+	err = (&MaintenanceHandler{AlwaysBlockPaths: []string{"/old"}, AlwaysBlockStatus: 301}).Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid always_block_status 301")
+}
+
+func TestParseCaddyfile_AlwaysBlockPaths(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		always_block_paths /api/v1/* /export
+		always_block_paths /legacy
+		always_block_status 404
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/api/v1/*", "/export", "/legacy"}, h.AlwaysBlockPaths)
+	assert.Equal(t, 404, h.AlwaysBlockStatus)
+
+	for _, input := range []string{
+		"maintenance {\n\talways_block_paths\n}",
+		"maintenance {\n\talways_block_status\n}",
+		"maintenance {\n\talways_block_status gone\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_HtpasswdInline(t *testing.T) {
+	const hash = "$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi" // password: password
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := w.Write([]byte("live"))
+		return err
+	})
+
+	serve := func(t *testing.T, h *MaintenanceHandler, user string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		if user != "" {
+			req.SetBasicAuth(user, "password")
+		}
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w.Code
+	}
+
+	t.Run("inline entries", func(t *testing.T) {
+		h := &MaintenanceHandler{
+			HtpasswdEntries: []string{"admin:" + hash, "ops:" + hash + ":expires=2000-01-01T00:00:00Z"},
+			DefaultEnabled:  true,
+		}
+		require.NoError(t, h.Validate())
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+
+		assert.Len(t, h.htpasswdEntries, 2)
+		assert.Equal(t, defaultAuthRealm, h.AuthRealm)
+		assert.Equal(t, http.StatusOK, serve(t, h, "admin"))
+		assert.Equal(t, http.StatusUnauthorized, serve(t, h, "ops"), "expired access")
+		assert.Equal(t, http.StatusUnauthorized, serve(t, h, ""))
+	})
+
+	t.Run("environment variable", func(t *testing.T) {
+		t.Setenv("FOPS_MAINTENANCE_TEST_HTPASSWD", "# Maintenance access\nadmin:"+hash+"\n\nops:"+hash+"\n")
+		h := &MaintenanceHandler{HtpasswdEnv: "FOPS_MAINTENANCE_TEST_HTPASSWD", DefaultEnabled: true}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+
+		assert.Len(t, h.htpasswdEntries, 2)
+		assert.Equal(t, http.StatusOK, serve(t, h, "ops"))
+	})
+
+	t.Run("invalid sources", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			handler  *MaintenanceHandler
+			expected string
+		}{
+			{name: "unset variable", handler: &MaintenanceHandler{HtpasswdEnv: "FOPS_MAINTENANCE_TEST_UNSET"}, expected: "htpasswd environment variable 'FOPS_MAINTENANCE_TEST_UNSET' is not set"},
+			{name: "invalid entry", handler: &MaintenanceHandler{HtpasswdEntries: []string{"admin:" + hash, "broken"}}, expected: "invalid htpasswd format at line 2"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.handler.Provision(caddy.Context{})
+				t.Cleanup(func() { _ = tt.handler.Cleanup() })
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expected)
+			})
+		}
+
+		err := (&MaintenanceHandler{HtpasswdFile: "/etc/caddy/.htpasswd", HtpasswdEntries: []string{"admin:" + hash}}).Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "htpasswd_file, htpasswd_entries and htpasswd_env cannot be combined")
+	})
+}
+
+func TestParseCaddyfile_HtpasswdInline(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		htpasswd_entries admin:$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi
+		htpasswd_entries ops:hash1 dev:hash2
+		htpasswd_env MAINTENANCE_HTPASSWD
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin:$2a$10$92IXUNpkjO0rOQ5byMi.Ye4oKoEa3Ro9llC/.og/at2.uheWG/igi", "ops:hash1", "dev:hash2"}, h.HtpasswdEntries)
+	assert.Equal(t, "MAINTENANCE_HTPASSWD", h.HtpasswdEnv)
+
+	for _, input := range []string{
+		"maintenance {\n\thtpasswd_entries\n}",
+		"maintenance {\n\thtpasswd_env\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_RepresentationStatus(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	h := &MaintenanceHandler{
+		RepresentationStatus: map[string]int{representationJSON: http.StatusTooManyRequests},
+		RetryAfter:           120,
+		DefaultEnabled:       true,
+	}
+	require.NoError(t, h.Validate())
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/api/orders", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w
+	}
+
+	w := serve("application/json")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "120", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "120", w.Header().Get("RateLimit-Reset"))
+	assert.Contains(t, w.Body.String(), `"retry_after":120`)
+
+	for _, accept := range []string{"text/html", "", "text/plain"} {
+		w := serve(accept)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, accept)
+		assert.Equal(t, "120", w.Header().Get("Retry-After"), accept)
+		assert.Empty(t, w.Header().Get("RateLimit-Reset"), accept)
+	}
+
+	t.Run("authentication prompts and lockdown keep their status", func(t *testing.T) {
+		h := &MaintenanceHandler{
+			RepresentationStatus: map[string]int{representationJSON: http.StatusTooManyRequests},
+			Lockdown:             true,
+			DefaultEnabled:       true,
+		}
+		require.NoError(t, h.Provision(caddy.Context{}))
+		t.Cleanup(func() { _ = h.Cleanup() })
+
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestMaintenanceHandler_Validate_RepresentationStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  *MaintenanceHandler
+		expected string
+	}{
+		{name: "unknown representation", handler: &MaintenanceHandler{RepresentationStatus: map[string]int{"xml": 429}}, expected: "invalid representation_status representation 'xml'"},
+		{name: "not an error status", handler: &MaintenanceHandler{RepresentationStatus: map[string]int{"json": 200}}, expected: "invalid representation_status 200 for json"},
+		{name: "snapshot status", handler: &MaintenanceHandler{RepresentationStatus: map[string]int{"html": 500}, SnapshotFile: "snapshot.html", SnapshotStatus: 200}, expected: "representation_status html cannot be combined with snapshot_status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestParseCaddyfile_RepresentationStatus(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		representation_status json 429
+		representation_status {
+			text 429
+		}
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"json": 429, "text": 429}, h.RepresentationStatus)
+
+	for _, input := range []string{
+		"maintenance {\n\trepresentation_status json\n}",
+		"maintenance {\n\trepresentation_status json many\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestMaintenanceHandler_MaintenanceNotice(t *testing.T) {
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		_, err := w.Write([]byte("live"))
+		return err
+	})
+	h := &MaintenanceHandler{
+		AllowedIPs:              []string{"192.0.2.10"},
+		BypassPaths:             []string{"/health"},
+		MaintenanceNoticeHeader: "X-Maintenance-Active",
+		MaintenanceNoticeCookie: "maintenance_active",
+		DefaultEnabled:          true,
+	}
+	require.NoError(t, h.Validate())
+	require.NoError(t, h.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = h.Cleanup() })
+
+	serve := func(remoteAddr, path string, cookie bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "https://example.com"+path, nil)
+		req.RemoteAddr = remoteAddr
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: "maintenance_active", Value: "active"})
+		}
+		w := httptest.NewRecorder()
+		require.NoError(t, h.ServeHTTP(w, req, next))
+		return w
+	}
+
+	w := serve("192.0.2.10:51234", "/", false)
+	assert.Equal(t, "live", w.Body.String())
+	assert.Equal(t, "active", w.Header().Get("X-Maintenance-Active"))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "maintenance_active", cookies[0].Name)
+	assert.Equal(t, "active", cookies[0].Value)
+	assert.Equal(t, "/", cookies[0].Path)
+	assert.True(t, cookies[0].Secure)
+	assert.False(t, cookies[0].HttpOnly)
+
+	// Every bypass signals the maintenance
+	assert.Equal(t, "active", serve("198.51.100.1:51234", "/health", false).Header().Get("X-Maintenance-Active"))
+
+	// Blocked clients get the maintenance page without the notice
+	w = serve("198.51.100.1:51234", "/", false)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, w.Header().Get("X-Maintenance-Active"))
+	assert.Empty(t, w.Result().Cookies())
+
+	h.enabledMux.Lock()
+	h.setEnabledLocked(false, time.Time{})
+	h.enabledMux.Unlock()
+
+	// Once disabled, the notice is gone and the cookie expired
+	w = serve("192.0.2.10:51234", "/", true)
+	assert.Equal(t, "live", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Maintenance-Active"))
+	cookies = w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "maintenance_active", cookies[0].Name)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+
+	assert.Empty(t, serve("192.0.2.10:51234", "/", false).Result().Cookies())
+
+	err := (&MaintenanceHandler{MaintenanceNoticeCookie: "bad name"}).Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid maintenance_notice_cookie 'bad name'")
+}
+
+func TestParseCaddyfile_MaintenanceNotice(t *testing.T) {
+	h, err := parseTestCaddyfile(t, `maintenance {
+		maintenance_notice_header X-Maintenance-Active
+		maintenance_notice_cookie maintenance_active
+	}`)
+	require.NoError(t, err)
+	assert.Equal(t, "X-Maintenance-Active", h.MaintenanceNoticeHeader)
+	assert.Equal(t, "maintenance_active", h.MaintenanceNoticeCookie)
+
+	for _, input := range []string{
+		"maintenance {\n\tmaintenance_notice_header\n}",
+		"maintenance {\n\tmaintenance_notice_cookie\n}",
+	} {
+		_, err := parseTestCaddyfile(t, input)
+		assert.Error(t, err, input)
+	}
+}